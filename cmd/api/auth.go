@@ -0,0 +1,49 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+
+    "github.com/Alyanaky/SecureDAG/internal/auth"
+    "github.com/gin-gonic/gin"
+)
+
+// callerClaims returns the claims of the request's bearer token, or nil if
+// the token is missing or invalid.
+func callerClaims(c *gin.Context) map[string]interface{} {
+    token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+    claims, err := auth.ParseToken(token)
+    if err != nil {
+        return nil
+    }
+    return claims
+}
+
+func callerRole(c *gin.Context) auth.Role {
+    return auth.RoleFromClaims(callerClaims(c))
+}
+
+func callerName(c *gin.Context) string {
+    sub, _ := callerClaims(c)["sub"].(string)
+    return sub
+}
+
+// governanceBypass reports whether the request carries the bypass header and
+// whether the caller is allowed to use it.
+func governanceBypass(c *gin.Context, resource string) (requested, allowed bool) {
+    if !strings.EqualFold(c.GetHeader("x-amz-bypass-governance-retention"), "true") {
+        return false, false
+    }
+    return true, auth.HasPermission(callerRole(c), resource, auth.ActionBypassGovernance)
+}
+
+// adminOnly rejects requests whose token does not grant access to the path.
+func adminOnly() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if !auth.HasPermission(callerRole(c), c.FullPath(), c.Request.Method) {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin role required"})
+            return
+        }
+        c.Next()
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/gin-gonic/gin"
+)
+
+func registerCARRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.GET("/export", func(c *gin.Context) {
+        bucket, key := c.Query("bucket"), c.Query("key")
+        version, err := strconv.Atoi(c.DefaultQuery("version", "1"))
+        if err != nil || (version != 1 && version != 2) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "version must be 1 or 2"})
+            return
+        }
+        c.Header("Content-Type", "application/vnd.ipld.car; version="+strconv.Itoa(version))
+        err = a.ExportCAR(c.Request.Context(), c.Writer, bucket, key, version)
+        if err == nil {
+            return
+        }
+        if !c.Writer.Written() {
+            writeError(c, err)
+            return
+        }
+        // The archive is cut short. Its header already names every root,
+        // so importing it fails on the blocks that never arrived.
+        log.Printf("CAR export of %s failed: %v", bucket, err)
+    })
+
+    admin.POST("/import", func(c *gin.Context) {
+        n, err := a.ImportCAR(ctx, c.Request.Body, c.Query("bucket"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"imported": n})
+    })
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/gin-gonic/gin"
+)
+
+// defaultConflictLimit is how many conflicts are listed unless the request
+// asks for a different number.
+const defaultConflictLimit = 100
+
+// registerConflictRoutes serves the log of concurrent writes that nodes
+// settled while replicating, and the per-bucket conflict mode.
+func registerConflictRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.GET("/conflicts", func(c *gin.Context) {
+        limit := defaultConflictLimit
+        if v := c.Query("limit"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n <= 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+                return
+            }
+            limit = n
+        }
+        bucket := c.Query("bucket")
+        conflicts, err := a.ListConflicts(ctx, bucket, limit)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        if conflicts == nil {
+            conflicts = []storage.Conflict{}
+        }
+        c.JSON(http.StatusOK, gin.H{"bucket": bucket, "conflicts": conflicts})
+    })
+
+    admin.GET("/conflicts/config", func(c *gin.Context) {
+        cfg, err := a.GetConflictConfiguration(ctx, c.Query("bucket"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, cfg)
+    })
+
+    admin.PUT("/conflicts/config", func(c *gin.Context) {
+        var cfg storage.ConflictConfiguration
+        if err := c.ShouldBindJSON(&cfg); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := a.PutConflictConfiguration(ctx, c.Query("bucket"), cfg); err != nil {
+            writeError(c, err)
+            return
+        }
+        c.Status(http.StatusOK)
+    })
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+    "context"
+    "net/http"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/gin-gonic/gin"
+)
+
+// getObjectDiff lists the byte ranges and blocks of a version that an
+// older version does not have.
+func getObjectDiff(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    from := c.Query("fromVersionId")
+    if from == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "fromVersionId is required"})
+        return
+    }
+    diff, err := a.DiffObjectVersions(ctx, c.Param("bucket"), c.Param("key"), from, c.Query("versionId"))
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, diff)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "net/http"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/gin-gonic/gin"
+)
+
+func registerErasureRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.GET("/erasure/config", func(c *gin.Context) {
+        cfg, err := a.GetErasureConfiguration(ctx, c.Query("bucket"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, cfg)
+    })
+
+    admin.PUT("/erasure/config", func(c *gin.Context) {
+        var cfg storage.ErasureConfiguration
+        if err := c.ShouldBindJSON(&cfg); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := a.PutErasureConfiguration(ctx, c.Query("bucket"), cfg); err != nil {
+            writeError(c, err)
+            return
+        }
+        c.Status(http.StatusOK)
+    })
+
+    admin.POST("/erasure/heal", func(c *gin.Context) {
+        healed, err := a.HealStripes(ctx)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"healed_shards": healed})
+    })
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/Alyanaky/SecureDAG/internal/lifecycle"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/gin-gonic/gin"
+)
+
+// writeError maps storage errors onto HTTP status codes.
+func writeError(c *gin.Context, err error) {
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrRetentionNotFound),
+        errors.Is(err, storage.ErrNoSuchUpload), errors.Is(err, storage.ErrNoSuchSnapshot):
+        status = http.StatusNotFound
+    case errors.Is(err, storage.ErrObjectLocked), errors.Is(err, storage.ErrInvalidObjectState):
+        status = http.StatusForbidden
+    case errors.Is(err, storage.ErrObjectExists):
+        status = http.StatusConflict
+    case errors.Is(err, storage.ErrObjectLockNotEnabled),
+        errors.Is(err, storage.ErrInvalidRetentionMode),
+        errors.Is(err, storage.ErrInvalidRetentionDate),
+        errors.Is(err, storage.ErrInvalidStorageClass),
+        errors.Is(err, storage.ErrInvalidErasureConfiguration),
+        errors.Is(err, storage.ErrInvalidConflictMode),
+        errors.Is(err, lifecycle.ErrInvalidConfiguration),
+        errors.Is(err, dag.ErrInvalidCAR), errors.Is(err, dag.ErrCIDMismatch),
+        errors.Is(err, translog.ErrInvalidRange):
+        status = http.StatusBadRequest
+    case errors.Is(err, storage.ErrInvalidRange):
+        status = http.StatusRequestedRangeNotSatisfiable
+    case errors.Is(err, storage.ErrNotEnoughPeers), errors.Is(err, storage.ErrShardsUnavailable):
+        status = http.StatusServiceUnavailable
+    }
+    c.JSON(status, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/gin-gonic/gin"
+)
+
+func registerGCRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.POST("/gc", func(c *gin.Context) {
+        opts := storage.DefaultGCOptions
+        if v := c.Query("grace"); v != "" {
+            grace, err := time.ParseDuration(v)
+            if err != nil || grace < 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid grace period"})
+                return
+            }
+            opts.GracePeriod = grace
+        }
+        report, err := a.CollectGarbage(ctx, opts)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, report)
+    })
+}
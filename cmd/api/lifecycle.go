@@ -0,0 +1,290 @@
+package main
+
+import (
+    "context"
+    "encoding/xml"
+    "io"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/lifecycle"
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/aws/aws-sdk-go-v2/aws"
+    aws_s3 "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+    "github.com/gin-gonic/gin"
+)
+
+type tagXML struct {
+    Key   string `xml:"Key"`
+    Value string `xml:"Value"`
+}
+
+type lifecycleAndXML struct {
+    Prefix                string   `xml:"Prefix,omitempty"`
+    Tags                  []tagXML `xml:"Tag"`
+    ObjectSizeGreaterThan int64    `xml:"ObjectSizeGreaterThan,omitempty"`
+    ObjectSizeLessThan    int64    `xml:"ObjectSizeLessThan,omitempty"`
+}
+
+type lifecycleFilterXML struct {
+    Prefix                string           `xml:"Prefix,omitempty"`
+    Tag                   *tagXML          `xml:"Tag,omitempty"`
+    ObjectSizeGreaterThan int64            `xml:"ObjectSizeGreaterThan,omitempty"`
+    ObjectSizeLessThan    int64            `xml:"ObjectSizeLessThan,omitempty"`
+    And                   *lifecycleAndXML `xml:"And,omitempty"`
+}
+
+type lifecycleExpirationXML struct {
+    Date                      *time.Time `xml:"Date,omitempty"`
+    Days                      int32      `xml:"Days,omitempty"`
+    ExpiredObjectDeleteMarker bool       `xml:"ExpiredObjectDeleteMarker,omitempty"`
+}
+
+type noncurrentVersionExpirationXML struct {
+    NoncurrentDays          int32 `xml:"NoncurrentDays"`
+    NewerNoncurrentVersions int32 `xml:"NewerNoncurrentVersions,omitempty"`
+}
+
+type abortIncompleteMultipartUploadXML struct {
+    DaysAfterInitiation int32 `xml:"DaysAfterInitiation"`
+}
+
+type transitionXML struct {
+    Date         *time.Time `xml:"Date,omitempty"`
+    Days         int32      `xml:"Days,omitempty"`
+    StorageClass string     `xml:"StorageClass"`
+}
+
+type lifecycleRuleXML struct {
+    ID                             string                             `xml:"ID"`
+    Status                         string                             `xml:"Status"`
+    Filter                         *lifecycleFilterXML                `xml:"Filter,omitempty"`
+    Expiration                     *lifecycleExpirationXML            `xml:"Expiration,omitempty"`
+    NoncurrentVersionExpiration    *noncurrentVersionExpirationXML    `xml:"NoncurrentVersionExpiration,omitempty"`
+    AbortIncompleteMultipartUpload *abortIncompleteMultipartUploadXML `xml:"AbortIncompleteMultipartUpload,omitempty"`
+    Transitions                    []transitionXML                    `xml:"Transition"`
+}
+
+type lifecycleConfigurationXML struct {
+    XMLName xml.Name           `xml:"LifecycleConfiguration"`
+    Rules   []lifecycleRuleXML `xml:"Rule"`
+}
+
+func putBucketLifecycle(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    var body lifecycleConfigurationXML
+    if err := xml.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    cfg := &types.BucketLifecycleConfiguration{}
+    for _, r := range body.Rules {
+        cfg.Rules = append(cfg.Rules, r.toRule())
+    }
+    input := &aws_s3.PutBucketLifecycleConfigurationInput{
+        Bucket:                 &bucket,
+        LifecycleConfiguration: cfg,
+    }
+    if _, err := a.PutBucketLifecycleConfiguration(ctx, input); err != nil {
+        writeError(c, err)
+        return
+    }
+    c.Status(http.StatusOK)
+}
+
+func getBucketLifecycle(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    output, err := a.GetBucketLifecycleConfiguration(ctx, &aws_s3.GetBucketLifecycleConfigurationInput{Bucket: &bucket})
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    var body lifecycleConfigurationXML
+    for _, r := range output.Rules {
+        body.Rules = append(body.Rules, lifecycleRuleFromRule(r))
+    }
+    c.XML(http.StatusOK, body)
+}
+
+func deleteBucketLifecycle(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    if _, err := a.DeleteBucketLifecycle(ctx, &aws_s3.DeleteBucketLifecycleInput{Bucket: &bucket}); err != nil {
+        writeError(c, err)
+        return
+    }
+    c.Status(http.StatusNoContent)
+}
+
+func registerLifecycleRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.GET("/lifecycle", func(c *gin.Context) {
+        cfg, err := a.GetLifecycleConfiguration(ctx, c.Query("bucket"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, cfg)
+    })
+
+    admin.PUT("/lifecycle", func(c *gin.Context) {
+        var cfg lifecycle.Configuration
+        if err := c.ShouldBindJSON(&cfg); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := a.PutLifecycleConfiguration(ctx, c.Query("bucket"), &cfg); err != nil {
+            writeError(c, err)
+            return
+        }
+        c.Status(http.StatusOK)
+    })
+
+    // With dryRun=true nothing is changed. The body may carry a candidate
+    // configuration to evaluate instead of the stored one, and rule limits the
+    // run to a single rule.
+    admin.POST("/lifecycle/execute", func(c *gin.Context) {
+        bucket := c.Query("bucket")
+        dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+        if !dryRun {
+            report, err := a.ApplyLifecycle(ctx, bucket)
+            if err != nil {
+                writeError(c, err)
+                return
+            }
+            c.JSON(http.StatusOK, report)
+            return
+        }
+        var cfg *lifecycle.Configuration
+        if err := c.ShouldBindJSON(&cfg); err != nil && err != io.EOF {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        report, err := a.DryRunLifecycle(ctx, bucket, cfg, c.Query("rule"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, report)
+    })
+}
+
+func (r lifecycleRuleXML) toRule() types.LifecycleRule {
+    rule := types.LifecycleRule{
+        ID:     aws.String(r.ID),
+        Status: types.ExpirationStatus(r.Status),
+    }
+    if f := r.Filter; f != nil {
+        rule.Filter = &types.LifecycleRuleFilter{
+            Prefix:                optionalString(f.Prefix),
+            ObjectSizeGreaterThan: optionalInt64(f.ObjectSizeGreaterThan),
+            ObjectSizeLessThan:    optionalInt64(f.ObjectSizeLessThan),
+        }
+        if f.Tag != nil {
+            rule.Filter.Tag = &types.Tag{Key: aws.String(f.Tag.Key), Value: aws.String(f.Tag.Value)}
+        }
+        if and := f.And; and != nil {
+            rule.Filter.And = &types.LifecycleRuleAndOperator{
+                Prefix:                optionalString(and.Prefix),
+                ObjectSizeGreaterThan: optionalInt64(and.ObjectSizeGreaterThan),
+                ObjectSizeLessThan:    optionalInt64(and.ObjectSizeLessThan),
+            }
+            for _, t := range and.Tags {
+                rule.Filter.And.Tags = append(rule.Filter.And.Tags, types.Tag{Key: aws.String(t.Key), Value: aws.String(t.Value)})
+            }
+        }
+    }
+    if e := r.Expiration; e != nil {
+        rule.Expiration = &types.LifecycleExpiration{
+            Date:                      e.Date,
+            Days:                      aws.Int32(e.Days),
+            ExpiredObjectDeleteMarker: aws.Bool(e.ExpiredObjectDeleteMarker),
+        }
+    }
+    if n := r.NoncurrentVersionExpiration; n != nil {
+        rule.NoncurrentVersionExpiration = &types.NoncurrentVersionExpiration{
+            NoncurrentDays:          aws.Int32(n.NoncurrentDays),
+            NewerNoncurrentVersions: aws.Int32(n.NewerNoncurrentVersions),
+        }
+    }
+    if u := r.AbortIncompleteMultipartUpload; u != nil {
+        rule.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{
+            DaysAfterInitiation: aws.Int32(u.DaysAfterInitiation),
+        }
+    }
+    for _, t := range r.Transitions {
+        rule.Transitions = append(rule.Transitions, types.Transition{
+            Date:         t.Date,
+            Days:         aws.Int32(t.Days),
+            StorageClass: types.TransitionStorageClass(t.StorageClass),
+        })
+    }
+    return rule
+}
+
+func lifecycleRuleFromRule(r types.LifecycleRule) lifecycleRuleXML {
+    rule := lifecycleRuleXML{
+        ID:     aws.ToString(r.ID),
+        Status: string(r.Status),
+    }
+    if f := r.Filter; f != nil {
+        rule.Filter = &lifecycleFilterXML{
+            Prefix:                aws.ToString(f.Prefix),
+            ObjectSizeGreaterThan: aws.ToInt64(f.ObjectSizeGreaterThan),
+            ObjectSizeLessThan:    aws.ToInt64(f.ObjectSizeLessThan),
+        }
+        if f.Tag != nil {
+            rule.Filter.Tag = &tagXML{Key: aws.ToString(f.Tag.Key), Value: aws.ToString(f.Tag.Value)}
+        }
+        if and := f.And; and != nil {
+            rule.Filter.And = &lifecycleAndXML{
+                Prefix:                aws.ToString(and.Prefix),
+                ObjectSizeGreaterThan: aws.ToInt64(and.ObjectSizeGreaterThan),
+                ObjectSizeLessThan:    aws.ToInt64(and.ObjectSizeLessThan),
+            }
+            for _, t := range and.Tags {
+                rule.Filter.And.Tags = append(rule.Filter.And.Tags, tagXML{Key: aws.ToString(t.Key), Value: aws.ToString(t.Value)})
+            }
+        }
+    }
+    if e := r.Expiration; e != nil {
+        rule.Expiration = &lifecycleExpirationXML{
+            Date:                      e.Date,
+            Days:                      aws.ToInt32(e.Days),
+            ExpiredObjectDeleteMarker: aws.ToBool(e.ExpiredObjectDeleteMarker),
+        }
+    }
+    if n := r.NoncurrentVersionExpiration; n != nil {
+        rule.NoncurrentVersionExpiration = &noncurrentVersionExpirationXML{
+            NoncurrentDays:          aws.ToInt32(n.NoncurrentDays),
+            NewerNoncurrentVersions: aws.ToInt32(n.NewerNoncurrentVersions),
+        }
+    }
+    if u := r.AbortIncompleteMultipartUpload; u != nil {
+        rule.AbortIncompleteMultipartUpload = &abortIncompleteMultipartUploadXML{
+            DaysAfterInitiation: aws.ToInt32(u.DaysAfterInitiation),
+        }
+    }
+    for _, t := range r.Transitions {
+        rule.Transitions = append(rule.Transitions, transitionXML{
+            Date:         t.Date,
+            Days:         aws.ToInt32(t.Days),
+            StorageClass: string(t.StorageClass),
+        })
+    }
+    return rule
+}
+
+func optionalString(s string) *string {
+    if s == "" {
+        return nil
+    }
+    return &s
+}
+
+func optionalInt64(n int64) *int64 {
+    if n == 0 {
+        return nil
+    }
+    return &n
+}
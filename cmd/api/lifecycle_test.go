@@ -0,0 +1,154 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "encoding/xml"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/lifecycle"
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/gin-gonic/gin"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// lifecycleServer routes the bucket ?lifecycle requests and the admin
+// lifecycle endpoints the way main does.
+func lifecycleServer(t *testing.T) (*gin.Engine, *storage.BadgerStore) {
+    t.Helper()
+    gin.SetMode(gin.TestMode)
+    store, err := storage.NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    t.Cleanup(func() { store.Close() })
+
+    ctx := context.Background()
+    a := s3.NewS3Adapter(store, nil)
+    r := gin.New()
+    r.PUT("/s3/:bucket", func(c *gin.Context) { putBucketLifecycle(ctx, a, c) })
+    r.GET("/s3/:bucket", func(c *gin.Context) { getBucketLifecycle(ctx, a, c) })
+    r.DELETE("/s3/:bucket", func(c *gin.Context) { deleteBucketLifecycle(ctx, a, c) })
+    registerLifecycleRoutes(ctx, r.Group("/admin"), a)
+    return r, store
+}
+
+func serve(r *gin.Engine, method, target, body string) *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+    return w
+}
+
+const lifecycleXML = `<LifecycleConfiguration>
+  <Rule>
+    <ID>expire-logs</ID>
+    <Status>Enabled</Status>
+    <Filter>
+      <And>
+        <Prefix>logs/</Prefix>
+        <Tag><Key>tier</Key><Value>debug</Value></Tag>
+      </And>
+    </Filter>
+    <Expiration><Days>30</Days></Expiration>
+    <NoncurrentVersionExpiration><NoncurrentDays>7</NoncurrentDays></NoncurrentVersionExpiration>
+    <AbortIncompleteMultipartUpload><DaysAfterInitiation>2</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+    <Transition><Days>90</Days><StorageClass>GLACIER</StorageClass></Transition>
+  </Rule>
+</LifecycleConfiguration>`
+
+func TestBucketLifecycle_XMLRoundTrip(t *testing.T) {
+    r, _ := lifecycleServer(t)
+
+    w := serve(r, http.MethodPut, "/s3/b?lifecycle", lifecycleXML)
+    require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+    w = serve(r, http.MethodGet, "/s3/b?lifecycle", "")
+    require.Equal(t, http.StatusOK, w.Code)
+    var got, want lifecycleConfigurationXML
+    require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &got))
+    require.NoError(t, xml.Unmarshal([]byte(lifecycleXML), &want))
+    assert.Equal(t, want, got)
+
+    w = serve(r, http.MethodDelete, "/s3/b?lifecycle", "")
+    assert.Equal(t, http.StatusNoContent, w.Code)
+    w = serve(r, http.MethodGet, "/s3/b?lifecycle", "")
+    assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestBucketLifecycle_RejectsInvalidConfigurations(t *testing.T) {
+    r, _ := lifecycleServer(t)
+
+    for name, body := range map[string]string{
+        "malformed":  `<LifecycleConfiguration><Rule>`,
+        "no rules":   `<LifecycleConfiguration></LifecycleConfiguration>`,
+        "no id":      `<LifecycleConfiguration><Rule><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`,
+        "bad status": `<LifecycleConfiguration><Rule><ID>r</ID><Status>On</Status><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`,
+        "no actions": `<LifecycleConfiguration><Rule><ID>r</ID><Status>Enabled</Status></Rule></LifecycleConfiguration>`,
+    } {
+        w := serve(r, http.MethodPut, "/s3/b?lifecycle", body)
+        assert.Equal(t, http.StatusBadRequest, w.Code, name)
+    }
+    w := serve(r, http.MethodPut, "/admin/lifecycle?bucket=b", `{"rules": [{"id": "r", "status": "Enabled"}]}`)
+    assert.Equal(t, http.StatusBadRequest, w.Code)
+    w = serve(r, http.MethodPut, "/admin/lifecycle?bucket=b", `{"rules": [`)
+    assert.Equal(t, http.StatusBadRequest, w.Code)
+
+    // Nothing was stored.
+    w = serve(r, http.MethodGet, "/s3/b?lifecycle", "")
+    assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminLifecycle_ExecuteDryRun(t *testing.T) {
+    r, store := lifecycleServer(t)
+    require.NoError(t, store.PutObject("b", "tmp/a", []byte("old")))
+    require.NoError(t, store.PutObject("b", "keep/b", []byte("kept")))
+
+    // Without a stored or candidate configuration there is nothing to run.
+    w := serve(r, http.MethodPost, "/admin/lifecycle/execute?bucket=b&dryRun=true", "")
+    assert.Equal(t, http.StatusNotFound, w.Code)
+
+    stored := `{"rules": [{"id": "tmp", "status": "Disabled", "filter": {"prefix": "tmp/"}, "expiration": {"date": "2020-01-01T00:00:00Z"}}]}`
+    w = serve(r, http.MethodPut, "/admin/lifecycle?bucket=b", stored)
+    require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+    w = serve(r, http.MethodGet, "/admin/lifecycle?bucket=b", "")
+    require.Equal(t, http.StatusOK, w.Code)
+    var cfg lifecycle.Configuration
+    require.NoError(t, json.Unmarshal(w.Body.Bytes(), &cfg))
+    require.Len(t, cfg.Rules, 1)
+    assert.Equal(t, "tmp", cfg.Rules[0].ID)
+
+    report := func(w *httptest.ResponseRecorder) lifecycle.Report {
+        t.Helper()
+        require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+        var report lifecycle.Report
+        require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+        return report
+    }
+
+    // The stored rule is disabled, unless the run names it.
+    got := report(serve(r, http.MethodPost, "/admin/lifecycle/execute?bucket=b&dryRun=true", ""))
+    assert.True(t, got.DryRun)
+    assert.Empty(t, got.Actions)
+    got = report(serve(r, http.MethodPost, "/admin/lifecycle/execute?bucket=b&dryRun=true&rule=tmp", ""))
+    require.Len(t, got.Actions, 1)
+    assert.Equal(t, lifecycle.ActionExpire, got.Actions[0].Type)
+    assert.Equal(t, "tmp/a", got.Actions[0].Key)
+    w = serve(r, http.MethodPost, "/admin/lifecycle/execute?bucket=b&dryRun=true&rule=missing", "")
+    assert.Equal(t, http.StatusBadRequest, w.Code)
+
+    // A candidate configuration in the body is evaluated instead.
+    candidate := `{"rules": [{"id": "all", "status": "Enabled", "expiration": {"date": "2020-01-01T00:00:00Z"}}]}`
+    got = report(serve(r, http.MethodPost, "/admin/lifecycle/execute?bucket=b&dryRun=true", candidate))
+    assert.Len(t, got.Actions, 2)
+    w = serve(r, http.MethodPost, "/admin/lifecycle/execute?bucket=b&dryRun=true", `{"rules": []}`)
+    assert.Equal(t, http.StatusBadRequest, w.Code)
+
+    // Dry runs change nothing.
+    for _, key := range []string{"tmp/a", "keep/b"} {
+        _, err := store.GetObject("b", key)
+        assert.NoError(t, err, key)
+    }
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "context"
+    "encoding/xml"
+    "net/http"
+    "strconv"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/aws/aws-sdk-go-v2/aws"
+    aws_s3 "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+    "github.com/gin-gonic/gin"
+)
+
+type initiateMultipartUploadResultXML struct {
+    XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+    Bucket   string   `xml:"Bucket"`
+    Key      string   `xml:"Key"`
+    UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUploadResultXML struct {
+    XMLName   xml.Name `xml:"CompleteMultipartUploadResult"`
+    Bucket    string   `xml:"Bucket"`
+    Key       string   `xml:"Key"`
+    VersionID string   `xml:"VersionId,omitempty"`
+}
+
+func createMultipartUpload(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    key := c.Param("key")
+    input := &aws_s3.CreateMultipartUploadInput{
+        Bucket:  &bucket,
+        Key:     &key,
+        Tagging:      optionalHeader(c, "x-amz-tagging"),
+        StorageClass: types.StorageClass(c.GetHeader("x-amz-storage-class")),
+    }
+    output, err := a.CreateMultipartUpload(ctx, input)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    c.XML(http.StatusOK, initiateMultipartUploadResultXML{
+        Bucket:   bucket,
+        Key:      key,
+        UploadID: aws.ToString(output.UploadId),
+    })
+}
+
+func uploadPart(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    key := c.Param("key")
+    partNumber, err := strconv.Atoi(c.Query("partNumber"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid partNumber"})
+        return
+    }
+    input := &aws_s3.UploadPartInput{
+        Bucket:     &bucket,
+        Key:        &key,
+        UploadId:   aws.String(c.Query("uploadId")),
+        PartNumber: aws.Int32(int32(partNumber)),
+        Body:       c.Request.Body,
+    }
+    output, err := a.UploadPart(ctx, input)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    c.Header("ETag", aws.ToString(output.ETag))
+    c.Status(http.StatusOK)
+}
+
+func completeMultipartUpload(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    key := c.Param("key")
+    input := &aws_s3.CompleteMultipartUploadInput{
+        Bucket:   &bucket,
+        Key:      &key,
+        UploadId: aws.String(c.Query("uploadId")),
+    }
+    output, err := a.CompleteMultipartUpload(ctx, input)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    c.XML(http.StatusOK, completeMultipartUploadResultXML{
+        Bucket:    bucket,
+        Key:       key,
+        VersionID: aws.ToString(output.VersionId),
+    })
+}
+
+func abortMultipartUpload(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    key := c.Param("key")
+    input := &aws_s3.AbortMultipartUploadInput{
+        Bucket:   &bucket,
+        Key:      &key,
+        UploadId: aws.String(c.Query("uploadId")),
+    }
+    if _, err := a.AbortMultipartUpload(ctx, input); err != nil {
+        writeError(c, err)
+        return
+    }
+    c.Status(http.StatusNoContent)
+}
+
+// optionalHeader returns a pointer to the header value, or nil if unset.
+func optionalHeader(c *gin.Context, name string) *string {
+    if v := c.GetHeader(name); v != "" {
+        return &v
+    }
+    return nil
+}
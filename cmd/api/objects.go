@@ -0,0 +1,122 @@
+package main
+
+import (
+    "context"
+    "encoding/xml"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/aws/aws-sdk-go-v2/aws"
+    aws_s3 "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+    "github.com/gin-gonic/gin"
+)
+
+type objectXML struct {
+    Key          string    `xml:"Key"`
+    LastModified time.Time `xml:"LastModified"`
+    Size         int64     `xml:"Size"`
+    StorageClass string    `xml:"StorageClass"`
+}
+
+type listBucketResultXML struct {
+    XMLName               xml.Name    `xml:"ListBucketResult"`
+    Name                  string      `xml:"Name"`
+    Prefix                string      `xml:"Prefix"`
+    KeyCount              int32       `xml:"KeyCount"`
+    MaxKeys               int32       `xml:"MaxKeys"`
+    IsTruncated           bool        `xml:"IsTruncated"`
+    NextContinuationToken string      `xml:"NextContinuationToken,omitempty"`
+    Contents              []objectXML `xml:"Contents"`
+}
+
+type restoreRequestXML struct {
+    XMLName xml.Name `xml:"RestoreRequest"`
+    Days    int32    `xml:"Days"`
+}
+
+func listObjects(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    input := &aws_s3.ListObjectsV2Input{
+        Bucket:            &bucket,
+        Prefix:            optionalQuery(c, "prefix"),
+        StartAfter:        optionalQuery(c, "start-after"),
+        ContinuationToken: optionalQuery(c, "continuation-token"),
+    }
+    if v := c.Query("max-keys"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid max-keys"})
+            return
+        }
+        input.MaxKeys = aws.Int32(int32(n))
+    }
+    output, err := a.ListObjectsV2(ctx, input)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    body := listBucketResultXML{
+        Name:                  bucket,
+        Prefix:                aws.ToString(output.Prefix),
+        KeyCount:              aws.ToInt32(output.KeyCount),
+        MaxKeys:               aws.ToInt32(output.MaxKeys),
+        IsTruncated:           aws.ToBool(output.IsTruncated),
+        NextContinuationToken: aws.ToString(output.NextContinuationToken),
+    }
+    for _, o := range output.Contents {
+        body.Contents = append(body.Contents, objectXML{
+            Key:          aws.ToString(o.Key),
+            LastModified: aws.ToTime(o.LastModified),
+            Size:         aws.ToInt64(o.Size),
+            StorageClass: string(o.StorageClass),
+        })
+    }
+    c.XML(http.StatusOK, body)
+}
+
+func headObject(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    key := c.Param("key")
+    input := &aws_s3.HeadObjectInput{
+        Bucket:    &bucket,
+        Key:       &key,
+        VersionId: optionalQuery(c, "versionId"),
+    }
+    output, err := a.HeadObject(ctx, input)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    c.Header("Content-Length", strconv.FormatInt(aws.ToInt64(output.ContentLength), 10))
+    c.Header("Last-Modified", aws.ToTime(output.LastModified).Format(http.TimeFormat))
+    c.Header("x-amz-version-id", aws.ToString(output.VersionId))
+    c.Header("x-amz-storage-class", string(output.StorageClass))
+    if output.Restore != nil {
+        c.Header("x-amz-restore", *output.Restore)
+    }
+    c.Status(http.StatusOK)
+}
+
+func restoreArchivedObject(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    key := c.Param("key")
+    var body restoreRequestXML
+    if err := xml.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    input := &aws_s3.RestoreObjectInput{
+        Bucket:         &bucket,
+        Key:            &key,
+        VersionId:      optionalQuery(c, "versionId"),
+        RestoreRequest: &types.RestoreRequest{Days: aws.Int32(body.Days)},
+    }
+    if _, err := a.RestoreArchivedObject(ctx, input); err != nil {
+        writeError(c, err)
+        return
+    }
+    c.Status(http.StatusAccepted)
+}
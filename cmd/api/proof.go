@@ -0,0 +1,34 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/gin-gonic/gin"
+)
+
+// getObjectProof returns the DAG root of an object version and inclusion
+// proofs for the chunks covering the requested byte range.
+func getObjectProof(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    var offset, length int64
+    for name, v := range map[string]*int64{"offset": &offset, "length": &length} {
+        s := c.Query(name)
+        if s == "" {
+            continue
+        }
+        n, err := strconv.ParseInt(s, 10, 64)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+            return
+        }
+        *v = n
+    }
+    proof, err := a.ProveObjectRange(ctx, c.Param("bucket"), c.Param("key"), c.Query("versionId"), offset, length)
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, proof)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/gin-gonic/gin"
+)
+
+func registerScrubRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.GET("/scrub", func(c *gin.Context) {
+        report, err := a.LastScrubReport(ctx)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, report)
+    })
+
+    admin.POST("/scrub", func(c *gin.Context) {
+        opts := storage.DefaultScrubOptions
+        if v := c.Query("blocks"); v != "" {
+            blocks, err := strconv.Atoi(v)
+            if err != nil || blocks <= 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid block count"})
+                return
+            }
+            opts.BlocksPerRun = blocks
+        }
+        report, err := a.Scrub(ctx, opts)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, report)
+    })
+}
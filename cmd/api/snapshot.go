@@ -0,0 +1,49 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/gin-gonic/gin"
+)
+
+func registerSnapshotRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.POST("/snapshots", func(c *gin.Context) {
+        snap, err := a.SnapshotBucket(ctx, c.Query("bucket"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, snap)
+    })
+
+    admin.GET("/snapshots", func(c *gin.Context) {
+        bucket := c.Query("bucket")
+        snaps, err := a.ListSnapshots(ctx, bucket)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        if snaps == nil {
+            snaps = []storage.BucketSnapshot{}
+        }
+        c.JSON(http.StatusOK, gin.H{"bucket": bucket, "public_key": a.SigningPublicKey(), "snapshots": snaps})
+    })
+
+    admin.GET("/snapshots/proof", func(c *gin.Context) {
+        seq, err := strconv.ParseUint(c.Query("sequence"), 10, 64)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sequence"})
+            return
+        }
+        proof, err := a.ProveSnapshotInclusion(ctx, c.Query("bucket"), seq, c.Query("key"), c.Query("versionId"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, proof)
+    })
+}
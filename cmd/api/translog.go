@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/gin-gonic/gin"
+)
+
+// registerTranslogRoutes serves the transparency log in the shape of the
+// RFC 6962 get-sth, get-entries, get-proof-by-hash and get-sth-consistency
+// calls. Besides admins, tokens with the auditor role may read them.
+func registerTranslogRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.GET("/translog/sth", func(c *gin.Context) {
+        head, err := a.LogTreeHead(ctx)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, head)
+    })
+
+    admin.GET("/translog/entries", func(c *gin.Context) {
+        start, end, ok := uintQueries(c, "start", "end")
+        if !ok {
+            return
+        }
+        records, err := a.LogEntries(ctx, start, end)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"entries": records})
+    })
+
+    admin.GET("/translog/proof", func(c *gin.Context) {
+        index, size, ok := uintQueries(c, "index", "tree_size")
+        if !ok {
+            return
+        }
+        proof, err := a.LogInclusionProof(ctx, index, size)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, proof)
+    })
+
+    admin.GET("/translog/consistency", func(c *gin.Context) {
+        first, second, ok := uintQueries(c, "first", "second")
+        if !ok {
+            return
+        }
+        proof, err := a.LogConsistencyProof(ctx, first, second)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, proof)
+    })
+}
+
+// uintQueries parses two required unsigned query parameters, answering 400
+// if either is missing or malformed.
+func uintQueries(c *gin.Context, a, b string) (uint64, uint64, bool) {
+    x, err := strconv.ParseUint(c.Query(a), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + a})
+        return 0, 0, false
+    }
+    y, err := strconv.ParseUint(c.Query(b), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + b})
+        return 0, 0, false
+    }
+    return x, y, true
+}
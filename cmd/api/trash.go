@@ -0,0 +1,55 @@
+package main
+
+import (
+    "context"
+    "net/http"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/gin-gonic/gin"
+)
+
+func registerTrashRoutes(ctx context.Context, admin *gin.RouterGroup, a *s3.S3Adapter) {
+    admin.GET("/trash", func(c *gin.Context) {
+        bucket := c.Query("bucket")
+        entries, err := a.ListTrash(ctx, bucket)
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        if entries == nil {
+            entries = []storage.TrashEntry{}
+        }
+        c.JSON(http.StatusOK, gin.H{"bucket": bucket, "entries": entries})
+    })
+
+    admin.POST("/trash/restore", func(c *gin.Context) {
+        if err := a.RestoreObject(ctx, c.Query("bucket"), c.Query("key"), c.Query("id")); err != nil {
+            writeError(c, err)
+            return
+        }
+        c.Status(http.StatusOK)
+    })
+
+    admin.GET("/trash/config", func(c *gin.Context) {
+        cfg, err := a.GetTrashConfiguration(ctx, c.Query("bucket"))
+        if err != nil {
+            writeError(c, err)
+            return
+        }
+        c.JSON(http.StatusOK, cfg)
+    })
+
+    admin.PUT("/trash/config", func(c *gin.Context) {
+        var cfg storage.TrashConfiguration
+        if err := c.ShouldBindJSON(&cfg); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := a.PutTrashConfiguration(ctx, c.Query("bucket"), cfg); err != nil {
+            writeError(c, err)
+            return
+        }
+        c.Status(http.StatusOK)
+    })
+}
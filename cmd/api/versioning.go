@@ -0,0 +1,45 @@
+package main
+
+import (
+    "context"
+    "encoding/xml"
+    "net/http"
+
+    "github.com/Alyanaky/SecureDAG/internal/s3"
+    aws_s3 "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+    "github.com/gin-gonic/gin"
+)
+
+type versioningConfigurationXML struct {
+    XMLName xml.Name `xml:"VersioningConfiguration"`
+    Status  string   `xml:"Status,omitempty"`
+}
+
+func putBucketVersioning(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    var body versioningConfigurationXML
+    if err := xml.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    input := &aws_s3.PutBucketVersioningInput{
+        Bucket:                  &bucket,
+        VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatus(body.Status)},
+    }
+    if _, err := a.PutBucketVersioning(ctx, input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.Status(http.StatusOK)
+}
+
+func getBucketVersioning(ctx context.Context, a *s3.S3Adapter, c *gin.Context) {
+    bucket := c.Param("bucket")
+    output, err := a.GetBucketVersioning(ctx, &aws_s3.GetBucketVersioningInput{Bucket: &bucket})
+    if err != nil {
+        writeError(c, err)
+        return
+    }
+    c.XML(http.StatusOK, versioningConfigurationXML{Status: string(output.Status)})
+}
@@ -2,24 +2,274 @@ package main
 
 import (
     "context"
+    "flag"
     "log"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
 
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/lifecycle"
+    "github.com/Alyanaky/SecureDAG/internal/metrics"
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
     "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/Alyanaky/SecureDAG/internal/sync"
+    "github.com/libp2p/go-libp2p/core/peer"
 )
 
+// version is reported to the cluster. Release builds set it with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
-    store, err := storage.NewBadgerStore("/tmp/securedag")
+    dataDir := flag.String("data", "/tmp/securedag", "Badger data directory")
+    listen := flag.String("listen", strings.Join(p2p.DefaultListenAddrs, ","), "comma-separated libp2p listen multiaddrs")
+    bootstrap := flag.String("bootstrap", "", "comma-separated multiaddrs of peers to bootstrap from")
+    capacity := flag.Float64("capacity", 1, "relative share of replicas this node takes")
+    zone := flag.String("zone", "", "availability zone of this node")
+    rack := flag.String("rack", "", "rack of this node")
+    balance := flag.String("balance", "p2c", "how reads pick a replica: round-robin, least-outstanding, peak-ewma or p2c")
+    clusterKey := flag.String("cluster-key", "", "file holding the hex key shared by every node of the cluster")
+    flag.Parse()
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    store, err := storage.NewBadgerStore(*dataDir)
     if err != nil {
         log.Fatal(err)
     }
     defer store.Close()
+    if *clusterKey != "" {
+        data, err := os.ReadFile(*clusterKey)
+        if err != nil {
+            log.Fatal(err)
+        }
+        key, err := crypto.ParseClusterKey(string(data))
+        if err != nil {
+            log.Fatal(err)
+        }
+        if err := store.SetClusterKey(key); err != nil {
+            log.Fatal(err)
+        }
+    }
+    // Created before the node joins, so that lifecycle rules other nodes
+    // send are checked from the start.
+    lifecycleManager := lifecycle.NewLifecycleManager(store)
+
+    // Only nodes holding the cluster key can connect, which is what makes
+    // membership, and every check against it, trustworthy.
+    node, err := p2p.NewNode(ctx, p2p.Config{
+        Identity:       store.PeerKey(),
+        ListenAddrs:    splitList(*listen),
+        BootstrapPeers: splitList(*bootstrap),
+        PSK:            store.ClusterKey().Derive("pnet"),
+    })
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer func() {
+        if err := node.Close(); err != nil {
+            log.Printf("Closing libp2p host failed: %v", err)
+        }
+    }()
+    for _, addr := range node.Addrs() {
+        log.Printf("Listening on %s", addr)
+    }
+
+    exchange := p2p.NewExchange(node, store)
+    defer exchange.Close()
+    store.SetBlockExchange(exchange)
+
+    provider := p2p.NewProvider(node.DHT)
+    store.SetAnnouncer(provider)
+    go provider.Run(ctx, store.ListBlocks, p2p.ReprovideInterval)
+
+    ops := p2p.NewDHTOperations(node.DHT)
+    store.SetDHT(ops)
+    replicator := storage.NewReplicator(store, ops)
+
+    placement := p2p.NewPlacement(p2p.DefaultFailureDomains)
+    exchange.SetPlacement(placement)
+    strategy, err := p2p.ParseStrategy(*balance)
+    if err != nil {
+        log.Fatal(err)
+    }
+    exchange.SetLoadBalancer(p2p.NewLoadBalancer(strategy))
+    replicator.UsePlacement(placement, exchange)
+    store.SetReplicator(replicator)
+
+    membership := p2p.NewMembership(node, p2p.NodeMeta{
+        Capacity: *capacity,
+        Zone:     *zone,
+        Rack:     *rack,
+        Version:  version,
+    }, p2p.DefaultMembershipConfig)
+    defer membership.Close()
+    exchange.SetMembers(membership.IsMember)
+    // Blocks move whenever the set of nodes changes, and the replicas a
+    // lost node held are rebuilt elsewhere.
+    rebalance := make(chan struct{}, 1)
+    membership.Subscribe(func(ev p2p.Event) {
+        log.Printf("Node %s %s", ev.Member.ID, ev.Type)
+        placement.SetPeers(membership.PlacementPeers())
+        store.SetShardPeers(shardPeers(membership, exchange))
+        if ev.Type == p2p.MemberFailed || ev.Type == p2p.MemberLeft {
+            store.RequestHeal()
+        }
+        select {
+        case rebalance <- struct{}{}:
+        default:
+        }
+    })
+    placement.SetPeers(membership.PlacementPeers())
+    store.SetShardPeers(shardPeers(membership, exchange))
+    if err := membership.Join(ctx, splitList(*bootstrap)); err != nil {
+        log.Printf("Joining the cluster failed: %v", err)
+    }
+    go membership.Run(ctx)
+    defer func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        membership.Leave(ctx)
+    }()
+
+    // Changes are broadcast to the cluster as they are made, and those a
+    // node missed while unreachable are pulled from its peers in the
+    // background.
+    pubsub, err := p2p.NewPubSub(ctx, node)
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer pubsub.Close()
+    pubsub.SetMembers(membership.IsMember)
+    antiEntropy := sync.NewAntiEntropy(node, store, exchange)
+    defer antiEntropy.Close()
+    antiEntropy.SetMembers(membership.IsMember)
+    gossip, err := sync.NewGossip(pubsub, store, antiEntropy)
+    if err != nil {
+        log.Fatal(err)
+    }
+    go gossip.Run(ctx)
+    go antiEntropy.Run(ctx, func() []peer.ID {
+        var peers []peer.ID
+        for _, m := range membership.Members() {
+            if m.ID != node.Host.ID() {
+                peers = append(peers, m.ID)
+            }
+        }
+        return peers
+    }, sync.DefaultInterval)
+
+    coldTier, err := storage.NewFilesystemTier("/tmp/securedag-cold")
+    if err != nil {
+        log.Fatal(err)
+    }
+    store.SetColdTier(coldTier)
 
-    ctx := context.Background()
     go func() {
         if err := store.SelfHeal(ctx); err != nil {
             log.Printf("Self-healing failed: %v", err)
         }
     }()
 
-    select {}
+    go func() {
+        ticker := time.NewTicker(storage.HealInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            case <-rebalance:
+            }
+            if err := replicator.EnsureReplicas(ctx); err != nil {
+                log.Printf("Replication failed: %v", err)
+            }
+        }
+    }()
+
+    go func() {
+        ticker := time.NewTicker(storage.PurgeInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            }
+            if err := store.PurgeDeletedObjects(ctx, storage.DefaultTrashRetention); err != nil {
+                log.Printf("Trash purge failed: %v", err)
+            }
+            if err := store.ExpireTombstones(ctx, storage.TombstoneRetention); err != nil {
+                log.Printf("Expiring tombstones failed: %v", err)
+            }
+            if err := store.ExpireConflicts(ctx, storage.ConflictRetention); err != nil {
+                log.Printf("Expiring conflict records failed: %v", err)
+            }
+            if err := store.ExpireRestoredCopies(ctx); err != nil {
+                log.Printf("Expiring restored copies failed: %v", err)
+            }
+            if err := store.SweepColdTier(ctx); err != nil {
+                log.Printf("Cold tier sweep failed: %v", err)
+            }
+            report, err := store.CollectGarbage(ctx, storage.DefaultGCOptions)
+            if err != nil {
+                log.Printf("Garbage collection failed: %v", err)
+            }
+            metrics.GCDeletedBlocks.Add(float64(report.DeletedBlocks))
+            metrics.GCReclaimedBytes.Add(float64(report.ReclaimedBytes))
+            if report.DeletedBlocks > 0 {
+                log.Printf("Garbage collection reclaimed %d bytes in %d blocks", report.ReclaimedBytes, report.DeletedBlocks)
+            }
+        }
+    }()
+
+    go func() {
+        ticker := time.NewTicker(storage.SnapshotInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            }
+            if err := store.SnapshotAllBuckets(); err != nil {
+                log.Printf("Bucket snapshots failed: %v", err)
+            }
+        }
+    }()
+
+    metrics.RegisterMetrics()
+    go metrics.ExposeMetrics()
+
+    scheduler := lifecycle.NewScheduler(lifecycleManager, lifecycle.DefaultInterval)
+    go scheduler.Run(ctx)
+
+    <-ctx.Done()
+    log.Println("Shutting down")
+}
+
+func splitList(s string) []string {
+    var items []string
+    for _, item := range strings.Split(s, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+// shardPeers returns the other live members of the cluster, which hold the
+// shards of erasure-coded chunks.
+func shardPeers(membership *p2p.Membership, exchange *p2p.Exchange) []storage.ShardPeer {
+    var ids []peer.ID
+    for _, m := range membership.Members() {
+        if m.ID != exchange.ID() {
+            ids = append(ids, m.ID)
+        }
+    }
+    return storage.ExchangePeers(exchange, ids)
 }
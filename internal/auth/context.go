@@ -0,0 +1,16 @@
+package auth
+
+import "context"
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx that carries the authenticated user name.
+func WithUser(ctx context.Context, user string) context.Context {
+    return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user stored by WithUser, or "".
+func UserFromContext(ctx context.Context) string {
+    user, _ := ctx.Value(userKey{}).(string)
+    return user
+}
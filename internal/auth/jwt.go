@@ -41,3 +41,18 @@ func GenerateToken(username string, customClaims map[string]interface{}) (string
     token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
     return token.SignedString(privateKey)
 }
+
+// ParseToken verifies a token signed by GenerateToken and returns its claims.
+func ParseToken(tokenString string) (jwt.MapClaims, error) {
+    if publicKey == nil {
+        return nil, jwt.ErrInvalidKey
+    }
+    claims := jwt.MapClaims{}
+    _, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+        return publicKey, nil
+    }, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+    if err != nil {
+        return nil, err
+    }
+    return claims, nil
+}
@@ -3,11 +3,16 @@ package auth
 type Role string
 
 const (
-    RoleAdmin  Role = "admin"
-    RoleUser   Role = "user"
-    RoleViewer Role = "viewer"
+    RoleAdmin   Role = "admin"
+    RoleUser    Role = "user"
+    RoleViewer  Role = "viewer"
+    // RoleAuditor may only read the transparency log.
+    RoleAuditor Role = "auditor"
 )
 
+// ActionBypassGovernance allows removing objects under governance-mode retention.
+const ActionBypassGovernance = "s3:BypassGovernanceRetention"
+
 type Policy struct {
     Resource string
     Actions  []string
@@ -23,6 +28,12 @@ var RolePolicies = map[Role][]Policy{
     RoleViewer: {
         {Resource: "/objects/*", Actions: []string{"GET"}},
     },
+    RoleAuditor: {
+        {Resource: "/admin/translog/sth", Actions: []string{"GET"}},
+        {Resource: "/admin/translog/entries", Actions: []string{"GET"}},
+        {Resource: "/admin/translog/proof", Actions: []string{"GET"}},
+        {Resource: "/admin/translog/consistency", Actions: []string{"GET"}},
+    },
 }
 
 func HasPermission(role Role, resource string, action string) bool {
@@ -43,3 +54,9 @@ func contains(slice []string, item string) bool {
     }
     return false
 }
+
+// RoleFromClaims returns the role carried in a token's "role" claim.
+func RoleFromClaims(claims map[string]interface{}) Role {
+    role, _ := claims["role"].(string)
+    return Role(role)
+}
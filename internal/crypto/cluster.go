@@ -0,0 +1,50 @@
+package crypto
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "strings"
+)
+
+// ClusterKeySize is the length of a cluster key.
+const ClusterKeySize = 32
+
+// ClusterKey is the secret every node of a cluster shares. Each use of it
+// works with its own subkey, derived under a purpose label, so that no two
+// uses share key material.
+type ClusterKey struct {
+    secret []byte
+}
+
+func NewClusterKey() (*ClusterKey, error) {
+    secret := make([]byte, ClusterKeySize)
+    if _, err := rand.Read(secret); err != nil {
+        return nil, err
+    }
+    return &ClusterKey{secret: secret}, nil
+}
+
+// ParseClusterKey restores a key saved with Encode. Surrounding whitespace
+// is ignored, so the key can be kept in a text file.
+func ParseClusterKey(s string) (*ClusterKey, error) {
+    secret, err := hex.DecodeString(strings.TrimSpace(s))
+    if err != nil || len(secret) != ClusterKeySize {
+        return nil, errors.New("invalid cluster key")
+    }
+    return &ClusterKey{secret: secret}, nil
+}
+
+// Encode returns the key as hex.
+func (k *ClusterKey) Encode() string {
+    return hex.EncodeToString(k.secret)
+}
+
+// Derive returns the subkey for purpose.
+func (k *ClusterKey) Derive(purpose string) []byte {
+    mac := hmac.New(sha256.New, k.secret)
+    mac.Write([]byte("securedag-cluster\x00" + purpose))
+    return mac.Sum(nil)
+}
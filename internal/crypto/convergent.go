@@ -0,0 +1,51 @@
+package crypto
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/sha256"
+)
+
+// ConvergentOverhead is the number of bytes ConvergentEncrypt adds.
+const ConvergentOverhead = 16
+
+// ConvergentEncrypt encrypts data under a key derived from the data itself
+// with HMAC-SHA256 under secret, so equal plaintexts give equal ciphertexts
+// among the holders of secret and can be deduplicated. Without secret, a
+// plaintext does not reveal which ciphertext it belongs to. The returned key
+// must be kept as secret as the data.
+func ConvergentEncrypt(secret, data []byte) (ciphertext, key []byte, err error) {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write(data)
+    key = mac.Sum(nil)
+    gcm, err := convergentGCM(key)
+    if err != nil {
+        return nil, nil, err
+    }
+    return gcm.Seal(nil, convergentNonce(key, gcm.NonceSize()), data, nil), key, nil
+}
+
+// ConvergentDecrypt reverses ConvergentEncrypt, verifying the GCM tag.
+func ConvergentDecrypt(ciphertext, key []byte) ([]byte, error) {
+    gcm, err := convergentGCM(key)
+    if err != nil {
+        return nil, err
+    }
+    return gcm.Open(nil, convergentNonce(key, gcm.NonceSize()), ciphertext, nil)
+}
+
+func convergentGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// convergentNonce is fixed per key. Reuse is safe because a key only ever
+// encrypts the one plaintext it was derived from.
+func convergentNonce(key []byte, size int) []byte {
+    sum := sha256.Sum256(append([]byte("securedag-convergent-nonce\x00"), key...))
+    return sum[:size]
+}
@@ -0,0 +1,34 @@
+package crypto
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestConvergentEncrypt_KeyedByClusterSecret(t *testing.T) {
+    a, err := NewClusterKey()
+    require.NoError(t, err)
+    b, err := NewClusterKey()
+    require.NoError(t, err)
+    data := []byte("a well-known file")
+
+    first, key, err := ConvergentEncrypt(a.Derive("convergent"), data)
+    require.NoError(t, err)
+    second, _, err := ConvergentEncrypt(a.Derive("convergent"), data)
+    require.NoError(t, err)
+    assert.Equal(t, first, second, "equal chunks deduplicate within a cluster")
+
+    other, _, err := ConvergentEncrypt(b.Derive("convergent"), data)
+    require.NoError(t, err)
+    assert.NotEqual(t, first, other, "without the secret a plaintext does not confirm its ciphertext")
+
+    plain, err := ConvergentDecrypt(first, key)
+    require.NoError(t, err)
+    assert.Equal(t, data, plain)
+
+    parsed, err := ParseClusterKey(a.Encode() + "\n")
+    require.NoError(t, err)
+    assert.Equal(t, a.Derive("convergent"), parsed.Derive("convergent"))
+}
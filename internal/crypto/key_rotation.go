@@ -3,10 +3,15 @@ package crypto
 import (
     "crypto/rand"
     "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/hex"
     "time"
 )
 
-func RotateKeys(km *KeyManager, interval time.Duration) error {
+// RotateKeys replaces the keys of km every interval. If rotated is not nil it
+// is called with each new public key.
+func RotateKeys(km *KeyManager, interval time.Duration, rotated func(*rsa.PublicKey)) error {
     ticker := time.NewTicker(interval)
     defer ticker.Stop()
 
@@ -17,6 +22,19 @@ func RotateKeys(km *KeyManager, interval time.Duration) error {
         }
         newPubKey := &newPrivKey.PublicKey
         km.SetKeys(newPrivKey, newPubKey)
+        if rotated != nil {
+            rotated(newPubKey)
+        }
     }
     return nil
 }
+
+// PublicKeyFingerprint returns the hex SHA-256 of a key's PKIX encoding.
+func PublicKeyFingerprint(pub *rsa.PublicKey) (string, error) {
+    der, err := x509.MarshalPKIXPublicKey(pub)
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(der)
+    return hex.EncodeToString(sum[:]), nil
+}
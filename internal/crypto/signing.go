@@ -0,0 +1,47 @@
+package crypto
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "errors"
+)
+
+// SigningKey is the long-lived Ed25519 key a node signs its public
+// commitments with. Unlike the RSA keys of a KeyManager it is never
+// rotated, so old signatures stay verifiable.
+type SigningKey struct {
+    private ed25519.PrivateKey
+}
+
+func NewSigningKey() (*SigningKey, error) {
+    _, private, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, err
+    }
+    return &SigningKey{private: private}, nil
+}
+
+// SigningKeyFromSeed restores a key saved with Seed.
+func SigningKeyFromSeed(seed []byte) (*SigningKey, error) {
+    if len(seed) != ed25519.SeedSize {
+        return nil, errors.New("invalid signing key seed")
+    }
+    return &SigningKey{private: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func (k *SigningKey) Seed() []byte {
+    return k.private.Seed()
+}
+
+func (k *SigningKey) PublicKey() ed25519.PublicKey {
+    return k.private.Public().(ed25519.PublicKey)
+}
+
+func (k *SigningKey) Sign(message []byte) []byte {
+    return ed25519.Sign(k.private, message)
+}
+
+// VerifySignature checks a signature made by the holder of publicKey.
+func VerifySignature(publicKey, message, signature []byte) bool {
+    return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, message, signature)
+}
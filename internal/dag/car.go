@@ -0,0 +1,269 @@
+package dag
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+
+    "github.com/ipfs/go-cid"
+    "github.com/ipld/go-ipld-prime/codec/dagcbor"
+    "github.com/ipld/go-ipld-prime/datamodel"
+    "github.com/ipld/go-ipld-prime/fluent/qp"
+    cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+    "github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// maxCARSection bounds the header and sections read from a CAR, so a bad
+// length prefix cannot make the reader allocate without limit.
+const maxCARSection = 64 << 20
+
+// carV2HeaderSize is the fixed header that follows the CARv2 pragma:
+// 16 bytes of characteristics, then the data offset, data size and index
+// offset as little-endian uint64s.
+const carV2HeaderSize = 40
+
+var ErrInvalidCAR = errors.New("invalid CAR archive")
+
+// carV2Pragma opens every CARv2 archive. It reads as a CARv1 header of
+// version 2.
+var carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// CARWriter writes a CARv1 archive: a DAG-CBOR header naming the roots,
+// followed by length-prefixed CID and block pairs. In a CARv2 archive the
+// same bytes follow the CARv2 header.
+type CARWriter struct {
+    w io.Writer
+    // size is the payload size a CARv2 header announced, written the
+    // bytes written since.
+    size, written uint64
+}
+
+func NewCARWriter(w io.Writer, roots []cid.Cid) (*CARWriter, error) {
+    header, err := carHeader(roots)
+    if err != nil {
+        return nil, err
+    }
+    cw := &CARWriter{w: w}
+    return cw, cw.writeSection(header)
+}
+
+// NewCARv2Writer writes a CARv2 archive without an index. The header
+// records the size of the CARv1 payload up front, so the caller passes it
+// in, as summed up by CARPayloadSize and CARBlockSize, and must put exactly
+// those blocks; Close reports a payload of any other size.
+func NewCARv2Writer(w io.Writer, roots []cid.Cid, payloadSize uint64) (*CARWriter, error) {
+    header := make([]byte, carV2HeaderSize)
+    // The characteristics stay zero: the archive has no index.
+    binary.LittleEndian.PutUint64(header[16:], uint64(len(carV2Pragma)+carV2HeaderSize))
+    binary.LittleEndian.PutUint64(header[24:], payloadSize)
+    if _, err := w.Write(append(append([]byte{}, carV2Pragma...), header...)); err != nil {
+        return nil, err
+    }
+    cw, err := NewCARWriter(w, roots)
+    if err != nil {
+        return nil, err
+    }
+    cw.size = payloadSize
+    return cw, nil
+}
+
+// CARPayloadSize returns the size of a CARv1 header naming roots. Adding
+// CARBlockSize for every block gives the size of the whole payload.
+func CARPayloadSize(roots []cid.Cid) (uint64, error) {
+    header, err := carHeader(roots)
+    if err != nil {
+        return 0, err
+    }
+    return sectionSize(len(header)), nil
+}
+
+// CARBlockSize returns the bytes a block of n bytes under c takes in a CAR.
+func CARBlockSize(c cid.Cid, n int) uint64 {
+    return sectionSize(c.ByteLen() + n)
+}
+
+func sectionSize(n int) uint64 {
+    return uint64(len(binary.AppendUvarint(nil, uint64(n))) + n)
+}
+
+func carHeader(roots []cid.Cid) ([]byte, error) {
+    header, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+        qp.MapEntry(ma, "roots", qp.List(int64(len(roots)), func(la datamodel.ListAssembler) {
+            for _, r := range roots {
+                qp.ListEntry(la, qp.Link(cidlink.Link{Cid: r}))
+            }
+        }))
+        qp.MapEntry(ma, "version", qp.Int(1))
+    })
+    if err != nil {
+        return nil, err
+    }
+    var buf bytes.Buffer
+    if err := dagcbor.Encode(header, &buf); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// Put appends a block. The caller is trusted to pass the block's own CID.
+func (cw *CARWriter) Put(c cid.Cid, data []byte) error {
+    return cw.writeSection(c.Bytes(), data)
+}
+
+// Close checks that a CARv2 payload came to the size its header announced.
+// It does not close the underlying writer.
+func (cw *CARWriter) Close() error {
+    if cw.size != 0 && cw.written != cw.size {
+        return fmt.Errorf("%w: wrote %d of %d payload bytes", ErrInvalidCAR, cw.written, cw.size)
+    }
+    return nil
+}
+
+func (cw *CARWriter) writeSection(parts ...[]byte) error {
+    n := 0
+    for _, p := range parts {
+        n += len(p)
+    }
+    if _, err := cw.w.Write(binary.AppendUvarint(nil, uint64(n))); err != nil {
+        return err
+    }
+    for _, p := range parts {
+        if _, err := cw.w.Write(p); err != nil {
+            return err
+        }
+    }
+    cw.written += sectionSize(n)
+    return nil
+}
+
+// CARReader reads the blocks of a CARv1 archive, or of the CARv1 payload of
+// a CARv2 archive. Every block is checked against its CID.
+type CARReader struct {
+    Roots []cid.Cid
+    r     *bufio.Reader
+}
+
+func NewCARReader(r io.Reader) (*CARReader, error) {
+    br := bufio.NewReader(r)
+    roots, version, err := readCARHeader(br)
+    if err != nil {
+        return nil, err
+    }
+    if version == 2 {
+        payload, err := carV2Payload(br)
+        if err != nil {
+            return nil, err
+        }
+        br = bufio.NewReader(payload)
+        if roots, version, err = readCARHeader(br); err != nil {
+            return nil, err
+        }
+    }
+    if version != 1 {
+        return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCAR, version)
+    }
+    return &CARReader{Roots: roots, r: br}, nil
+}
+
+// Next returns the next block, or io.EOF after the last one.
+func (cr *CARReader) Next() (cid.Cid, []byte, error) {
+    section, err := readSection(cr.r)
+    if err != nil {
+        return cid.Undef, nil, err
+    }
+    n, c, err := cid.CidFromBytes(section)
+    if err != nil {
+        return cid.Undef, nil, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+    }
+    data := section[n:]
+    return c, data, VerifyCID(c, data)
+}
+
+// readCARHeader reads a CAR header. The CARv2 pragma parses as a header of
+// version 2 without roots.
+func readCARHeader(r *bufio.Reader) ([]cid.Cid, int64, error) {
+    section, err := readSection(r)
+    if err == io.EOF {
+        return nil, 0, fmt.Errorf("%w: missing header", ErrInvalidCAR)
+    }
+    if err != nil {
+        return nil, 0, err
+    }
+    nb := basicnode.Prototype.Any.NewBuilder()
+    if err := dagcbor.Decode(nb, bytes.NewReader(section)); err != nil {
+        return nil, 0, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+    }
+    header := nb.Build()
+
+    versionNode, err := header.LookupByString("version")
+    if err != nil {
+        return nil, 0, fmt.Errorf("%w: header has no version", ErrInvalidCAR)
+    }
+    version, err := versionNode.AsInt()
+    if err != nil {
+        return nil, 0, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+    }
+    rootsNode, err := header.LookupByString("roots")
+    if err != nil {
+        return nil, version, nil
+    }
+    var roots []cid.Cid
+    it := rootsNode.ListIterator()
+    for it != nil && !it.Done() {
+        _, n, err := it.Next()
+        if err != nil {
+            return nil, 0, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+        }
+        link, err := n.AsLink()
+        if err != nil {
+            return nil, 0, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+        }
+        l, ok := link.(cidlink.Link)
+        if !ok {
+            return nil, 0, fmt.Errorf("%w: root is not a CID", ErrInvalidCAR)
+        }
+        roots = append(roots, l.Cid)
+    }
+    return roots, version, nil
+}
+
+// carV2Payload reads the CARv2 header following the pragma and returns the
+// inner CARv1 payload.
+func carV2Payload(r *bufio.Reader) (io.Reader, error) {
+    header := make([]byte, carV2HeaderSize)
+    if _, err := io.ReadFull(r, header); err != nil {
+        return nil, fmt.Errorf("%w: truncated CARv2 header", ErrInvalidCAR)
+    }
+    dataOffset := binary.LittleEndian.Uint64(header[16:])
+    dataSize := binary.LittleEndian.Uint64(header[24:])
+    // The payload cannot start before the end of the header.
+    read := uint64(len(carV2Pragma) + carV2HeaderSize)
+    if dataOffset < read {
+        return nil, fmt.Errorf("%w: bad CARv2 data offset", ErrInvalidCAR)
+    }
+    if _, err := r.Discard(int(dataOffset - read)); err != nil {
+        return nil, fmt.Errorf("%w: truncated CARv2 archive", ErrInvalidCAR)
+    }
+    return io.LimitReader(r, int64(dataSize)), nil
+}
+
+func readSection(r *bufio.Reader) ([]byte, error) {
+    n, err := binary.ReadUvarint(r)
+    if err != nil {
+        if err == io.EOF {
+            return nil, io.EOF
+        }
+        return nil, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+    }
+    if n == 0 || n > maxCARSection {
+        return nil, fmt.Errorf("%w: section of %d bytes", ErrInvalidCAR, n)
+    }
+    section := make([]byte, n)
+    if _, err := io.ReadFull(r, section); err != nil {
+        return nil, fmt.Errorf("%w: truncated section", ErrInvalidCAR)
+    }
+    return section, nil
+}
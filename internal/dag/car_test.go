@@ -0,0 +1,69 @@
+package dag
+
+import (
+    "bytes"
+    "encoding/binary"
+    "io"
+    "testing"
+
+    "github.com/ipfs/go-cid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestCAR_RoundTrip(t *testing.T) {
+    blocks := [][]byte{[]byte("one"), []byte("two")}
+    var cids []cid.Cid
+    var buf bytes.Buffer
+    for _, b := range blocks {
+        c, err := NewCID(CodecRaw, b)
+        require.NoError(t, err)
+        cids = append(cids, c)
+    }
+    cw, err := NewCARWriter(&buf, cids[:1])
+    require.NoError(t, err)
+    for i, b := range blocks {
+        require.NoError(t, cw.Put(cids[i], b))
+    }
+    v1 := buf.Bytes()
+
+    // A CARv2 wraps the same payload after a pragma and a fixed header.
+    size, err := CARPayloadSize(cids[:1])
+    require.NoError(t, err)
+    for i, b := range blocks {
+        size += CARBlockSize(cids[i], len(b))
+    }
+    assert.Equal(t, uint64(len(v1)), size)
+    var buf2 bytes.Buffer
+    cw, err = NewCARv2Writer(&buf2, cids[:1], size)
+    require.NoError(t, err)
+    for i, b := range blocks {
+        require.NoError(t, cw.Put(cids[i], b))
+    }
+    require.NoError(t, cw.Close())
+    v2 := buf2.Bytes()
+    assert.Equal(t, v1, v2[len(carV2Pragma)+carV2HeaderSize:])
+    assert.Equal(t, uint64(len(v1)), binary.LittleEndian.Uint64(v2[len(carV2Pragma)+24:]))
+
+    for _, archive := range [][]byte{v1, v2} {
+        cr, err := NewCARReader(bytes.NewReader(archive))
+        require.NoError(t, err)
+        assert.Equal(t, cids[:1], cr.Roots)
+        for i := range blocks {
+            c, data, err := cr.Next()
+            require.NoError(t, err)
+            assert.Equal(t, cids[i], c)
+            assert.Equal(t, blocks[i], data)
+        }
+        _, _, err = cr.Next()
+        assert.Equal(t, io.EOF, err)
+    }
+
+    tampered := bytes.Replace(v1, []byte("two"), []byte("tw0"), 1)
+    cr, err := NewCARReader(bytes.NewReader(tampered))
+    require.NoError(t, err)
+    _, _, err = cr.Next()
+    require.NoError(t, err)
+    _, _, err = cr.Next()
+    assert.ErrorIs(t, err, ErrCIDMismatch)
+}
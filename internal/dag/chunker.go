@@ -0,0 +1,77 @@
+package dag
+
+import "math/bits"
+
+// Chunker splits data at content-defined boundaries using FastCDC: a gear
+// rolling hash with normalized chunking, so that an edit only changes the
+// chunks around it.
+type Chunker struct {
+    MinSize int
+    AvgSize int
+    MaxSize int
+}
+
+var DefaultChunker = Chunker{
+    MinSize: 16 << 10,
+    AvgSize: 64 << 10,
+    MaxSize: 256 << 10,
+}
+
+var gear = func() [256]uint64 {
+    var table [256]uint64
+    // splitmix64 with a fixed seed keeps boundaries stable across releases.
+    x := uint64(0x5ec0de0da9)
+    for i := range table {
+        x += 0x9e3779b97f4a7c15
+        z := x
+        z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+        z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+        table[i] = z ^ (z >> 31)
+    }
+    return table
+}()
+
+// topMask selects the n highest bits, which depend on the last 64 bytes.
+func topMask(n int) uint64 {
+    return ^uint64(0) << (64 - n)
+}
+
+// Split returns the chunks of data. The chunks share data's backing array.
+func (c Chunker) Split(data []byte) [][]byte {
+    var chunks [][]byte
+    for len(data) > 0 {
+        n := c.cut(data)
+        chunks = append(chunks, data[:n:n])
+        data = data[n:]
+    }
+    return chunks
+}
+
+// cut returns the length of the first chunk of data.
+func (c Chunker) cut(data []byte) int {
+    if len(data) <= c.MinSize {
+        return len(data)
+    }
+    end := min(len(data), c.MaxSize)
+    normal := min(end, c.AvgSize)
+    avgBits := bits.Len(uint(c.AvgSize)) - 1
+    // A stricter mask before the average size and a looser one after it
+    // pull chunk sizes towards the average.
+    maskS, maskL := topMask(avgBits+2), topMask(avgBits-2)
+
+    var h uint64
+    i := c.MinSize
+    for ; i < normal; i++ {
+        h = (h << 1) + gear[data[i]]
+        if h&maskS == 0 {
+            return i + 1
+        }
+    }
+    for ; i < end; i++ {
+        h = (h << 1) + gear[data[i]]
+        if h&maskL == 0 {
+            return i + 1
+        }
+    }
+    return end
+}
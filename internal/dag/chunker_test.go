@@ -0,0 +1,55 @@
+package dag
+
+import (
+    "bytes"
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestChunker_BoundariesFollowContent(t *testing.T) {
+    data := make([]byte, 2<<20)
+    rand.New(rand.NewSource(1)).Read(data)
+
+    chunks := DefaultChunker.Split(data)
+    require.Greater(t, len(chunks), 4)
+    assert.Equal(t, data, bytes.Join(chunks, nil))
+    for _, c := range chunks[:len(chunks)-1] {
+        assert.GreaterOrEqual(t, len(c), DefaultChunker.MinSize)
+        assert.LessOrEqual(t, len(c), DefaultChunker.MaxSize)
+    }
+
+    // Inserting bytes near the start only disturbs the chunks around them.
+    edited := append(append(append([]byte{}, data[:100<<10]...), []byte("inserted")...), data[100<<10:]...)
+    seen := make(map[string]bool)
+    for _, c := range chunks {
+        seen[string(c)] = true
+    }
+    shared := 0
+    for _, c := range DefaultChunker.Split(edited) {
+        if seen[string(c)] {
+            shared++
+        }
+    }
+    assert.GreaterOrEqual(t, shared, len(chunks)-3)
+}
+
+func TestBuildTree_OddLeafCount(t *testing.T) {
+    leaves := [][]byte{{1}, {2}, {3}, {4}, {5}}
+    root := BuildTree(leaves)
+    assert.Equal(t, leaves, Leaves(root))
+
+    // The fifth leaf is carried up to pair with the root of the first four.
+    four := BuildTree(leaves[:4])
+    assert.Equal(t, NewMerkleNode(nil, four, &MerkleNode{Hash: []byte{5}}).Hash, root.Hash)
+    assert.Nil(t, BuildTree(nil))
+}
+
+func TestNewMerkleNode_SingleChild(t *testing.T) {
+    leaf := &MerkleNode{Hash: []byte{1}}
+    assert.Same(t, leaf, NewMerkleNode(nil, leaf, nil))
+    assert.Same(t, leaf, NewMerkleNode(nil, nil, leaf))
+    assert.Equal(t, RootHash(leaf), RootHash(NewMerkleNode(nil, leaf, nil)))
+}
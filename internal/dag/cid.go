@@ -0,0 +1,53 @@
+package dag
+
+import (
+    "errors"
+    "fmt"
+
+    "github.com/ipfs/go-cid"
+    "github.com/multiformats/go-multicodec"
+    "github.com/multiformats/go-multihash"
+)
+
+// Codecs of the blocks SecureDAG stores. Encrypted chunks are raw blocks,
+// DAG nodes are DAG-CBOR and exported object records are JSON.
+const (
+    CodecRaw     = uint64(multicodec.Raw)
+    CodecDagCBOR = uint64(multicodec.DagCbor)
+    CodecJSON    = uint64(multicodec.Json)
+)
+
+var ErrCIDMismatch = errors.New("block does not match its CID")
+
+// NewCID addresses data with a CIDv1 over its SHA2-256 multihash.
+func NewCID(codec uint64, data []byte) (cid.Cid, error) {
+    prefix := cid.Prefix{
+        Version:  1,
+        Codec:    codec,
+        MhType:   multihash.SHA2_256,
+        MhLength: -1,
+    }
+    return prefix.Sum(data)
+}
+
+// LeafCID returns the CID of the raw block whose SHA2-256 digest is hash, as
+// found in the leaves of a tree built by BuildTree.
+func LeafCID(hash []byte) (cid.Cid, error) {
+    mh, err := multihash.Encode(hash, multihash.SHA2_256)
+    if err != nil {
+        return cid.Undef, err
+    }
+    return cid.NewCidV1(CodecRaw, mh), nil
+}
+
+// VerifyCID checks that data hashes to c with the hash function c names.
+func VerifyCID(c cid.Cid, data []byte) error {
+    sum, err := c.Prefix().Sum(data)
+    if err != nil {
+        return err
+    }
+    if !sum.Equals(c) {
+        return fmt.Errorf("%w: %s", ErrCIDMismatch, c)
+    }
+    return nil
+}
@@ -0,0 +1,24 @@
+package dag
+
+import (
+    "crypto/sha256"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestCID_LeavesAreRawBlocks(t *testing.T) {
+    block := []byte("encrypted chunk")
+    hash := sha256.Sum256(block)
+
+    byContent, err := NewCID(CodecRaw, block)
+    require.NoError(t, err)
+    byLeaf, err := LeafCID(hash[:])
+    require.NoError(t, err)
+    assert.Equal(t, byContent, byLeaf)
+    assert.Equal(t, uint64(1), byLeaf.Version())
+
+    assert.NoError(t, VerifyCID(byLeaf, block))
+    assert.ErrorIs(t, VerifyCID(byLeaf, []byte("tampered")), ErrCIDMismatch)
+}
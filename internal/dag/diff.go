@@ -0,0 +1,81 @@
+package dag
+
+import "github.com/ipfs/go-cid"
+
+// NodeGetter loads a stored DAG node by CID.
+type NodeGetter func(cid.Cid) (*Node, error)
+
+// LeafRange is a run of consecutive leaves of a DAG, counted from zero in
+// leaf order.
+type LeafRange struct {
+    Start uint64
+    CIDs  []cid.Cid
+}
+
+// Diff returns the runs of leaves of the DAG rooted at to whose chunks do
+// not occur anywhere in the DAG rooted at from. Subtrees of to whose CID
+// appears in from are skipped without being loaded, using the leaf counts
+// on the links to keep track of positions. An undefined from marks every
+// leaf as changed and an undefined to has no leaves.
+func Diff(get NodeGetter, from, to cid.Cid) ([]LeafRange, error) {
+    seen := make(map[cid.Cid]bool)
+    if from.Defined() {
+        if err := collect(get, from, seen); err != nil {
+            return nil, err
+        }
+    }
+    if !to.Defined() || seen[to] {
+        return nil, nil
+    }
+
+    var ranges []LeafRange
+    var pos uint64
+    var walk func(c cid.Cid) error
+    walk = func(c cid.Cid) error {
+        n, err := get(c)
+        if err != nil {
+            return err
+        }
+        for _, l := range n.Links {
+            switch {
+            case seen[l.CID]:
+            case l.CID.Prefix().Codec == CodecRaw:
+                if last := len(ranges) - 1; last >= 0 && ranges[last].Start+uint64(len(ranges[last].CIDs)) == pos {
+                    ranges[last].CIDs = append(ranges[last].CIDs, l.CID)
+                } else {
+                    ranges = append(ranges, LeafRange{Start: pos, CIDs: []cid.Cid{l.CID}})
+                }
+            default:
+                if err := walk(l.CID); err != nil {
+                    return err
+                }
+                continue
+            }
+            pos += l.Leaves
+        }
+        return nil
+    }
+    return ranges, walk(to)
+}
+
+// collect adds the CIDs of every node and chunk under c to seen. Subtrees
+// already seen are not loaded again.
+func collect(get NodeGetter, c cid.Cid, seen map[cid.Cid]bool) error {
+    if seen[c] {
+        return nil
+    }
+    seen[c] = true
+    if c.Prefix().Codec == CodecRaw {
+        return nil
+    }
+    n, err := get(c)
+    if err != nil {
+        return err
+    }
+    for _, l := range n.Links {
+        if err := collect(get, l.CID, seen); err != nil {
+            return err
+        }
+    }
+    return nil
+}
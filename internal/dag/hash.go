@@ -0,0 +1,26 @@
+package dag
+
+import "crypto/sha256"
+
+// HashLeaf and HashChildren are the RFC 6962 Merkle tree hashes. The prefix
+// bytes keep a leaf from ever hashing like an interior node.
+func HashLeaf(data []byte) []byte {
+    h := sha256.New()
+    h.Write([]byte{0x00})
+    h.Write(data)
+    return h.Sum(nil)
+}
+
+func HashChildren(left, right []byte) []byte {
+    h := sha256.New()
+    h.Write([]byte{0x01})
+    h.Write(left)
+    h.Write(right)
+    return h.Sum(nil)
+}
+
+// EmptyTreeHash is the root hash of a tree without leaves.
+func EmptyTreeHash() []byte {
+    sum := sha256.Sum256(nil)
+    return sum[:]
+}
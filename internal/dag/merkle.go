@@ -1,33 +1,52 @@
 package dag
 
 import (
-    "bytes"
     "crypto/sha256"
 )
 
+// MerkleNode is a node of a tree built by BuildTree. A leaf holds the
+// SHA-256 hash of its block, which is also the digest of the block's CID; an
+// interior node always has both children.
 type MerkleNode struct {
     Hash  []byte
     Left  *MerkleNode
     Right *MerkleNode
 }
 
+// NewMerkleNode returns a leaf for data if it has no children, or the
+// interior node joining them. A single child is returned itself, carried up
+// as BuildTree carries a node left without a partner.
 func NewMerkleNode(data []byte, left, right *MerkleNode) *MerkleNode {
-    var hash [32]byte
-    if left == nil && right == nil {
-        hash = sha256.Sum256(data)
-    } else {
-        var buf bytes.Buffer
-        if left != nil {
-            buf.Write(left.Hash)
-        }
-        if right != nil {
-            buf.Write(right.Hash)
-        }
-        hash = sha256.Sum256(buf.Bytes())
+    switch {
+    case left == nil && right == nil:
+        hash := sha256.Sum256(data)
+        return &MerkleNode{Hash: hash[:]}
+    case right == nil:
+        return left
+    case left == nil:
+        return right
     }
     return &MerkleNode{
-        Hash:  hash[:],
+        Hash:  HashChildren(left.nodeHash(), right.nodeHash()),
         Left:  left,
         Right: right,
     }
 }
+
+// nodeHash is what a node contributes to its parent. A leaf's hash is hashed
+// again with the leaf prefix, so no block can pass for an interior node.
+func (n *MerkleNode) nodeHash() []byte {
+    if n.Left == nil && n.Right == nil {
+        return HashLeaf(n.Hash)
+    }
+    return n.Hash
+}
+
+// RootHash returns the hash that commits to the whole tree, which inclusion
+// proofs are checked against.
+func RootHash(root *MerkleNode) []byte {
+    if root == nil {
+        return EmptyTreeHash()
+    }
+    return root.nodeHash()
+}
@@ -0,0 +1,238 @@
+package dag
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+
+    "github.com/ipfs/go-cid"
+    "github.com/ipld/go-ipld-prime/codec/dagcbor"
+    "github.com/ipld/go-ipld-prime/datamodel"
+    "github.com/ipld/go-ipld-prime/fluent/qp"
+    cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+    "github.com/ipld/go-ipld-prime/node/basicnode"
+    "github.com/multiformats/go-multihash"
+)
+
+// NodeFormat is the version of the node encoding written by Node.Encode.
+const NodeFormat = 1
+
+var ErrInvalidNode = errors.New("invalid DAG node")
+
+// Link references a child of a node by CID. Children are either further
+// nodes or raw chunk blocks. Size is the total size of the blocks below the
+// link and Leaves the number of chunks.
+type Link struct {
+    CID    cid.Cid
+    Size   uint64
+    Leaves uint64
+}
+
+// Node is one interior node of a stored DAG. It is encoded as DAG-CBOR:
+//
+//	{"count": 2, "links": [{"cid": <CID>, "size": 65552, "leaves": 1}, ...], "version": 1}
+//
+// count repeats the number of links so that truncated nodes are caught.
+type Node struct {
+    Links []Link
+}
+
+// Size returns the total size of the blocks below the node.
+func (n *Node) Size() uint64 {
+    var size uint64
+    for _, l := range n.Links {
+        size += l.Size
+    }
+    return size
+}
+
+// Leaves returns the number of chunks below the node.
+func (n *Node) Leaves() uint64 {
+    var leaves uint64
+    for _, l := range n.Links {
+        leaves += l.Leaves
+    }
+    return leaves
+}
+
+// Encode returns the canonical DAG-CBOR encoding of the node.
+func (n *Node) Encode() ([]byte, error) {
+    built, err := qp.BuildMap(basicnode.Prototype.Any, 3, func(ma datamodel.MapAssembler) {
+        qp.MapEntry(ma, "version", qp.Int(NodeFormat))
+        qp.MapEntry(ma, "count", qp.Int(int64(len(n.Links))))
+        qp.MapEntry(ma, "links", qp.List(int64(len(n.Links)), func(la datamodel.ListAssembler) {
+            for _, l := range n.Links {
+                qp.ListEntry(la, qp.Map(3, func(ma datamodel.MapAssembler) {
+                    qp.MapEntry(ma, "cid", qp.Link(cidlink.Link{Cid: l.CID}))
+                    qp.MapEntry(ma, "size", qp.Int(int64(l.Size)))
+                    qp.MapEntry(ma, "leaves", qp.Int(int64(l.Leaves)))
+                }))
+            }
+        }))
+    })
+    if err != nil {
+        return nil, err
+    }
+    var buf bytes.Buffer
+    if err := dagcbor.Encode(built, &buf); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// DecodeNode parses a node written by Encode.
+func DecodeNode(data []byte) (*Node, error) {
+    nb := basicnode.Prototype.Any.NewBuilder()
+    if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+    }
+    m := nb.Build()
+    version, err := intField(m, "version")
+    if err != nil {
+        return nil, err
+    }
+    if version != NodeFormat {
+        return nil, fmt.Errorf("%w: unsupported format %d", ErrInvalidNode, version)
+    }
+    count, err := intField(m, "count")
+    if err != nil {
+        return nil, err
+    }
+    links, err := m.LookupByString("links")
+    if err != nil {
+        return nil, fmt.Errorf("%w: no links", ErrInvalidNode)
+    }
+    if links.Length() != count || count == 0 {
+        return nil, fmt.Errorf("%w: count %d for %d links", ErrInvalidNode, count, links.Length())
+    }
+
+    n := &Node{Links: make([]Link, 0, count)}
+    it := links.ListIterator()
+    for it != nil && !it.Done() {
+        _, entry, err := it.Next()
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+        }
+        target, err := entry.LookupByString("cid")
+        if err != nil {
+            return nil, fmt.Errorf("%w: link without cid", ErrInvalidNode)
+        }
+        link, err := target.AsLink()
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+        }
+        c, ok := link.(cidlink.Link)
+        if !ok {
+            return nil, fmt.Errorf("%w: link is not a CID", ErrInvalidNode)
+        }
+        size, err := intField(entry, "size")
+        if err != nil {
+            return nil, err
+        }
+        leaves, err := intField(entry, "leaves")
+        if err != nil {
+            return nil, err
+        }
+        n.Links = append(n.Links, Link{CID: c.Cid, Size: uint64(size), Leaves: uint64(leaves)})
+    }
+    return n, nil
+}
+
+func intField(m datamodel.Node, name string) (int64, error) {
+    v, err := m.LookupByString(name)
+    if err != nil {
+        return 0, fmt.Errorf("%w: no %s", ErrInvalidNode, name)
+    }
+    i, err := v.AsInt()
+    if err != nil || i < 0 {
+        return 0, fmt.Errorf("%w: bad %s", ErrInvalidNode, name)
+    }
+    return i, nil
+}
+
+// Block is an encoded node and its CID.
+type Block struct {
+    CID  cid.Cid
+    Data []byte
+}
+
+// EncodeTree encodes a tree built by BuildTree as one node per interior
+// MerkleNode, linking to the raw chunk blocks at the leaves. sizes holds
+// the size of each chunk block in leaf order. Children come before their
+// parents, so the root node is the last block. A single chunk still gets a
+// root node linking to it. It returns nothing for an empty tree.
+func EncodeTree(root *MerkleNode, sizes []uint64) ([]Block, error) {
+    if root == nil {
+        return nil, nil
+    }
+    var blocks []Block
+    next := 0
+    var encode func(*MerkleNode) (Link, error)
+    encode = func(m *MerkleNode) (Link, error) {
+        if m.Left == nil && m.Right == nil {
+            if next >= len(sizes) {
+                return Link{}, errors.New("more leaves than chunk sizes")
+            }
+            c, err := LeafCID(m.Hash)
+            if err != nil {
+                return Link{}, err
+            }
+            next++
+            return Link{CID: c, Size: sizes[next-1], Leaves: 1}, nil
+        }
+        var n Node
+        for _, child := range []*MerkleNode{m.Left, m.Right} {
+            if child == nil {
+                continue
+            }
+            l, err := encode(child)
+            if err != nil {
+                return Link{}, err
+            }
+            n.Links = append(n.Links, l)
+        }
+        return appendNode(&blocks, &n)
+    }
+
+    l, err := encode(root)
+    if err != nil {
+        return nil, err
+    }
+    if next != len(sizes) {
+        return nil, errors.New("fewer leaves than chunk sizes")
+    }
+    if l.CID.Prefix().Codec == CodecRaw {
+        if _, err := appendNode(&blocks, &Node{Links: []Link{l}}); err != nil {
+            return nil, err
+        }
+    }
+    return blocks, nil
+}
+
+func appendNode(blocks *[]Block, n *Node) (Link, error) {
+    data, err := n.Encode()
+    if err != nil {
+        return Link{}, err
+    }
+    c, err := NewCID(CodecDagCBOR, data)
+    if err != nil {
+        return Link{}, err
+    }
+    *blocks = append(*blocks, Block{CID: c, Data: data})
+    return Link{CID: c, Size: n.Size(), Leaves: n.Leaves()}, nil
+}
+
+// LeafHash returns the leaf hash of a raw chunk CID, the inverse of LeafCID.
+func LeafHash(c cid.Cid) ([]byte, error) {
+    if c.Prefix().Codec != CodecRaw {
+        return nil, fmt.Errorf("%w: %s is not a raw block", ErrInvalidNode, c)
+    }
+    decoded, err := multihash.Decode(c.Hash())
+    if err != nil {
+        return nil, err
+    }
+    if decoded.Code != multihash.SHA2_256 {
+        return nil, fmt.Errorf("%w: %s is not SHA2-256", ErrInvalidNode, c)
+    }
+    return decoded.Digest, nil
+}
@@ -0,0 +1,54 @@
+package dag
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "fmt"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestEncodeTree(t *testing.T) {
+    for _, n := range []int{1, 2, 5} {
+        var leaves [][]byte
+        var sizes []uint64
+        for i := 0; i < n; i++ {
+            h := sha256.Sum256([]byte(fmt.Sprint(i)))
+            leaves = append(leaves, h[:])
+            sizes = append(sizes, uint64(100+i))
+        }
+        blocks, err := EncodeTree(BuildTree(leaves), sizes)
+        require.NoError(t, err)
+
+        // A tree over n leaves has n-1 interior nodes; a lone leaf gets one.
+        assert.Len(t, blocks, max(n-1, 1))
+        root, err := DecodeNode(blocks[len(blocks)-1].Data)
+        require.NoError(t, err)
+        assert.Equal(t, uint64(n), root.Leaves())
+        var total uint64
+        for _, s := range sizes {
+            total += s
+        }
+        assert.Equal(t, total, root.Size())
+
+        again, err := root.Encode()
+        require.NoError(t, err)
+        assert.Equal(t, blocks[len(blocks)-1].Data, again)
+    }
+}
+
+func TestDecodeNode_RejectsBadCount(t *testing.T) {
+    c, err := LeafCID(make([]byte, 32))
+    require.NoError(t, err)
+    data, err := (&Node{Links: []Link{{CID: c, Size: 1, Leaves: 1}}}).Encode()
+    require.NoError(t, err)
+
+    // Bump the count from 1 to 2 in place; the map starts with "count".
+    i := bytes.Index(data, []byte("count")) + len("count")
+    require.Equal(t, byte(0x01), data[i])
+    data[i] = 0x02
+    _, err = DecodeNode(data)
+    assert.ErrorIs(t, err, ErrInvalidNode)
+}
@@ -0,0 +1,84 @@
+package dag
+
+import (
+    "bytes"
+    "errors"
+)
+
+var ErrLeafOutOfRange = errors.New("leaf index out of range")
+
+// Proof shows that Leaf is the leaf at Index of a tree of Size leaves. Path
+// holds the siblings from the leaf upwards; which side each is on follows
+// from Index and Size. Nodes that BuildTree carried up without a partner add
+// no step, so paths in unbalanced trees are shorter on the right edge.
+type Proof struct {
+    Index int      `json:"index"`
+    Size  int      `json:"size"`
+    Leaf  []byte   `json:"leaf"`
+    Path  [][]byte `json:"path"`
+}
+
+// Prove builds the inclusion proof of the leaf at index.
+func Prove(root *MerkleNode, index int) (*Proof, error) {
+    if root == nil || index < 0 {
+        return nil, ErrLeafOutOfRange
+    }
+    var path [][]byte
+    n, i := root, index
+    for n.Left != nil || n.Right != nil {
+        if n.Left == nil || n.Right == nil {
+            return nil, errors.New("merkle node has a single child")
+        }
+        if left := countLeaves(n.Left); i < left {
+            path = append(path, n.Right.nodeHash())
+            n = n.Left
+        } else {
+            path = append(path, n.Left.nodeHash())
+            n, i = n.Right, i-left
+        }
+    }
+    if i != 0 {
+        return nil, ErrLeafOutOfRange
+    }
+    for l, r := 0, len(path)-1; l < r; l, r = l+1, r-1 {
+        path[l], path[r] = path[r], path[l]
+    }
+    return &Proof{Index: index, Size: countLeaves(root), Leaf: n.Hash, Path: path}, nil
+}
+
+// VerifyProof reports whether p proves that its leaf is the leaf at p.Index
+// of the tree of p.Size leaves with the given root hash, as returned by
+// RootHash.
+func VerifyProof(root []byte, p *Proof) bool {
+    if p == nil || p.Index < 0 || p.Index >= p.Size {
+        return false
+    }
+    h, path := HashLeaf(p.Leaf), p.Path
+    // Walk up the levels BuildTree made: nodes pair up from the left, and
+    // the last one of an odd level is carried up alone.
+    for i, n := p.Index, p.Size; n > 1; i, n = i/2, (n+1)/2 {
+        if i%2 == 0 && i+1 == n {
+            continue
+        }
+        if len(path) == 0 {
+            return false
+        }
+        if i%2 == 1 {
+            h = HashChildren(path[0], h)
+        } else {
+            h = HashChildren(h, path[0])
+        }
+        path = path[1:]
+    }
+    return len(path) == 0 && bytes.Equal(h, root)
+}
+
+func countLeaves(n *MerkleNode) int {
+    if n == nil {
+        return 0
+    }
+    if n.Left == nil && n.Right == nil {
+        return 1
+    }
+    return countLeaves(n.Left) + countLeaves(n.Right)
+}
@@ -0,0 +1,52 @@
+package dag
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestProve_UnbalancedTrees(t *testing.T) {
+    for n := 1; n <= 9; n++ {
+        var leaves [][]byte
+        for i := 0; i < n; i++ {
+            h := sha256.Sum256([]byte(fmt.Sprint(i)))
+            leaves = append(leaves, h[:])
+        }
+        root := BuildTree(leaves)
+        for i := range leaves {
+            p, err := Prove(root, i)
+            require.NoError(t, err)
+            assert.Equal(t, leaves[i], p.Leaf)
+            assert.True(t, VerifyProof(RootHash(root), p), "%d leaves, index %d", n, i)
+
+            p.Leaf = leaves[(i+1)%n]
+            assert.Equal(t, n == 1, VerifyProof(RootHash(root), p))
+            p.Leaf = leaves[i]
+
+            // The proof is bound to the leaf's position.
+            p.Index = (i + 1) % n
+            assert.Equal(t, n == 1, VerifyProof(RootHash(root), p))
+            p.Index, p.Size = i, i
+            assert.False(t, VerifyProof(RootHash(root), p))
+        }
+        _, err := Prove(root, n)
+        assert.ErrorIs(t, err, ErrLeafOutOfRange)
+    }
+}
+
+func TestVerifyProof_InteriorNodeIsNotALeaf(t *testing.T) {
+    var leaves [][]byte
+    for i := 0; i < 4; i++ {
+        h := sha256.Sum256([]byte(fmt.Sprint(i)))
+        leaves = append(leaves, h[:])
+    }
+    root := BuildTree(leaves)
+
+    // Present the left subtree as a leaf of a two-leaf tree.
+    forged := &Proof{Index: 0, Size: 2, Leaf: root.Left.Hash, Path: [][]byte{root.Right.Hash}}
+    assert.False(t, VerifyProof(RootHash(root), forged))
+}
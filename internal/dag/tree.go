@@ -0,0 +1,38 @@
+package dag
+
+// BuildTree builds a Merkle tree over leaves, which are already hashed block
+// contents, pairing nodes level by level. A node left without a partner is
+// carried up unchanged, so odd leaf counts give an unbalanced tree. It
+// returns nil for no leaves.
+func BuildTree(leaves [][]byte) *MerkleNode {
+    level := make([]*MerkleNode, len(leaves))
+    for i, h := range leaves {
+        level[i] = &MerkleNode{Hash: h}
+    }
+    if len(level) == 0 {
+        return nil
+    }
+    for len(level) > 1 {
+        var next []*MerkleNode
+        for i := 0; i < len(level); i += 2 {
+            if i+1 == len(level) {
+                next = append(next, level[i])
+                continue
+            }
+            next = append(next, NewMerkleNode(nil, level[i], level[i+1]))
+        }
+        level = next
+    }
+    return level[0]
+}
+
+// Leaves returns the leaf hashes of the tree in order.
+func Leaves(root *MerkleNode) [][]byte {
+    if root == nil {
+        return nil
+    }
+    if root.Left == nil && root.Right == nil {
+        return [][]byte{root.Hash}
+    }
+    return append(Leaves(root.Left), Leaves(root.Right)...)
+}
@@ -0,0 +1,107 @@
+// Package hlc implements hybrid logical clocks. A timestamp follows the
+// wall clock while it moves forward, and a logical counter orders events
+// that happen within the same tick or after a message from a node whose
+// clock runs ahead. Every event a node observes, locally or from a peer,
+// is stamped later than everything it has seen before.
+package hlc
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+)
+
+// MaxDrift is how far ahead of the local wall clock a remote timestamp may
+// be. Later timestamps are rejected, so that one node with a broken clock
+// cannot drag the cluster into the future.
+const MaxDrift = time.Minute
+
+var ErrClockDrift = errors.New("remote clock too far ahead")
+
+// Timestamp is a point in hybrid logical time.
+type Timestamp struct {
+    // Wall is the physical time in Unix nanoseconds.
+    Wall int64 `json:"wall"`
+    // Logical orders timestamps with the same wall time.
+    Logical uint32 `json:"logical,omitempty"`
+}
+
+// Compare returns -1, 0 or 1 as t is before, equal to or after u.
+func (t Timestamp) Compare(u Timestamp) int {
+    switch {
+    case t.Wall < u.Wall:
+        return -1
+    case t.Wall > u.Wall:
+        return 1
+    case t.Logical < u.Logical:
+        return -1
+    case t.Logical > u.Logical:
+        return 1
+    }
+    return 0
+}
+
+// After reports whether t is later than u.
+func (t Timestamp) After(u Timestamp) bool {
+    return t.Compare(u) > 0
+}
+
+func (t Timestamp) IsZero() bool {
+    return t == Timestamp{}
+}
+
+// Time returns the wall time of t.
+func (t Timestamp) Time() time.Time {
+    return time.Unix(0, t.Wall).UTC()
+}
+
+func (t Timestamp) String() string {
+    return fmt.Sprintf("%s+%d", t.Time().Format(time.RFC3339Nano), t.Logical)
+}
+
+// Clock issues timestamps. It is safe for concurrent use.
+type Clock struct {
+    now func() time.Time
+
+    mu   sync.Mutex
+    last Timestamp
+}
+
+func NewClock() *Clock {
+    return &Clock{now: time.Now}
+}
+
+// Now returns a timestamp later than any the clock has issued or observed.
+func (c *Clock) Now() Timestamp {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if wall := c.now().UnixNano(); wall > c.last.Wall {
+        c.last = Timestamp{Wall: wall}
+    } else {
+        c.last.Logical++
+    }
+    return c.last
+}
+
+// Update observes a timestamp received from another node and returns one
+// later than both it and the local clock. It returns ErrClockDrift, and
+// observes nothing, if remote is more than MaxDrift ahead.
+func (c *Clock) Update(remote Timestamp) (Timestamp, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    wall := c.now().UnixNano()
+    if remote.Wall > wall+int64(MaxDrift) {
+        return c.last, fmt.Errorf("%w: %s", ErrClockDrift, remote)
+    }
+    latest := c.last
+    if remote.After(latest) {
+        latest = remote
+    }
+    if wall > latest.Wall {
+        c.last = Timestamp{Wall: wall}
+    } else {
+        c.last = Timestamp{Wall: latest.Wall, Logical: latest.Logical + 1}
+    }
+    return c.last, nil
+}
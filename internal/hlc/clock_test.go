@@ -0,0 +1,40 @@
+package hlc
+
+import (
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestClock_Orders(t *testing.T) {
+    wall := time.Unix(1000, 0)
+    c := NewClock()
+    c.now = func() time.Time { return wall }
+
+    // Within one tick the logical counter advances.
+    a := c.Now()
+    b := c.Now()
+    assert.True(t, b.After(a))
+    assert.Equal(t, a.Wall, b.Wall)
+
+    // A clock that went backwards still issues later timestamps.
+    wall = wall.Add(-time.Second)
+    assert.True(t, c.Now().After(b))
+
+    // A peer running ahead pulls the clock forward.
+    remote := Timestamp{Wall: time.Unix(1030, 0).UnixNano(), Logical: 4}
+    got, err := c.Update(remote)
+    require.NoError(t, err)
+    assert.Equal(t, Timestamp{Wall: remote.Wall, Logical: 5}, got)
+    assert.True(t, c.Now().After(remote))
+
+    // Once the wall clock catches up, it takes over again.
+    wall = time.Unix(1031, 0)
+    assert.Equal(t, Timestamp{Wall: wall.UnixNano()}, c.Now())
+
+    _, err = c.Update(Timestamp{Wall: wall.Add(2 * MaxDrift).UnixNano()})
+    assert.ErrorIs(t, err, ErrClockDrift)
+    assert.Equal(t, wall.UnixNano(), c.Now().Wall)
+}
@@ -0,0 +1,135 @@
+package lifecycle
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+const (
+    StatusEnabled  = "Enabled"
+    StatusDisabled = "Disabled"
+)
+
+// Configuration is the S3-style lifecycle configuration of a bucket.
+type Configuration struct {
+    Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+    ID                             string                          `json:"id"`
+    Status                         string                          `json:"status"`
+    Filter                         Filter                          `json:"filter"`
+    Expiration                     *Expiration                     `json:"expiration,omitempty"`
+    NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `json:"noncurrent_version_expiration,omitempty"`
+    AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `json:"abort_incomplete_multipart_upload,omitempty"`
+    Transitions                    []Transition                    `json:"transitions,omitempty"`
+}
+
+// Filter selects the objects a rule applies to. Every set field must match.
+type Filter struct {
+    Prefix                string            `json:"prefix,omitempty"`
+    Tags                  map[string]string `json:"tags,omitempty"`
+    ObjectSizeGreaterThan int64             `json:"object_size_greater_than,omitempty"`
+    ObjectSizeLessThan    int64             `json:"object_size_less_than,omitempty"`
+}
+
+type Expiration struct {
+    Days                      int        `json:"days,omitempty"`
+    Date                      *time.Time `json:"date,omitempty"`
+    ExpiredObjectDeleteMarker bool       `json:"expired_object_delete_marker,omitempty"`
+}
+
+type NoncurrentVersionExpiration struct {
+    NoncurrentDays          int `json:"noncurrent_days"`
+    NewerNoncurrentVersions int `json:"newer_noncurrent_versions,omitempty"`
+}
+
+type AbortIncompleteMultipartUpload struct {
+    DaysAfterInitiation int `json:"days_after_initiation"`
+}
+
+type Transition struct {
+    Days         int        `json:"days,omitempty"`
+    Date         *time.Time `json:"date,omitempty"`
+    StorageClass string     `json:"storage_class"`
+}
+
+func (c *Configuration) Validate() error {
+    if len(c.Rules) == 0 {
+        return errors.New("lifecycle configuration has no rules")
+    }
+    seen := make(map[string]bool)
+    for _, r := range c.Rules {
+        if r.ID == "" {
+            return errors.New("lifecycle rule is missing an id")
+        }
+        if seen[r.ID] {
+            return fmt.Errorf("duplicate lifecycle rule id %q", r.ID)
+        }
+        seen[r.ID] = true
+        if r.Status != StatusEnabled && r.Status != StatusDisabled {
+            return fmt.Errorf("rule %q: status must be %s or %s", r.ID, StatusEnabled, StatusDisabled)
+        }
+        if r.Expiration == nil && r.NoncurrentVersionExpiration == nil &&
+            r.AbortIncompleteMultipartUpload == nil && len(r.Transitions) == 0 {
+            return fmt.Errorf("rule %q has no actions", r.ID)
+        }
+        if e := r.Expiration; e != nil {
+            if e.Days < 0 || (e.Days > 0 && e.Date != nil) {
+                return fmt.Errorf("rule %q: expiration needs either days or a date", r.ID)
+            }
+            if e.Days == 0 && e.Date == nil && !e.ExpiredObjectDeleteMarker {
+                return fmt.Errorf("rule %q: empty expiration", r.ID)
+            }
+        }
+        if n := r.NoncurrentVersionExpiration; n != nil && (n.NoncurrentDays <= 0 || n.NewerNoncurrentVersions < 0) {
+            return fmt.Errorf("rule %q: noncurrent days must be positive", r.ID)
+        }
+        if a := r.AbortIncompleteMultipartUpload; a != nil && a.DaysAfterInitiation <= 0 {
+            return fmt.Errorf("rule %q: days after initiation must be positive", r.ID)
+        }
+        for _, t := range r.Transitions {
+            if t.StorageClass == "" || t.StorageClass == storage.StorageClassStandard || !storage.ValidStorageClass(t.StorageClass) {
+                return fmt.Errorf("rule %q: transition needs a non-standard storage class, got %q", r.ID, t.StorageClass)
+            }
+            if t.Days < 0 || (t.Days > 0 && t.Date != nil) {
+                return fmt.Errorf("rule %q: transition needs either days or a date", r.ID)
+            }
+        }
+    }
+    return nil
+}
+
+func (f Filter) matchesKey(key string) bool {
+    return strings.HasPrefix(key, f.Prefix)
+}
+
+func (f Filter) matches(key string, v storage.ObjectVersion) bool {
+    if !f.matchesKey(key) {
+        return false
+    }
+    if f.ObjectSizeGreaterThan > 0 && v.Size <= f.ObjectSizeGreaterThan {
+        return false
+    }
+    if f.ObjectSizeLessThan > 0 && v.Size >= f.ObjectSizeLessThan {
+        return false
+    }
+    for k, want := range f.Tags {
+        if got, ok := v.Tags[k]; !ok || got != want {
+            return false
+        }
+    }
+    return true
+}
+
+// due reports whether an age threshold given as days or as a date has passed.
+func due(since time.Time, days int, date *time.Time, now time.Time) bool {
+    if date != nil {
+        return !now.Before(*date)
+    }
+    return days > 0 && !now.Before(since.AddDate(0, 0, days))
+}
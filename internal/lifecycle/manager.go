@@ -2,30 +2,407 @@ package lifecycle
 
 import (
     "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
     "time"
 
+    "github.com/Alyanaky/SecureDAG/internal/metrics"
     "github.com/Alyanaky/SecureDAG/internal/storage"
 )
 
+const (
+    configName       = "lifecycle"
+    DefaultBatchSize = 500
+)
+
+var ErrInvalidConfiguration = errors.New("invalid lifecycle configuration")
+
+type ActionType string
+
+const (
+    ActionExpire             ActionType = "Expire"
+    ActionExpireNoncurrent   ActionType = "ExpireNoncurrentVersion"
+    ActionRemoveDeleteMarker ActionType = "RemoveExpiredDeleteMarker"
+    ActionAbortMultipart     ActionType = "AbortIncompleteMultipartUpload"
+    ActionTransition         ActionType = "Transition"
+)
+
+// Action is a single change a lifecycle rule makes to a bucket.
+type Action struct {
+    Type         ActionType `json:"type"`
+    RuleID       string     `json:"rule_id"`
+    Bucket       string     `json:"bucket"`
+    Key          string     `json:"key"`
+    VersionID    string     `json:"version_id,omitempty"`
+    UploadID     string     `json:"upload_id,omitempty"`
+    Size         int64      `json:"size"`
+    StorageClass string     `json:"storage_class,omitempty"`
+}
+
+// Report summarises one evaluation of a bucket's rules. In a dry run Actions
+// lists what would be done and Skipped what object lock would prevent.
+type Report struct {
+    Bucket  string   `json:"bucket"`
+    DryRun  bool     `json:"dry_run,omitempty"`
+    Actions []Action `json:"actions"`
+    Skipped []Action `json:"skipped,omitempty"`
+}
+
 type LifecycleManager struct {
-    store *storage.BadgerStore
+    store     *storage.BadgerStore
+    batchSize int
 }
 
+// NewLifecycleManager also makes store check the lifecycle configurations
+// other nodes send.
 func NewLifecycleManager(store *storage.BadgerStore) *LifecycleManager {
-    return &LifecycleManager{store: store}
+    store.SetConfigValidator(configName, validateDocument)
+    return &LifecycleManager{store: store, batchSize: DefaultBatchSize}
+}
+
+func validateDocument(doc []byte) error {
+    var cfg Configuration
+    if err := json.Unmarshal(doc, &cfg); err != nil {
+        return err
+    }
+    return cfg.Validate()
 }
 
+func (m *LifecycleManager) PutConfiguration(bucket string, cfg *Configuration) error {
+    if err := cfg.Validate(); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidConfiguration, err)
+    }
+    doc, err := json.Marshal(cfg)
+    if err != nil {
+        return err
+    }
+    return m.store.PutBucketConfig(bucket, configName, doc)
+}
+
+// GetConfiguration returns storage.ErrNotFound if the bucket has no rules.
+func (m *LifecycleManager) GetConfiguration(bucket string) (*Configuration, error) {
+    doc, err := m.store.GetBucketConfig(bucket, configName)
+    if err != nil {
+        return nil, err
+    }
+    var cfg Configuration
+    if err := json.Unmarshal(doc, &cfg); err != nil {
+        return nil, err
+    }
+    return &cfg, nil
+}
+
+func (m *LifecycleManager) DeleteConfiguration(bucket string) error {
+    return m.store.DeleteBucketConfig(bucket, configName)
+}
+
+// ConfiguredBuckets lists the buckets that have a lifecycle configuration.
+func (m *LifecycleManager) ConfiguredBuckets() ([]string, error) {
+    return m.store.BucketsWithConfig(configName)
+}
+
+// ApplyRetentionPolicy installs a rule named "retention-policy" that expires
+// current objects once they are older than retention, and applies it.
 func (m *LifecycleManager) ApplyRetentionPolicy(ctx context.Context, bucket string, retention time.Duration) error {
-    // Заглушка для применения политики удержания
-    return nil
+    if retention <= 0 {
+        return errors.New("retention must be positive")
+    }
+    cfg, err := m.GetConfiguration(bucket)
+    if errors.Is(err, storage.ErrNotFound) {
+        cfg, err = &Configuration{}, nil
+    }
+    if err != nil {
+        return err
+    }
+    rule := Rule{
+        ID:         "retention-policy",
+        Status:     StatusEnabled,
+        Expiration: &Expiration{Days: int(math.Ceil(retention.Hours() / 24))},
+    }
+    replaced := false
+    for i := range cfg.Rules {
+        if cfg.Rules[i].ID == rule.ID {
+            cfg.Rules[i], replaced = rule, true
+        }
+    }
+    if !replaced {
+        cfg.Rules = append(cfg.Rules, rule)
+    }
+    if err := m.PutConfiguration(bucket, cfg); err != nil {
+        return err
+    }
+    _, err = m.run(ctx, bucket, func(a Action) bool { return a.RuleID == rule.ID })
+    return err
 }
 
+// ExpireObjects applies the expiration actions of the bucket's rules.
 func (m *LifecycleManager) ExpireObjects(ctx context.Context, bucket string) error {
-    // Заглушка для удаления устаревших объектов
-    return nil
+    _, err := m.run(ctx, bucket, func(a Action) bool { return a.Type != ActionTransition })
+    return err
 }
 
+// TransitionObjects applies the bucket's transitions into newStorageClass.
 func (m *LifecycleManager) TransitionObjects(ctx context.Context, bucket string, newStorageClass string) error {
-    // Заглушка для перехода объектов в другой класс хранения
+    _, err := m.run(ctx, bucket, func(a Action) bool {
+        return a.Type == ActionTransition && a.StorageClass == newStorageClass
+    })
+    return err
+}
+
+// Apply evaluates every rule of the bucket and executes the resulting actions.
+func (m *LifecycleManager) Apply(ctx context.Context, bucket string) (*Report, error) {
+    return m.run(ctx, bucket, nil)
+}
+
+// DryRun reports the actions cfg would take on the bucket without changing
+// anything. A nil cfg evaluates the bucket's stored configuration. If ruleID
+// is set only that rule is evaluated, even while it is still disabled.
+func (m *LifecycleManager) DryRun(ctx context.Context, bucket string, cfg *Configuration, ruleID string) (*Report, error) {
+    var err error
+    if cfg == nil {
+        if cfg, err = m.GetConfiguration(bucket); err != nil {
+            return nil, err
+        }
+    } else if err := cfg.Validate(); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidConfiguration, err)
+    }
+    if ruleID != "" {
+        var selected *Rule
+        for _, r := range cfg.Rules {
+            if r.ID == ruleID {
+                r.Status = StatusEnabled
+                selected = &r
+            }
+        }
+        if selected == nil {
+            return nil, fmt.Errorf("%w: no rule %q", ErrInvalidConfiguration, ruleID)
+        }
+        cfg = &Configuration{Rules: []Rule{*selected}}
+    }
+    return m.evaluate(ctx, bucket, cfg, nil, true)
+}
+
+// run applies the bucket's stored configuration, executing the actions
+// accepted by include.
+func (m *LifecycleManager) run(ctx context.Context, bucket string, include func(Action) bool) (*Report, error) {
+    cfg, err := m.GetConfiguration(bucket)
+    if err != nil {
+        return nil, err
+    }
+    return m.evaluate(ctx, bucket, cfg, include, false)
+}
+
+// evaluate walks the bucket in batches, evaluating the rules against each
+// batch and executing the actions accepted by include before moving on. A dry
+// run only checks whether each action would be blocked by object lock.
+func (m *LifecycleManager) evaluate(ctx context.Context, bucket string, cfg *Configuration, include func(Action) bool, dryRun bool) (*Report, error) {
+    report := &Report{Bucket: bucket, DryRun: dryRun, Actions: []Action{}}
+    now := time.Now()
+    apply := m.execute
+    if dryRun {
+        apply = m.check
+    }
+    record := func(a Action, result string) {
+        if !dryRun {
+            metrics.LifecycleActions.WithLabelValues(string(a.Type), result).Inc()
+        }
+    }
+
+    process := func(actions []Action) error {
+        for _, a := range actions {
+            if include != nil && !include(a) {
+                continue
+            }
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            err := apply(ctx, a)
+            switch {
+            case err == nil:
+                record(a, "ok")
+                report.Actions = append(report.Actions, a)
+            case errors.Is(err, storage.ErrObjectLocked):
+                record(a, "locked")
+                report.Skipped = append(report.Skipped, a)
+            case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrNoSuchUpload):
+                // Changed underneath us since it was listed.
+            default:
+                record(a, "error")
+                return err
+            }
+        }
+        return nil
+    }
+
+    uploads, err := m.store.ListMultipartUploads(bucket)
+    if err != nil {
+        return nil, err
+    }
+    if err := process(evaluateUploads(cfg, uploads, now)); err != nil {
+        return report, err
+    }
+
+    startAfter := ""
+    for {
+        if err := ctx.Err(); err != nil {
+            return report, err
+        }
+        batch, err := m.store.ListObjects(bucket, startAfter, m.batchSize)
+        if err != nil {
+            return report, err
+        }
+        for _, info := range batch {
+            if err := process(evaluateObject(cfg, info, now)); err != nil {
+                return report, err
+            }
+        }
+        if len(batch) < m.batchSize {
+            return report, nil
+        }
+        startAfter = batch[len(batch)-1].Key
+    }
+}
+
+func (m *LifecycleManager) execute(ctx context.Context, a Action) error {
+    switch a.Type {
+    case ActionExpire:
+        return m.store.RemoveObject(ctx, a.Bucket, a.Key, "lifecycle:"+a.RuleID, false)
+    case ActionExpireNoncurrent, ActionRemoveDeleteMarker:
+        return m.store.DeleteObjectVersion(ctx, a.Bucket, a.Key, a.VersionID, false)
+    case ActionAbortMultipart:
+        return m.store.AbortMultipartUpload(a.Bucket, a.UploadID)
+    case ActionTransition:
+        return m.store.TransitionObjectVersion(ctx, a.Bucket, a.Key, a.VersionID, a.StorageClass)
+    }
+    return errors.New("unknown lifecycle action " + string(a.Type))
+}
+
+// check returns the error execute would fail a with because of object lock.
+func (m *LifecycleManager) check(ctx context.Context, a Action) error {
+    switch a.Type {
+    case ActionExpire:
+        status, err := m.store.GetBucketVersioning(a.Bucket)
+        if err != nil {
+            return err
+        }
+        // With versioning enabled expiring only adds a delete marker.
+        if status == storage.VersioningEnabled {
+            return nil
+        }
+        return m.store.CheckObjectLock(a.Bucket, a.Key, storage.NullVersionID)
+    case ActionExpireNoncurrent, ActionRemoveDeleteMarker:
+        return m.store.CheckObjectLock(a.Bucket, a.Key, a.VersionID)
+    }
     return nil
 }
+
+// evaluateObject returns the actions the enabled rules take on one key. When
+// several rules expire the same version, only the first one does.
+func evaluateObject(cfg *Configuration, info storage.ObjectInfo, now time.Time) []Action {
+    var actions []Action
+    latest, ok := info.Latest()
+    if !ok {
+        return nil
+    }
+    expired := make(map[string]bool)
+    expire := func(a Action) {
+        if !expired[a.VersionID] {
+            expired[a.VersionID] = true
+            actions = append(actions, a)
+        }
+    }
+    for _, r := range cfg.Rules {
+        if r.Status != StatusEnabled {
+            continue
+        }
+        action := Action{RuleID: r.ID, Bucket: info.Bucket, Key: info.Key}
+
+        if e := r.Expiration; e != nil {
+            if latest.IsDeleteMarker {
+                // A marker with nothing underneath it no longer hides anything.
+                if e.ExpiredObjectDeleteMarker && len(info.Versions) == 1 && r.Filter.matchesKey(info.Key) {
+                    a := action
+                    a.Type, a.VersionID = ActionRemoveDeleteMarker, latest.VersionID
+                    expire(a)
+                }
+            } else if r.Filter.matches(info.Key, latest) && due(latest.LastModified, e.Days, e.Date, now) {
+                a := action
+                a.Type, a.VersionID, a.Size = ActionExpire, latest.VersionID, latest.Size
+                expire(a)
+            }
+        }
+
+        if t := latestTransition(r.Transitions, latest, now); t != nil && !latest.IsDeleteMarker &&
+            r.Filter.matches(info.Key, latest) && storage.IsColderStorageClass(latest.StorageClass, t.StorageClass) {
+            a := action
+            a.Type, a.VersionID, a.Size, a.StorageClass = ActionTransition, latest.VersionID, latest.Size, t.StorageClass
+            actions = append(actions, a)
+        }
+
+        if n := r.NoncurrentVersionExpiration; n != nil {
+            kept := 0
+            for i := 1; i < len(info.Versions); i++ {
+                v := info.Versions[i]
+                matched := r.Filter.matches(info.Key, v)
+                if v.IsDeleteMarker {
+                    // Markers have no size or tags; only the prefix applies.
+                    matched = r.Filter.matchesKey(info.Key)
+                }
+                if !matched {
+                    continue
+                }
+                if kept < n.NewerNoncurrentVersions {
+                    kept++
+                    continue
+                }
+                // A version becomes noncurrent when the next one is written.
+                if due(info.Versions[i-1].LastModified, n.NoncurrentDays, nil, now) {
+                    a := action
+                    a.Type, a.VersionID, a.Size = ActionExpireNoncurrent, v.VersionID, v.Size
+                    expire(a)
+                }
+            }
+        }
+    }
+    return actions
+}
+
+// latestTransition returns the furthest transition of a rule that is due.
+func latestTransition(transitions []Transition, v storage.ObjectVersion, now time.Time) *Transition {
+    var best *Transition
+    for i := range transitions {
+        t := &transitions[i]
+        if !due(v.LastModified, t.Days, t.Date, now) {
+            continue
+        }
+        if best == nil || t.Days > best.Days || (t.Date != nil && best.Date != nil && t.Date.After(*best.Date)) {
+            best = t
+        }
+    }
+    return best
+}
+
+func evaluateUploads(cfg *Configuration, uploads []storage.MultipartUpload, now time.Time) []Action {
+    var actions []Action
+    for _, u := range uploads {
+        for _, r := range cfg.Rules {
+            a := r.AbortIncompleteMultipartUpload
+            if r.Status != StatusEnabled || a == nil || !r.Filter.matchesKey(u.Key) {
+                continue
+            }
+            if due(u.Initiated, a.DaysAfterInitiation, nil, now) {
+                actions = append(actions, Action{
+                    Type:     ActionAbortMultipart,
+                    RuleID:   r.ID,
+                    Bucket:   u.Bucket,
+                    Key:      u.Key,
+                    UploadID: u.UploadID,
+                })
+                break
+            }
+        }
+    }
+    return actions
+}
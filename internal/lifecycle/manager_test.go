@@ -0,0 +1,144 @@
+package lifecycle
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestEvaluateObject_NoncurrentVersions(t *testing.T) {
+    now := time.Now()
+    day := 24 * time.Hour
+    info := storage.ObjectInfo{
+        Bucket: "b",
+        Key:    "logs/app",
+        Versions: []storage.ObjectVersion{
+            {VersionID: "v3", LastModified: now.Add(-1 * day)},
+            {VersionID: "v2", LastModified: now.Add(-10 * day)},
+            {VersionID: "v1", LastModified: now.Add(-20 * day)},
+        },
+    }
+    cfg := &Configuration{Rules: []Rule{{
+        ID:                          "noncurrent",
+        Status:                      StatusEnabled,
+        Filter:                      Filter{Prefix: "logs/"},
+        NoncurrentVersionExpiration: &NoncurrentVersionExpiration{NoncurrentDays: 5},
+    }}}
+
+    // v2 only became noncurrent a day ago, when v3 was written.
+    actions := evaluateObject(cfg, info, now)
+    require.Len(t, actions, 1)
+    assert.Equal(t, ActionExpireNoncurrent, actions[0].Type)
+    assert.Equal(t, "v1", actions[0].VersionID)
+
+    cfg.Rules[0].Filter.Prefix = "tmp/"
+    assert.Empty(t, evaluateObject(cfg, info, now))
+}
+
+func TestEvaluateObject_NoncurrentDeleteMarkersHonourPrefix(t *testing.T) {
+    now := time.Now()
+    day := 24 * time.Hour
+    info := storage.ObjectInfo{
+        Bucket: "b",
+        Key:    "data/app",
+        Versions: []storage.ObjectVersion{
+            {VersionID: "v3", LastModified: now.Add(-10 * day)},
+            {VersionID: "marker", LastModified: now.Add(-20 * day), IsDeleteMarker: true},
+            {VersionID: "v1", LastModified: now.Add(-30 * day), Size: 10},
+        },
+    }
+    cfg := &Configuration{Rules: []Rule{{
+        ID:                          "logs",
+        Status:                      StatusEnabled,
+        Filter:                      Filter{Prefix: "logs/", ObjectSizeGreaterThan: 5},
+        NoncurrentVersionExpiration: &NoncurrentVersionExpiration{NoncurrentDays: 5},
+    }}}
+    assert.Empty(t, evaluateObject(cfg, info, now))
+
+    // Within the prefix, markers expire despite the size filter.
+    info.Key = "logs/app"
+    var expired []string
+    for _, a := range evaluateObject(cfg, info, now) {
+        expired = append(expired, a.VersionID)
+    }
+    assert.ElementsMatch(t, []string{"marker", "v1"}, expired)
+}
+
+func TestApply_SkipsLockedObjects(t *testing.T) {
+    store, err := storage.NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObjectLockConfiguration("b", storage.ObjectLockConfiguration{Enabled: true}))
+    require.NoError(t, store.PutObject("b", "free", []byte("data")))
+    require.NoError(t, store.PutObject("b", "held", []byte("data")))
+    require.NoError(t, store.PutObjectLegalHold("b", "held", "", true))
+
+    past := time.Now().Add(-time.Hour)
+    m := NewLifecycleManager(store)
+    require.NoError(t, m.PutConfiguration("b", &Configuration{Rules: []Rule{{
+        ID:         "expire-all",
+        Status:     StatusEnabled,
+        Expiration: &Expiration{Date: &past},
+    }}}))
+
+    candidate := &Configuration{Rules: []Rule{{
+        ID:         "expire-all",
+        Status:     StatusDisabled,
+        Expiration: &Expiration{Date: &past},
+    }}}
+    plan, err := m.DryRun(context.Background(), "b", candidate, "expire-all")
+    require.NoError(t, err)
+    assert.True(t, plan.DryRun)
+    require.Len(t, plan.Actions, 1)
+    assert.Equal(t, "free", plan.Actions[0].Key)
+    assert.Equal(t, int64(4), plan.Actions[0].Size)
+    require.Len(t, plan.Skipped, 1)
+    _, err = store.GetObject("b", "free")
+    require.NoError(t, err)
+
+    report, err := m.Apply(context.Background(), "b")
+    require.NoError(t, err)
+    require.Len(t, report.Actions, 1)
+    assert.Equal(t, "free", report.Actions[0].Key)
+    require.Len(t, report.Skipped, 1)
+    assert.Equal(t, "held", report.Skipped[0].Key)
+
+    _, err = store.GetObject("b", "free")
+    assert.ErrorIs(t, err, storage.ErrNotFound)
+    _, err = store.GetObject("b", "held")
+    assert.NoError(t, err)
+}
+
+func TestApply_ExpiresOnceThroughTheTrash(t *testing.T) {
+    ctx := context.Background()
+    store, err := storage.NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutTrashConfiguration("b", storage.TrashConfiguration{RetentionDays: 7}))
+    require.NoError(t, store.PutObject("b", "logs/app", []byte("data")))
+
+    past := time.Now().Add(-time.Hour)
+    m := NewLifecycleManager(store)
+    require.NoError(t, m.PutConfiguration("b", &Configuration{Rules: []Rule{
+        {ID: "all", Status: StatusEnabled, Expiration: &Expiration{Date: &past}},
+        {ID: "logs", Status: StatusEnabled, Filter: Filter{Prefix: "logs/"}, Expiration: &Expiration{Date: &past}},
+    }}))
+
+    report, err := m.Apply(ctx, "b")
+    require.NoError(t, err)
+    require.Len(t, report.Actions, 1)
+    assert.Equal(t, "all", report.Actions[0].RuleID)
+
+    _, err = store.GetObject("b", "logs/app")
+    assert.ErrorIs(t, err, storage.ErrNotFound)
+    entries, err := store.ListTrash(ctx, "b")
+    require.NoError(t, err)
+    require.Len(t, entries, 1)
+    assert.Equal(t, "lifecycle:all", entries[0].DeletedBy)
+}
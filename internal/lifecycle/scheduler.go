@@ -0,0 +1,52 @@
+package lifecycle
+
+import (
+    "context"
+    "log"
+    "time"
+)
+
+const DefaultInterval = time.Hour
+
+// Scheduler periodically applies the lifecycle rules of every configured
+// bucket.
+type Scheduler struct {
+    manager  *LifecycleManager
+    interval time.Duration
+}
+
+func NewScheduler(manager *LifecycleManager, interval time.Duration) *Scheduler {
+    return &Scheduler{manager: manager, interval: interval}
+}
+
+func (s *Scheduler) Run(ctx context.Context) error {
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-ticker.C:
+            s.runOnce(ctx)
+        }
+    }
+}
+
+func (s *Scheduler) runOnce(ctx context.Context) {
+    buckets, err := s.manager.ConfiguredBuckets()
+    if err != nil {
+        log.Printf("Lifecycle: listing configured buckets failed: %v", err)
+        return
+    }
+    for _, bucket := range buckets {
+        report, err := s.manager.Apply(ctx, bucket)
+        if err != nil {
+            log.Printf("Lifecycle: bucket %s failed: %v", bucket, err)
+            continue
+        }
+        if len(report.Actions) > 0 || len(report.Skipped) > 0 {
+            log.Printf("Lifecycle: bucket %s: %d actions taken, %d skipped (locked)", bucket, len(report.Actions), len(report.Skipped))
+        }
+    }
+}
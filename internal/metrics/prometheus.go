@@ -6,19 +6,91 @@ import (
     "github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var LifecycleActions = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "securedag_lifecycle_actions_total",
+        Help: "Lifecycle actions taken, by action and result",
+    },
+    []string{"action", "result"},
+)
+
+var GCReclaimedBytes = prometheus.NewCounter(
+    prometheus.CounterOpts{
+        Name: "securedag_gc_reclaimed_bytes_total",
+        Help: "Bytes of unreferenced blocks deleted by garbage collection",
+    },
+)
+
+var GCDeletedBlocks = prometheus.NewCounter(
+    prometheus.CounterOpts{
+        Name: "securedag_gc_deleted_blocks_total",
+        Help: "Unreferenced blocks deleted by garbage collection",
+    },
+)
+
+var ScrubbedBytes = prometheus.NewCounter(
+    prometheus.CounterOpts{
+        Name: "securedag_scrub_bytes_total",
+        Help: "Bytes of blocks re-read and verified by the scrubber",
+    },
+)
+
+var ScrubFindings = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "securedag_scrub_findings_total",
+        Help: "Problems found by the scrubber, by kind",
+    },
+    []string{"finding"},
+)
+
+var ScrubRepairs = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "securedag_scrub_repairs_total",
+        Help: "Repairs attempted by the scrubber, by action and result",
+    },
+    []string{"action", "result"},
+)
+
+var ProvideQueueLength = prometheus.NewGauge(
+    prometheus.GaugeOpts{
+        Name: "securedag_provide_queue_length",
+        Help: "Blocks waiting to be announced as DHT provider records",
+    },
+)
+
+var ProvideFailures = prometheus.NewCounter(
+    prometheus.CounterOpts{
+        Name: "securedag_provide_failures_total",
+        Help: "Provider record announcements that failed",
+    },
+)
+
+var ActiveNodes = prometheus.NewGauge(
+    prometheus.GaugeOpts{
+        Name: "securedag_active_nodes",
+        Help: "Number of active nodes in the network",
+    },
+)
+
+var SyncConflicts = prometheus.NewCounter(
+    prometheus.CounterOpts{
+        Name: "securedag_sync_conflicts_total",
+        Help: "Concurrent writes to the same object found while replicating between nodes",
+    },
+)
+
 func RegisterMetrics() {
+    prometheus.MustRegister(LifecycleActions)
+    prometheus.MustRegister(GCReclaimedBytes, GCDeletedBlocks)
+    prometheus.MustRegister(ScrubbedBytes, ScrubFindings, ScrubRepairs)
+    prometheus.MustRegister(ProvideQueueLength, ProvideFailures)
+    prometheus.MustRegister(ActiveNodes, SyncConflicts)
     prometheus.MustRegister(prometheus.NewCounter(
         prometheus.CounterOpts{
             Name: "securedag_operations_total",
             Help: "Total number of operations performed",
         },
     ))
-    prometheus.MustRegister(prometheus.NewGauge(
-        prometheus.GaugeOpts{
-            Name: "securedag_active_nodes",
-            Help: "Number of active nodes in the network",
-        },
-    ))
 }
 
 func ExposeMetrics() {
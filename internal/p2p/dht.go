@@ -2,26 +2,125 @@ package p2p
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
-	"github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
 )
 
-func NewDHT(ctx context.Context) (*dht.IpfsDHT, host.Host, error) {
-	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+// Namespace prefixes the protocols nodes speak. The DHT runs as
+// /securedag/kad/1.0.0, so nodes form their own network rather than joining
+// the public IPFS one.
+const Namespace = "securedag"
+
+const dialTimeout = 10 * time.Second
+
+var DefaultListenAddrs = []string{"/ip4/0.0.0.0/tcp/4001"}
+
+// Config configures a node's libp2p host. Identity keeps the peer ID stable
+// across restarts; a nil Identity gets a fresh key. BootstrapPeers are
+// multiaddrs ending in /p2p/<peer ID>. A node with a PSK only connects to
+// nodes holding the same key, so that no outsider can speak any of the
+// cluster's protocols.
+type Config struct {
+	Identity       crypto.PrivKey
+	ListenAddrs    []string
+	BootstrapPeers []string
+	PSK            pnet.PSK
+}
+
+
+// Node is a libp2p host running a DHT server. The DHT only carries
+// provider records; blocks themselves travel over the block exchange.
+type Node struct {
+	Host host.Host
+	DHT  *dht.IpfsDHT
+}
+
+// NewNode starts a host listening on the configured addresses, connects it
+// to the bootstrap peers and bootstraps the DHT. Peers that cannot be
+// reached are logged and retried by the DHT whenever its routing table
+// runs empty, so the first node of a cluster can start on its own.
+func NewNode(ctx context.Context, cfg Config) (*Node, error) {
+	bootstrap := make([]peer.AddrInfo, 0, len(cfg.BootstrapPeers))
+	for _, addr := range cfg.BootstrapPeers {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %q: %w", addr, err)
+		}
+		bootstrap = append(bootstrap, *info)
+	}
+
+	listen := cfg.ListenAddrs
+	if len(listen) == 0 {
+		listen = DefaultListenAddrs
+	}
+	opts := []libp2p.Option{libp2p.ListenAddrStrings(listen...)}
+	if cfg.Identity != nil {
+		opts = append(opts, libp2p.Identity(cfg.Identity))
+	}
+	if cfg.PSK != nil {
+		opts = append(opts, libp2p.PrivateNetwork(cfg.PSK))
+	}
+	h, err := libp2p.New(opts...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeServer))
+	kadDHT, err := dht.New(ctx, h,
+		dht.Mode(dht.ModeServer),
+		dht.ProtocolPrefix("/"+Namespace),
+		dht.DisableValues(),
+		dht.BootstrapPeers(bootstrap...),
+	)
 	if err != nil {
-		return nil, nil, err
+		h.Close()
+		return nil, err
+	}
+	n := &Node{Host: h, DHT: kadDHT}
+
+	n.connect(ctx, bootstrap)
+	if err := kadDHT.Bootstrap(ctx); err != nil {
+		n.Close()
+		return nil, err
 	}
+	return n, nil
+}
 
-	if err = kadDHT.Bootstrap(ctx); err != nil {
-		return nil, nil, err
+func (n *Node) connect(ctx context.Context, peers []peer.AddrInfo) {
+	var wg sync.WaitGroup
+	for _, info := range peers {
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+			defer cancel()
+			if err := n.Host.Connect(ctx, info); err != nil {
+				log.Printf("Failed to connect to bootstrap peer %s: %v", info.ID, err)
+			}
+		}(info)
 	}
+	wg.Wait()
+}
+
+// Addrs returns the addresses other nodes can bootstrap from.
+func (n *Node) Addrs() []string {
+	var addrs []string
+	for _, a := range n.Host.Addrs() {
+		addrs = append(addrs, fmt.Sprintf("%s/p2p/%s", a, n.Host.ID()))
+	}
+	return addrs
+}
 
-	return kadDHT, h, nil
+// Close stops the DHT and shuts the host down.
+func (n *Node) Close() error {
+	return errors.Join(n.DHT.Close(), n.Host.Close())
 }
@@ -2,12 +2,16 @@ package p2p
 
 import (
     "context"
+    "errors"
     "log"
     dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/Alyanaky/SecureDAG/internal/dag"
     "github.com/ipfs/go-cid"
-    "github.com/multiformats/go-multihash"
 )
 
+// ErrNoDHT is returned by lookups made before a DHT is attached.
+var ErrNoDHT = errors.New("no DHT configured")
+
 type DHTOperations struct {
     dht *dht.IpfsDHT
 }
@@ -16,18 +20,18 @@ func NewDHTOperations(dht *dht.IpfsDHT) *DHTOperations {
     return &DHTOperations{dht: dht}
 }
 
+// ReplicateData checks how many peers provide the content stored under key.
+// The content itself is fetched over the block exchange.
 func (ops *DHTOperations) ReplicateData(ctx context.Context, key string, data []byte) error {
-    err := ops.dht.PutValue(ctx, key, data)
-    if err != nil {
-        return err
+    if ops.dht == nil {
+        return ErrNoDHT
     }
 
-    // Convert string key to CID
-    mh, err := multihash.Sum([]byte(key), multihash.SHA2_256, -1)
+    // Providers are looked up by the CID of the content, not of its key
+    cidKey, err := dag.NewCID(dag.CodecRaw, data)
     if err != nil {
         return err
     }
-    cidKey := cid.NewCidV1(cid.Raw, mh)
 
     providers, err := ops.dht.FindProviders(ctx, cidKey)
     if err != nil {
@@ -40,3 +44,12 @@ func (ops *DHTOperations) ReplicateData(ctx context.Context, key string, data []
 
     return nil
 }
+
+// CountProviders returns the number of peers that announce they hold c.
+func (ops *DHTOperations) CountProviders(ctx context.Context, c cid.Cid) (int, error) {
+    if ops.dht == nil {
+        return 0, ErrNoDHT
+    }
+    providers, err := ops.dht.FindProviders(ctx, c)
+    return len(providers), err
+}
@@ -0,0 +1,91 @@
+package p2p
+
+import (
+    "context"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func startNode(t *testing.T, ctx context.Context, bootstrap ...string) *Node {
+    t.Helper()
+    n, err := NewNode(ctx, Config{
+        ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+        BootstrapPeers: bootstrap,
+    })
+    require.NoError(t, err)
+    t.Cleanup(func() { n.Close() })
+    return n
+}
+
+// startCluster starts n nodes on loopback, all bootstrapped from the first,
+// and waits until each knows every other.
+func startCluster(t *testing.T, ctx context.Context, n int) []*Node {
+    t.Helper()
+    nodes := []*Node{startNode(t, ctx)}
+    for len(nodes) < n {
+        nodes = append(nodes, startNode(t, ctx, nodes[0].Addrs()...))
+    }
+    for _, node := range nodes {
+        node := node
+        require.Eventually(t, func() bool {
+            return node.DHT.RoutingTable().Size() == n-1
+        }, 10*time.Second, 50*time.Millisecond)
+    }
+    return nodes
+}
+
+func TestNode_LoopbackCluster(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    // The later nodes learn about each other through the first.
+    nodes := startCluster(t, ctx, 3)
+    assert.NotEqual(t, nodes[1].Host.ID(), nodes[2].Host.ID())
+}
+
+func TestNode_PrivateNetwork(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    start := func(psk string, bootstrap ...string) *Node {
+        n, err := NewNode(ctx, Config{
+            ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+            BootstrapPeers: bootstrap,
+            PSK:            []byte(strings.Repeat(psk, 32)),
+        })
+        require.NoError(t, err)
+        t.Cleanup(func() { n.Close() })
+        return n
+    }
+    a := start("a")
+    b := start("a", a.Addrs()...)
+    assert.Equal(t, network.Connected, a.Host.Network().Connectedness(b.Host.ID()))
+
+    // A node without the cluster's key cannot connect at all.
+    outsider := start("b")
+    info, err := peer.AddrInfoFromString(a.Addrs()[0])
+    require.NoError(t, err)
+    dialCtx, cancelDial := context.WithTimeout(ctx, 2*time.Second)
+    defer cancelDial()
+    assert.Error(t, outsider.Host.Connect(dialCtx, *info))
+    assert.NotEqual(t, network.Connected, a.Host.Network().Connectedness(outsider.Host.ID()))
+}
+
+func TestNode_RejectsInvalidBootstrapPeer(t *testing.T) {
+    _, err := NewNode(context.Background(), Config{
+        ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+        BootstrapPeers: []string{"/ip4/127.0.0.1/tcp/4001"},
+    })
+    assert.Error(t, err)
+}
+
+func TestDHTOperations_WithoutDHT(t *testing.T) {
+    ops := NewDHTOperations(nil)
+    assert.ErrorIs(t, ops.ReplicateData(context.Background(), "k", []byte("v")), ErrNoDHT)
+}
@@ -0,0 +1,717 @@
+package p2p
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/ipfs/go-cid"
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// BlockProtocol is the stream protocol peers request and send blocks over.
+const BlockProtocol = protocol.ID("/" + Namespace + "/blocks/1.0.0")
+
+const (
+    // Window is how many wants a requester leaves unanswered on a stream
+    // before it waits for answers.
+    Window = 32
+    // MaxWantlist is how many unanswered wants a node queues per stream.
+    // Requesters that send more are disconnected.
+    MaxWantlist = 256
+    // MaxProviders is how many providers a block is requested from at once.
+    MaxProviders = 3
+    // PutQuota is how many bytes of pushed blocks a node stores for one
+    // member in each PutQuotaPeriod. Pushes beyond it are refused until the
+    // period is over.
+    PutQuota       = 256 << 20
+    PutQuotaPeriod = time.Minute
+    // StreamTimeout is how long either side of a stream waits for the
+    // other's next message while it expects one. A peer that takes longer
+    // is dropped, and its unanswered wants count as failed.
+    StreamTimeout = 30 * time.Second
+)
+
+var (
+    ErrBlockNotFound = errors.New("no peer provided the block")
+    ErrBlockRejected = errors.New("peer refused the block")
+    ErrPutQuota      = errors.New("peer exceeded its put quota")
+)
+
+// Blockstore is the local store a node serves blocks from and stores the
+// blocks that peers send it in. A pinned block is kept for as long as any
+// peer that pinned it has not unpinned it.
+type Blockstore interface {
+    GetBlock(ctx context.Context, c cid.Cid) ([]byte, error)
+    HasBlock(ctx context.Context, c cid.Cid) (bool, error)
+    PutBlock(ctx context.Context, c cid.Cid, data []byte) error
+    PinBlock(ctx context.Context, owner peer.ID, c cid.Cid, data []byte) error
+    UnpinBlock(ctx context.Context, owner peer.ID, c cid.Cid) error
+}
+
+// Exchange requests blocks from peers and answers their requests. Each
+// stream carries a wantlist that is answered in order; wants that are
+// cancelled before their turn are answered without sending the block.
+type Exchange struct {
+    host      host.Host
+    dht       *dht.IpfsDHT
+    store     Blockstore
+    placement *Placement
+    balancer  *LoadBalancer
+    timeout   time.Duration
+
+    mu      sync.Mutex
+    members func(peer.ID) bool
+    puts    map[peer.ID]*putUsage
+}
+
+// putUsage is how many bytes a peer pushed in the current quota period.
+type putUsage struct {
+    since time.Time
+    bytes int
+}
+
+// NewExchange serves store to the peers of n.
+func NewExchange(n *Node, store Blockstore) *Exchange {
+    e := &Exchange{host: n.Host, dht: n.DHT, store: store, timeout: StreamTimeout, puts: make(map[peer.ID]*putUsage)}
+    e.host.SetStreamHandler(BlockProtocol, e.handleStream)
+    return e
+}
+
+// Close stops answering requests.
+func (e *Exchange) Close() {
+    e.host.RemoveStreamHandler(BlockProtocol)
+}
+
+// SetPlacement makes GetBlocks ask the peers a block is placed on before
+// looking up its providers.
+func (e *Exchange) SetPlacement(p *Placement) {
+    e.placement = p
+}
+
+// SetLoadBalancer makes GetBlocks ask one peer for each block, chosen by
+// lb, before asking the others, and records every request in lb.
+func (e *Exchange) SetLoadBalancer(lb *LoadBalancer) {
+    e.balancer = lb
+}
+
+// SetMembers makes the exchange answer the peers that members reports as
+// part of the cluster, storing up to PutQuota bytes of the blocks each
+// pushes per PutQuotaPeriod. Until it is called no peer is answered.
+func (e *Exchange) SetMembers(members func(peer.ID) bool) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.members = members
+}
+
+func (e *Exchange) isMember(p peer.ID) bool {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    return e.members != nil && e.members(p)
+}
+
+// ID returns the peer ID the exchange answers requests as.
+func (e *Exchange) ID() peer.ID {
+    return e.host.ID()
+}
+
+// FindProviders returns up to MaxProviders other peers that announce they
+// hold c.
+func (e *Exchange) FindProviders(ctx context.Context, c cid.Cid) ([]peer.ID, error) {
+    if e.dht == nil {
+        return nil, ErrNoDHT
+    }
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    var providers []peer.ID
+    for info := range e.dht.FindProvidersAsync(ctx, c, MaxProviders+1) {
+        if info.ID != e.host.ID() && len(providers) < MaxProviders {
+            providers = append(providers, info.ID)
+        }
+    }
+    return providers, ctx.Err()
+}
+
+// GetBlock fetches c from the peers that provide it.
+func (e *Exchange) GetBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+    blocks, err := e.GetBlocks(ctx, []cid.Cid{c})
+    if err != nil {
+        return nil, err
+    }
+    data, ok := blocks[c]
+    if !ok {
+        return nil, ErrBlockNotFound
+    }
+    return data, nil
+}
+
+// GetBlocks fetches blocks from up to MaxProviders peers each, cancelling
+// the other wants once one of them answers. The peers a block is placed on
+// are asked first and the providers found in the DHT for whatever they
+// lack. Blocks no peer could supply are left out.
+func (e *Exchange) GetBlocks(ctx context.Context, cids []cid.Cid) (map[cid.Cid][]byte, error) {
+    blocks := make(map[cid.Cid][]byte)
+    if e.placement != nil {
+        candidates := make(map[cid.Cid][]peer.ID)
+        for _, c := range cids {
+            for _, p := range e.placement.Place(c, MaxProviders) {
+                if p != e.host.ID() {
+                    candidates[c] = append(candidates[c], p)
+                }
+            }
+        }
+        if err := e.fetchAny(ctx, candidates, blocks); err != nil {
+            return nil, err
+        }
+    }
+
+    candidates := make(map[cid.Cid][]peer.ID)
+    for _, c := range cids {
+        if _, ok := blocks[c]; ok {
+            continue
+        }
+        providers, err := e.FindProviders(ctx, c)
+        if err != nil {
+            return nil, err
+        }
+        candidates[c] = providers
+    }
+    err := e.fetchAny(ctx, candidates, blocks)
+    return blocks, err
+}
+
+// fetchAny fetches each block from one of its candidates into blocks. With
+// a load balancer, the candidate it selects is asked alone first.
+func (e *Exchange) fetchAny(ctx context.Context, candidates map[cid.Cid][]peer.ID, blocks map[cid.Cid][]byte) error {
+    asked := make(map[cid.Cid]peer.ID)
+    if e.balancer != nil {
+        wants := make(map[peer.ID][]cid.Cid)
+        for c, peers := range candidates {
+            if p, ok := e.balancer.Select(peers); ok {
+                wants[p] = append(wants[p], c)
+                asked[c] = p
+            }
+        }
+        fetched, err := e.fetch(ctx, wants, msgWantBlock)
+        for c, data := range fetched {
+            blocks[c] = data
+        }
+        if err != nil {
+            return err
+        }
+    }
+
+    wants := make(map[peer.ID][]cid.Cid)
+    for c, peers := range candidates {
+        if _, ok := blocks[c]; ok {
+            continue
+        }
+        for _, p := range peers {
+            if p != asked[c] {
+                wants[p] = append(wants[p], c)
+            }
+        }
+    }
+    fetched, err := e.fetch(ctx, wants, msgWantBlock)
+    for c, data := range fetched {
+        blocks[c] = data
+    }
+    return err
+}
+
+// FetchBlocks requests blocks from one peer.
+func (e *Exchange) FetchBlocks(ctx context.Context, p peer.ID, cids []cid.Cid) (map[cid.Cid][]byte, error) {
+    return e.fetch(ctx, map[peer.ID][]cid.Cid{p: cids}, msgWantBlock)
+}
+
+// HasBlocks asks p which of cids it holds.
+func (e *Exchange) HasBlocks(ctx context.Context, p peer.ID, cids []cid.Cid) (map[cid.Cid]bool, error) {
+    held, err := e.fetch(ctx, map[peer.ID][]cid.Cid{p: cids}, msgWantHave)
+    has := make(map[cid.Cid]bool, len(held))
+    for c := range held {
+        has[c] = true
+    }
+    return has, err
+}
+
+// HasBlock asks p whether it holds c.
+func (e *Exchange) HasBlock(ctx context.Context, p peer.ID, c cid.Cid) (bool, error) {
+    answer, err := e.request(ctx, p, message{Type: msgWantHave, CID: c})
+    return answer.Type == msgHave, err
+}
+
+// PutBlock sends a block to p for it to store.
+func (e *Exchange) PutBlock(ctx context.Context, p peer.ID, c cid.Cid, data []byte) error {
+    answer, err := e.request(ctx, p, message{Type: msgPut, CID: c, Data: data})
+    if err == nil && answer.Type != msgHave {
+        err = ErrBlockRejected
+    }
+    return err
+}
+
+// PinBlock sends a block to p for it to keep until UnpinBlock releases it.
+func (e *Exchange) PinBlock(ctx context.Context, p peer.ID, c cid.Cid, data []byte) error {
+    answer, err := e.request(ctx, p, message{Type: msgPin, CID: c, Data: data})
+    if err == nil && answer.Type != msgHave {
+        err = ErrBlockRejected
+    }
+    return err
+}
+
+// UnpinBlock releases a block pinned on p.
+func (e *Exchange) UnpinBlock(ctx context.Context, p peer.ID, c cid.Cid) error {
+    answer, err := e.request(ctx, p, message{Type: msgUnpin, CID: c})
+    if err == nil && answer.Type != msgHave {
+        err = ErrBlockRejected
+    }
+    return err
+}
+
+// request sends a single want and waits for its answer.
+func (e *Exchange) request(ctx context.Context, p peer.ID, m message) (message, error) {
+    s, err := e.host.NewStream(ctx, p, BlockProtocol)
+    if err != nil {
+        return message{}, err
+    }
+    defer s.Close()
+    s.SetDeadline(time.Now().Add(e.timeout))
+    stop := context.AfterFunc(ctx, func() { s.Reset() })
+    defer stop()
+
+    r := e.balancer.Begin(p)
+    if err := writeMessage(s, m); err != nil {
+        s.Reset()
+        r.Fail()
+        return message{}, err
+    }
+    s.CloseWrite()
+    answer, err := readMessage(bufio.NewReader(s))
+    if err == nil && (!answer.isAnswer() || !answer.CID.Equals(m.CID)) {
+        err = ErrMalformedMessage
+    }
+    switch {
+    case ctx.Err() != nil:
+        r.Abandon()
+        err = ctx.Err()
+    case err != nil:
+        r.Fail()
+    default:
+        r.Succeed()
+    }
+    return answer, err
+}
+
+// answer is a peer's reply to a want, or a stand-in for one that was never
+// sent or never answered.
+type answer struct {
+    peer peer.ID
+    message
+}
+
+// fetch sends each peer its wants over one stream and collects the
+// verified blocks. Wants of type msgWantHave collect the CIDs of held
+// blocks, with nil data.
+func (e *Exchange) fetch(ctx context.Context, wants map[peer.ID][]cid.Cid, want byte) (map[cid.Cid][]byte, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    // A peer answers each CID once, however often it is wanted.
+    total := 0
+    pending := make(map[cid.Cid]int)
+    for p, cids := range wants {
+        seen := make(map[cid.Cid]bool, len(cids))
+        unique := make([]cid.Cid, 0, len(cids))
+        for _, c := range cids {
+            if !seen[c] {
+                seen[c] = true
+                unique = append(unique, c)
+                pending[c]++
+            }
+        }
+        wants[p] = unique
+        total += len(unique)
+    }
+    // Every want yields at most one answer, so senders never block.
+    answers := make(chan answer, total)
+    blocks := make(map[cid.Cid][]byte)
+    var mu sync.Mutex
+    have := func(c cid.Cid) bool {
+        mu.Lock()
+        defer mu.Unlock()
+        _, ok := blocks[c]
+        return ok
+    }
+
+    sessions := make(map[peer.ID]*session)
+    for p, cids := range wants {
+        s, err := e.openSession(ctx, p, answers)
+        if err != nil {
+            if ctx.Err() == nil {
+                e.balancer.Begin(p).Fail()
+            }
+            log.Printf("Failed to open block exchange with %s: %v", p, err)
+            for _, c := range cids {
+                answers <- answer{peer: p, message: message{Type: msgDontHave, CID: c}}
+            }
+            continue
+        }
+        defer s.close()
+        sessions[p] = s
+        go s.pump(ctx, want, cids, have)
+    }
+
+    for remaining := len(pending); remaining > 0; {
+        var a answer
+        select {
+        case a = <-answers:
+        case <-ctx.Done():
+            return blocks, ctx.Err()
+        }
+        if have(a.CID) {
+            continue
+        }
+        if a.Type == msgHave && want == msgWantHave {
+            mu.Lock()
+            blocks[a.CID] = nil
+            mu.Unlock()
+            remaining--
+            continue
+        }
+        if a.Type == msgBlock && want == msgWantBlock {
+            if err := dag.VerifyCID(a.CID, a.Data); err != nil {
+                log.Printf("Discarding block from %s: %v", a.peer, err)
+            } else {
+                mu.Lock()
+                blocks[a.CID] = a.Data
+                mu.Unlock()
+                remaining--
+                for p, s := range sessions {
+                    if p != a.peer {
+                        s.cancel(a.CID)
+                    }
+                }
+                continue
+            }
+        }
+        if pending[a.CID]--; pending[a.CID] == 0 {
+            remaining--
+        }
+    }
+    return blocks, nil
+}
+
+// session is a stream of wants to one peer. At most Window wants are
+// outstanding at a time.
+type session struct {
+    peer    peer.ID
+    stream  network.Stream
+    answers chan<- answer
+    credit  chan struct{}
+    lb      *LoadBalancer
+    timeout time.Duration
+
+    mu          sync.Mutex
+    w           *bufio.Writer
+    outstanding map[cid.Cid]*Request
+    dead        bool
+}
+
+func (e *Exchange) openSession(ctx context.Context, p peer.ID, answers chan<- answer) (*session, error) {
+    stream, err := e.host.NewStream(ctx, p, BlockProtocol)
+    if err != nil {
+        return nil, err
+    }
+    s := &session{
+        peer:        p,
+        stream:      stream,
+        answers:     answers,
+        credit:      make(chan struct{}, Window),
+        lb:          e.balancer,
+        timeout:     e.timeout,
+        w:           bufio.NewWriter(stream),
+        outstanding: make(map[cid.Cid]*Request),
+    }
+    go s.receive()
+    return s, nil
+}
+
+// pump sends the wants of cids that are still needed as the window allows.
+func (s *session) pump(ctx context.Context, want byte, cids []cid.Cid, have func(cid.Cid) bool) {
+    for _, c := range cids {
+        select {
+        case s.credit <- struct{}{}:
+        case <-ctx.Done():
+            return
+        }
+        s.mu.Lock()
+        if s.dead || have(c) {
+            s.mu.Unlock()
+            <-s.credit
+            s.answers <- answer{peer: s.peer, message: message{Type: msgDontHave, CID: c}}
+            continue
+        }
+        s.outstanding[c] = s.lb.Begin(s.peer)
+        err := s.send(message{Type: want, CID: c})
+        s.mu.Unlock()
+        if err != nil {
+            s.fail()
+        }
+    }
+}
+
+// receive passes answers on until the stream ends or the peer leaves its
+// wants unanswered for too long, then gives up on those still outstanding.
+func (s *session) receive() {
+    r := bufio.NewReader(s.stream)
+    for {
+        s.stream.SetReadDeadline(time.Now().Add(s.timeout))
+        m, err := readMessage(r)
+        if err != nil || !m.isAnswer() {
+            s.fail()
+            return
+        }
+        s.mu.Lock()
+        r, expected := s.outstanding[m.CID]
+        delete(s.outstanding, m.CID)
+        s.mu.Unlock()
+        if !expected {
+            continue
+        }
+        r.Succeed()
+        <-s.credit
+        s.answers <- answer{peer: s.peer, message: m}
+    }
+}
+
+func (s *session) fail() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.dead {
+        return
+    }
+    s.dead = true
+    s.stream.Reset()
+    for c, r := range s.outstanding {
+        r.Fail()
+        <-s.credit
+        s.answers <- answer{peer: s.peer, message: message{Type: msgDontHave, CID: c}}
+    }
+    s.outstanding = nil
+}
+
+// cancel withdraws the want for c if it is still unanswered.
+func (s *session) cancel(c cid.Cid) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    r, ok := s.outstanding[c]
+    if !s.dead && ok {
+        r.Abandon()
+        s.send(message{Type: msgCancel, CID: c})
+    }
+}
+
+func (s *session) send(m message) error {
+    if err := writeMessage(s.w, m); err != nil {
+        return err
+    }
+    return s.w.Flush()
+}
+
+// close ends the session. Wants still outstanding are abandoned rather
+// than failed, as the peer did nothing wrong.
+func (s *session) close() {
+    s.mu.Lock()
+    for _, r := range s.outstanding {
+        r.Abandon()
+    }
+    s.mu.Unlock()
+    s.stream.Reset()
+}
+
+// handleStream answers the wants a member sends on s in order.
+func (e *Exchange) handleStream(s network.Stream) {
+    from := s.Conn().RemotePeer()
+    if !e.isMember(from) {
+        s.Reset()
+        return
+    }
+    wants := newWantlist()
+    go func() {
+        defer wants.close()
+        r := bufio.NewReader(s)
+        for {
+            s.SetReadDeadline(time.Now().Add(e.timeout))
+            m, err := readMessage(r)
+            if err != nil {
+                return
+            }
+            switch {
+            case m.Type == msgCancel:
+                wants.cancel(m.CID)
+            case !m.isWant() || !wants.push(m):
+                s.Reset()
+                return
+            }
+        }
+    }()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    w := bufio.NewWriter(s)
+    for {
+        m, ok := wants.pop()
+        if !ok {
+            break
+        }
+        s.SetWriteDeadline(time.Now().Add(e.timeout))
+        if err := writeMessage(w, e.answer(ctx, from, m)); err != nil {
+            s.Reset()
+            return
+        }
+        if wants.empty() {
+            if err := w.Flush(); err != nil {
+                s.Reset()
+                return
+            }
+        }
+    }
+    w.Flush()
+    s.Close()
+}
+
+func (e *Exchange) answer(ctx context.Context, from peer.ID, m message) message {
+    reply := message{Type: msgDontHave, CID: m.CID}
+    switch m.Type {
+    case msgWantBlock:
+        data, err := e.store.GetBlock(ctx, m.CID)
+        if err == nil && len(data) <= MaxBlockSize {
+            reply.Type, reply.Data = msgBlock, data
+        }
+    case msgWantHave:
+        if ok, err := e.store.HasBlock(ctx, m.CID); err == nil && ok {
+            reply.Type = msgHave
+        }
+    case msgPut:
+        if err := e.admitPut(from, len(m.Data)); err != nil {
+            log.Printf("Refusing block %s from %s: %v", m.CID, from, err)
+        } else if err := e.store.PutBlock(ctx, m.CID, m.Data); err != nil {
+            log.Printf("Refusing block %s: %v", m.CID, err)
+        } else {
+            reply.Type = msgHave
+        }
+    case msgPin:
+        if err := e.admitPut(from, len(m.Data)); err != nil {
+            log.Printf("Refusing block %s from %s: %v", m.CID, from, err)
+        } else if err := e.store.PinBlock(ctx, from, m.CID, m.Data); err != nil {
+            log.Printf("Refusing block %s: %v", m.CID, err)
+        } else {
+            reply.Type = msgHave
+        }
+    case msgUnpin:
+        if err := e.admitPut(from, 0); err != nil {
+            log.Printf("Refusing to unpin %s for %s: %v", m.CID, from, err)
+        } else if err := e.store.UnpinBlock(ctx, from, m.CID); err != nil {
+            log.Printf("Failed to unpin %s for %s: %v", m.CID, from, err)
+        } else {
+            reply.Type = msgHave
+        }
+    }
+    return reply
+}
+
+// admitPut charges a push of size bytes to from's quota, or refuses it if
+// from has used up its quota.
+func (e *Exchange) admitPut(from peer.ID, size int) error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    now := time.Now()
+    for p, u := range e.puts {
+        if now.Sub(u.since) >= PutQuotaPeriod {
+            delete(e.puts, p)
+        }
+    }
+    u := e.puts[from]
+    if u == nil {
+        u = &putUsage{since: now}
+        e.puts[from] = u
+    }
+    if u.bytes+size > PutQuota {
+        return ErrPutQuota
+    }
+    u.bytes += size
+    return nil
+}
+
+// wantlist queues the unanswered wants of one stream. A cancelled want
+// stays in place and is answered with dont-have, so the requester can count
+// on one answer per want.
+type wantlist struct {
+    mu      sync.Mutex
+    cond    *sync.Cond
+    pending []message
+    closed  bool
+}
+
+func newWantlist() *wantlist {
+    wl := &wantlist{}
+    wl.cond = sync.NewCond(&wl.mu)
+    return wl
+}
+
+func (wl *wantlist) push(m message) bool {
+    wl.mu.Lock()
+    defer wl.mu.Unlock()
+    if len(wl.pending) >= MaxWantlist {
+        return false
+    }
+    wl.pending = append(wl.pending, m)
+    wl.cond.Signal()
+    return true
+}
+
+func (wl *wantlist) cancel(c cid.Cid) {
+    wl.mu.Lock()
+    defer wl.mu.Unlock()
+    for i, m := range wl.pending {
+        if m.CID.Equals(c) && (m.Type == msgWantBlock || m.Type == msgWantHave) {
+            wl.pending[i].Type = msgCancel
+        }
+    }
+}
+
+// pop waits for the next want. It reports false once the requester has
+// stopped sending and every want is answered.
+func (wl *wantlist) pop() (message, bool) {
+    wl.mu.Lock()
+    defer wl.mu.Unlock()
+    for len(wl.pending) == 0 && !wl.closed {
+        wl.cond.Wait()
+    }
+    if len(wl.pending) == 0 {
+        return message{}, false
+    }
+    m := wl.pending[0]
+    wl.pending = wl.pending[1:]
+    return m, true
+}
+
+func (wl *wantlist) empty() bool {
+    wl.mu.Lock()
+    defer wl.mu.Unlock()
+    return len(wl.pending) == 0
+}
+
+func (wl *wantlist) close() {
+    wl.mu.Lock()
+    defer wl.mu.Unlock()
+    wl.closed = true
+    wl.cond.Broadcast()
+}
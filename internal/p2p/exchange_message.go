@@ -0,0 +1,100 @@
+package p2p
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+
+    "github.com/ipfs/go-cid"
+)
+
+// Messages of the block exchange protocol. Each is framed as a uvarint
+// length followed by a type byte, the uvarint-prefixed CID and, for blocks,
+// puts and pins, the block itself.
+const (
+    msgWantBlock byte = iota + 1 // ask for a block
+    msgWantHave                  // ask whether the peer holds a block
+    msgCancel                    // withdraw a want that has not been answered
+    msgPut                       // ask the peer to store a block
+    msgBlock                     // a wanted block
+    msgHave                      // the peer holds the block, stored a put or pin, or released a pin
+    msgDontHave                  // the peer lacks the block, or refused a put
+    msgPin                       // ask the peer to store a block and keep it for the sender
+    msgUnpin                     // release a block pinned earlier
+)
+
+// MaxBlockSize bounds the blocks peers exchange. Chunks, DAG nodes and
+// shards are all well below it.
+const MaxBlockSize = 1 << 20
+
+const maxMessageSize = MaxBlockSize + 1024
+
+var ErrMalformedMessage = errors.New("malformed block exchange message")
+
+type message struct {
+    Type byte
+    CID  cid.Cid
+    Data []byte
+}
+
+func (m message) isWant() bool {
+    switch m.Type {
+    case msgWantBlock, msgWantHave, msgPut, msgPin, msgUnpin:
+        return true
+    }
+    return false
+}
+
+func (m message) isAnswer() bool {
+    return m.Type == msgBlock || m.Type == msgHave || m.Type == msgDontHave
+}
+
+func writeMessage(w io.Writer, m message) error {
+    c := m.CID.Bytes()
+    frame := make([]byte, 0, 2*binary.MaxVarintLen64+1+len(c)+len(m.Data))
+    frame = binary.AppendUvarint(frame, uint64(1+uvarintLen(len(c))+len(c)+len(m.Data)))
+    frame = append(frame, m.Type)
+    frame = binary.AppendUvarint(frame, uint64(len(c)))
+    frame = append(frame, c...)
+    frame = append(frame, m.Data...)
+    _, err := w.Write(frame)
+    return err
+}
+
+func readMessage(r *bufio.Reader) (message, error) {
+    var m message
+    n, err := binary.ReadUvarint(r)
+    if err != nil {
+        return m, err
+    }
+    if n < 2 || n > maxMessageSize {
+        return m, fmt.Errorf("%w: message of %d bytes", ErrMalformedMessage, n)
+    }
+    body := make([]byte, n)
+    if _, err := io.ReadFull(r, body); err != nil {
+        return m, err
+    }
+    m.Type = body[0]
+    if m.Type < msgWantBlock || m.Type > msgUnpin {
+        return m, fmt.Errorf("%w: unknown type %d", ErrMalformedMessage, m.Type)
+    }
+    cidLen, read := binary.Uvarint(body[1:])
+    if read <= 0 || cidLen > uint64(len(body)-1-read) {
+        return m, fmt.Errorf("%w: bad CID length", ErrMalformedMessage)
+    }
+    start := 1 + read
+    if m.CID, err = cid.Cast(body[start : start+int(cidLen)]); err != nil {
+        return m, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+    }
+    if rest := body[start+int(cidLen):]; len(rest) > 0 {
+        m.Data = rest
+    }
+    return m, nil
+}
+
+func uvarintLen(n int) int {
+    var buf [binary.MaxVarintLen64]byte
+    return binary.PutUvarint(buf[:], uint64(n))
+}
@@ -0,0 +1,266 @@
+package p2p
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+type memoryBlockstore struct {
+    mu     sync.Mutex
+    blocks map[cid.Cid][]byte
+    pins   map[cid.Cid]map[peer.ID]bool
+}
+
+func allMembers(peer.ID) bool { return true }
+
+func newMemoryBlockstore() *memoryBlockstore {
+    return &memoryBlockstore{blocks: make(map[cid.Cid][]byte), pins: make(map[cid.Cid]map[peer.ID]bool)}
+}
+
+func (m *memoryBlockstore) GetBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    data, ok := m.blocks[c]
+    if !ok {
+        return nil, errors.New("not found")
+    }
+    return data, nil
+}
+
+func (m *memoryBlockstore) HasBlock(ctx context.Context, c cid.Cid) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    _, ok := m.blocks[c]
+    return ok, nil
+}
+
+func (m *memoryBlockstore) PutBlock(ctx context.Context, c cid.Cid, data []byte) error {
+    if err := dag.VerifyCID(c, data); err != nil {
+        return err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.blocks[c] = data
+    return nil
+}
+
+func (m *memoryBlockstore) PinBlock(ctx context.Context, owner peer.ID, c cid.Cid, data []byte) error {
+    if err := m.PutBlock(ctx, c, data); err != nil {
+        return err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.pins[c] == nil {
+        m.pins[c] = make(map[peer.ID]bool)
+    }
+    m.pins[c][owner] = true
+    return nil
+}
+
+func (m *memoryBlockstore) UnpinBlock(ctx context.Context, owner peer.ID, c cid.Cid) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    delete(m.pins[c], owner)
+    return nil
+}
+
+func (m *memoryBlockstore) pinned(c cid.Cid, owner peer.ID) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.pins[c][owner]
+}
+
+func (m *memoryBlockstore) add(t *testing.T, data []byte) cid.Cid {
+    c, err := dag.NewCID(dag.CodecRaw, data)
+    require.NoError(t, err)
+    require.NoError(t, m.PutBlock(context.Background(), c, data))
+    return c
+}
+
+func TestExchange_GetBlocksFromProviders(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    nodes := startCluster(t, ctx, 3)
+    stores := make([]*memoryBlockstore, len(nodes))
+    exchanges := make([]*Exchange, len(nodes))
+    for i, n := range nodes {
+        stores[i] = newMemoryBlockstore()
+        exchanges[i] = NewExchange(n, stores[i])
+        exchanges[i].SetMembers(allMembers)
+    }
+
+    // Both other nodes provide the shared block, only the first the other.
+    shared := stores[0].add(t, []byte("shared block"))
+    stores[1].add(t, []byte("shared block"))
+    only := stores[0].add(t, []byte("only on the first node"))
+    require.NoError(t, nodes[0].DHT.Provide(ctx, shared, true))
+    require.NoError(t, nodes[0].DHT.Provide(ctx, only, true))
+    require.NoError(t, nodes[1].DHT.Provide(ctx, shared, true))
+
+    providers, err := exchanges[2].FindProviders(ctx, shared)
+    require.NoError(t, err)
+    assert.ElementsMatch(t, providers, []peer.ID{nodes[0].Host.ID(), nodes[1].Host.ID()})
+
+    missing, err := dag.NewCID(dag.CodecRaw, []byte("nobody has this"))
+    require.NoError(t, err)
+    blocks, err := exchanges[2].GetBlocks(ctx, []cid.Cid{shared, only, missing})
+    require.NoError(t, err)
+    assert.Equal(t, map[cid.Cid][]byte{
+        shared: []byte("shared block"),
+        only:   []byte("only on the first node"),
+    }, blocks)
+
+    _, err = exchanges[2].GetBlock(ctx, missing)
+    assert.ErrorIs(t, err, ErrBlockNotFound)
+}
+
+func TestExchange_FetchPutAndHas(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    server, client := startNode(t, ctx), startNode(t, ctx)
+    serverStore := newMemoryBlockstore()
+    serverExchange := NewExchange(server, serverStore)
+    exchange := NewExchange(client, newMemoryBlockstore())
+    client.Host.Peerstore().AddAddrs(server.Host.ID(), server.Host.Addrs(), time.Hour)
+
+    // Only members are answered.
+    var cids []cid.Cid
+    for i := 0; i < 3*Window; i++ {
+        cids = append(cids, serverStore.add(t, []byte(fmt.Sprint("block ", i))))
+    }
+    blocks, err := exchange.FetchBlocks(ctx, server.Host.ID(), cids)
+    require.NoError(t, err)
+    assert.Empty(t, blocks)
+    serverExchange.SetMembers(func(p peer.ID) bool { return p == client.Host.ID() })
+
+    // More wants than fit in the window are sent as answers come back.
+    blocks, err = exchange.FetchBlocks(ctx, server.Host.ID(), cids)
+    require.NoError(t, err)
+    assert.Len(t, blocks, len(cids))
+
+    data := []byte("pushed block")
+    c, err := dag.NewCID(dag.CodecRaw, data)
+    require.NoError(t, err)
+    ok, err := exchange.HasBlock(ctx, server.Host.ID(), c)
+    require.NoError(t, err)
+    assert.False(t, ok)
+
+    assert.ErrorIs(t, exchange.PutBlock(ctx, server.Host.ID(), c, []byte("not the block")), ErrBlockRejected)
+    require.NoError(t, exchange.PutBlock(ctx, server.Host.ID(), c, data))
+    ok, err = exchange.HasBlock(ctx, server.Host.ID(), c)
+    require.NoError(t, err)
+    assert.True(t, ok)
+
+    // Pins are kept for the member that made them.
+    require.NoError(t, exchange.PinBlock(ctx, server.Host.ID(), c, data))
+    assert.True(t, serverStore.pinned(c, client.Host.ID()))
+    require.NoError(t, exchange.UnpinBlock(ctx, server.Host.ID(), c))
+    assert.False(t, serverStore.pinned(c, client.Host.ID()))
+}
+
+func TestExchange_PutQuota(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    n := startNode(t, ctx)
+    e := NewExchange(n, newMemoryBlockstore())
+    member := peer.ID("member")
+
+    require.NoError(t, e.admitPut(member, PutQuota-1))
+    require.NoError(t, e.admitPut(member, 1))
+    assert.ErrorIs(t, e.admitPut(member, 1), ErrPutQuota)
+
+    // The quota is refilled once the period is over.
+    e.puts[member].since = time.Now().Add(-PutQuotaPeriod)
+    require.NoError(t, e.admitPut(member, 1))
+}
+
+func TestExchange_DropsSilentPeers(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    // The server takes wants and never answers them.
+    server, client := startNode(t, ctx), startNode(t, ctx)
+    server.Host.SetStreamHandler(BlockProtocol, func(s network.Stream) {
+        io.Copy(io.Discard, s)
+    })
+    client.Host.Peerstore().AddAddrs(server.Host.ID(), server.Host.Addrs(), time.Hour)
+    exchange := NewExchange(client, newMemoryBlockstore())
+    exchange.timeout = 200 * time.Millisecond
+    lb := NewLoadBalancer(LeastOutstanding{})
+    exchange.SetLoadBalancer(lb)
+
+    c, err := dag.NewCID(dag.CodecRaw, []byte("never sent"))
+    require.NoError(t, err)
+    blocks, err := exchange.FetchBlocks(ctx, server.Host.ID(), []cid.Cid{c})
+    require.NoError(t, err)
+    assert.Empty(t, blocks)
+    _, err = exchange.HasBlock(ctx, server.Host.ID(), c)
+    assert.Error(t, err)
+    assert.Zero(t, lb.Load(server.Host.ID()).Outstanding)
+    assert.Equal(t, 2, lb.peers[server.Host.ID()].failures)
+}
+
+func TestWantlist_CancelledWantsAreStillAnswered(t *testing.T) {
+    a, err := dag.NewCID(dag.CodecRaw, []byte("a"))
+    require.NoError(t, err)
+    b, err := dag.NewCID(dag.CodecRaw, []byte("b"))
+    require.NoError(t, err)
+
+    wl := newWantlist()
+    require.True(t, wl.push(message{Type: msgWantBlock, CID: a}))
+    require.True(t, wl.push(message{Type: msgWantBlock, CID: b}))
+    wl.cancel(a)
+    wl.close()
+
+    m, ok := wl.pop()
+    require.True(t, ok)
+    assert.Equal(t, msgCancel, m.Type)
+    m, ok = wl.pop()
+    require.True(t, ok)
+    assert.Equal(t, msgWantBlock, m.Type)
+    _, ok = wl.pop()
+    assert.False(t, ok)
+
+    full := newWantlist()
+    for i := 0; i < MaxWantlist; i++ {
+        require.True(t, full.push(message{Type: msgWantHave, CID: a}))
+    }
+    assert.False(t, full.push(message{Type: msgWantHave, CID: a}))
+}
+
+func TestMessage_RoundTrip(t *testing.T) {
+    c, err := dag.NewCID(dag.CodecRaw, []byte("data"))
+    require.NoError(t, err)
+
+    var buf bytes.Buffer
+    require.NoError(t, writeMessage(&buf, message{Type: msgBlock, CID: c, Data: []byte("data")}))
+    require.NoError(t, writeMessage(&buf, message{Type: msgWantHave, CID: c}))
+    r := bufio.NewReader(&buf)
+
+    m, err := readMessage(r)
+    require.NoError(t, err)
+    assert.Equal(t, message{Type: msgBlock, CID: c, Data: []byte("data")}, m)
+    m, err = readMessage(r)
+    require.NoError(t, err)
+    assert.Equal(t, message{Type: msgWantHave, CID: c}, m)
+
+    _, err = readMessage(bufio.NewReader(bytes.NewReader([]byte{3, 99, 1, 0})))
+    assert.ErrorIs(t, err, ErrMalformedMessage)
+}
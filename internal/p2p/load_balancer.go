@@ -1,16 +1,305 @@
 package p2p
 
+import (
+    "fmt"
+    "math"
+    "math/rand/v2"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    // OutlierFailures is how many requests in a row a peer must fail to be
+    // ejected.
+    OutlierFailures = 5
+    // OutlierEjection is how long a peer is first ejected for. Each further
+    // ejection before it recovers doubles the time, up to MaxOutlierEjection.
+    OutlierEjection    = 30 * time.Second
+    MaxOutlierEjection = 5 * time.Minute
+
+    // latencyDecay is the time constant over which old latency samples
+    // lose their weight.
+    latencyDecay = 10 * time.Second
+    // unmeasuredLatency stands in for the latency of a busy peer that has
+    // not answered a request yet.
+    unmeasuredLatency = time.Second
+)
+
+// PeerLoad is a snapshot of the requests a peer is serving.
+type PeerLoad struct {
+    ID peer.ID
+    // Outstanding is the number of requests sent to the peer and not yet
+    // answered.
+    Outstanding int
+    // Latency is the peak-EWMA of the peer's answer times: it jumps to any
+    // slower answer at once and decays towards faster ones.
+    Latency time.Duration
+}
+
+// Cost is the expected wait for one more request to the peer: its latency
+// times the requests it would then be serving. A peer that has not been
+// measured costs nothing while idle, so that it gets tried.
+func (l PeerLoad) Cost() float64 {
+    latency := l.Latency
+    if latency == 0 && l.Outstanding > 0 {
+        latency = unmeasuredLatency
+    }
+    return float64(latency) * float64(l.Outstanding+1)
+}
+
+// Strategy picks one of the candidates for a request and returns its
+// index. Candidates come best placed first. Strategies are called
+// concurrently.
+type Strategy interface {
+    Pick(candidates []PeerLoad) int
+}
+
+// RoundRobin takes the candidates in turn.
+type RoundRobin struct {
+    next atomic.Uint64
+}
+
+func (r *RoundRobin) Pick(candidates []PeerLoad) int {
+    return int((r.next.Add(1) - 1) % uint64(len(candidates)))
+}
+
+// LeastOutstanding picks the candidate serving the fewest requests.
+type LeastOutstanding struct{}
+
+func (LeastOutstanding) Pick(candidates []PeerLoad) int {
+    best := 0
+    for i, c := range candidates {
+        if c.Outstanding < candidates[best].Outstanding {
+            best = i
+        }
+    }
+    return best
+}
+
+// PeakEWMA picks the candidate with the lowest cost.
+type PeakEWMA struct{}
+
+func (PeakEWMA) Pick(candidates []PeerLoad) int {
+    best := 0
+    for i, c := range candidates {
+        if c.Cost() < candidates[best].Cost() {
+            best = i
+        }
+    }
+    return best
+}
+
+// PowerOfTwoChoices picks the cheaper of two random candidates, which
+// spreads load almost as well as comparing them all without every
+// requester herding onto the same peer.
+type PowerOfTwoChoices struct{}
+
+func (PowerOfTwoChoices) Pick(candidates []PeerLoad) int {
+    if len(candidates) == 1 {
+        return 0
+    }
+    i := rand.IntN(len(candidates))
+    j := rand.IntN(len(candidates) - 1)
+    if j >= i {
+        j++
+    }
+    if candidates[j].Cost() < candidates[i].Cost() {
+        return j
+    }
+    return i
+}
+
+// ParseStrategy returns the strategy called name: "round-robin",
+// "least-outstanding", "peak-ewma" or "p2c".
+func ParseStrategy(name string) (Strategy, error) {
+    switch name {
+    case "round-robin":
+        return &RoundRobin{}, nil
+    case "least-outstanding":
+        return LeastOutstanding{}, nil
+    case "peak-ewma":
+        return PeakEWMA{}, nil
+    case "p2c":
+        return PowerOfTwoChoices{}, nil
+    }
+    return nil, fmt.Errorf("unknown load balancing strategy %q", name)
+}
+
+// LoadBalancer routes requests among the peers that can serve them. It
+// tracks every request through Begin, and ejects peers that keep failing
+// for a while.
 type LoadBalancer struct {
-    nodes []string
+    strategy Strategy
+    now      func() time.Time
+
+    mu    sync.Mutex
+    peers map[peer.ID]*peerStats
+}
+
+type peerStats struct {
+    id          peer.ID
+    outstanding int
+    latency     time.Duration
+    sampled     time.Time
+
+    failures     int
+    ejections    int
+    ejectedUntil time.Time
 }
 
-func NewLoadBalancer(nodes []string) *LoadBalancer {
-    return &LoadBalancer{nodes: nodes}
+func NewLoadBalancer(strategy Strategy) *LoadBalancer {
+    return &LoadBalancer{
+        strategy: strategy,
+        now:      time.Now,
+        peers:    make(map[peer.ID]*peerStats),
+    }
+}
+
+func (lb *LoadBalancer) stats(p peer.ID) *peerStats {
+    s, ok := lb.peers[p]
+    if !ok {
+        s = &peerStats{id: p}
+        lb.peers[p] = s
+    }
+    return s
+}
+
+func (s *peerStats) load() PeerLoad {
+    return PeerLoad{ID: s.id, Outstanding: s.outstanding, Latency: s.latency}
+}
+
+// Select picks the peer to send a request to among candidates. Ejected
+// peers are passed over unless every candidate is ejected. It returns
+// false if there are no candidates.
+func (lb *LoadBalancer) Select(candidates []peer.ID) (peer.ID, bool) {
+    if len(candidates) == 0 {
+        return "", false
+    }
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+    now := lb.now()
+    var healthy, all []PeerLoad
+    for _, p := range candidates {
+        s := lb.stats(p)
+        all = append(all, s.load())
+        if !now.Before(s.ejectedUntil) {
+            healthy = append(healthy, s.load())
+        }
+    }
+    if len(healthy) == 0 {
+        healthy = all
+    }
+    return healthy[lb.strategy.Pick(healthy)].ID, true
+}
+
+// Load returns the current load of p.
+func (lb *LoadBalancer) Load(p peer.ID) PeerLoad {
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+    return lb.stats(p).load()
+}
+
+// Ejected reports whether p is ejected.
+func (lb *LoadBalancer) Ejected(p peer.ID) bool {
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+    return lb.now().Before(lb.stats(p).ejectedUntil)
+}
+
+// Request is one request to a peer. Exactly one of Succeed, Fail and
+// Abandon must be called when it ends; later calls do nothing. A nil
+// Request does nothing.
+type Request struct {
+    lb    *LoadBalancer
+    stats *peerStats
+    start time.Time
+    done  bool
+}
+
+// Begin records a request sent to p. A nil LoadBalancer returns a nil
+// Request.
+func (lb *LoadBalancer) Begin(p peer.ID) *Request {
+    if lb == nil {
+        return nil
+    }
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+    s := lb.stats(p)
+    s.outstanding++
+    return &Request{lb: lb, stats: s, start: lb.now()}
+}
+
+// end closes the request and reports whether it was still open. It must
+// be called with lb.mu held.
+func (r *Request) end() bool {
+    if r.done {
+        return false
+    }
+    r.done = true
+    r.stats.outstanding--
+    return true
+}
+
+// Succeed records that the peer answered, and samples its latency.
+func (r *Request) Succeed() {
+    if r == nil {
+        return
+    }
+    r.lb.mu.Lock()
+    defer r.lb.mu.Unlock()
+    if !r.end() {
+        return
+    }
+    s, now := r.stats, r.lb.now()
+    rtt := now.Sub(r.start)
+    if rtt > s.latency {
+        s.latency = rtt
+    } else {
+        w := math.Exp(-float64(now.Sub(s.sampled)) / float64(latencyDecay))
+        s.latency = time.Duration(float64(s.latency)*w + float64(rtt)*(1-w))
+    }
+    s.sampled = now
+    s.failures = 0
+    if !now.Before(s.ejectedUntil) {
+        s.ejections = 0
+    }
+}
+
+// Fail records that the peer did not answer or answered wrongly. A peer
+// that fails OutlierFailures requests in a row is ejected.
+func (r *Request) Fail() {
+    if r == nil {
+        return
+    }
+    r.lb.mu.Lock()
+    defer r.lb.mu.Unlock()
+    if !r.end() {
+        return
+    }
+    s, now := r.stats, r.lb.now()
+    s.failures++
+    if s.failures < OutlierFailures || now.Before(s.ejectedUntil) {
+        return
+    }
+    ejection := OutlierEjection
+    for i := 0; i < s.ejections && ejection < MaxOutlierEjection; i++ {
+        ejection *= 2
+    }
+    s.ejectedUntil = now.Add(min(ejection, MaxOutlierEjection))
+    s.ejections++
+    s.failures = 0
 }
 
-func (lb *LoadBalancer) SelectNode() string {
-    if len(lb.nodes) == 0 {
-        return ""
+// Abandon records that the request was withdrawn, such as a want that was
+// cancelled, without judging the peer.
+func (r *Request) Abandon() {
+    if r == nil {
+        return
     }
-    return lb.nodes[0]
+    r.lb.mu.Lock()
+    defer r.lb.mu.Unlock()
+    r.end()
 }
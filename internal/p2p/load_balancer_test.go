@@ -0,0 +1,203 @@
+package p2p
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
+
+func newTestBalancer(strategy Strategy) (*LoadBalancer, *testClock) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    lb := NewLoadBalancer(strategy)
+    lb.now = clock.Now
+    return lb, clock
+}
+
+// answerAfter records a request to p that took d.
+func answerAfter(lb *LoadBalancer, clock *testClock, p peer.ID, d time.Duration) {
+    r := lb.Begin(p)
+    clock.Advance(d)
+    r.Succeed()
+}
+
+func TestLoadBalancer_Strategies(t *testing.T) {
+    peers := []peer.ID{"a", "b", "c"}
+
+    lb, _ := newTestBalancer(&RoundRobin{})
+    var picked []peer.ID
+    for i := 0; i < 4; i++ {
+        p, ok := lb.Select(peers)
+        require.True(t, ok)
+        picked = append(picked, p)
+    }
+    assert.Equal(t, []peer.ID{"a", "b", "c", "a"}, picked)
+
+    lb, _ = newTestBalancer(LeastOutstanding{})
+    lb.Begin("a")
+    lb.Begin("a")
+    lb.Begin("b")
+    p, _ := lb.Select(peers)
+    assert.Equal(t, peer.ID("c"), p)
+
+    // Peak-EWMA jumps to a slow answer at once and forgets it gradually.
+    lb, clock := newTestBalancer(PeakEWMA{})
+    answerAfter(lb, clock, "a", 10*time.Millisecond)
+    answerAfter(lb, clock, "b", 20*time.Millisecond)
+    answerAfter(lb, clock, "c", 30*time.Millisecond)
+    p, _ = lb.Select(peers)
+    assert.Equal(t, peer.ID("a"), p)
+    answerAfter(lb, clock, "a", 50*time.Millisecond)
+    assert.Equal(t, 50*time.Millisecond, lb.Load("a").Latency)
+    p, _ = lb.Select(peers)
+    assert.Equal(t, peer.ID("b"), p)
+    for i := 0; i < 10; i++ {
+        clock.Advance(10 * time.Second)
+        answerAfter(lb, clock, "a", 5*time.Millisecond)
+    }
+    assert.Less(t, lb.Load("a").Latency, 10*time.Millisecond)
+
+    // Of two candidates, the cheaper one always wins.
+    lb, clock = newTestBalancer(PowerOfTwoChoices{})
+    answerAfter(lb, clock, "a", 10*time.Millisecond)
+    answerAfter(lb, clock, "b", 40*time.Millisecond)
+    for i := 0; i < 20; i++ {
+        p, _ = lb.Select(peers[:2])
+        assert.Equal(t, peer.ID("a"), p)
+    }
+
+    _, err := ParseStrategy("p2c")
+    assert.NoError(t, err)
+    _, err = ParseStrategy("random")
+    assert.Error(t, err)
+}
+
+func TestLoadBalancer_EjectsOutliers(t *testing.T) {
+    lb, clock := newTestBalancer(LeastOutstanding{})
+    peers := []peer.ID{"a", "b"}
+
+    for i := 0; i < OutlierFailures; i++ {
+        lb.Begin("a").Fail()
+    }
+    require.True(t, lb.Ejected("a"))
+    lb.Begin("b")
+    lb.Begin("b")
+    p, _ := lb.Select(peers)
+    assert.Equal(t, peer.ID("b"), p, "ejected peer passed over")
+
+    // With every candidate ejected, one is still chosen.
+    p, ok := lb.Select(peers[:1])
+    assert.True(t, ok)
+    assert.Equal(t, peer.ID("a"), p)
+
+    clock.Advance(OutlierEjection)
+    assert.False(t, lb.Ejected("a"))
+
+    // Failing again before recovering ejects for twice as long.
+    for i := 0; i < OutlierFailures; i++ {
+        lb.Begin("a").Fail()
+    }
+    clock.Advance(OutlierEjection)
+    assert.True(t, lb.Ejected("a"))
+    clock.Advance(OutlierEjection)
+    assert.False(t, lb.Ejected("a"))
+
+    // Abandoned requests are not held against the peer.
+    for i := 0; i < 2*OutlierFailures; i++ {
+        lb.Begin("b").Abandon()
+    }
+    assert.False(t, lb.Ejected("b"))
+    assert.Equal(t, 2, lb.Load("b").Outstanding)
+}
+
+func TestLoadBalancer_ConcurrentRequests(t *testing.T) {
+    lb := NewLoadBalancer(PowerOfTwoChoices{})
+    peers := []peer.ID{"a", "b", "c", "d"}
+    var wg sync.WaitGroup
+    for i := 0; i < 16; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 200; j++ {
+                p, _ := lb.Select(peers)
+                r := lb.Begin(p)
+                if j%7 == 0 {
+                    r.Fail()
+                } else {
+                    r.Succeed()
+                }
+                // Ending a request twice counts once.
+                r.Succeed()
+            }
+        }()
+    }
+    wg.Wait()
+    for _, p := range peers {
+        assert.Zero(t, lb.Load(p).Outstanding)
+    }
+}
+
+func TestExchange_RecordsFetchesInLoadBalancer(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    nodes := startCluster(t, ctx, 3)
+    stores := make([]*memoryBlockstore, len(nodes))
+    exchanges := make([]*Exchange, len(nodes))
+    for i, n := range nodes {
+        stores[i] = newMemoryBlockstore()
+        exchanges[i] = NewExchange(n, stores[i])
+        exchanges[i].SetMembers(allMembers)
+    }
+    c := stores[1].add(t, []byte("placed block"))
+    stores[2].add(t, []byte("placed block"))
+
+    placement := NewPlacement(nil)
+    placement.SetPeers([]PlacementPeer{
+        {ID: nodes[1].Host.ID(), Capacity: 1},
+        {ID: nodes[2].Host.ID(), Capacity: 1},
+    })
+    lb := NewLoadBalancer(LeastOutstanding{})
+    exchanges[0].SetPlacement(placement)
+    exchanges[0].SetLoadBalancer(lb)
+
+    // The block is asked of the one peer the balancer selects.
+    data, err := exchanges[0].GetBlock(ctx, c)
+    require.NoError(t, err)
+    assert.Equal(t, []byte("placed block"), data)
+    first, second := lb.Load(nodes[1].Host.ID()), lb.Load(nodes[2].Host.ID())
+    assert.Zero(t, first.Outstanding)
+    assert.Zero(t, second.Outstanding)
+    assert.True(t, (first.Latency > 0) != (second.Latency > 0), "one peer measured")
+
+    // A peer that is gone fails its requests and is ejected.
+    gone := nodes[2].Host.ID()
+    require.NoError(t, nodes[2].Close())
+    for i := 0; i < OutlierFailures; i++ {
+        _, err := exchanges[0].FetchBlocks(ctx, gone, []cid.Cid{c})
+        require.NoError(t, err)
+    }
+    assert.True(t, lb.Ejected(gone))
+}
@@ -0,0 +1,700 @@
+package p2p
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "math/rand/v2"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/metrics"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/peerstore"
+    "github.com/libp2p/go-libp2p/core/protocol"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// MembershipProtocol is the stream protocol nodes probe each other and
+// gossip membership changes over.
+const MembershipProtocol = protocol.ID("/" + Namespace + "/swim/1.0.0")
+
+const (
+    // MaxPiggyback is how many membership changes ride on each message.
+    MaxPiggyback = 8
+
+    maxMembershipMessage    = 1 << 20
+    membershipStreamTimeout = 10 * time.Second
+)
+
+// ErrNoSeeds is returned by Join when none of the given peers could be
+// reached.
+var ErrNoSeeds = errors.New("no seed peer could be reached")
+
+// MemberState is what a node believes about another. States only move
+// forward for a given incarnation; a node proves it is alive after all by
+// announcing a higher incarnation.
+type MemberState int
+
+const (
+    StateAlive MemberState = iota
+    // StateSuspect members missed a probe. They are declared dead unless
+    // they refute the suspicion in time.
+    StateSuspect
+    StateDead
+    // StateLeft members shut down gracefully.
+    StateLeft
+)
+
+func (s MemberState) String() string {
+    switch s {
+    case StateAlive:
+        return "alive"
+    case StateSuspect:
+        return "suspect"
+    case StateDead:
+        return "dead"
+    case StateLeft:
+        return "left"
+    }
+    return fmt.Sprintf("MemberState(%d)", int(s))
+}
+
+// Live reports whether a member in state s still counts as part of the
+// cluster.
+func (s MemberState) Live() bool {
+    return s == StateAlive || s == StateSuspect
+}
+
+// NodeMeta is what a node tells the cluster about itself.
+type NodeMeta struct {
+    Capacity float64 `json:"capacity"`
+    Zone     string  `json:"zone,omitempty"`
+    Rack     string  `json:"rack,omitempty"`
+    Version  string  `json:"version,omitempty"`
+}
+
+// Member is one node as seen by the cluster.
+type Member struct {
+    ID          peer.ID     `json:"id"`
+    Addrs       []string    `json:"addrs,omitempty"`
+    Meta        NodeMeta    `json:"meta"`
+    State       MemberState `json:"state"`
+    Incarnation uint64      `json:"incarnation"`
+}
+
+// valid reports whether u could have been sent by a well-behaved node: a
+// node's capacity is a finite share, and no incarnation is so high that
+// the member could not refute it.
+func (u Member) valid() bool {
+    c := u.Meta.Capacity
+    return c >= 0 && !math.IsInf(c, 0) && !math.IsNaN(c) && u.Incarnation < math.MaxUint64
+}
+
+// supersedes reports whether u is newer news about a member than cur.
+func (u Member) supersedes(cur Member) bool {
+    if u.Incarnation != cur.Incarnation {
+        return u.Incarnation > cur.Incarnation
+    }
+    switch u.State {
+    case StateSuspect:
+        return cur.State == StateAlive
+    case StateDead, StateLeft:
+        return cur.State.Live()
+    }
+    return false
+}
+
+type EventType int
+
+const (
+    MemberJoined EventType = iota
+    // MemberUpdated means a live member changed its metadata.
+    MemberUpdated
+    MemberFailed
+    MemberLeft
+)
+
+func (t EventType) String() string {
+    switch t {
+    case MemberJoined:
+        return "joined"
+    case MemberUpdated:
+        return "updated"
+    case MemberFailed:
+        return "failed"
+    case MemberLeft:
+        return "left"
+    }
+    return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a change in the cluster's membership.
+type Event struct {
+    Type   EventType
+    Member Member
+}
+
+// MembershipConfig tunes failure detection.
+type MembershipConfig struct {
+    // ProbeInterval is how often a node probes one of its peers.
+    ProbeInterval time.Duration
+    // ProbeTimeout is how long a direct probe waits for its ack before
+    // other peers are asked to probe indirectly.
+    ProbeTimeout time.Duration
+    // IndirectProbes is how many peers are asked to probe indirectly.
+    IndirectProbes int
+    // SuspicionMultiplier scales how many probe intervals a suspect has to
+    // refute the suspicion, times the log of the cluster size.
+    SuspicionMultiplier int
+    // RetransmitMultiplier scales how often a change is gossiped, times
+    // the log of the cluster size.
+    RetransmitMultiplier int
+    // SyncInterval is how often a node exchanges its full view with a
+    // random peer, to catch changes that gossip missed.
+    SyncInterval time.Duration
+    // DeadRetention is how long dead and departed members are remembered,
+    // so that stale news cannot bring them back.
+    DeadRetention time.Duration
+}
+
+var DefaultMembershipConfig = MembershipConfig{
+    ProbeInterval:        time.Second,
+    ProbeTimeout:         500 * time.Millisecond,
+    IndirectProbes:       3,
+    SuspicionMultiplier:  4,
+    RetransmitMultiplier: 4,
+    SyncInterval:         30 * time.Second,
+    DeadRetention:        10 * time.Minute,
+}
+
+// Membership tracks which nodes are part of the cluster with SWIM: each
+// probe interval a node pings one peer, in a shuffled round, and asks
+// others to ping it indirectly if it does not answer. A peer that no one
+// reaches becomes suspect and is declared dead unless it refutes the
+// suspicion in time. Changes spread by riding on probe messages.
+type Membership struct {
+    host host.Host
+    cfg  MembershipConfig
+
+    notifyMu sync.Mutex
+    handlers []func(Event)
+
+    mu         sync.Mutex
+    self       Member
+    members    map[peer.ID]*memberInfo
+    order      []peer.ID
+    broadcasts map[peer.ID]*broadcast
+}
+
+type memberInfo struct {
+    Member
+    // since is when the member entered its state.
+    since time.Time
+}
+
+type broadcast struct {
+    member    Member
+    transmits int
+}
+
+// NewMembership starts answering probes for n, which joins the cluster
+// alone with meta.
+func NewMembership(n *Node, meta NodeMeta, cfg MembershipConfig) *Membership {
+    var addrs []string
+    for _, addr := range n.Host.Addrs() {
+        addrs = append(addrs, addr.String())
+    }
+    m := &Membership{
+        host:       n.Host,
+        cfg:        cfg,
+        self:       Member{ID: n.Host.ID(), Addrs: addrs, Meta: meta},
+        members:    make(map[peer.ID]*memberInfo),
+        broadcasts: make(map[peer.ID]*broadcast),
+    }
+    m.host.SetStreamHandler(MembershipProtocol, m.handleStream)
+    metrics.ActiveNodes.Set(1)
+    return m
+}
+
+// Close stops answering probes. Peers will declare the node dead; Leave
+// tells them first.
+func (m *Membership) Close() {
+    m.host.RemoveStreamHandler(MembershipProtocol)
+}
+
+// Subscribe calls fn with every membership change. Handlers run one at a
+// time, in the order the changes happened, and should return quickly.
+func (m *Membership) Subscribe(fn func(Event)) {
+    m.notifyMu.Lock()
+    defer m.notifyMu.Unlock()
+    m.handlers = append(m.handlers, fn)
+}
+
+// Self returns the local node's member record.
+func (m *Membership) Self() Member {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.self
+}
+
+// Members returns the live members, the local node among them, ordered by
+// ID.
+func (m *Membership) Members() []Member {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var members []Member
+    if m.self.State.Live() {
+        members = append(members, m.self)
+    }
+    for _, info := range m.members {
+        if info.State.Live() {
+            members = append(members, info.Member)
+        }
+    }
+    sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+    return members
+}
+
+// IsMember reports whether id is a live member of the cluster.
+func (m *Membership) IsMember(id peer.ID) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if id == m.self.ID {
+        return m.self.State.Live()
+    }
+    info, ok := m.members[id]
+    return ok && info.State.Live()
+}
+
+// PlacementPeers returns the live members as placement peers, labelled
+// with their zone and rack.
+func (m *Membership) PlacementPeers() []PlacementPeer {
+    var peers []PlacementPeer
+    for _, member := range m.Members() {
+        peers = append(peers, PlacementPeer{
+            ID:       member.ID,
+            Capacity: member.Meta.Capacity,
+            Labels:   map[string]string{"zone": member.Meta.Zone, "rack": member.Meta.Rack},
+        })
+    }
+    return peers
+}
+
+// Join exchanges full membership views with the seed peers at addrs. It
+// succeeds if any seed answers, or if there are no seeds.
+func (m *Membership) Join(ctx context.Context, addrs []string) error {
+    if len(addrs) == 0 {
+        return nil
+    }
+    joined := 0
+    for _, addr := range addrs {
+        info, err := peer.AddrInfoFromString(addr)
+        if err != nil {
+            return fmt.Errorf("invalid seed peer %q: %w", addr, err)
+        }
+        if info.ID == m.host.ID() {
+            continue
+        }
+        m.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.AddressTTL)
+        if err := m.sync(ctx, info.ID); err != nil {
+            log.Printf("Failed to join through %s: %v", info.ID, err)
+            continue
+        }
+        joined++
+    }
+    if joined == 0 {
+        return ErrNoSeeds
+    }
+    return nil
+}
+
+// Leave tells a few peers that the node is leaving, so that the cluster
+// does not wait to detect its failure. The node stops refuting rumours
+// about itself.
+func (m *Membership) Leave(ctx context.Context) {
+    m.mu.Lock()
+    m.self.State = StateLeft
+    m.self.Incarnation++
+    self := m.self
+    m.queue(self)
+    m.mu.Unlock()
+
+    for _, p := range m.randomMembers(m.cfg.IndirectProbes, "") {
+        ctx, cancel := context.WithTimeout(ctx, m.cfg.ProbeTimeout)
+        _, err := m.roundTrip(ctx, p, swimMessage{Type: swimPing, Updates: []Member{self}})
+        cancel()
+        if err != nil {
+            log.Printf("Failed to tell %s about leaving: %v", p, err)
+        }
+    }
+}
+
+// Run probes peers and expires suspects until ctx is cancelled.
+func (m *Membership) Run(ctx context.Context) {
+    probe := time.NewTicker(m.cfg.ProbeInterval)
+    defer probe.Stop()
+    resync := time.NewTicker(m.cfg.SyncInterval)
+    defer resync.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-probe.C:
+            m.expire()
+            m.probe(ctx)
+        case <-resync.C:
+            if peers := m.randomMembers(1, ""); len(peers) > 0 {
+                if err := m.sync(ctx, peers[0]); err != nil && ctx.Err() == nil {
+                    log.Printf("Membership sync with %s failed: %v", peers[0], err)
+                }
+            }
+        }
+    }
+}
+
+// probe pings the next peer of the round, directly and then through
+// others, and suspects it if no ack arrives.
+func (m *Membership) probe(ctx context.Context) {
+    target, ok := m.nextTarget()
+    if !ok {
+        return
+    }
+    pctx, cancel := context.WithTimeout(ctx, m.cfg.ProbeTimeout)
+    reply, err := m.roundTrip(pctx, target.ID, swimMessage{Type: swimPing, Updates: m.gossip()})
+    cancel()
+    if err == nil {
+        m.apply(reply.Updates)
+        return
+    }
+
+    helpers := m.randomMembers(m.cfg.IndirectProbes, target.ID)
+    ictx, cancel := context.WithTimeout(ctx, 2*m.cfg.ProbeTimeout)
+    defer cancel()
+    acks := make(chan bool, len(helpers))
+    for _, h := range helpers {
+        go func(h peer.ID) {
+            reply, err := m.roundTrip(ictx, h, swimMessage{Type: swimPingReq, Target: target.ID, Updates: m.gossip()})
+            if err == nil {
+                m.apply(reply.Updates)
+            }
+            acks <- err == nil && reply.Type == swimAck
+        }(h)
+    }
+    for range helpers {
+        if <-acks {
+            return
+        }
+    }
+    if ctx.Err() != nil {
+        return
+    }
+    suspect := target
+    suspect.State = StateSuspect
+    m.apply([]Member{suspect})
+}
+
+// nextTarget returns the next live peer to probe. Each round probes every
+// peer once, in a new random order.
+func (m *Membership) nextTarget() (Member, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for refilled := false; ; refilled = true {
+        for len(m.order) > 0 {
+            id := m.order[0]
+            m.order = m.order[1:]
+            if info, ok := m.members[id]; ok && info.State.Live() {
+                return info.Member, true
+            }
+        }
+        if refilled {
+            return Member{}, false
+        }
+        for id, info := range m.members {
+            if info.State.Live() {
+                m.order = append(m.order, id)
+            }
+        }
+        rand.Shuffle(len(m.order), func(i, j int) { m.order[i], m.order[j] = m.order[j], m.order[i] })
+    }
+}
+
+// randomMembers returns up to n live peers other than exclude.
+func (m *Membership) randomMembers(n int, exclude peer.ID) []peer.ID {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var ids []peer.ID
+    for id, info := range m.members {
+        if id != exclude && info.State.Live() {
+            ids = append(ids, id)
+        }
+    }
+    rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+    return ids[:min(n, len(ids))]
+}
+
+// expire declares dead the suspects whose time to refute has run out, and
+// forgets members that have been gone for DeadRetention.
+func (m *Membership) expire() {
+    m.mu.Lock()
+    now := time.Now()
+    timeout := m.suspicionTimeout()
+    var dead []Member
+    for id, info := range m.members {
+        switch {
+        case info.State == StateSuspect && now.Sub(info.since) >= timeout:
+            member := info.Member
+            member.State = StateDead
+            dead = append(dead, member)
+        case !info.State.Live() && now.Sub(info.since) >= m.cfg.DeadRetention:
+            delete(m.members, id)
+        }
+    }
+    m.mu.Unlock()
+    m.apply(dead)
+}
+
+// suspicionTimeout is how long a suspect has to refute the suspicion. It
+// grows with the log of the cluster size, as gossip takes longer to reach
+// every node. It must be called with m.mu held.
+func (m *Membership) suspicionTimeout() time.Duration {
+    scale := math.Max(1, math.Log10(float64(len(m.members)+1)))
+    return time.Duration(float64(m.cfg.SuspicionMultiplier) * scale * float64(m.cfg.ProbeInterval))
+}
+
+// apply merges news about members into the local view and notifies the
+// subscribers of the changes.
+func (m *Membership) apply(updates []Member) {
+    if len(updates) == 0 {
+        return
+    }
+    m.mu.Lock()
+    var events []Event
+    for _, u := range updates {
+        if ev, ok := m.applyOne(u); ok {
+            events = append(events, ev)
+        }
+    }
+    live := 0
+    if m.self.State.Live() {
+        live++
+    }
+    for _, info := range m.members {
+        if info.State.Live() {
+            live++
+        }
+    }
+    // Taking notifyMu before releasing mu delivers events in the order
+    // they were applied.
+    m.notifyMu.Lock()
+    m.mu.Unlock()
+    defer m.notifyMu.Unlock()
+    if len(events) == 0 {
+        return
+    }
+    metrics.ActiveNodes.Set(float64(live))
+    for _, ev := range events {
+        for _, fn := range m.handlers {
+            fn(ev)
+        }
+    }
+}
+
+// applyOne merges u into the local view and queues it to be gossiped on
+// if it is news. It must be called with m.mu held.
+func (m *Membership) applyOne(u Member) (Event, bool) {
+    if !u.valid() {
+        return Event{}, false
+    }
+    if u.ID == m.self.ID {
+        m.refute(u)
+        return Event{}, false
+    }
+    info, known := m.members[u.ID]
+    if known && !u.supersedes(info.Member) {
+        return Event{}, false
+    }
+    if known && len(u.Addrs) == 0 {
+        u.Addrs = info.Addrs
+    }
+    m.queue(u)
+    if u.State.Live() {
+        m.addAddrs(u)
+    }
+    if !known {
+        m.members[u.ID] = &memberInfo{Member: u, since: time.Now()}
+        return Event{Type: MemberJoined, Member: u}, u.State.Live()
+    }
+
+    old := info.Member
+    info.Member = u
+    if u.State != old.State {
+        info.since = time.Now()
+    }
+    switch {
+    case !old.State.Live() && u.State.Live():
+        return Event{Type: MemberJoined, Member: u}, true
+    case old.State.Live() && u.State == StateDead:
+        return Event{Type: MemberFailed, Member: u}, true
+    case old.State.Live() && u.State == StateLeft:
+        return Event{Type: MemberLeft, Member: u}, true
+    case u.State.Live() && u.Meta != old.Meta:
+        return Event{Type: MemberUpdated, Member: u}, true
+    }
+    return Event{}, false
+}
+
+// refute answers news about the local node: a rumour that it is suspect
+// or dead, or a record from before it restarted, is outdone with a higher
+// incarnation. It must be called with m.mu held.
+func (m *Membership) refute(u Member) {
+    if !m.self.State.Live() || u.Incarnation < m.self.Incarnation {
+        return
+    }
+    if u.State == StateAlive && u.Incarnation == m.self.Incarnation {
+        return
+    }
+    m.self.Incarnation = u.Incarnation + 1
+    m.queue(m.self)
+}
+
+func (m *Membership) addAddrs(u Member) {
+    var addrs []multiaddr.Multiaddr
+    for _, s := range u.Addrs {
+        if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+            addrs = append(addrs, addr)
+        }
+    }
+    m.host.Peerstore().AddAddrs(u.ID, addrs, peerstore.AddressTTL)
+}
+
+// queue schedules u to be gossiped, replacing older news about the same
+// member. It must be called with m.mu held.
+func (m *Membership) queue(u Member) {
+    m.broadcasts[u.ID] = &broadcast{member: u}
+}
+
+// gossip takes the changes to piggyback on the next message, least
+// gossiped first. Each change is sent a few times the log of the cluster
+// size and then dropped.
+func (m *Membership) gossip() []Member {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    queued := make([]*broadcast, 0, len(m.broadcasts))
+    for _, b := range m.broadcasts {
+        queued = append(queued, b)
+    }
+    sort.Slice(queued, func(i, j int) bool { return queued[i].transmits < queued[j].transmits })
+    limit := m.cfg.RetransmitMultiplier * int(math.Ceil(math.Log10(float64(len(m.members)+2))))
+    var updates []Member
+    for _, b := range queued[:min(MaxPiggyback, len(queued))] {
+        updates = append(updates, b.member)
+        if b.transmits++; b.transmits >= limit {
+            delete(m.broadcasts, b.member.ID)
+        }
+    }
+    return updates
+}
+
+// snapshot returns every member the node knows about, itself included.
+func (m *Membership) snapshot() []Member {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    members := []Member{m.self}
+    for _, info := range m.members {
+        members = append(members, info.Member)
+    }
+    return members
+}
+
+// sync exchanges full membership views with p.
+func (m *Membership) sync(ctx context.Context, p peer.ID) error {
+    reply, err := m.roundTrip(ctx, p, swimMessage{Type: swimSync, Updates: m.snapshot()})
+    if err != nil {
+        return err
+    }
+    m.apply(reply.Updates)
+    return nil
+}
+
+type swimType string
+
+const (
+    swimPing    swimType = "ping"
+    swimPingReq swimType = "ping-req"
+    swimAck     swimType = "ack"
+    swimNack    swimType = "nack"
+    swimSync    swimType = "sync"
+)
+
+// swimMessage is one request or reply of the membership protocol. Each
+// stream carries one request and its reply.
+type swimMessage struct {
+    Type swimType `json:"type"`
+    // Target is the peer a ping-req asks to be probed.
+    Target  peer.ID  `json:"target,omitempty"`
+    Updates []Member `json:"updates,omitempty"`
+}
+
+func (m *Membership) roundTrip(ctx context.Context, p peer.ID, req swimMessage) (swimMessage, error) {
+    s, err := m.host.NewStream(ctx, p, MembershipProtocol)
+    if err != nil {
+        return swimMessage{}, err
+    }
+    defer s.Close()
+    stop := context.AfterFunc(ctx, func() { s.Reset() })
+    defer stop()
+
+    if err := json.NewEncoder(s).Encode(req); err != nil {
+        s.Reset()
+        return swimMessage{}, err
+    }
+    s.CloseWrite()
+    var reply swimMessage
+    err = json.NewDecoder(io.LimitReader(s, maxMembershipMessage)).Decode(&reply)
+    if ctx.Err() != nil {
+        err = ctx.Err()
+    }
+    return reply, err
+}
+
+func (m *Membership) handleStream(s network.Stream) {
+    defer s.Close()
+    s.SetDeadline(time.Now().Add(membershipStreamTimeout))
+    var req swimMessage
+    if err := json.NewDecoder(io.LimitReader(s, maxMembershipMessage)).Decode(&req); err != nil {
+        s.Reset()
+        return
+    }
+    m.apply(req.Updates)
+
+    reply := swimMessage{Type: swimAck}
+    switch req.Type {
+    case swimPing:
+        reply.Updates = m.gossip()
+    case swimPingReq:
+        ctx, cancel := context.WithTimeout(context.Background(), m.cfg.ProbeTimeout)
+        ack, err := m.roundTrip(ctx, req.Target, swimMessage{Type: swimPing, Updates: m.gossip()})
+        cancel()
+        if err != nil {
+            reply.Type = swimNack
+        }
+        m.apply(ack.Updates)
+        reply.Updates = m.gossip()
+    case swimSync:
+        reply = swimMessage{Type: swimSync, Updates: m.snapshot()}
+    default:
+        s.Reset()
+        return
+    }
+    if err := json.NewEncoder(s).Encode(reply); err != nil {
+        s.Reset()
+    }
+}
@@ -0,0 +1,178 @@
+package p2p
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+var testMembershipConfig = MembershipConfig{
+    ProbeInterval:        100 * time.Millisecond,
+    ProbeTimeout:         50 * time.Millisecond,
+    IndirectProbes:       2,
+    SuspicionMultiplier:  3,
+    RetransmitMultiplier: 4,
+    SyncInterval:         time.Second,
+    DeadRetention:        time.Minute,
+}
+
+type recordedEvents struct {
+    mu     sync.Mutex
+    events []Event
+}
+
+func (r *recordedEvents) record(ev Event) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.events = append(r.events, ev)
+}
+
+func (r *recordedEvents) has(typ EventType, id peer.ID) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, ev := range r.events {
+        if ev.Type == typ && ev.Member.ID == id {
+            return true
+        }
+    }
+    return false
+}
+
+// startMembers starts n nodes that join the cluster through the first,
+// without the DHT knowing any of them.
+func startMembers(t *testing.T, ctx context.Context, n int) ([]*Node, []*Membership) {
+    t.Helper()
+    var (
+        nodes   []*Node
+        members []*Membership
+    )
+    for i := 0; i < n; i++ {
+        node := startNode(t, ctx)
+        m := NewMembership(node, NodeMeta{Capacity: 1, Zone: fmt.Sprint("zone-", i%2), Version: "test"}, testMembershipConfig)
+        if i > 0 {
+            require.NoError(t, m.Join(ctx, nodes[0].Addrs()))
+        }
+        go m.Run(ctx)
+        nodes = append(nodes, node)
+        members = append(members, m)
+    }
+    for _, m := range members {
+        m := m
+        require.Eventually(t, func() bool {
+            return len(m.Members()) == n
+        }, 10*time.Second, 20*time.Millisecond)
+    }
+    return nodes, members
+}
+
+func TestMembership_DetectsFailure(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    nodes, members := startMembers(t, ctx, 4)
+    var events recordedEvents
+    members[1].Subscribe(events.record)
+
+    peers := members[2].PlacementPeers()
+    require.Len(t, peers, 4)
+    for _, p := range peers {
+        assert.Equal(t, 1.0, p.Capacity)
+        assert.Contains(t, []string{"zone-0", "zone-1"}, p.Labels["zone"])
+    }
+
+    // The last node stops answering without saying goodbye.
+    failed := nodes[3].Host.ID()
+    require.NoError(t, nodes[3].Close())
+    for _, m := range members[:3] {
+        m := m
+        require.Eventually(t, func() bool {
+            return len(m.Members()) == 3
+        }, 10*time.Second, 20*time.Millisecond)
+    }
+    assert.True(t, events.has(MemberFailed, failed))
+}
+
+func TestMembership_Leave(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    _, members := startMembers(t, ctx, 3)
+    var events recordedEvents
+    members[0].Subscribe(events.record)
+
+    leaving := members[2].Self().ID
+    members[2].Leave(ctx)
+    members[2].Close()
+    require.Eventually(t, func() bool {
+        return events.has(MemberLeft, leaving)
+    }, 5*time.Second, 20*time.Millisecond)
+    assert.False(t, events.has(MemberFailed, leaving))
+    require.Eventually(t, func() bool {
+        return len(members[1].Members()) == 2
+    }, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestMembership_RefutesSuspicion(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    _, members := startMembers(t, ctx, 2)
+    var events recordedEvents
+    members[0].Subscribe(events.record)
+
+    // A false rumour that the second node is dead is outdone by its next
+    // incarnation once it hears it.
+    self := members[1].Self()
+    rumour := self
+    rumour.State = StateDead
+    members[0].apply([]Member{rumour})
+    assert.True(t, events.has(MemberFailed, self.ID))
+    members[1].apply([]Member{rumour})
+    assert.Equal(t, self.Incarnation+1, members[1].Self().Incarnation)
+    assert.Equal(t, StateAlive, members[1].Self().State)
+
+    require.NoError(t, members[0].sync(ctx, self.ID))
+    assert.True(t, events.has(MemberJoined, self.ID))
+    assert.Len(t, members[0].Members(), 2)
+
+    // Older news no longer counts.
+    suspect := self
+    suspect.State = StateSuspect
+    members[0].apply([]Member{suspect})
+    for _, m := range members[0].Members() {
+        assert.Equal(t, StateAlive, m.State)
+    }
+}
+
+func TestMembership_IgnoresInvalidRecords(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    _, members := startMembers(t, ctx, 2)
+    other := members[1].Self()
+    for _, capacity := range []float64{-1, math.Inf(1), math.NaN()} {
+        u := Member{ID: peer.ID(fmt.Sprint("greedy-", capacity)), Meta: NodeMeta{Capacity: capacity}}
+        members[0].apply([]Member{u})
+    }
+    assert.Len(t, members[0].Members(), 2)
+
+    // A record no node could outdo is dropped, about others or oneself.
+    forged := other
+    forged.State = StateDead
+    forged.Incarnation = math.MaxUint64
+    members[0].apply([]Member{forged})
+    assert.Len(t, members[0].Members(), 2)
+    self := members[0].Self()
+    forged = self
+    forged.State = StateDead
+    forged.Incarnation = math.MaxUint64
+    members[0].apply([]Member{forged})
+    assert.Equal(t, self.Incarnation, members[0].Self().Incarnation)
+}
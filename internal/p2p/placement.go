@@ -0,0 +1,140 @@
+package p2p
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "math"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DefaultFailureDomains are the labels placement spreads replicas over,
+// widest first.
+var DefaultFailureDomains = []string{"zone", "rack"}
+
+// PlacementPeer is a node that blocks can be placed on. Capacity weights
+// its share of blocks; a peer without capacity receives none. Labels name
+// its failure domains, such as "zone" and "rack".
+type PlacementPeer struct {
+    ID       peer.ID
+    Capacity float64
+    Labels   map[string]string
+}
+
+// Placement chooses the peers that hold each block by weighted rendezvous
+// hashing: every peer scores every block, and the highest scores win. A
+// peer joining or leaving only moves the blocks it wins or held.
+type Placement struct {
+    domains []string
+
+    mu    sync.RWMutex
+    peers []PlacementPeer
+}
+
+func NewPlacement(domains []string) *Placement {
+    return &Placement{domains: domains}
+}
+
+// SetPeers replaces the set of live peers.
+func (p *Placement) SetPeers(peers []PlacementPeer) {
+    live := make([]PlacementPeer, 0, len(peers))
+    for _, peer := range peers {
+        if peer.Capacity > 0 {
+            live = append(live, peer)
+        }
+    }
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.peers = live
+}
+
+// Peers returns the live peers that have capacity.
+func (p *Placement) Peers() []PlacementPeer {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    return append([]PlacementPeer(nil), p.peers...)
+}
+
+// Place returns up to n peers for c, best first. Replicas go to distinct
+// zones while there are zones left, then to distinct racks, then to any
+// remaining peer.
+func (p *Placement) Place(c cid.Cid, n int) []peer.ID {
+    p.mu.RLock()
+    ranked := append([]PlacementPeer(nil), p.peers...)
+    p.mu.RUnlock()
+
+    scores := make(map[peer.ID]float64, len(ranked))
+    for _, candidate := range ranked {
+        scores[candidate.ID] = rendezvousScore(candidate, c)
+    }
+    sort.Slice(ranked, func(i, j int) bool {
+        si, sj := scores[ranked[i].ID], scores[ranked[j].ID]
+        if si != sj {
+            return si > sj
+        }
+        return ranked[i].ID < ranked[j].ID
+    })
+
+    var chosen []PlacementPeer
+    taken := make(map[peer.ID]bool)
+    for level := 0; level <= len(p.domains) && len(chosen) < n; level++ {
+        used := make(map[string]bool)
+        if level < len(p.domains) {
+            for _, peer := range chosen {
+                used[p.domainKey(peer, level)] = true
+            }
+        }
+        for _, candidate := range ranked {
+            if len(chosen) == n {
+                break
+            }
+            if taken[candidate.ID] {
+                continue
+            }
+            if level < len(p.domains) {
+                key := p.domainKey(candidate, level)
+                if used[key] {
+                    continue
+                }
+                used[key] = true
+            }
+            chosen = append(chosen, candidate)
+            taken[candidate.ID] = true
+        }
+    }
+
+    ids := make([]peer.ID, len(chosen))
+    for i, peer := range chosen {
+        ids[i] = peer.ID
+    }
+    return ids
+}
+
+// domainKey names the failure domain of peer at level, such as "a/r1" for
+// rack r1 of zone a. A peer missing a label is a domain of its own.
+func (p *Placement) domainKey(peer PlacementPeer, level int) string {
+    parts := make([]string, 0, level+1)
+    for _, label := range p.domains[:level+1] {
+        value, ok := peer.Labels[label]
+        if !ok || value == "" {
+            return "peer/" + string(peer.ID)
+        }
+        parts = append(parts, value)
+    }
+    return strings.Join(parts, "/")
+}
+
+// rendezvousScore weights a uniform hash of the peer and block so that a
+// peer's chance of winning is proportional to its capacity.
+func rendezvousScore(candidate PlacementPeer, c cid.Cid) float64 {
+    h := sha256.New()
+    h.Write([]byte(candidate.ID))
+    h.Write(c.Bytes())
+    sum := h.Sum(nil)
+    u := (float64(binary.BigEndian.Uint64(sum)>>11) + 0.5) / (1 << 53)
+    return candidate.Capacity / -math.Log(u)
+}
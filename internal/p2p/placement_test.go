@@ -0,0 +1,131 @@
+package p2p
+
+import (
+    "fmt"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func testCIDs(t *testing.T, n int) []cid.Cid {
+    var cids []cid.Cid
+    for i := 0; i < n; i++ {
+        c, err := dag.NewCID(dag.CodecRaw, []byte(fmt.Sprint("block ", i)))
+        require.NoError(t, err)
+        cids = append(cids, c)
+    }
+    return cids
+}
+
+func testPeers(n int) []PlacementPeer {
+    var peers []PlacementPeer
+    for i := 0; i < n; i++ {
+        peers = append(peers, PlacementPeer{ID: peer.ID(fmt.Sprint("peer-", i)), Capacity: 1})
+    }
+    return peers
+}
+
+func TestPlacement_MovesOnlyAffectedBlocks(t *testing.T) {
+    cids := testCIDs(t, 2000)
+    peers := testPeers(10)
+    p := NewPlacement(nil)
+    p.SetPeers(peers)
+    before := make(map[cid.Cid][]peer.ID)
+    for _, c := range cids {
+        before[c] = p.Place(c, 3)
+        require.Len(t, before[c], 3)
+        assert.Equal(t, before[c], p.Place(c, 3), "placement is deterministic")
+    }
+
+    // A leaving peer's blocks each gain one replacement; nothing else moves.
+    gone := peers[4].ID
+    p.SetPeers(append(append([]PlacementPeer(nil), peers[:4]...), peers[5:]...))
+    moved := 0
+    for _, c := range cids {
+        after := p.Place(c, 3)
+        if !assert.NotContains(t, after, gone) {
+            continue
+        }
+        var kept []peer.ID
+        for _, id := range before[c] {
+            if id != gone {
+                kept = append(kept, id)
+            }
+        }
+        assert.Subset(t, after, kept)
+        if len(kept) < 3 {
+            moved++
+        }
+    }
+    assert.InDelta(t, 2000*3/10, moved, 100)
+
+    // A joining peer only takes blocks for itself.
+    joined := PlacementPeer{ID: "newcomer", Capacity: 1}
+    p.SetPeers(append(peers, joined))
+    for _, c := range cids {
+        after := p.Place(c, 3)
+        for _, id := range after {
+            if id != joined.ID {
+                assert.Contains(t, before[c], id)
+            }
+        }
+    }
+}
+
+func TestPlacement_WeightsByCapacity(t *testing.T) {
+    cids := testCIDs(t, 4000)
+    peers := testPeers(3)
+    peers[2].Capacity = 2
+    peers = append(peers, PlacementPeer{ID: "draining", Capacity: 0})
+    p := NewPlacement(nil)
+    p.SetPeers(peers)
+    assert.Len(t, p.Peers(), 3)
+
+    first := make(map[peer.ID]int)
+    for _, c := range cids {
+        first[p.Place(c, 1)[0]]++
+    }
+    assert.InDelta(t, 2000, first[peers[2].ID], 200)
+    assert.InDelta(t, 1000, first[peers[0].ID], 150)
+    assert.Zero(t, first["draining"])
+}
+
+func TestPlacement_SpreadsAcrossFailureDomains(t *testing.T) {
+    var peers []PlacementPeer
+    for _, zone := range []string{"a", "b", "c"} {
+        for _, rack := range []string{"r1", "r2"} {
+            for i := 0; i < 2; i++ {
+                peers = append(peers, PlacementPeer{
+                    ID:       peer.ID(fmt.Sprint(zone, rack, i)),
+                    Capacity: 1,
+                    Labels:   map[string]string{"zone": zone, "rack": rack},
+                })
+            }
+        }
+    }
+    labels := make(map[peer.ID]map[string]string)
+    for _, p := range peers {
+        labels[p.ID] = p.Labels
+    }
+    p := NewPlacement(DefaultFailureDomains)
+    p.SetPeers(peers)
+
+    for _, c := range testCIDs(t, 200) {
+        zones := make(map[string]bool)
+        for _, id := range p.Place(c, 3) {
+            zones[labels[id]["zone"]] = true
+        }
+        assert.Len(t, zones, 3)
+
+        racks := make(map[string]bool)
+        for _, id := range p.Place(c, 6) {
+            racks[labels[id]["zone"]+labels[id]["rack"]] = true
+        }
+        assert.Len(t, racks, 6)
+        assert.Len(t, p.Place(c, 20), len(peers))
+    }
+}
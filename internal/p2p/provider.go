@@ -0,0 +1,188 @@
+package p2p
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/Alyanaky/SecureDAG/internal/metrics"
+    "github.com/ipfs/go-cid"
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+const (
+    // ReprovideInterval is how often every held block is announced again.
+    // Provider records expire after 48 hours.
+    ReprovideInterval = 22 * time.Hour
+    // ProvideBatchSize is how many blocks are announced between checks for
+    // reprovide and retry ticks.
+    ProvideBatchSize = 256
+    // ProvideConcurrency is how many announcements run at once.
+    ProvideConcurrency = 8
+
+    provideTimeout       = time.Minute
+    provideRetryInterval = 5 * time.Minute
+)
+
+// BlockLister calls fn with the CID of every block the node holds.
+type BlockLister func(ctx context.Context, fn func(c cid.Cid) error) error
+
+// Provider announces the blocks a node holds as DHT provider records. DAG
+// nodes, object roots among them, are announced before the raw chunks they
+// link to, so that peers can find an object as soon as possible.
+type Provider struct {
+    dht  *dht.IpfsDHT
+    wake chan struct{}
+
+    mu     sync.Mutex
+    nodes  []cid.Cid
+    leaves []cid.Cid
+    queued map[cid.Cid]bool
+    failed []cid.Cid
+}
+
+func NewProvider(d *dht.IpfsDHT) *Provider {
+    return &Provider{
+        dht:    d,
+        wake:   make(chan struct{}, 1),
+        queued: make(map[cid.Cid]bool),
+    }
+}
+
+// Announce queues c to be announced. Blocks already queued are skipped.
+func (p *Provider) Announce(c cid.Cid) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.enqueue(c)
+    select {
+    case p.wake <- struct{}{}:
+    default:
+    }
+}
+
+func (p *Provider) enqueue(c cid.Cid) {
+    if p.queued[c] {
+        return
+    }
+    p.queued[c] = true
+    if c.Prefix().Codec == dag.CodecRaw {
+        p.leaves = append(p.leaves, c)
+    } else {
+        p.nodes = append(p.nodes, c)
+    }
+    metrics.ProvideQueueLength.Set(float64(len(p.queued)))
+}
+
+// QueueLength returns the number of blocks waiting to be announced.
+func (p *Provider) QueueLength() int {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return len(p.queued)
+}
+
+// next takes up to n blocks off the queue, DAG nodes first.
+func (p *Provider) next(n int) []cid.Cid {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    var batch []cid.Cid
+    for _, queue := range []*[]cid.Cid{&p.nodes, &p.leaves} {
+        take := min(n-len(batch), len(*queue))
+        batch = append(batch, (*queue)[:take]...)
+        *queue = (*queue)[take:]
+    }
+    for _, c := range batch {
+        delete(p.queued, c)
+    }
+    metrics.ProvideQueueLength.Set(float64(len(p.queued)))
+    return batch
+}
+
+// Run announces queued blocks until ctx is cancelled. Every block that list
+// reports is announced at start and again each interval; announcements that
+// fail are retried a few minutes later.
+func (p *Provider) Run(ctx context.Context, list BlockLister, interval time.Duration) {
+    reprovide := time.NewTicker(interval)
+    defer reprovide.Stop()
+    retry := time.NewTicker(provideRetryInterval)
+    defer retry.Stop()
+
+    p.reprovide(ctx, list)
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-reprovide.C:
+            p.reprovide(ctx, list)
+        case <-retry.C:
+            p.retry()
+        default:
+        }
+        if batch := p.next(ProvideBatchSize); len(batch) > 0 {
+            p.provide(ctx, batch)
+            continue
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-reprovide.C:
+            p.reprovide(ctx, list)
+        case <-retry.C:
+            p.retry()
+        case <-p.wake:
+        }
+    }
+}
+
+func (p *Provider) reprovide(ctx context.Context, list BlockLister) {
+    err := list(ctx, func(c cid.Cid) error {
+        p.Announce(c)
+        return nil
+    })
+    if err != nil {
+        log.Printf("Listing blocks to reprovide failed: %v", err)
+    }
+}
+
+func (p *Provider) retry() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    for _, c := range p.failed {
+        p.enqueue(c)
+    }
+    p.failed = nil
+}
+
+// provide announces a batch, ProvideConcurrency blocks at a time.
+func (p *Provider) provide(ctx context.Context, batch []cid.Cid) {
+    var (
+        wg     sync.WaitGroup
+        mu     sync.Mutex
+        failed []cid.Cid
+    )
+    sem := make(chan struct{}, ProvideConcurrency)
+    for _, c := range batch {
+        sem <- struct{}{}
+        wg.Add(1)
+        go func(c cid.Cid) {
+            defer func() { <-sem; wg.Done() }()
+            ctx, cancel := context.WithTimeout(ctx, provideTimeout)
+            defer cancel()
+            if err := p.dht.Provide(ctx, c, true); err != nil {
+                metrics.ProvideFailures.Inc()
+                mu.Lock()
+                failed = append(failed, c)
+                mu.Unlock()
+            }
+        }(c)
+    }
+    wg.Wait()
+
+    if len(failed) > 0 && ctx.Err() == nil {
+        log.Printf("Failed to announce %d of %d blocks", len(failed), len(batch))
+        p.mu.Lock()
+        p.failed = append(p.failed, failed...)
+        p.mu.Unlock()
+    }
+}
@@ -0,0 +1,62 @@
+package p2p
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/ipfs/go-cid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestProvider_AnnouncesDAGNodesFirst(t *testing.T) {
+    p := NewProvider(nil)
+    var leaves, nodes []cid.Cid
+    for i := 0; i < 3; i++ {
+        leaf, err := dag.NewCID(dag.CodecRaw, []byte(fmt.Sprint("leaf ", i)))
+        require.NoError(t, err)
+        node, err := dag.NewCID(dag.CodecDagCBOR, []byte(fmt.Sprint("node ", i)))
+        require.NoError(t, err)
+        leaves, nodes = append(leaves, leaf), append(nodes, node)
+        p.Announce(leaf)
+        p.Announce(node)
+    }
+    p.Announce(leaves[0])
+    assert.Equal(t, 6, p.QueueLength())
+
+    assert.Equal(t, append(nodes, leaves[0]), p.next(4))
+    assert.Equal(t, leaves[1:], p.next(4))
+    assert.Empty(t, p.next(4))
+    assert.Zero(t, p.QueueLength())
+}
+
+func TestProvider_RunAnnouncesListedAndQueuedBlocks(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    nodes := startCluster(t, ctx, 2)
+
+    held, err := dag.NewCID(dag.CodecRaw, []byte("held since start"))
+    require.NoError(t, err)
+    written, err := dag.NewCID(dag.CodecRaw, []byte("written later"))
+    require.NoError(t, err)
+    list := func(ctx context.Context, fn func(cid.Cid) error) error {
+        return fn(held)
+    }
+
+    p := NewProvider(nodes[0].DHT)
+    go p.Run(ctx, list, time.Hour)
+    p.Announce(written)
+
+    exchange := NewExchange(nodes[1], newMemoryBlockstore())
+    for _, c := range []cid.Cid{held, written} {
+        c := c
+        require.Eventually(t, func() bool {
+            providers, err := exchange.FindProviders(ctx, c)
+            return err == nil && len(providers) == 1 && providers[0] == nodes[0].Host.ID()
+        }, 10*time.Second, 50*time.Millisecond)
+    }
+    assert.Zero(t, p.QueueLength())
+}
@@ -0,0 +1,224 @@
+package p2p
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    pubsub "github.com/libp2p/go-libp2p-pubsub"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// GossipProtocol is the GossipSub protocol nodes exchange subscriptions
+// and messages over. It is namespaced so that only SecureDAG nodes mesh
+// with each other.
+const GossipProtocol = protocol.ID("/" + Namespace + "/meshsub/1.1.0")
+
+const (
+    // SeenTTL is how long a message is remembered, so that copies of it
+    // arriving over other paths are dropped.
+    SeenTTL = 2 * time.Minute
+
+    maxGossipMessage = 16 << 20
+)
+
+// Message is a message published to a topic, signed by the node that
+// published it.
+type Message struct {
+    Topic string
+    From  peer.ID
+    Data  []byte
+}
+
+// PubSub broadcasts messages to the nodes subscribed to a topic over
+// libp2p GossipSub. Every message is signed with its publisher's libp2p
+// key. Only messages published by cluster members and relayed by members
+// are delivered or passed on, and only members are grafted into the mesh;
+// until SetMembers is called that is none but the node itself.
+type PubSub struct {
+    ps     *pubsub.PubSub
+    self   peer.ID
+    cancel context.CancelFunc
+
+    // membersMu is separate from mu, as GossipSub consults members while
+    // Subscribe holds mu and waits for it.
+    membersMu sync.Mutex
+    members   func(peer.ID) bool
+
+    mu       sync.Mutex
+    topics   map[string]*pubsub.Topic
+    handlers map[string]func(*Message)
+    subs     map[string]*pubsub.Subscription
+}
+
+// NewPubSub starts GossipSub for the peers of n. It subscribes to no
+// topic.
+func NewPubSub(ctx context.Context, n *Node) (*PubSub, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    ps := &PubSub{
+        self:     n.Host.ID(),
+        cancel:   cancel,
+        topics:   make(map[string]*pubsub.Topic),
+        handlers: make(map[string]func(*Message)),
+        subs:     make(map[string]*pubsub.Subscription),
+    }
+    features := func(feat pubsub.GossipSubFeature, _ protocol.ID) bool {
+        return pubsub.GossipSubDefaultFeatures(feat, pubsub.GossipSubID_v11)
+    }
+    var err error
+    ps.ps, err = pubsub.NewGossipSub(ctx, n.Host,
+        pubsub.WithGossipSubProtocols([]protocol.ID{GossipProtocol}, features),
+        pubsub.WithMessageSignaturePolicy(pubsub.StrictSign),
+        // A node sends what it publishes to every subscriber rather than
+        // just its mesh, so that peers not yet grafted do not miss it.
+        pubsub.WithFloodPublish(true),
+        pubsub.WithPeerFilter(func(p peer.ID, _ string) bool { return ps.isMember(p) }),
+        pubsub.WithDefaultValidator(pubsub.ValidatorEx(ps.validate)),
+        pubsub.WithMaxMessageSize(maxGossipMessage),
+        pubsub.WithSeenMessagesTTL(SeenTTL),
+    )
+    if err != nil {
+        cancel()
+        return nil, err
+    }
+    return ps, nil
+}
+
+// Close stops relaying messages.
+func (ps *PubSub) Close() {
+    ps.mu.Lock()
+    for topic, sub := range ps.subs {
+        sub.Cancel()
+        delete(ps.subs, topic)
+    }
+    ps.mu.Unlock()
+    ps.cancel()
+}
+
+// SetMembers makes the node accept messages that members reports were
+// published and relayed by cluster members.
+func (ps *PubSub) SetMembers(members func(peer.ID) bool) {
+    ps.membersMu.Lock()
+    defer ps.membersMu.Unlock()
+    ps.members = members
+}
+
+func (ps *PubSub) isMember(p peer.ID) bool {
+    if p == ps.self {
+        return true
+    }
+    ps.membersMu.Lock()
+    members := ps.members
+    ps.membersMu.Unlock()
+    return members != nil && members(p)
+}
+
+// validate rejects messages from outside the cluster. GossipSub has
+// checked the publisher's signature already.
+func (ps *PubSub) validate(_ context.Context, from peer.ID, m *pubsub.Message) pubsub.ValidationResult {
+    if !ps.isMember(from) || !ps.isMember(m.GetFrom()) {
+        return pubsub.ValidationReject
+    }
+    return pubsub.ValidationAccept
+}
+
+// topic returns the handle of topic, joining it on first use. ps.mu must
+// be held.
+func (ps *PubSub) topic(name string) (*pubsub.Topic, error) {
+    if t, ok := ps.topics[name]; ok {
+        return t, nil
+    }
+    t, err := ps.ps.Join(name)
+    if err != nil {
+        return nil, err
+    }
+    ps.topics[name] = t
+    return t, nil
+}
+
+// Subscribe calls fn with every message published to topic by other
+// nodes, replacing any earlier handler. Handlers run concurrently.
+func (ps *PubSub) Subscribe(topic string, fn func(*Message)) error {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    ps.handlers[topic] = fn
+    if _, ok := ps.subs[topic]; ok {
+        return nil
+    }
+    t, err := ps.topic(topic)
+    if err != nil {
+        return err
+    }
+    sub, err := t.Subscribe()
+    if err != nil {
+        return err
+    }
+    ps.subs[topic] = sub
+    go ps.deliver(topic, sub)
+    return nil
+}
+
+// deliver hands the messages of sub to the topic's handler until sub is
+// cancelled.
+func (ps *PubSub) deliver(topic string, sub *pubsub.Subscription) {
+    for {
+        m, err := sub.Next(context.Background())
+        if err != nil {
+            return
+        }
+        if m.GetFrom() == ps.self {
+            continue
+        }
+        ps.mu.Lock()
+        fn := ps.handlers[topic]
+        ps.mu.Unlock()
+        if fn != nil {
+            go fn(&Message{Topic: topic, From: m.GetFrom(), Data: m.Data})
+        }
+    }
+}
+
+// Unsubscribe stops delivering messages published to topic.
+func (ps *PubSub) Unsubscribe(topic string) {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    delete(ps.handlers, topic)
+    if sub, ok := ps.subs[topic]; ok {
+        sub.Cancel()
+        delete(ps.subs, topic)
+    }
+}
+
+// Subscribed reports whether this node subscribes to topic.
+func (ps *PubSub) Subscribed(topic string) bool {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    _, ok := ps.subs[topic]
+    return ok
+}
+
+// Subscribers returns the connected cluster members that subscribe to
+// topic.
+func (ps *PubSub) Subscribers(topic string) []peer.ID {
+    var peers []peer.ID
+    for _, p := range ps.ps.ListPeers(topic) {
+        if ps.isMember(p) {
+            peers = append(peers, p)
+        }
+    }
+    return peers
+}
+
+// Publish signs data and sends it to the subscribers of topic. It returns
+// once the message is handed to GossipSub; subscribers that miss it are
+// left to hear of it from others.
+func (ps *PubSub) Publish(ctx context.Context, topic string, data []byte) error {
+    ps.mu.Lock()
+    t, err := ps.topic(topic)
+    ps.mu.Unlock()
+    if err != nil {
+        return err
+    }
+    return t.Publish(ctx, data)
+}
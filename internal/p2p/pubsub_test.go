@@ -0,0 +1,68 @@
+package p2p
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+type receivedMessages struct {
+    mu   sync.Mutex
+    data []string
+}
+
+func (r *receivedMessages) record(m *Message) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.data = append(r.data, string(m.Data))
+}
+
+func (r *receivedMessages) get() []string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([]string(nil), r.data...)
+}
+
+func TestPubSub_DeliversOnceToSubscribers(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    // The last node is not a member of the cluster.
+    nodes := startCluster(t, ctx, 4)
+    outsider := nodes[3].Host.ID()
+    pubsubs := make([]*PubSub, len(nodes))
+    received := make([]*receivedMessages, len(nodes))
+    for i, n := range nodes {
+        var err error
+        pubsubs[i], err = NewPubSub(ctx, n)
+        require.NoError(t, err)
+        t.Cleanup(pubsubs[i].Close)
+        pubsubs[i].SetMembers(func(p peer.ID) bool { return p != outsider })
+        received[i] = &receivedMessages{}
+    }
+    for i := 1; i < 3; i++ {
+        require.NoError(t, pubsubs[i].Subscribe("topic", received[i].record))
+    }
+    require.Eventually(t, func() bool {
+        return len(pubsubs[0].Subscribers("topic")) == 2 && len(pubsubs[3].Subscribers("topic")) == 2
+    }, 5*time.Second, 20*time.Millisecond)
+
+    require.NoError(t, pubsubs[0].Publish(ctx, "topic", []byte("hello")))
+    require.NoError(t, pubsubs[3].Publish(ctx, "topic", []byte("from outside")))
+    for i := 1; i < 3; i++ {
+        i := i
+        require.Eventually(t, func() bool {
+            return len(received[i].get()) == 1
+        }, 5*time.Second, 20*time.Millisecond)
+    }
+    time.Sleep(500 * time.Millisecond)
+    assert.Equal(t, []string{"hello"}, received[1].get())
+    assert.Equal(t, []string{"hello"}, received[2].get())
+    assert.Empty(t, received[0].get())
+    assert.Empty(t, received[3].get())
+}
@@ -0,0 +1,14 @@
+package s3
+
+import (
+    "context"
+    "io"
+)
+
+func (a *S3Adapter) ExportCAR(ctx context.Context, w io.Writer, bucket, key string, version int) error {
+    return a.storageBackend.ExportCAR(ctx, w, bucket, key, version)
+}
+
+func (a *S3Adapter) ImportCAR(ctx context.Context, r io.Reader, bucket string) (int, error) {
+    return a.storageBackend.ImportCAR(ctx, r, bucket)
+}
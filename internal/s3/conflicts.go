@@ -0,0 +1,19 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) PutConflictConfiguration(ctx context.Context, bucket string, cfg storage.ConflictConfiguration) error {
+    return a.storageBackend.PutConflictConfiguration(bucket, cfg)
+}
+
+func (a *S3Adapter) GetConflictConfiguration(ctx context.Context, bucket string) (storage.ConflictConfiguration, error) {
+    return a.storageBackend.GetConflictConfiguration(bucket)
+}
+
+func (a *S3Adapter) ListConflicts(ctx context.Context, bucket string, limit int) ([]storage.Conflict, error) {
+    return a.storageBackend.ListConflicts(ctx, bucket, limit)
+}
@@ -0,0 +1,11 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) DiffObjectVersions(ctx context.Context, bucket, key, fromVersionID, toVersionID string) (*storage.VersionDiff, error) {
+    return a.storageBackend.DiffVersions(bucket, key, fromVersionID, toVersionID)
+}
@@ -0,0 +1,19 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) PutErasureConfiguration(ctx context.Context, bucket string, cfg storage.ErasureConfiguration) error {
+    return a.storageBackend.PutErasureConfiguration(bucket, cfg)
+}
+
+func (a *S3Adapter) GetErasureConfiguration(ctx context.Context, bucket string) (storage.ErasureConfiguration, error) {
+    return a.storageBackend.GetErasureConfiguration(bucket)
+}
+
+func (a *S3Adapter) HealStripes(ctx context.Context) (int, error) {
+    return a.storageBackend.HealStripes(ctx)
+}
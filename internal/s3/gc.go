@@ -0,0 +1,11 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) CollectGarbage(ctx context.Context, opts storage.GCOptions) (storage.GCReport, error) {
+    return a.storageBackend.CollectGarbage(ctx, opts)
+}
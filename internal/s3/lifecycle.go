@@ -0,0 +1,209 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/lifecycle"
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func (a *S3Adapter) lifecycleManager() *lifecycle.LifecycleManager {
+    return lifecycle.NewLifecycleManager(a.storageBackend)
+}
+
+func (a *S3Adapter) PutBucketLifecycleConfiguration(ctx context.Context, input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+    var cfg lifecycle.Configuration
+    if input.LifecycleConfiguration != nil {
+        for _, r := range input.LifecycleConfiguration.Rules {
+            cfg.Rules = append(cfg.Rules, fromLifecycleRule(r))
+        }
+    }
+    if err := a.lifecycleManager().PutConfiguration(*input.Bucket, &cfg); err != nil {
+        return nil, err
+    }
+    return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func (a *S3Adapter) GetBucketLifecycleConfiguration(ctx context.Context, input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+    cfg, err := a.lifecycleManager().GetConfiguration(*input.Bucket)
+    if err != nil {
+        return nil, err
+    }
+    out := &s3.GetBucketLifecycleConfigurationOutput{}
+    for _, r := range cfg.Rules {
+        out.Rules = append(out.Rules, toLifecycleRule(r))
+    }
+    return out, nil
+}
+
+func (a *S3Adapter) DeleteBucketLifecycle(ctx context.Context, input *s3.DeleteBucketLifecycleInput) (*s3.DeleteBucketLifecycleOutput, error) {
+    if err := a.lifecycleManager().DeleteConfiguration(*input.Bucket); err != nil {
+        return nil, err
+    }
+    return &s3.DeleteBucketLifecycleOutput{}, nil
+}
+
+func (a *S3Adapter) PutLifecycleConfiguration(ctx context.Context, bucket string, cfg *lifecycle.Configuration) error {
+    return a.lifecycleManager().PutConfiguration(bucket, cfg)
+}
+
+func (a *S3Adapter) GetLifecycleConfiguration(ctx context.Context, bucket string) (*lifecycle.Configuration, error) {
+    return a.lifecycleManager().GetConfiguration(bucket)
+}
+
+func (a *S3Adapter) ApplyLifecycle(ctx context.Context, bucket string) (*lifecycle.Report, error) {
+    return a.lifecycleManager().Apply(ctx, bucket)
+}
+
+func (a *S3Adapter) DryRunLifecycle(ctx context.Context, bucket string, cfg *lifecycle.Configuration, ruleID string) (*lifecycle.Report, error) {
+    return a.lifecycleManager().DryRun(ctx, bucket, cfg, ruleID)
+}
+
+func fromLifecycleRule(r types.LifecycleRule) lifecycle.Rule {
+    rule := lifecycle.Rule{
+        ID:     aws.ToString(r.ID),
+        Status: string(r.Status),
+    }
+    if f := r.Filter; f != nil {
+        rule.Filter = lifecycle.Filter{
+            Prefix:                aws.ToString(f.Prefix),
+            ObjectSizeGreaterThan: aws.ToInt64(f.ObjectSizeGreaterThan),
+            ObjectSizeLessThan:    aws.ToInt64(f.ObjectSizeLessThan),
+        }
+        tags := []types.Tag{}
+        if f.Tag != nil {
+            tags = append(tags, *f.Tag)
+        }
+        if and := f.And; and != nil {
+            if and.Prefix != nil {
+                rule.Filter.Prefix = *and.Prefix
+            }
+            if and.ObjectSizeGreaterThan != nil {
+                rule.Filter.ObjectSizeGreaterThan = *and.ObjectSizeGreaterThan
+            }
+            if and.ObjectSizeLessThan != nil {
+                rule.Filter.ObjectSizeLessThan = *and.ObjectSizeLessThan
+            }
+            tags = append(tags, and.Tags...)
+        }
+        for _, t := range tags {
+            if rule.Filter.Tags == nil {
+                rule.Filter.Tags = make(map[string]string)
+            }
+            rule.Filter.Tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+        }
+    }
+    if e := r.Expiration; e != nil {
+        rule.Expiration = &lifecycle.Expiration{
+            Days:                      int(aws.ToInt32(e.Days)),
+            Date:                      e.Date,
+            ExpiredObjectDeleteMarker: aws.ToBool(e.ExpiredObjectDeleteMarker),
+        }
+    }
+    if n := r.NoncurrentVersionExpiration; n != nil {
+        rule.NoncurrentVersionExpiration = &lifecycle.NoncurrentVersionExpiration{
+            NoncurrentDays:          int(aws.ToInt32(n.NoncurrentDays)),
+            NewerNoncurrentVersions: int(aws.ToInt32(n.NewerNoncurrentVersions)),
+        }
+    }
+    if u := r.AbortIncompleteMultipartUpload; u != nil {
+        rule.AbortIncompleteMultipartUpload = &lifecycle.AbortIncompleteMultipartUpload{
+            DaysAfterInitiation: int(aws.ToInt32(u.DaysAfterInitiation)),
+        }
+    }
+    for _, t := range r.Transitions {
+        rule.Transitions = append(rule.Transitions, lifecycle.Transition{
+            Days:         int(aws.ToInt32(t.Days)),
+            Date:         t.Date,
+            StorageClass: string(t.StorageClass),
+        })
+    }
+    return rule
+}
+
+func toLifecycleRule(r lifecycle.Rule) types.LifecycleRule {
+    rule := types.LifecycleRule{
+        ID:     aws.String(r.ID),
+        Status: types.ExpirationStatus(r.Status),
+        Filter: toLifecycleFilter(r.Filter),
+    }
+    if e := r.Expiration; e != nil {
+        rule.Expiration = &types.LifecycleExpiration{Date: e.Date}
+        if e.Days > 0 {
+            rule.Expiration.Days = aws.Int32(int32(e.Days))
+        }
+        if e.ExpiredObjectDeleteMarker {
+            rule.Expiration.ExpiredObjectDeleteMarker = aws.Bool(true)
+        }
+    }
+    if n := r.NoncurrentVersionExpiration; n != nil {
+        rule.NoncurrentVersionExpiration = &types.NoncurrentVersionExpiration{
+            NoncurrentDays: aws.Int32(int32(n.NoncurrentDays)),
+        }
+        if n.NewerNoncurrentVersions > 0 {
+            rule.NoncurrentVersionExpiration.NewerNoncurrentVersions = aws.Int32(int32(n.NewerNoncurrentVersions))
+        }
+    }
+    if u := r.AbortIncompleteMultipartUpload; u != nil {
+        rule.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{
+            DaysAfterInitiation: aws.Int32(int32(u.DaysAfterInitiation)),
+        }
+    }
+    for _, t := range r.Transitions {
+        transition := types.Transition{Date: t.Date, StorageClass: types.TransitionStorageClass(t.StorageClass)}
+        if t.Days > 0 {
+            transition.Days = aws.Int32(int32(t.Days))
+        }
+        rule.Transitions = append(rule.Transitions, transition)
+    }
+    return rule
+}
+
+// toLifecycleFilter uses an And operator when more than one condition is set,
+// as S3 requires.
+func toLifecycleFilter(f lifecycle.Filter) *types.LifecycleRuleFilter {
+    var tags []types.Tag
+    for k, v := range f.Tags {
+        tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+    }
+    prefix, greater, less := optionalString(f.Prefix), optionalInt64(f.ObjectSizeGreaterThan), optionalInt64(f.ObjectSizeLessThan)
+    conditions := len(tags)
+    for _, set := range []bool{prefix != nil, greater != nil, less != nil} {
+        if set {
+            conditions++
+        }
+    }
+    if conditions > 1 {
+        return &types.LifecycleRuleFilter{And: &types.LifecycleRuleAndOperator{
+            Prefix:                prefix,
+            Tags:                  tags,
+            ObjectSizeGreaterThan: greater,
+            ObjectSizeLessThan:    less,
+        }}
+    }
+    filter := &types.LifecycleRuleFilter{
+        Prefix:                prefix,
+        ObjectSizeGreaterThan: greater,
+        ObjectSizeLessThan:    less,
+    }
+    if len(tags) == 1 {
+        filter.Tag = &tags[0]
+    }
+    return filter
+}
+
+func optionalString(s string) *string {
+    if s == "" {
+        return nil
+    }
+    return &s
+}
+
+func optionalInt64(n int64) *int64 {
+    if n == 0 {
+        return nil
+    }
+    return &n
+}
@@ -0,0 +1,11 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) ProveObjectRange(ctx context.Context, bucket, key, versionID string, offset, length int64) (*storage.RangeProof, error) {
+    return a.storageBackend.ProveRange(bucket, key, versionID, offset, length)
+}
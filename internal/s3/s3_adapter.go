@@ -3,10 +3,16 @@ package s3
 import (
     "bytes"
     "context"
+    "fmt"
     "io"
+    "net/http"
+    "net/url"
+    "strings"
 
+    "github.com/Alyanaky/SecureDAG/internal/auth"
     "github.com/Alyanaky/SecureDAG/internal/storage"
     "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
     "github.com/aws/aws-sdk-go-v2/aws"
 )
 
@@ -27,15 +33,25 @@ func (a *S3Adapter) PutObject(ctx context.Context, input *s3.PutObjectInput) (*s
     if err != nil {
         return nil, err
     }
-    err = a.storageBackend.PutObject(*input.Bucket, *input.Key, data)
+    opts := storage.PutOptions{
+        Tags:         parseTagging(aws.ToString(input.Tagging)),
+        StorageClass: string(input.StorageClass),
+    }
+    version, err := a.storageBackend.PutObjectWithOptions(*input.Bucket, *input.Key, data, opts)
     if err != nil {
         return nil, err
     }
-    return &s3.PutObjectOutput{}, nil
+    return &s3.PutObjectOutput{VersionId: aws.String(version.VersionID)}, nil
 }
 
 func (a *S3Adapter) GetObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
-    data, err := a.storageBackend.GetObject(*input.Bucket, *input.Key)
+    var data []byte
+    var err error
+    if input.VersionId != nil {
+        data, err = a.storageBackend.GetObjectVersion(*input.Bucket, *input.Key, *input.VersionId)
+    } else {
+        data, err = a.storageBackend.GetObject(*input.Bucket, *input.Key)
+    }
     if err != nil {
         return nil, err
     }
@@ -44,22 +60,191 @@ func (a *S3Adapter) GetObject(ctx context.Context, input *s3.GetObjectInput) (*s
     }, nil
 }
 
+func (a *S3Adapter) HeadObject(ctx context.Context, input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+    info, err := a.storageBackend.GetObjectInfo(*input.Bucket, *input.Key)
+    if err != nil {
+        return nil, err
+    }
+    version, ok := info.Latest()
+    if input.VersionId != nil {
+        ok = false
+        for _, v := range info.Versions {
+            if v.VersionID == *input.VersionId {
+                version, ok = v, true
+            }
+        }
+    }
+    if !ok || version.IsDeleteMarker {
+        return nil, storage.ErrNotFound
+    }
+    output := &s3.HeadObjectOutput{
+        ContentLength: aws.Int64(version.Size),
+        LastModified:  aws.Time(version.LastModified),
+        VersionId:     aws.String(version.VersionID),
+        StorageClass:  types.StorageClass(version.EffectiveStorageClass()),
+    }
+    if version.RestoredUntil != nil {
+        output.Restore = aws.String(fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`,
+            version.RestoredUntil.Format(http.TimeFormat)))
+    }
+    return output, nil
+}
+
+// ListObjectsV2 lists the current versions of a bucket's objects. The
+// continuation token is the last key returned.
+func (a *S3Adapter) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+    maxKeys := int(aws.ToInt32(input.MaxKeys))
+    if maxKeys <= 0 || maxKeys > 1000 {
+        maxKeys = 1000
+    }
+    prefix := aws.ToString(input.Prefix)
+    startAfter := aws.ToString(input.StartAfter)
+    if input.ContinuationToken != nil {
+        startAfter = *input.ContinuationToken
+    }
+
+    output := &s3.ListObjectsV2Output{Name: input.Bucket, Prefix: input.Prefix, MaxKeys: aws.Int32(int32(maxKeys))}
+    for {
+        batch, err := a.storageBackend.ListObjects(*input.Bucket, startAfter, maxKeys)
+        if err != nil {
+            return nil, err
+        }
+        for _, info := range batch {
+            startAfter = info.Key
+            latest, ok := info.Latest()
+            if !strings.HasPrefix(info.Key, prefix) || !ok || latest.IsDeleteMarker {
+                continue
+            }
+            output.Contents = append(output.Contents, types.Object{
+                Key:          aws.String(info.Key),
+                Size:         aws.Int64(latest.Size),
+                LastModified: aws.Time(latest.LastModified),
+                StorageClass: types.ObjectStorageClass(latest.EffectiveStorageClass()),
+            })
+            if len(output.Contents) == maxKeys {
+                output.IsTruncated = aws.Bool(true)
+                output.NextContinuationToken = aws.String(info.Key)
+                output.KeyCount = aws.Int32(int32(maxKeys))
+                return output, nil
+            }
+        }
+        if len(batch) < maxKeys || (prefix != "" && startAfter > prefix && !strings.HasPrefix(startAfter, prefix)) {
+            break
+        }
+    }
+    output.IsTruncated = aws.Bool(false)
+    output.KeyCount = aws.Int32(int32(len(output.Contents)))
+    return output, nil
+}
+
+// RestoreArchivedObject makes a GLACIER object readable for the requested
+// number of days.
+func (a *S3Adapter) RestoreArchivedObject(ctx context.Context, input *s3.RestoreObjectInput) (*s3.RestoreObjectOutput, error) {
+    days := 1
+    if input.RestoreRequest != nil && input.RestoreRequest.Days != nil {
+        days = int(*input.RestoreRequest.Days)
+    }
+    if err := a.storageBackend.RestoreArchivedObject(ctx, *input.Bucket, *input.Key, aws.ToString(input.VersionId), days); err != nil {
+        return nil, err
+    }
+    return &s3.RestoreObjectOutput{}, nil
+}
+
 func (a *S3Adapter) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
-    err := a.storageBackend.DeleteObject(*input.Bucket, *input.Key)
+    bypass := aws.ToBool(input.BypassGovernanceRetention)
+    versionID := aws.ToString(input.VersionId)
+    var err error
+    if versionID == "" {
+        err = a.storageBackend.RemoveObject(ctx, *input.Bucket, *input.Key, auth.UserFromContext(ctx), bypass)
+    } else {
+        err = a.storageBackend.DeleteObjectVersion(ctx, *input.Bucket, *input.Key, versionID, bypass)
+    }
     if err != nil {
         return nil, err
     }
-    return &s3.DeleteObjectOutput{}, nil
+    return &s3.DeleteObjectOutput{VersionId: input.VersionId}, nil
+}
+
+func (a *S3Adapter) PutBucketVersioning(ctx context.Context, input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+    var status storage.VersioningStatus
+    if input.VersioningConfiguration != nil {
+        status = storage.VersioningStatus(input.VersioningConfiguration.Status)
+    }
+    if err := a.storageBackend.PutBucketVersioning(*input.Bucket, status); err != nil {
+        return nil, err
+    }
+    return &s3.PutBucketVersioningOutput{}, nil
+}
+
+func (a *S3Adapter) GetBucketVersioning(ctx context.Context, input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error) {
+    status, err := a.storageBackend.GetBucketVersioning(*input.Bucket)
+    if err != nil {
+        return nil, err
+    }
+    return &s3.GetBucketVersioningOutput{Status: types.BucketVersioningStatus(status)}, nil
 }
 
 func (a *S3Adapter) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+    opts := storage.PutOptions{
+        Tags:         parseTagging(aws.ToString(input.Tagging)),
+        StorageClass: string(input.StorageClass),
+    }
+    if !storage.ValidStorageClass(opts.StorageClass) {
+        return nil, storage.ErrInvalidStorageClass
+    }
+    upload, err := a.storageBackend.CreateMultipartUpload(*input.Bucket, *input.Key, opts)
+    if err != nil {
+        return nil, err
+    }
     return &s3.CreateMultipartUploadOutput{
-        UploadId: aws.String("mock-upload-id"),
+        Bucket:   input.Bucket,
+        Key:      input.Key,
+        UploadId: aws.String(upload.UploadID),
     }, nil
 }
 
 func (a *S3Adapter) UploadPart(ctx context.Context, input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+    data, err := io.ReadAll(input.Body)
+    if err != nil {
+        return nil, err
+    }
+    etag, err := a.storageBackend.UploadPart(*input.Bucket, *input.UploadId, int(aws.ToInt32(input.PartNumber)), data)
+    if err != nil {
+        return nil, err
+    }
     return &s3.UploadPartOutput{
-        ETag: aws.String("mock-etag"),
+        ETag: aws.String(`"` + etag + `"`),
     }, nil
 }
+
+func (a *S3Adapter) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+    version, err := a.storageBackend.CompleteMultipartUpload(*input.Bucket, *input.UploadId)
+    if err != nil {
+        return nil, err
+    }
+    return &s3.CompleteMultipartUploadOutput{
+        Bucket:    input.Bucket,
+        Key:       input.Key,
+        VersionId: aws.String(version.VersionID),
+    }, nil
+}
+
+func (a *S3Adapter) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+    if err := a.storageBackend.AbortMultipartUpload(*input.Bucket, *input.UploadId); err != nil {
+        return nil, err
+    }
+    return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// parseTagging decodes an x-amz-tagging header of the form "k1=v1&k2=v2".
+func parseTagging(tagging string) map[string]string {
+    values, err := url.ParseQuery(tagging)
+    if err != nil || len(values) == 0 {
+        return nil
+    }
+    tags := make(map[string]string, len(values))
+    for k, v := range values {
+        tags[k] = v[0]
+    }
+    return tags
+}
@@ -0,0 +1,15 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) Scrub(ctx context.Context, opts storage.ScrubOptions) (storage.ScrubReport, error) {
+    return a.storageBackend.Scrub(ctx, opts)
+}
+
+func (a *S3Adapter) LastScrubReport(ctx context.Context) (storage.ScrubReport, error) {
+    return a.storageBackend.LastScrubReport()
+}
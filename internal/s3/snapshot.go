@@ -0,0 +1,24 @@
+package s3
+
+import (
+    "context"
+    "crypto/ed25519"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) SnapshotBucket(ctx context.Context, bucket string) (*storage.BucketSnapshot, error) {
+    return a.storageBackend.SnapshotBucket(bucket)
+}
+
+func (a *S3Adapter) ListSnapshots(ctx context.Context, bucket string) ([]storage.BucketSnapshot, error) {
+    return a.storageBackend.ListSnapshots(bucket)
+}
+
+func (a *S3Adapter) ProveSnapshotInclusion(ctx context.Context, bucket string, seq uint64, key, versionID string) (*storage.SnapshotProof, error) {
+    return a.storageBackend.ProveSnapshotInclusion(bucket, seq, key, versionID)
+}
+
+func (a *S3Adapter) SigningPublicKey() ed25519.PublicKey {
+    return a.storageBackend.SigningPublicKey()
+}
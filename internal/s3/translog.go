@@ -0,0 +1,23 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+)
+
+func (a *S3Adapter) LogTreeHead(ctx context.Context) (*translog.TreeHead, error) {
+    return a.storageBackend.TransparencyLog().TreeHead()
+}
+
+func (a *S3Adapter) LogEntries(ctx context.Context, start, end uint64) ([]translog.Record, error) {
+    return a.storageBackend.TransparencyLog().Entries(start, end)
+}
+
+func (a *S3Adapter) LogInclusionProof(ctx context.Context, index, size uint64) (*translog.InclusionProof, error) {
+    return a.storageBackend.TransparencyLog().InclusionProof(index, size)
+}
+
+func (a *S3Adapter) LogConsistencyProof(ctx context.Context, first, second uint64) (*translog.ConsistencyProof, error) {
+    return a.storageBackend.TransparencyLog().ConsistencyProof(first, second)
+}
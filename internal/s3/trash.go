@@ -0,0 +1,23 @@
+package s3
+
+import (
+    "context"
+
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+func (a *S3Adapter) PutTrashConfiguration(ctx context.Context, bucket string, cfg storage.TrashConfiguration) error {
+    return a.storageBackend.PutTrashConfiguration(bucket, cfg)
+}
+
+func (a *S3Adapter) GetTrashConfiguration(ctx context.Context, bucket string) (storage.TrashConfiguration, error) {
+    return a.storageBackend.GetTrashConfiguration(bucket)
+}
+
+func (a *S3Adapter) ListTrash(ctx context.Context, bucket string) ([]storage.TrashEntry, error) {
+    return a.storageBackend.ListTrash(ctx, bucket)
+}
+
+func (a *S3Adapter) RestoreObject(ctx context.Context, bucket, key, id string) error {
+    return a.storageBackend.RestoreObject(ctx, bucket, key, id)
+}
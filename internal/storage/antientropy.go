@@ -0,0 +1,492 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
+    "github.com/Alyanaky/SecureDAG/internal/metrics"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TombstoneRetention is how long a deleted object is remembered, so that
+// anti-entropy does not bring it back from a node that missed the delete.
+// Nodes partitioned for longer may resurrect it.
+const TombstoneRetention = 7 * 24 * time.Hour
+
+// ErrMissingBlocks is returned by ApplySyncEntry when blocks of the entry
+// have not been stored yet.
+var ErrMissingBlocks = errors.New("blocks of the entry are missing")
+
+var tombstonePrefix = []byte("tombstone/")
+
+func tombstoneKey(bucket, key string) []byte {
+    return []byte("tombstone/" + bucket + "/" + key)
+}
+
+// Tombstone records when an object lost its last version. It is stamped
+// like a version: by the hybrid logical clock of Origin, the node the
+// object was deleted on, over the version that was current there.
+type Tombstone struct {
+    DeletedAt  time.Time     `json:"deleted_at"`
+    Stamp      hlc.Timestamp `json:"stamp"`
+    Origin     peer.ID       `json:"origin,omitempty"`
+    Supersedes hlc.Timestamp `json:"supersedes"`
+}
+
+// removeObjectInfo deletes the metadata of an object that has no versions
+// left and leaves a tombstone stamped now in its place. An object that was
+// never stored is left alone.
+func (s *BadgerStore) removeObjectInfo(txn *badger.Txn, bucket, key string) error {
+    stored, err := getObjectInfo(txn, bucket, key)
+    if err != nil {
+        return err
+    }
+    if len(stored.Versions) == 0 {
+        return nil
+    }
+    t := Tombstone{DeletedAt: time.Now().UTC(), Stamp: s.clock.Now(), Origin: s.NodeID(), Supersedes: latestStamp(stored)}
+    return putTombstone(txn, bucket, key, t)
+}
+
+func putTombstone(txn *badger.Txn, bucket, key string, t Tombstone) error {
+    data, err := json.Marshal(t)
+    if err != nil {
+        return err
+    }
+    if err := txn.Set(tombstoneKey(bucket, key), data); err != nil {
+        return err
+    }
+    return txn.Delete(objectMetaKey(bucket, key))
+}
+
+// SyncEntry is the state of one object that nodes reconcile: its current
+// version, or when it was deleted. Entries sent between nodes carry the
+// version's manifest in the clear, so that the receiver can seal it under
+// its own key, and the blocks the version needs. Older versions are not
+// reconciled.
+type SyncEntry struct {
+    Bucket    string        `json:"bucket"`
+    Key       string        `json:"key"`
+    Version   ObjectVersion `json:"version"`
+    Tombstone *Tombstone    `json:"tombstone,omitempty"`
+    Manifest  *Manifest     `json:"manifest,omitempty"`
+    Blocks    []cid.Cid     `json:"blocks,omitempty"`
+}
+
+// SyncDigest names an entry and hashes its state.
+type SyncDigest struct {
+    Name   string `json:"name"`
+    Digest []byte `json:"digest"`
+}
+
+// Name identifies the entry's object. Bucket names cannot hold a slash, so
+// names are unique.
+func (e SyncEntry) Name() string {
+    return e.Bucket + "/" + e.Key
+}
+
+// Digest hashes what nodes must agree on. Where a node keeps its copy,
+// such as its storage class, is left out.
+func (e SyncEntry) Digest() []byte {
+    data, _ := json.Marshal(struct {
+        Bucket       string            `json:"bucket"`
+        Key          string            `json:"key"`
+        VersionID    string            `json:"version_id,omitempty"`
+        Size         int64             `json:"size,omitempty"`
+        LastModified time.Time         `json:"last_modified"`
+        DeleteMarker bool              `json:"delete_marker,omitempty"`
+        Tags         map[string]string `json:"tags,omitempty"`
+        Stamp        hlc.Timestamp     `json:"stamp"`
+        Origin       peer.ID           `json:"origin,omitempty"`
+        Tombstone    *Tombstone        `json:"tombstone,omitempty"`
+    }{e.Bucket, e.Key, e.Version.VersionID, e.Version.Size, e.Version.LastModified,
+        e.Version.IsDeleteMarker, e.Version.Tags, e.Version.Stamp, e.Version.Origin, e.Tombstone})
+    return dag.HashLeaf(data)
+}
+
+// Deleted reports whether the entry records a delete: a tombstone or a
+// delete marker.
+func (e SyncEntry) Deleted() bool {
+    return e.Tombstone != nil || e.Version.IsDeleteMarker
+}
+
+// stamp returns when and on which node the entry's state was written.
+// Versions and tombstones from before stamps were kept fall back to their
+// wall time.
+func (e SyncEntry) stamp() (hlc.Timestamp, peer.ID) {
+    ts, origin, wall := e.Version.Stamp, e.Version.Origin, e.Version.LastModified
+    if t := e.Tombstone; t != nil {
+        ts, origin, wall = t.Stamp, t.Origin, t.DeletedAt
+    }
+    if ts.IsZero() {
+        ts = hlc.Timestamp{Wall: wall.UnixNano()}
+    }
+    return ts, origin
+}
+
+func (e SyncEntry) supersedes() hlc.Timestamp {
+    if e.Tombstone != nil {
+        return e.Tombstone.Supersedes
+    }
+    return e.Version.Supersedes
+}
+
+// newerThan reports whether e should replace o, and why: the later stamp
+// wins, and between equal stamps the greater origin node ID. Every node
+// picks the same side.
+func (e SyncEntry) newerThan(o SyncEntry) (bool, string) {
+    et, eo := e.stamp()
+    ot, oo := o.stamp()
+    if c := et.Compare(ot); c != 0 {
+        return c > 0, "later timestamp"
+    }
+    if eo != oo {
+        return eo > oo, "same timestamp, greater origin node ID"
+    }
+    return bytes.Compare(e.Digest(), o.Digest()) > 0, "same timestamp and origin, greater digest"
+}
+
+// concurrent reports whether newer was written without its writer having
+// seen older, so that older was overwritten unseen rather than replaced.
+func concurrent(newer, older SyncEntry) bool {
+    written := newer.Version.Stamp
+    if newer.Tombstone != nil {
+        written = newer.Tombstone.Stamp
+    }
+    if written.IsZero() {
+        return false
+    }
+    ts, _ := older.stamp()
+    return newer.supersedes().Compare(ts) < 0
+}
+
+// syncEntry returns the local state of an object, without manifest and
+// blocks. It reports false if the object is unknown.
+func syncEntry(txn *badger.Txn, bucket, key string) (SyncEntry, bool, error) {
+    e := SyncEntry{Bucket: bucket, Key: key}
+    info, err := getObjectInfo(txn, bucket, key)
+    if err != nil {
+        return e, false, err
+    }
+    if latest, ok := info.Latest(); ok {
+        e.Version = latest
+        return e, true, nil
+    }
+    item, err := txn.Get(tombstoneKey(bucket, key))
+    if err == badger.ErrKeyNotFound {
+        return e, false, nil
+    }
+    if err != nil {
+        return e, false, err
+    }
+    var t Tombstone
+    if err := item.Value(func(val []byte) error { return json.Unmarshal(val, &t) }); err != nil {
+        return e, false, err
+    }
+    e.Tombstone = &t
+    return e, true, nil
+}
+
+// SyncDigests returns the digest of every object and tombstone, ordered by
+// name.
+func (s *BadgerStore) SyncDigests(ctx context.Context) ([]SyncDigest, error) {
+    var digests []SyncDigest
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        for _, prefix := range [][]byte{[]byte("objmeta/"), tombstonePrefix} {
+            for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+                if ctx.Err() != nil {
+                    return ctx.Err()
+                }
+                bucket, key, ok := strings.Cut(string(it.Item().Key()[len(prefix):]), "/")
+                if !ok {
+                    continue
+                }
+                e, ok, err := syncEntry(txn, bucket, key)
+                if err != nil {
+                    return err
+                }
+                if ok {
+                    digests = append(digests, SyncDigest{Name: e.Name(), Digest: e.Digest()})
+                }
+            }
+        }
+        return nil
+    })
+    sort.Slice(digests, func(i, j int) bool { return digests[i].Name < digests[j].Name })
+    return digests, err
+}
+
+// SyncEntries returns the entries with the given names, ready to be sent to
+// another node. Unknown names are skipped.
+func (s *BadgerStore) SyncEntries(names []string) ([]SyncEntry, error) {
+    var entries []SyncEntry
+    err := s.db.View(func(txn *badger.Txn) error {
+        for _, name := range names {
+            bucket, key, ok := strings.Cut(name, "/")
+            if !ok {
+                continue
+            }
+            e, ok, err := syncEntry(txn, bucket, key)
+            if err != nil {
+                return err
+            }
+            if !ok {
+                continue
+            }
+            if !e.Deleted() {
+                info, err := getObjectInfo(txn, bucket, key)
+                if err != nil {
+                    return err
+                }
+                if e.Manifest, e.Blocks, err = s.syncManifest(txn, info); err != nil {
+                    return err
+                }
+            }
+            entries = append(entries, e)
+        }
+        return nil
+    })
+    return entries, err
+}
+
+// syncManifest opens the manifest of the current version and lists its
+// DAG nodes and chunks.
+func (s *BadgerStore) syncManifest(txn *badger.Txn, info *ObjectInfo) (*Manifest, []cid.Cid, error) {
+    dataKey, keyKey := versionDataKeys(info, 0)
+    data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+    if err != nil {
+        return nil, nil, err
+    }
+    m, err := s.openManifest(data, aesKey)
+    if err != nil {
+        return nil, nil, err
+    }
+    var blocks []cid.Cid
+    if m.DAG != "" {
+        root, err := cid.Decode(m.DAG)
+        if err != nil {
+            return nil, nil, err
+        }
+        seen := make(map[cid.Cid]bool)
+        err = walkDAG(txn, root, func(c cid.Cid, _ []byte) error {
+            if !seen[c] {
+                seen[c] = true
+                blocks = append(blocks, c)
+            }
+            return nil
+        })
+        return m, blocks, err
+    }
+    leaves, err := manifestLeaves(txn, m)
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, hash := range leaves {
+        c, err := dag.LeafCID(hash)
+        if err != nil {
+            return nil, nil, err
+        }
+        blocks = append(blocks, c)
+    }
+    return m, blocks, nil
+}
+
+// MissingBlocks returns the blocks of cids that are not stored in Badger.
+func (s *BadgerStore) MissingBlocks(cids []cid.Cid) ([]cid.Cid, error) {
+    var missing []cid.Cid
+    err := s.db.View(func(txn *badger.Txn) error {
+        for _, c := range cids {
+            _, err := txn.Get(blockKey(c))
+            if err == badger.ErrKeyNotFound {
+                missing = append(missing, c)
+            } else if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    return missing, err
+}
+
+// ApplySyncEntry adopts e if it is newer than the local state of its
+// object, and reports whether it changed anything. The blocks of e must be
+// stored first. A newer tombstone removes every version of the object,
+// unless object lock protects one. When e and the local state were written
+// concurrently, the conflict is recorded, and in buckets that keep siblings
+// the losing write stays on as an older version.
+func (s *BadgerStore) ApplySyncEntry(ctx context.Context, e SyncEntry) (bool, error) {
+    if e.Bucket == "" || e.Key == "" {
+        return false, errors.New("sync entry names no object")
+    }
+    ts, _ := e.stamp()
+    if _, err := s.clock.Update(ts); err != nil {
+        return false, err
+    }
+    var manifest, wrappedKey []byte
+    if !e.Deleted() {
+        if e.Manifest == nil {
+            return false, errors.New("sync entry has no manifest")
+        }
+        missing, err := s.MissingBlocks(e.Blocks)
+        if err != nil {
+            return false, err
+        }
+        if len(missing) > 0 {
+            return false, ErrMissingBlocks
+        }
+        if manifest, wrappedKey, err = s.sealManifest(e.Manifest); err != nil {
+            return false, err
+        }
+    }
+    version := e.Version
+    version.StorageClass = StorageClassStandard
+    version.TierKey = ""
+    version.RestoredUntil = nil
+
+    applied, newer, conflicts := false, false, 0
+    versionID := version.VersionID
+    apply := func(txn *badger.Txn) error {
+        local, ok, err := syncEntry(txn, e.Bucket, e.Key)
+        if err != nil {
+            return err
+        }
+        if ok && bytes.Equal(local.Digest(), e.Digest()) {
+            return nil
+        }
+        info, err := getObjectInfo(txn, e.Bucket, e.Key)
+        if err != nil {
+            return err
+        }
+        reason := ""
+        newer = !ok
+        if ok {
+            newer, reason = e.newerThan(local)
+        }
+
+        siblings := false
+        if ok {
+            winner, loser := e, local
+            if !newer {
+                winner, loser = local, e
+            }
+            if concurrent(winner, loser) {
+                mode, err := getConflictMode(txn, e.Bucket)
+                if err != nil {
+                    return err
+                }
+                // Only two writes can both be kept; a delete wins or loses
+                // outright.
+                if winner.Deleted() || loser.Deleted() {
+                    mode = ConflictLastWriterWins
+                }
+                siblings = mode == ConflictSiblings
+                recorded, err := recordConflict(txn, winner, loser, mode, reason)
+                if err != nil {
+                    return err
+                }
+                if recorded {
+                    conflicts++
+                }
+            }
+        }
+
+        if !newer {
+            if !siblings {
+                return nil
+            }
+            versionID, err = s.keepSibling(txn, info, version, manifest, wrappedKey)
+            applied = versionID != ""
+            return err
+        }
+        applied = true
+        if siblings && local.Version.VersionID == version.VersionID {
+            if err := renameVersion(txn, info, 0, siblingVersionID(local.Version)); err != nil {
+                return err
+            }
+        }
+        switch {
+        case e.Tombstone != nil:
+            for len(info.Versions) > 0 {
+                if err := dropVersion(txn, info, info.Versions[0].VersionID, false); err != nil {
+                    return err
+                }
+            }
+            return putTombstone(txn, e.Bucket, e.Key, *e.Tombstone)
+        case e.Version.IsDeleteMarker:
+            if err := dropVersion(txn, info, e.Version.VersionID, false); err != nil && err != ErrNotFound {
+                return err
+            }
+            if err := retireLatest(txn, info); err != nil {
+                return err
+            }
+            info.Versions = append([]ObjectVersion{e.Version}, info.Versions...)
+            return s.putObjectInfo(txn, info)
+        }
+        return s.insertVersion(txn, info, version, manifest, wrappedKey)
+    }
+    var entry translog.Entry
+    err := s.updateReplicated(&entry, func(txn *badger.Txn) error {
+        if err := apply(txn); err != nil || !applied {
+            return err
+        }
+        entry = translog.Entry{Type: translog.TypePut, Bucket: e.Bucket, Key: e.Key, VersionID: versionID}
+        if e.Deleted() {
+            entry.Type = translog.TypeDelete
+        }
+        if !newer {
+            entry.Type, entry.Detail = translog.TypeVersion, "sibling"
+        }
+        return nil
+    })
+    if err != nil {
+        return false, err
+    }
+    metrics.SyncConflicts.Add(float64(conflicts))
+    return applied, nil
+}
+
+// ExpireTombstones forgets objects deleted more than retention ago.
+func (s *BadgerStore) ExpireTombstones(ctx context.Context, retention time.Duration) error {
+    cutoff := time.Now().Add(-retention)
+    var expired [][]byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        for it.Seek(tombstonePrefix); it.ValidForPrefix(tombstonePrefix); it.Next() {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            var t Tombstone
+            if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &t) }); err != nil {
+                return err
+            }
+            if t.DeletedAt.Before(cutoff) {
+                expired = append(expired, it.Item().KeyCopy(nil))
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    return s.db.Update(func(txn *badger.Txn) error {
+        for _, k := range expired {
+            if err := txn.Delete(k); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
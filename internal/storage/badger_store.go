@@ -2,26 +2,63 @@ package storage
 
 import (
     "context"
+    "crypto/ed25519"
     "crypto/rand"
     "log"
+    "sync"
     "time"
 
     "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
     "github.com/Alyanaky/SecureDAG/internal/p2p"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
     "github.com/dgraph-io/badger/v4"
     "github.com/dgraph-io/badger/v4/options"
+    "github.com/ipfs/go-cid"
+    p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
 )
 
 const (
-    HealInterval = 5 * time.Minute
-    MinReplicas  = 3
+    HealInterval          = 5 * time.Minute
+    MinReplicas           = 3
+    PurgeInterval         = time.Hour
+    DefaultTrashRetention = 30 * 24 * time.Hour
 )
 
+var (
+    signingKeyKey = []byte("nodekey/signing")
+    peerKeyKey    = []byte("nodekey/peer")
+    clusterKeyKey = []byte("nodekey/cluster")
+)
+
+// ErrNotFound is returned when a requested object or version does not exist.
+var ErrNotFound = badger.ErrKeyNotFound
+
 type BadgerStore struct {
     db          *badger.DB
     keyManager  *crypto.KeyManager
     dht         *p2p.DHTOperations
+    exchange    BlockExchange
+    replicator  *Replicator
+    announcer   BlockAnnouncer
+    coldTier    ColdTier
+    signingKey  *crypto.SigningKey
+    peerKey     p2pcrypto.PrivKey
+    clusterKey  *crypto.ClusterKey
+    convergent  []byte
+    translog    *translog.Log
+    clock       *hlc.Clock
     healInterval time.Duration
+    healNow      chan struct{}
+
+    peersMu    sync.RWMutex
+    shardPeers []ShardPeer
+
+    changesMu  sync.RWMutex
+    changeSubs []func(Change)
+
+    validatorsMu     sync.RWMutex
+    configValidators map[string]func([]byte) error
 }
 
 func NewBadgerStore(dir string) (*BadgerStore, error) {
@@ -34,15 +71,51 @@ func NewBadgerStore(dir string) (*BadgerStore, error) {
         return nil, err
     }
 
+    if err := migrateDataKeys(db); err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    signingKey, err := loadSigningKey(db)
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    peerKey, err := loadPeerKey(db)
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    clusterKey, err := loadClusterKey(db)
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    tlog, err := translog.Open(db, signingKey)
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+
     km := crypto.NewKeyManager()
-    go crypto.RotateKeys(km, 24*time.Hour)
 
     store := &BadgerStore{
         db:          db,
         keyManager:  km,
-        dht:         p2p.NewDHTOperations(nil), // Предполагается, что DHT инициализируется позже
+        signingKey:  signingKey,
+        peerKey:     peerKey,
+        clusterKey:  clusterKey,
+        convergent:  clusterKey.Derive("convergent"),
+        translog:    tlog,
+        clock:       hlc.NewClock(),
+        dht:         p2p.NewDHTOperations(nil),
         healInterval: HealInterval,
+        healNow:      make(chan struct{}, 1),
     }
+    go crypto.RotateKeys(km, 24*time.Hour, store.logKeyRotation)
 
     return store, nil
 }
@@ -51,57 +124,250 @@ func (s *BadgerStore) Close() error {
     return s.db.Close()
 }
 
-func (s *BadgerStore) PutObject(bucket, key string, data []byte) error {
-    aesKey := make([]byte, 32)
-    if _, err := rand.Read(aesKey); err != nil {
+// SetDHT attaches the DHT used to count replicas of stored objects.
+func (s *BadgerStore) SetDHT(ops *p2p.DHTOperations) {
+    s.dht = ops
+}
+
+// SetReplicator attaches the replicator the scrubber sends the blocks of
+// under-replicated objects with.
+func (s *BadgerStore) SetReplicator(r *Replicator) {
+    s.replicator = r
+}
+
+// BlockExchange fetches blocks from the peers that provide them.
+type BlockExchange interface {
+    GetBlock(ctx context.Context, c cid.Cid) ([]byte, error)
+}
+
+// SetBlockExchange attaches the exchange that the scrubber fetches missing
+// and corrupt blocks over.
+func (s *BadgerStore) SetBlockExchange(x BlockExchange) {
+    s.exchange = x
+}
+
+// loadSigningKey returns the node's signing key, creating it on first use.
+func loadSigningKey(db *badger.DB) (*crypto.SigningKey, error) {
+    var key *crypto.SigningKey
+    err := db.Update(func(txn *badger.Txn) error {
+        item, err := txn.Get(signingKeyKey)
+        if err == badger.ErrKeyNotFound {
+            if key, err = crypto.NewSigningKey(); err != nil {
+                return err
+            }
+            return txn.Set(signingKeyKey, key.Seed())
+        }
+        if err != nil {
+            return err
+        }
+        return item.Value(func(val []byte) error {
+            key, err = crypto.SigningKeyFromSeed(val)
+            return err
+        })
+    })
+    return key, err
+}
+
+// loadPeerKey returns the key behind the node's libp2p peer ID, creating it
+// on first use.
+func loadPeerKey(db *badger.DB) (p2pcrypto.PrivKey, error) {
+    var key p2pcrypto.PrivKey
+    err := db.Update(func(txn *badger.Txn) error {
+        item, err := txn.Get(peerKeyKey)
+        if err == badger.ErrKeyNotFound {
+            if key, _, err = p2pcrypto.GenerateEd25519Key(rand.Reader); err != nil {
+                return err
+            }
+            data, err := p2pcrypto.MarshalPrivateKey(key)
+            if err != nil {
+                return err
+            }
+            return txn.Set(peerKeyKey, data)
+        }
+        if err != nil {
+            return err
+        }
+        return item.Value(func(val []byte) error {
+            key, err = p2pcrypto.UnmarshalPrivateKey(val)
+            return err
+        })
+    })
+    return key, err
+}
+
+// loadClusterKey returns the cluster key, creating one on first use.
+func loadClusterKey(db *badger.DB) (*crypto.ClusterKey, error) {
+    var key *crypto.ClusterKey
+    err := db.Update(func(txn *badger.Txn) error {
+        item, err := txn.Get(clusterKeyKey)
+        if err == badger.ErrKeyNotFound {
+            if key, err = crypto.NewClusterKey(); err != nil {
+                return err
+            }
+            return txn.Set(clusterKeyKey, []byte(key.Encode()))
+        }
+        if err != nil {
+            return err
+        }
+        return item.Value(func(val []byte) error {
+            key, err = crypto.ParseClusterKey(string(val))
+            return err
+        })
+    })
+    return key, err
+}
+
+// SetClusterKey replaces the cluster key, keeping it for later runs. All
+// nodes of a cluster must share it: it keys convergent encryption, so
+// only nodes holding the same key deduplicate each other's chunks.
+// Objects already stored stay readable.
+func (s *BadgerStore) SetClusterKey(key *crypto.ClusterKey) error {
+    err := s.db.Update(func(txn *badger.Txn) error {
+        return txn.Set(clusterKeyKey, []byte(key.Encode()))
+    })
+    if err != nil {
         return err
     }
+    s.clusterKey = key
+    s.convergent = key.Derive("convergent")
+    return nil
+}
 
-    encryptedData, err := s.keyManager.EncryptData(data, aesKey)
+// ClusterKey returns the secret shared by the nodes of the cluster.
+func (s *BadgerStore) ClusterKey() *crypto.ClusterKey {
+    return s.clusterKey
+}
+
+// PeerKey returns the identity the node's libp2p host runs under.
+func (s *BadgerStore) PeerKey() p2pcrypto.PrivKey {
+    return s.peerKey
+}
+
+// SigningPublicKey returns the key that verifies the node's signed
+// commitments.
+func (s *BadgerStore) SigningPublicKey() ed25519.PublicKey {
+    return s.signingKey.PublicKey()
+}
+
+// PutOptions carries optional attributes of a new object version.
+type PutOptions struct {
+    Tags         map[string]string `json:"tags,omitempty"`
+    StorageClass string            `json:"storage_class,omitempty"`
+}
+
+func (s *BadgerStore) PutObject(bucket, key string, data []byte) error {
+    _, err := s.PutObjectWithOptions(bucket, key, data, PutOptions{})
+    return err
+}
+
+// PutObjectWithOptions stores a new version of an object. The data is split
+// into encrypted, content-addressed blocks and the version records the
+// manifest of its Merkle DAG. In buckets without versioning it replaces the
+// "null" version, unless that version is locked.
+func (s *BadgerStore) PutObjectWithOptions(bucket, key string, data []byte, opts PutOptions) (ObjectVersion, error) {
+    if !ValidStorageClass(opts.StorageClass) {
+        return ObjectVersion{}, ErrInvalidStorageClass
+    }
+    manifest, root, blocks, err := s.encodeObject(data)
     if err != nil {
-        return err
+        return ObjectVersion{}, err
     }
 
-    encryptedAESKey, err := s.keyManager.EncryptAESKey(s.keyManager.GetPublicKey(), aesKey)
+    // Blocks and the DAG go in first; if the version is never recorded they
+    // are simply unreferenced.
+    var tierKey string
+    if isColdStorageClass(opts.StorageClass) {
+        if tierKey, err = s.putCold(context.Background(), packBlocks(blocks)); err != nil {
+            return ObjectVersion{}, err
+        }
+    } else if err := s.storeBlocks(context.Background(), bucket, blocks); err != nil {
+        return ObjectVersion{}, err
+    }
+    if err := s.storeObjectDAG(manifest, root); err != nil {
+        return ObjectVersion{}, err
+    }
+    encryptedManifest, encryptedAESKey, err := s.sealManifest(manifest)
     if err != nil {
-        return err
+        return ObjectVersion{}, err
     }
 
-    return s.db.Update(func(txn *badger.Txn) error {
-        objKey := []byte(bucket + "/" + key)
-        if err := txn.Set(objKey, encryptedData); err != nil {
-            return err
+    version := ObjectVersion{
+        VersionID:    NullVersionID,
+        Size:         int64(len(data)),
+        LastModified: time.Now().UTC(),
+        Tags:         opts.Tags,
+        StorageClass: opts.StorageClass,
+        TierKey:      tierKey,
+    }
+    err = s.recordVersion(bucket, key, &version, encryptedManifest, encryptedAESKey)
+    if err != nil && tierKey != "" {
+        if derr := s.coldTier.Delete(context.Background(), tierKey); derr != nil {
+            log.Printf("Failed to remove cold copy %s: %v", tierKey, derr)
         }
-        keyEncKey := []byte(bucket + "/" + key + "/key")
-        return txn.Set(keyEncKey, encryptedAESKey)
-    })
+    }
+    return version, err
 }
 
-func (s *BadgerStore) GetObject(bucket, key string) ([]byte, error) {
-    var encryptedData, encryptedAESKey []byte
-    err := s.db.View(func(txn *badger.Txn) error {
-        objKey := []byte(bucket + "/" + key)
-        item, err := txn.Get(objKey)
+// recordVersion makes version the current version of an object, with the
+// given encrypted manifest and wrapped key. Its version ID is assigned from
+// the bucket's versioning status.
+func (s *BadgerStore) recordVersion(bucket, key string, version *ObjectVersion, manifest, wrappedKey []byte) error {
+    e := translog.Entry{Type: translog.TypePut, Bucket: bucket, Key: key}
+    return s.update(&e, func(txn *badger.Txn) error {
+        status, err := getBucketVersioning(txn, bucket)
         if err != nil {
             return err
         }
-        encryptedData, err = item.ValueCopy(nil)
-        if err != nil {
-            return err
+        if status == VersioningEnabled {
+            if version.VersionID, err = newVersionID(); err != nil {
+                return err
+            }
         }
-
-        keyEncKey := []byte(bucket + "/" + key + "/key")
-        item, err = txn.Get(keyEncKey)
+        info, err := getObjectInfo(txn, bucket, key)
         if err != nil {
             return err
         }
-        encryptedAESKey, err = item.ValueCopy(nil)
-        return err
+        s.stampVersion(version, latestStamp(info))
+        e.VersionID = version.VersionID
+        return s.insertVersion(txn, info, *version, manifest, wrappedKey)
     })
-    if err != nil {
-        return nil, err
+}
+
+// insertVersion makes version the current version of info, replacing any
+// version with the same ID.
+func (s *BadgerStore) insertVersion(txn *badger.Txn, info *ObjectInfo, version ObjectVersion, manifest, wrappedKey []byte) error {
+    // Overwriting replaces the null version, so a locked one stays put.
+    if err := dropVersion(txn, info, version.VersionID, false); err != nil && err != ErrNotFound {
+        return err
+    }
+    if err := retireLatest(txn, info); err != nil {
+        return err
+    }
+
+    objKey, keyEncKey := currentDataKeys(info.Bucket, info.Key)
+    if err := txn.Set(objKey, manifest); err != nil {
+        return err
+    }
+    if err := txn.Set(keyEncKey, wrappedKey); err != nil {
+        return err
+    }
+    info.Versions = append([]ObjectVersion{version}, info.Versions...)
+    if err := s.putObjectInfo(txn, info); err != nil {
+        return err
     }
+    return applyDefaultRetention(txn, info.Bucket, info.Key, version.VersionID)
+}
+
+func (s *BadgerStore) GetObject(bucket, key string) ([]byte, error) {
+    return s.readObject(bucket, key, func(info *ObjectInfo) int {
+        if len(info.Versions) == 0 {
+            return -1
+        }
+        return 0
+    })
+}
 
+func (s *BadgerStore) decrypt(encryptedData, encryptedAESKey []byte) ([]byte, error) {
     aesKey, err := s.keyManager.DecryptAESKey(s.keyManager.GetPrivateKey(), encryptedAESKey)
     if err != nil {
         return nil, err
@@ -110,18 +376,66 @@ func (s *BadgerStore) GetObject(bucket, key string) ([]byte, error) {
     return s.keyManager.DecryptData(encryptedData, aesKey)
 }
 
-func (s *BadgerStore) DeleteObject(bucket, key string) error {
-    return s.db.Update(func(txn *badger.Txn) error {
-        objKey := []byte(bucket + "/" + key)
-        keyEncKey := []byte(bucket + "/" + key + "/key")
-        if err := txn.Delete(objKey); err != nil {
+// DeleteObject removes the current version of an object. With versioning
+// enabled or suspended it places a delete marker on top instead, leaving older
+// versions in place.
+func (s *BadgerStore) DeleteObject(bucket, key string, bypassGovernance bool) error {
+    e := translog.Entry{Type: translog.TypeDelete, Bucket: bucket, Key: key}
+    return s.update(&e, func(txn *badger.Txn) error {
+        status, err := getBucketVersioning(txn, bucket)
+        if err != nil {
             return err
         }
-        return txn.Delete(keyEncKey)
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        supersedes := latestStamp(info)
+        if status != VersioningEnabled {
+            if err := dropVersion(txn, info, NullVersionID, bypassGovernance); err != nil && err != ErrNotFound {
+                return err
+            }
+        }
+        if status == "" {
+            return s.putObjectInfo(txn, info)
+        }
+
+        marker := ObjectVersion{
+            VersionID:      NullVersionID,
+            LastModified:   time.Now().UTC(),
+            IsDeleteMarker: true,
+        }
+        s.stampVersion(&marker, supersedes)
+        if status == VersioningEnabled {
+            if marker.VersionID, err = newVersionID(); err != nil {
+                return err
+            }
+        }
+        if err := retireLatest(txn, info); err != nil {
+            return err
+        }
+        info.Versions = append([]ObjectVersion{marker}, info.Versions...)
+        e.VersionID, e.Detail = marker.VersionID, "delete-marker"
+        return s.putObjectInfo(txn, info)
     })
 }
 
 func (s *BadgerStore) healBlock(ctx context.Context) error {
     log.Println("Running self-healing process")
+    healed, err := s.HealStripes(ctx)
+    if err != nil {
+        log.Printf("Shard repair failed: %v", err)
+    }
+    if healed > 0 {
+        log.Printf("Regenerated %d lost shards", healed)
+    }
+    report, err := s.Scrub(ctx, DefaultScrubOptions)
+    if err != nil {
+        log.Printf("Scrub failed: %v", err)
+    }
+    if report.Corrupt+report.Missing+report.UnderReplicated > 0 {
+        log.Printf("Scrub found %d corrupt, %d missing and %d under-replicated, repaired %d",
+            report.Corrupt, report.Missing, report.UnderReplicated, report.Repaired)
+    }
     return nil
 }
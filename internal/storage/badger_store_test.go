@@ -0,0 +1,26 @@
+package storage
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestNewBadgerStore_NodeKeysSurviveObjectWrites(t *testing.T) {
+    dir := t.TempDir()
+    store, err := NewBadgerStore(dir)
+    require.NoError(t, err)
+    signer := store.signingKey.PublicKey()
+    nodeID := store.NodeID()
+
+    require.NoError(t, store.PutObject("nodekey", "signing", []byte("not a seed")))
+    require.NoError(t, store.PutObject("nodekey", "peer", []byte("not a key")))
+    require.NoError(t, store.Close())
+
+    store, err = NewBadgerStore(dir)
+    require.NoError(t, err)
+    defer store.Close()
+    assert.Equal(t, signer, store.signingKey.PublicKey())
+    assert.Equal(t, nodeID, store.NodeID())
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+    "strings"
+
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+)
+
+// Bucket configuration documents owned by other packages, such as lifecycle
+// rules, are stored opaquely under "bucketconfig/<name>/<bucket>".
+
+func bucketConfigKey(name, bucket string) []byte {
+    return []byte("bucketconfig/" + name + "/" + bucket)
+}
+
+// SetConfigValidator makes the store check documents named name that other
+// nodes send with validate before adopting them. Documents of a name with
+// no validator are not adopted.
+func (s *BadgerStore) SetConfigValidator(name string, validate func(doc []byte) error) {
+    s.validatorsMu.Lock()
+    defer s.validatorsMu.Unlock()
+    if s.configValidators == nil {
+        s.configValidators = make(map[string]func([]byte) error)
+    }
+    s.configValidators[name] = validate
+}
+
+func (s *BadgerStore) configValidator(name string) func([]byte) error {
+    s.validatorsMu.RLock()
+    defer s.validatorsMu.RUnlock()
+    return s.configValidators[name]
+}
+
+func (s *BadgerStore) PutBucketConfig(bucket, name string, doc []byte) error {
+    return s.updatePolicy(bucket, ConfigPolicyPrefix+name, func(txn *badger.Txn) error {
+        return txn.Set(bucketConfigKey(name, bucket), doc)
+    }, translog.Entry{Type: translog.TypePolicy, Bucket: bucket, Detail: name})
+}
+
+// GetBucketConfig returns ErrNotFound if the bucket has no such document.
+func (s *BadgerStore) GetBucketConfig(bucket, name string) ([]byte, error) {
+    var doc []byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        item, err := txn.Get(bucketConfigKey(name, bucket))
+        if err != nil {
+            return err
+        }
+        doc, err = item.ValueCopy(nil)
+        return err
+    })
+    return doc, err
+}
+
+func (s *BadgerStore) DeleteBucketConfig(bucket, name string) error {
+    return s.updatePolicy(bucket, ConfigPolicyPrefix+name, func(txn *badger.Txn) error {
+        return txn.Delete(bucketConfigKey(name, bucket))
+    }, translog.Entry{Type: translog.TypePolicy, Bucket: bucket, Detail: name + " removed"})
+}
+
+// BucketsWithConfig lists the buckets that have a document of the given name.
+func (s *BadgerStore) BucketsWithConfig(name string) ([]string, error) {
+    var buckets []string
+    err := s.db.View(func(txn *badger.Txn) error {
+        opts := badger.DefaultIteratorOptions
+        opts.PrefetchValues = false
+        it := txn.NewIterator(opts)
+        defer it.Close()
+
+        prefix := bucketConfigKey(name, "")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            buckets = append(buckets, strings.TrimPrefix(string(it.Item().Key()), string(prefix)))
+        }
+        return nil
+    })
+    return buckets, err
+}
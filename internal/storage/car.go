@@ -0,0 +1,366 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+)
+
+// carRecord is the root block of an object exported to a CAR. It carries
+// the encrypted manifest as stored, with its key sealed under a key derived
+// from the cluster key, so any node of the cluster can import it.
+type carRecord struct {
+    Bucket      string        `json:"bucket"`
+    Key         string        `json:"key"`
+    Version     ObjectVersion `json:"version"`
+    Manifest    []byte        `json:"manifest"`
+    ManifestKey []byte        `json:"manifest_key"`
+}
+
+type carObject struct {
+    root     cid.Cid
+    record   []byte
+    manifest *Manifest
+    nodes    []dag.Block
+    leaves   [][]byte
+    tierKey  string
+}
+
+// ExportCAR writes the current version of an object, or of every object in
+// the bucket if key is empty, to w as a CAR archive of the given version,
+// 1 or 2. Each root is a JSON record of one object, followed by its DAG and
+// encrypted chunks. Archived chunks are read from the cold tier.
+func (s *BadgerStore) ExportCAR(ctx context.Context, w io.Writer, bucket, key string, version int) error {
+    if version != 1 && version != 2 {
+        return fmt.Errorf("%w: unsupported version %d", dag.ErrInvalidCAR, version)
+    }
+    var infos []ObjectInfo
+    if key != "" {
+        info, err := s.GetObjectInfo(bucket, key)
+        if err != nil {
+            return err
+        }
+        infos = append(infos, *info)
+    } else {
+        var err error
+        if infos, err = s.ListObjects(bucket, "", 0); err != nil {
+            return err
+        }
+    }
+
+    var objects []*carObject
+    var roots []cid.Cid
+    err := s.db.View(func(txn *badger.Txn) error {
+        for i := range infos {
+            obj, err := s.exportObject(txn, &infos[i])
+            if err != nil {
+                return err
+            }
+            if obj != nil {
+                objects = append(objects, obj)
+                roots = append(roots, obj.root)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    if key != "" && len(objects) == 0 {
+        return ErrNotFound
+    }
+
+    var cw *dag.CARWriter
+    if version == 2 {
+        size, err := carPayloadSize(roots, objects)
+        if err != nil {
+            return err
+        }
+        cw, err = dag.NewCARv2Writer(w, roots, size)
+    } else {
+        cw, err = dag.NewCARWriter(w, roots)
+    }
+    if err != nil {
+        return err
+    }
+    written := make(map[cid.Cid]bool)
+    put := func(c cid.Cid, data []byte) error {
+        if written[c] {
+            return nil
+        }
+        written[c] = true
+        return cw.Put(c, data)
+    }
+    for _, obj := range objects {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        if err := put(obj.root, obj.record); err != nil {
+            return err
+        }
+        if err := s.exportBlocks(ctx, obj, put); err != nil {
+            return err
+        }
+    }
+    return cw.Close()
+}
+
+// carPayloadSize sums up the CARv1 payload ExportCAR writes for objects,
+// counting each block once. Chunk sizes come from the manifests, so cold
+// chunks need not be fetched twice.
+func carPayloadSize(roots []cid.Cid, objects []*carObject) (uint64, error) {
+    size, err := dag.CARPayloadSize(roots)
+    if err != nil {
+        return 0, err
+    }
+    seen := make(map[cid.Cid]bool)
+    add := func(c cid.Cid, n int) {
+        if !seen[c] {
+            seen[c] = true
+            size += dag.CARBlockSize(c, n)
+        }
+    }
+    for _, obj := range objects {
+        add(obj.root, len(obj.record))
+        for _, n := range obj.nodes {
+            add(n.CID, len(n.Data))
+        }
+        for i, hash := range obj.leaves {
+            c, err := dag.LeafCID(hash)
+            if err != nil {
+                return 0, err
+            }
+            add(c, int(obj.manifest.Chunks[i].Size)+crypto.ConvergentOverhead)
+        }
+    }
+    return size, nil
+}
+
+func (s *BadgerStore) exportObject(txn *badger.Txn, info *ObjectInfo) (*carObject, error) {
+    latest, ok := info.Latest()
+    if !ok || latest.IsDeleteMarker {
+        return nil, nil
+    }
+    dataKey, keyKey := versionDataKeys(info, 0)
+    data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+    if err != nil {
+        return nil, err
+    }
+    m, err := s.openManifest(data, aesKey)
+    if err != nil {
+        return nil, err
+    }
+    leaves, err := manifestLeaves(txn, m)
+    if err != nil {
+        return nil, err
+    }
+    var nodes []dag.Block
+    if m.DAG != "" {
+        root, err := cid.Decode(m.DAG)
+        if err != nil {
+            return nil, err
+        }
+        err = walkDAG(txn, root, func(c cid.Cid, data []byte) error {
+            if data != nil {
+                nodes = append(nodes, dag.Block{CID: c, Data: data})
+            }
+            return nil
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+    manifestKey, err := s.exportManifestKey(aesKey)
+    if err != nil {
+        return nil, err
+    }
+    record, err := json.Marshal(carRecord{
+        Bucket:      info.Bucket,
+        Key:         info.Key,
+        Version:     latest,
+        Manifest:    data,
+        ManifestKey: manifestKey,
+    })
+    if err != nil {
+        return nil, err
+    }
+    root, err := dag.NewCID(dag.CodecJSON, record)
+    if err != nil {
+        return nil, err
+    }
+    obj := &carObject{root: root, record: record, manifest: m, nodes: nodes, leaves: leaves}
+    if !inBadger(latest) {
+        obj.tierKey = latest.TierKey
+    }
+    return obj, nil
+}
+
+// exportBlocks writes the DAG nodes and chunks of an object.
+func (s *BadgerStore) exportBlocks(ctx context.Context, obj *carObject, put func(cid.Cid, []byte) error) error {
+    for _, n := range obj.nodes {
+        if err := put(n.CID, n.Data); err != nil {
+            return err
+        }
+    }
+
+    var cold [][]byte
+    if obj.tierKey != "" {
+        var err error
+        if cold, err = s.getCold(ctx, obj.tierKey, obj.manifest, obj.leaves); err != nil {
+            return err
+        }
+    }
+    for i, hash := range obj.leaves {
+        c, err := dag.LeafCID(hash)
+        if err != nil {
+            return err
+        }
+        var block []byte
+        if cold != nil {
+            block = cold[i]
+        } else if block, err = s.GetBlock(ctx, c); err != nil {
+            return err
+        }
+        if err := put(c, block); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ImportCAR reads an archive written by ExportCAR, CARv1 or CARv2, and
+// records each object it holds as a new STANDARD version. Every block is
+// checked against its CID before it is stored. Objects go into bucket, or
+// back into the bucket they were exported from if bucket is empty. It
+// returns the number of objects imported.
+func (s *BadgerStore) ImportCAR(ctx context.Context, r io.Reader, bucket string) (int, error) {
+    cr, err := dag.NewCARReader(r)
+    if err != nil {
+        return 0, err
+    }
+    records := make(map[cid.Cid][]byte)
+    for _, root := range cr.Roots {
+        records[root] = nil
+    }
+
+    wb := s.db.NewWriteBatch()
+    defer wb.Cancel()
+    var stored []cid.Cid
+    for {
+        if ctx.Err() != nil {
+            return 0, ctx.Err()
+        }
+        c, data, err := cr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return 0, err
+        }
+        if _, ok := records[c]; ok {
+            records[c] = data
+            continue
+        }
+        if err := wb.Set(blockKey(c), data); err != nil {
+            return 0, err
+        }
+        stored = append(stored, c)
+    }
+    if err := wb.Flush(); err != nil {
+        return 0, err
+    }
+    s.announce(stored...)
+
+    imported := 0
+    for _, root := range cr.Roots {
+        data := records[root]
+        if data == nil {
+            return imported, fmt.Errorf("%w: missing root block %s", dag.ErrInvalidCAR, root)
+        }
+        var rec carRecord
+        if err := json.Unmarshal(data, &rec); err != nil {
+            return imported, fmt.Errorf("%w: %v", dag.ErrInvalidCAR, err)
+        }
+        if bucket != "" {
+            rec.Bucket = bucket
+        }
+        if err := s.importRecord(rec); err != nil {
+            return imported, fmt.Errorf("importing %s/%s: %w", rec.Bucket, rec.Key, err)
+        }
+        imported++
+    }
+    return imported, nil
+}
+
+func (s *BadgerStore) importRecord(rec carRecord) error {
+    if rec.Bucket == "" || rec.Key == "" {
+        return errors.New("record names no object")
+    }
+    wrappedKey, err := s.importManifestKey(rec.ManifestKey)
+    if err != nil {
+        return err
+    }
+    m, err := s.openManifest(rec.Manifest, wrappedKey)
+    if err != nil {
+        return err
+    }
+    // Every chunk must have arrived; the chunks themselves were verified
+    // as they were read.
+    err = s.db.View(func(txn *badger.Txn) error {
+        leaves, err := manifestLeaves(txn, m)
+        if err != nil {
+            return err
+        }
+        for _, hash := range leaves {
+            c, err := dag.LeafCID(hash)
+            if err != nil {
+                return err
+            }
+            if _, err := txn.Get(blockKey(c)); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err == badger.ErrKeyNotFound {
+        return fmt.Errorf("%w: missing blocks", dag.ErrInvalidCAR)
+    }
+    if err != nil {
+        return err
+    }
+
+    version := rec.Version
+    version.VersionID = NullVersionID
+    version.StorageClass = StorageClassStandard
+    version.TierKey = ""
+    version.RestoredUntil = nil
+    return s.recordVersion(rec.Bucket, rec.Key, &version, rec.Manifest, wrappedKey)
+}
+
+// exportManifestKey reseals a manifest key wrapped under the node's key
+// pair, which does not outlive the process, under the persisted cluster
+// key, so that the archive imports after a restart and on other nodes.
+func (s *BadgerStore) exportManifestKey(wrappedKey []byte) ([]byte, error) {
+    aesKey, err := s.keyManager.DecryptAESKey(s.keyManager.GetPrivateKey(), wrappedKey)
+    if err != nil {
+        return nil, err
+    }
+    return s.keyManager.EncryptData(aesKey, s.clusterKey.Derive("car"))
+}
+
+// importManifestKey opens a manifest key sealed by exportManifestKey and
+// wraps it under the node's key pair, as stored manifest keys are.
+func (s *BadgerStore) importManifestKey(sealed []byte) ([]byte, error) {
+    aesKey, err := s.keyManager.DecryptData(sealed, s.clusterKey.Derive("car"))
+    if err != nil {
+        return nil, fmt.Errorf("%w: manifest key is not sealed under the cluster key", dag.ErrInvalidCAR)
+    }
+    return s.keyManager.EncryptAESKey(s.keyManager.GetPublicKey(), aesKey)
+}
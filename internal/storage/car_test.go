@@ -0,0 +1,82 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "math/rand"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestCAR_ExportImportBucket(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    ctx := context.Background()
+
+    big := make([]byte, 512<<10)
+    rand.New(rand.NewSource(5)).Read(big)
+    require.NoError(t, store.PutObject("src", "big", big))
+    require.NoError(t, store.PutObject("src", "small", []byte("small")))
+    require.NoError(t, store.PutObject("src", "empty", nil))
+
+    var car bytes.Buffer
+    require.NoError(t, store.ExportCAR(ctx, &car, "src", "", 1))
+    archive := car.Bytes()
+
+    var v2 bytes.Buffer
+    require.NoError(t, store.ExportCAR(ctx, &v2, "src", "small", 2))
+    n, err := store.ImportCAR(ctx, &v2, "v2")
+    require.NoError(t, err)
+    assert.Equal(t, 1, n)
+    got, err := store.GetObject("v2", "small")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("small"), got)
+
+    n, err = store.ImportCAR(ctx, bytes.NewReader(archive), "dst")
+    require.NoError(t, err)
+    assert.Equal(t, 3, n)
+    got, err = store.GetObject("dst", "big")
+    require.NoError(t, err)
+    assert.Equal(t, big, got)
+    got, err = store.GetObject("dst", "small")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("small"), got)
+
+    _, err = store.ImportCAR(ctx, bytes.NewReader(archive[:len(archive)-10]), "cut")
+    assert.ErrorIs(t, err, dag.ErrInvalidCAR)
+}
+
+func TestCAR_ImportsOnAnotherNode(t *testing.T) {
+    ctx := context.Background()
+    src, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer src.Close()
+    require.NoError(t, src.PutObject("src", "k", []byte("moved between nodes")))
+
+    var car bytes.Buffer
+    require.NoError(t, src.ExportCAR(ctx, &car, "src", "k", 1))
+    archive := car.Bytes()
+
+    // A node of another cluster cannot open the manifest.
+    stranger, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer stranger.Close()
+    _, err = stranger.ImportCAR(ctx, bytes.NewReader(archive), "dst")
+    assert.ErrorIs(t, err, dag.ErrInvalidCAR)
+
+    // A node sharing the cluster key can, with a key pair of its own.
+    dst, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer dst.Close()
+    require.NoError(t, dst.SetClusterKey(src.ClusterKey()))
+    n, err := dst.ImportCAR(ctx, bytes.NewReader(archive), "dst")
+    require.NoError(t, err)
+    assert.Equal(t, 1, n)
+    got, err := dst.GetObject("dst", "k")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("moved between nodes"), got)
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Bucket policies other nodes learn of through PolicyUpdate. Bucket
+// configuration documents are named ConfigPolicyPrefix plus the document's
+// name.
+const (
+    PolicyVersioning   = "versioning"
+    PolicyObjectLock   = "object-lock"
+    PolicyErasure      = "erasure"
+    PolicyTrash        = "trash"
+    PolicyConflicts    = "conflicts"
+    ConfigPolicyPrefix = "config/"
+)
+
+// Change is a local change other nodes should hear of: Key names an object
+// whose state changed, or Policy a bucket policy.
+type Change struct {
+    Bucket string
+    Key    string
+    Policy string
+}
+
+// SubscribeChanges calls fn after each change made on this node to an
+// object or bucket policy. Changes adopted from other nodes are not
+// passed on. fn is called on the writer's goroutine and must not block.
+func (s *BadgerStore) SubscribeChanges(fn func(Change)) {
+    s.changesMu.Lock()
+    defer s.changesMu.Unlock()
+    s.changeSubs = append(s.changeSubs, fn)
+}
+
+func (s *BadgerStore) notifyChange(c Change) {
+    s.changesMu.RLock()
+    defer s.changesMu.RUnlock()
+    for _, fn := range s.changeSubs {
+        fn(c)
+    }
+}
+
+// Clock returns the hybrid logical clock that stamps changes made on this
+// node.
+func (s *BadgerStore) Clock() *hlc.Clock {
+    return s.clock
+}
+
+// NodeID returns the peer ID of the node's libp2p identity, which names it
+// as the origin of its changes.
+func (s *BadgerStore) NodeID() peer.ID {
+    id, _ := peer.IDFromPrivateKey(s.peerKey)
+    return id
+}
+
+// PolicyUpdate is the state of one bucket policy, stamped by the node that
+// last changed it. Doc is the stored document, nil if the policy was
+// removed.
+type PolicyUpdate struct {
+    Bucket string        `json:"bucket"`
+    Name   string        `json:"name"`
+    Doc    []byte        `json:"doc,omitempty"`
+    Stamp  hlc.Timestamp `json:"stamp"`
+    Origin peer.ID       `json:"origin"`
+}
+
+// newerThan orders updates by stamp, and by origin between nodes that
+// stamped the same time.
+func (u PolicyUpdate) newerThan(o PolicyUpdate) bool {
+    if c := u.Stamp.Compare(o.Stamp); c != 0 {
+        return c > 0
+    }
+    return u.Origin > o.Origin
+}
+
+type policyStamp struct {
+    Stamp  hlc.Timestamp `json:"stamp"`
+    Origin peer.ID       `json:"origin"`
+}
+
+var policyStampPrefix = []byte("policystamp/")
+
+func policyStampKey(bucket, name string) []byte {
+    return []byte("policystamp/" + bucket + "/" + name)
+}
+
+// policyKey returns the key a bucket policy is stored under.
+func policyKey(bucket, name string) ([]byte, error) {
+    switch name {
+    case PolicyVersioning:
+        return versioningKey(bucket), nil
+    case PolicyObjectLock:
+        return objectLockKey(bucket), nil
+    case PolicyErasure:
+        return erasureConfigKey(bucket), nil
+    case PolicyTrash:
+        return trashConfigKey(bucket), nil
+    case PolicyConflicts:
+        return conflictConfigKey(bucket), nil
+    }
+    if doc, ok := strings.CutPrefix(name, ConfigPolicyPrefix); ok && doc != "" {
+        return bucketConfigKey(doc, bucket), nil
+    }
+    return nil, fmt.Errorf("unknown bucket policy %q", name)
+}
+
+// updatePolicy changes a bucket policy in fn, stamps the change and logs
+// it as e.
+func (s *BadgerStore) updatePolicy(bucket, name string, fn func(txn *badger.Txn) error, e translog.Entry) error {
+    err := s.update(&e, func(txn *badger.Txn) error {
+        if err := fn(txn); err != nil {
+            return err
+        }
+        return putPolicyStamp(txn, bucket, name, policyStamp{Stamp: s.clock.Now(), Origin: s.NodeID()})
+    })
+    if err != nil {
+        return err
+    }
+    s.notifyChange(Change{Bucket: bucket, Policy: name})
+    return nil
+}
+
+func putPolicyStamp(txn *badger.Txn, bucket, name string, stamp policyStamp) error {
+    data, err := json.Marshal(stamp)
+    if err != nil {
+        return err
+    }
+    return txn.Set(policyStampKey(bucket, name), data)
+}
+
+func getPolicyUpdate(txn *badger.Txn, bucket, name string) (PolicyUpdate, error) {
+    u := PolicyUpdate{Bucket: bucket, Name: name}
+    key, err := policyKey(bucket, name)
+    if err != nil {
+        return u, err
+    }
+    item, err := txn.Get(policyStampKey(bucket, name))
+    if err == nil {
+        var stamp policyStamp
+        if err := item.Value(func(val []byte) error { return json.Unmarshal(val, &stamp) }); err != nil {
+            return u, err
+        }
+        u.Stamp, u.Origin = stamp.Stamp, stamp.Origin
+    } else if err != badger.ErrKeyNotFound {
+        return u, err
+    }
+    item, err = txn.Get(key)
+    if err == badger.ErrKeyNotFound {
+        return u, nil
+    }
+    if err != nil {
+        return u, err
+    }
+    u.Doc, err = item.ValueCopy(nil)
+    return u, err
+}
+
+// GetPolicyUpdate returns the current state of a bucket policy. A policy
+// that was never changed has a zero stamp.
+func (s *BadgerStore) GetPolicyUpdate(bucket, name string) (PolicyUpdate, error) {
+    var u PolicyUpdate
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        u, err = getPolicyUpdate(txn, bucket, name)
+        return err
+    })
+    return u, err
+}
+
+// ErrInvalidPolicyUpdate is returned for a policy update that the local
+// setter of the policy would refuse.
+var ErrInvalidPolicyUpdate = errors.New("invalid policy update")
+
+// ApplyPolicyUpdate adopts u if it is newer than the local state of its
+// policy, and reports whether it did. The document is checked as the
+// policy's setter checks it, and a stamp more than hlc.MaxDrift ahead of
+// the local clock is refused, so that it cannot outrank every later change.
+func (s *BadgerStore) ApplyPolicyUpdate(u PolicyUpdate) (bool, error) {
+    key, err := policyKey(u.Bucket, u.Name)
+    if err != nil {
+        return false, err
+    }
+    if _, err := s.clock.Update(u.Stamp); err != nil {
+        return false, err
+    }
+    var e translog.Entry
+    err = s.updateReplicated(&e, func(txn *badger.Txn) error {
+        local, err := getPolicyUpdate(txn, u.Bucket, u.Name)
+        if err != nil {
+            return err
+        }
+        if !u.newerThan(local) {
+            return nil
+        }
+        if err := s.checkPolicyUpdate(txn, u); err != nil {
+            return fmt.Errorf("%w: %s: %v", ErrInvalidPolicyUpdate, u.Name, err)
+        }
+        e = translog.Entry{Type: translog.TypePolicy, Bucket: u.Bucket, Detail: u.Name}
+        if u.Doc == nil {
+            err = txn.Delete(key)
+        } else {
+            err = txn.Set(key, u.Doc)
+        }
+        if err != nil {
+            return err
+        }
+        return putPolicyStamp(txn, u.Bucket, u.Name, policyStamp{Stamp: u.Stamp, Origin: u.Origin})
+    })
+    return e.Type != "", err
+}
+
+// checkPolicyUpdate decodes the document of u and makes the checks its
+// policy's setter makes. Only erasure coding and bucket configuration
+// documents can be removed.
+func (s *BadgerStore) checkPolicyUpdate(txn *badger.Txn, u PolicyUpdate) error {
+    config, isConfig := strings.CutPrefix(u.Name, ConfigPolicyPrefix)
+    if u.Doc == nil {
+        if u.Name == PolicyErasure || isConfig {
+            return nil
+        }
+        return errors.New("policy cannot be removed")
+    }
+    switch u.Name {
+    case PolicyVersioning:
+        return VersioningStatus(u.Doc).validate()
+    case PolicyObjectLock:
+        var cfg ObjectLockConfiguration
+        if err := json.Unmarshal(u.Doc, &cfg); err != nil {
+            return err
+        }
+        if err := cfg.validate(); err != nil {
+            return err
+        }
+        return checkObjectLockChange(txn, u.Bucket, cfg)
+    case PolicyErasure:
+        var cfg ErasureConfiguration
+        if err := json.Unmarshal(u.Doc, &cfg); err != nil {
+            return err
+        }
+        // Disabling erasure coding removes the document.
+        if !cfg.Enabled() {
+            return ErrInvalidErasureConfiguration
+        }
+        return cfg.validate()
+    case PolicyTrash:
+        var cfg TrashConfiguration
+        if err := json.Unmarshal(u.Doc, &cfg); err != nil {
+            return err
+        }
+        return cfg.validate()
+    case PolicyConflicts:
+        var cfg ConflictConfiguration
+        if err := json.Unmarshal(u.Doc, &cfg); err != nil {
+            return err
+        }
+        return cfg.validate()
+    }
+    validate := s.configValidator(config)
+    if validate == nil {
+        return fmt.Errorf("no validator for bucket configuration %q", config)
+    }
+    return validate(u.Doc)
+}
+
+// Buckets lists the buckets that hold objects or tombstones or have had a
+// policy changed.
+func (s *BadgerStore) Buckets() ([]string, error) {
+    var buckets []string
+    err := s.db.View(func(txn *badger.Txn) error {
+        opts := badger.DefaultIteratorOptions
+        opts.PrefetchValues = false
+        it := txn.NewIterator(opts)
+        defer it.Close()
+        seen := make(map[string]bool)
+        for _, prefix := range [][]byte{[]byte("objmeta/"), tombstonePrefix, policyStampPrefix} {
+            for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+                bucket, _, ok := strings.Cut(string(it.Item().Key()[len(prefix):]), "/")
+                if ok && !seen[bucket] {
+                    seen[bucket] = true
+                    buckets = append(buckets, bucket)
+                }
+            }
+        }
+        return nil
+    })
+    return buckets, err
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+    "encoding/json"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestApplyPolicyUpdate_ChecksDocuments(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    require.NoError(t, store.PutObjectLockConfiguration("b", ObjectLockConfiguration{Enabled: true}))
+
+    update := func(name string, doc []byte) PolicyUpdate {
+        return PolicyUpdate{Bucket: "b", Name: name, Doc: doc, Stamp: store.Clock().Now(), Origin: peer.ID("other")}
+    }
+    apply := func(u PolicyUpdate) error {
+        _, err := store.ApplyPolicyUpdate(u)
+        return err
+    }
+    lockDoc := func(cfg ObjectLockConfiguration) []byte {
+        doc, err := json.Marshal(cfg)
+        require.NoError(t, err)
+        return doc
+    }
+
+    // Nothing another node sends switches object lock off.
+    assert.ErrorIs(t, apply(update(PolicyObjectLock, lockDoc(ObjectLockConfiguration{}))), ErrInvalidPolicyUpdate)
+    assert.ErrorIs(t, apply(update(PolicyObjectLock, nil)), ErrInvalidPolicyUpdate)
+    assert.ErrorIs(t, apply(update(PolicyObjectLock, lockDoc(ObjectLockConfiguration{Enabled: true, DefaultMode: "FOREVER"}))), ErrInvalidPolicyUpdate)
+    cfg, err := store.GetObjectLockConfiguration("b")
+    require.NoError(t, err)
+    assert.True(t, cfg.Enabled)
+    require.NoError(t, apply(update(PolicyObjectLock, lockDoc(ObjectLockConfiguration{Enabled: true, DefaultMode: RetentionGovernance, DefaultDays: 1}))))
+
+    // Malformed documents are refused.
+    assert.ErrorIs(t, apply(update(PolicyVersioning, []byte("Sometimes"))), ErrInvalidPolicyUpdate)
+    assert.ErrorIs(t, apply(update(PolicyTrash, []byte("{"))), ErrInvalidPolicyUpdate)
+    assert.ErrorIs(t, apply(update(PolicyTrash, []byte(`{"retention_days":-1}`))), ErrInvalidPolicyUpdate)
+    assert.ErrorIs(t, apply(update(PolicyErasure, []byte(`{"data_shards":0,"parity_shards":0}`))), ErrInvalidPolicyUpdate)
+    assert.ErrorIs(t, apply(update(PolicyConflicts, []byte(`{"mode":"random"}`))), ErrInvalidPolicyUpdate)
+    require.NoError(t, apply(update(PolicyVersioning, []byte(VersioningEnabled))))
+
+    // Bucket configuration documents need a validator.
+    assert.ErrorIs(t, apply(update(ConfigPolicyPrefix+"rules", []byte("{}"))), ErrInvalidPolicyUpdate)
+    store.SetConfigValidator("rules", func(doc []byte) error {
+        if !json.Valid(doc) {
+            return errors.New("not JSON")
+        }
+        return nil
+    })
+    assert.ErrorIs(t, apply(update(ConfigPolicyPrefix+"rules", []byte("{"))), ErrInvalidPolicyUpdate)
+    require.NoError(t, apply(update(ConfigPolicyPrefix+"rules", []byte("{}"))))
+
+    // A stamp far ahead of the local clock cannot outrank later changes.
+    future := update(PolicyVersioning, []byte(VersioningSuspended))
+    future.Stamp = hlc.Timestamp{Wall: time.Now().Add(time.Hour).UnixNano()}
+    assert.ErrorIs(t, apply(future), hlc.ErrClockDrift)
+    status, err := store.GetBucketVersioning("b")
+    require.NoError(t, err)
+    assert.Equal(t, VersioningEnabled, status)
+}
@@ -0,0 +1,342 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/json"
+    "errors"
+    "fmt"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const manifestFormat = 3
+
+// Manifest is what a version's data slot decrypts to: the CID of the root
+// node of the object's DAG, whose leaves are the encrypted chunks, and the
+// plaintext size and key of every chunk in leaf order.
+type Manifest struct {
+    Format int        `json:"format"`
+    DAG    string     `json:"dag,omitempty"`
+    Size   int64      `json:"size"`
+    Chunks []ChunkRef `json:"chunks"`
+}
+
+type ChunkRef struct {
+    Size int64  `json:"size"`
+    Key  []byte `json:"key"`
+}
+
+// blockKey is where the block addressed by c is stored. Chunks are raw
+// blocks, so their CIDs can be fetched from IPFS peers as they are.
+func blockKey(c cid.Cid) []byte {
+    return []byte("block/" + c.String())
+}
+
+// encodeObject splits data into content-defined chunks and encrypts each
+// with its convergent key under the cluster key. It returns the manifest,
+// the DAG over the encrypted chunks and the encrypted chunks in leaf order.
+// The manifest's DAG CID is filled in once the DAG is stored.
+func (s *BadgerStore) encodeObject(data []byte) (*Manifest, *dag.MerkleNode, [][]byte, error) {
+    m := &Manifest{Format: manifestFormat, Size: int64(len(data))}
+    var blocks, leaves [][]byte
+    for _, chunk := range dag.DefaultChunker.Split(data) {
+        block, key, err := crypto.ConvergentEncrypt(s.convergent, chunk)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        hash := sha256.Sum256(block)
+        blocks = append(blocks, block)
+        leaves = append(leaves, hash[:])
+        m.Chunks = append(m.Chunks, ChunkRef{Size: int64(len(chunk)), Key: key})
+    }
+    return m, dag.BuildTree(leaves), blocks, nil
+}
+
+// putBlocks writes blocks outside of any transaction. Blocks are content
+// addressed, so rewriting an existing one is harmless.
+func (s *BadgerStore) putBlocks(blocks [][]byte) error {
+    wb := s.db.NewWriteBatch()
+    defer wb.Cancel()
+    cids := make([]cid.Cid, 0, len(blocks))
+    for _, block := range blocks {
+        c, err := dag.NewCID(dag.CodecRaw, block)
+        if err != nil {
+            return err
+        }
+        if err := wb.Set(blockKey(c), block); err != nil {
+            return err
+        }
+        cids = append(cids, c)
+    }
+    if err := wb.Flush(); err != nil {
+        return err
+    }
+    s.announce(cids...)
+    return nil
+}
+
+// PutBlock stores a block under its CID after checking that they match.
+func (s *BadgerStore) PutBlock(ctx context.Context, c cid.Cid, block []byte) error {
+    if err := dag.VerifyCID(c, block); err != nil {
+        return err
+    }
+    err := s.db.Update(func(txn *badger.Txn) error {
+        return txn.Set(blockKey(c), block)
+    })
+    if err == nil {
+        s.announce(c)
+    }
+    return err
+}
+
+// pinKey records that owner asked this node to keep block c. It holds the
+// block's CID.
+func pinKey(c cid.Cid, owner peer.ID) []byte {
+    return nameKey("pin", c.String(), owner.String())
+}
+
+// PinBlock stores a block for owner, a peer that placed a shard or copy on
+// this node. Garbage collection keeps the block until every owner that
+// pinned it has unpinned it.
+func (s *BadgerStore) PinBlock(ctx context.Context, owner peer.ID, c cid.Cid, block []byte) error {
+    if err := dag.VerifyCID(c, block); err != nil {
+        return err
+    }
+    err := s.db.Update(func(txn *badger.Txn) error {
+        if err := txn.Set(blockKey(c), block); err != nil {
+            return err
+        }
+        return txn.Set(pinKey(c, owner), c.Bytes())
+    })
+    if err == nil {
+        s.announce(c)
+    }
+    return err
+}
+
+// UnpinBlock drops owner's pin on a block. The block itself is left to
+// garbage collection.
+func (s *BadgerStore) UnpinBlock(ctx context.Context, owner peer.ID, c cid.Cid) error {
+    return s.db.Update(func(txn *badger.Txn) error {
+        return txn.Delete(pinKey(c, owner))
+    })
+}
+
+// GetBlock returns the block addressed by c, or ErrNotFound. Erasure-coded
+// chunks are rebuilt from their shards.
+func (s *BadgerStore) GetBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+    var block []byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        block, err = s.loadBlock(txn, c)
+        return err
+    })
+    if err == badger.ErrKeyNotFound {
+        return nil, ErrNotFound
+    }
+    return block, err
+}
+
+func (s *BadgerStore) HasBlock(ctx context.Context, c cid.Cid) (bool, error) {
+    err := s.db.View(func(txn *badger.Txn) error {
+        _, err := txn.Get(blockKey(c))
+        return err
+    })
+    if err == badger.ErrKeyNotFound {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// getBlock reads a block and checks it against its CID.
+func getBlock(txn *badger.Txn, c cid.Cid) ([]byte, error) {
+    item, err := txn.Get(blockKey(c))
+    if err != nil {
+        return nil, err
+    }
+    block, err := item.ValueCopy(nil)
+    if err != nil {
+        return nil, err
+    }
+    return block, dag.VerifyCID(c, block)
+}
+
+// sealManifest encrypts a manifest under a fresh AES key and wraps the key.
+func (s *BadgerStore) sealManifest(m *Manifest) (data, wrappedKey []byte, err error) {
+    plain, err := json.Marshal(m)
+    if err != nil {
+        return nil, nil, err
+    }
+    aesKey := make([]byte, 32)
+    if _, err := rand.Read(aesKey); err != nil {
+        return nil, nil, err
+    }
+    if data, err = s.keyManager.EncryptData(plain, aesKey); err != nil {
+        return nil, nil, err
+    }
+    wrappedKey, err = s.keyManager.EncryptAESKey(s.keyManager.GetPublicKey(), aesKey)
+    return data, wrappedKey, err
+}
+
+func (s *BadgerStore) openManifest(data, wrappedKey []byte) (*Manifest, error) {
+    plain, err := s.decrypt(data, wrappedKey)
+    if err != nil {
+        return nil, err
+    }
+    var m Manifest
+    if err := json.Unmarshal(plain, &m); err != nil {
+        return nil, err
+    }
+    if m.Format != manifestFormat {
+        return nil, fmt.Errorf("unsupported manifest format %d", m.Format)
+    }
+    return &m, nil
+}
+
+// versionManifest opens the manifest of an object version, the current one
+// if versionID is empty, and returns it with the resolved version ID.
+func (s *BadgerStore) versionManifest(txn *badger.Txn, bucket, key, versionID string) (string, *Manifest, error) {
+    versionID, err := resolveVersion(txn, bucket, key, versionID)
+    if err != nil {
+        return "", nil, err
+    }
+    info, err := getObjectInfo(txn, bucket, key)
+    if err != nil {
+        return "", nil, err
+    }
+    dataKey, keyKey := versionDataKeys(info, info.find(versionID))
+    data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+    if err != nil {
+        return "", nil, err
+    }
+    m, err := s.openManifest(data, aesKey)
+    return versionID, m, err
+}
+
+// manifestRoot returns the CID of a manifest's root node, which is
+// undefined for empty objects.
+func manifestRoot(m *Manifest) (cid.Cid, error) {
+    if m.DAG == "" {
+        return cid.Undef, nil
+    }
+    return cid.Decode(m.DAG)
+}
+
+// manifestDAG loads the Merkle tree of a manifest. Empty objects have none.
+func manifestDAG(txn *badger.Txn, m *Manifest) (*dag.MerkleNode, error) {
+    if m.DAG == "" {
+        return nil, nil
+    }
+    c, err := cid.Decode(m.DAG)
+    if err != nil {
+        return nil, err
+    }
+    return loadDAG(txn, c)
+}
+
+// manifestLeaves walks the manifest's DAG and returns its block hashes.
+func manifestLeaves(txn *badger.Txn, m *Manifest) ([][]byte, error) {
+    if m.DAG == "" {
+        return nil, nil
+    }
+    c, err := cid.Decode(m.DAG)
+    if err != nil {
+        return nil, err
+    }
+    var leaves [][]byte
+    err = walkDAG(txn, c, func(c cid.Cid, data []byte) error {
+        if data != nil {
+            return nil
+        }
+        hash, err := dag.LeafHash(c)
+        leaves = append(leaves, hash)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(leaves) != len(m.Chunks) {
+        return nil, fmt.Errorf("manifest lists %d chunks but its DAG has %d", len(m.Chunks), len(leaves))
+    }
+    return leaves, nil
+}
+
+// assemble decrypts and concatenates the chunks of an object.
+func assemble(m *Manifest, blocks [][]byte) ([]byte, error) {
+    out := make([]byte, 0, m.Size)
+    for i, block := range blocks {
+        chunk, err := crypto.ConvergentDecrypt(block, m.Chunks[i].Key)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, chunk...)
+    }
+    return out, nil
+}
+
+// packBlocks concatenates blocks into one cold tier object.
+func packBlocks(blocks [][]byte) []byte {
+    return bytes.Join(blocks, nil)
+}
+
+// unpackBlocks splits a pack using the chunk sizes of the manifest and checks
+// each block against its leaf hash.
+func unpackBlocks(pack []byte, m *Manifest, leaves [][]byte) ([][]byte, error) {
+    blocks := make([][]byte, len(m.Chunks))
+    for i, c := range m.Chunks {
+        n := int(c.Size) + crypto.ConvergentOverhead
+        if len(pack) < n {
+            return nil, errors.New("cold tier pack is truncated")
+        }
+        blocks[i], pack = pack[:n], pack[n:]
+        c, err := dag.LeafCID(leaves[i])
+        if err != nil {
+            return nil, err
+        }
+        if err := dag.VerifyCID(c, blocks[i]); err != nil {
+            return nil, err
+        }
+    }
+    return blocks, nil
+}
+
+// readBlocks loads the blocks of a manifest from Badger or, for
+// erasure-coded chunks, from peers.
+func (s *BadgerStore) readBlocks(txn *badger.Txn, leaves [][]byte) ([][]byte, error) {
+    blocks := make([][]byte, len(leaves))
+    for i, hash := range leaves {
+        c, err := dag.LeafCID(hash)
+        if err != nil {
+            return nil, err
+        }
+        if blocks[i], err = s.loadBlock(txn, c); err != nil {
+            return nil, err
+        }
+    }
+    return blocks, nil
+}
+
+// storeObjectDAG persists the DAG of a new object, if it has one, and
+// records its CID in the manifest.
+func (s *BadgerStore) storeObjectDAG(m *Manifest, root *dag.MerkleNode) error {
+    if root == nil {
+        return nil
+    }
+    sizes := make([]uint64, len(m.Chunks))
+    for i, c := range m.Chunks {
+        sizes[i] = uint64(c.Size) + crypto.ConvergentOverhead
+    }
+    c, err := s.StoreDAG(context.Background(), root, sizes)
+    if err != nil {
+        return err
+    }
+    m.DAG = c.String()
+    return nil
+}
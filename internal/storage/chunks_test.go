@@ -0,0 +1,72 @@
+package storage
+
+import (
+    "math/rand"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPutObject_ChunkedAndDeduplicated(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    data := make([]byte, 1<<20)
+    rand.New(rand.NewSource(7)).Read(data)
+    require.NoError(t, store.PutObject("b", "one", data))
+    got, err := store.GetObject("b", "one")
+    require.NoError(t, err)
+    assert.Equal(t, data, got)
+
+    countBlocks := func() int {
+        n := 0
+        require.NoError(t, store.db.View(func(txn *badger.Txn) error {
+            opts := badger.DefaultIteratorOptions
+            opts.PrefetchValues = false
+            it := txn.NewIterator(opts)
+            defer it.Close()
+            prefix := []byte("block/")
+            for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+                n++
+            }
+            return nil
+        }))
+        return n
+    }
+    blocks := countBlocks()
+    require.Greater(t, blocks, 1)
+
+    require.NoError(t, store.PutObject("b", "two", data))
+    assert.Equal(t, blocks, countBlocks())
+
+    empty, err := store.GetObject("b", "missing")
+    assert.ErrorIs(t, err, ErrNotFound)
+    assert.Nil(t, empty)
+    require.NoError(t, store.PutObject("b", "empty", nil))
+    got, err = store.GetObject("b", "empty")
+    require.NoError(t, err)
+    assert.Empty(t, got)
+}
+
+func TestGetObject_DetectsCorruptBlock(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObject("b", "k", []byte("some data")))
+    require.NoError(t, store.db.Update(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        prefix := []byte("block/bafkrei")
+        it.Seek(prefix)
+        require.True(t, it.ValidForPrefix(prefix))
+        return txn.Set(it.Item().KeyCopy(nil), []byte("garbage"))
+    }))
+
+    _, err = store.GetObject("b", "k")
+    assert.ErrorIs(t, err, dag.ErrCIDMismatch)
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ConflictRetention is how long recorded conflicts are kept.
+const ConflictRetention = 30 * 24 * time.Hour
+
+// ConflictMode is how a bucket settles concurrent writes to one key.
+type ConflictMode string
+
+const (
+    // ConflictLastWriterWins keeps the write with the later stamp and
+    // discards the other.
+    ConflictLastWriterWins ConflictMode = "last-writer-wins"
+    // ConflictSiblings makes the later write current and keeps the other
+    // as an older version. Deletes still win or lose outright.
+    ConflictSiblings ConflictMode = "siblings"
+)
+
+var ErrInvalidConflictMode = errors.New("invalid conflict mode")
+
+type ConflictConfiguration struct {
+    Mode ConflictMode `json:"mode"`
+}
+
+// ConflictWrite is one side of a conflict.
+type ConflictWrite struct {
+    VersionID string        `json:"version_id,omitempty"`
+    Stamp     hlc.Timestamp `json:"stamp"`
+    Origin    peer.ID       `json:"origin,omitempty"`
+    Deleted   bool          `json:"deleted,omitempty"`
+}
+
+// Conflict records two writes to an object made concurrently on different
+// nodes, neither writer having seen the other's, and how they were
+// settled.
+type Conflict struct {
+    Bucket     string        `json:"bucket"`
+    Key        string        `json:"key"`
+    DetectedAt time.Time     `json:"detected_at"`
+    Winner     ConflictWrite `json:"winner"`
+    Loser      ConflictWrite `json:"loser"`
+    Resolution ConflictMode  `json:"resolution"`
+    Reason     string        `json:"reason"`
+}
+
+func conflictConfigKey(bucket string) []byte {
+    return []byte("conflictmode/" + bucket)
+}
+
+func conflictPrefix(bucket string) []byte {
+    if bucket == "" {
+        return []byte("conflict/")
+    }
+    return []byte("conflict/" + bucket + "/")
+}
+
+// conflictKey identifies a conflict by its losing write, which loses to
+// the same winner wherever it is found.
+func conflictKey(bucket, key string, loser ConflictWrite) []byte {
+    return []byte(fmt.Sprintf("conflict/%s/%s/%020d.%010d/%s", bucket, key, loser.Stamp.Wall, loser.Stamp.Logical, loser.Origin))
+}
+
+func (c ConflictConfiguration) validate() error {
+    if c.Mode != ConflictLastWriterWins && c.Mode != ConflictSiblings {
+        return ErrInvalidConflictMode
+    }
+    return nil
+}
+
+func (s *BadgerStore) PutConflictConfiguration(bucket string, cfg ConflictConfiguration) error {
+    if err := cfg.validate(); err != nil {
+        return err
+    }
+    data, err := json.Marshal(cfg)
+    if err != nil {
+        return err
+    }
+    return s.updatePolicy(bucket, PolicyConflicts, func(txn *badger.Txn) error {
+        return txn.Set(conflictConfigKey(bucket), data)
+    }, translog.Entry{Type: translog.TypePolicy, Bucket: bucket, Detail: "conflicts=" + string(cfg.Mode)})
+}
+
+// GetConflictConfiguration returns the bucket's conflict mode, last writer
+// wins unless set.
+func (s *BadgerStore) GetConflictConfiguration(bucket string) (ConflictConfiguration, error) {
+    var cfg ConflictConfiguration
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        cfg.Mode, err = getConflictMode(txn, bucket)
+        return err
+    })
+    return cfg, err
+}
+
+func getConflictMode(txn *badger.Txn, bucket string) (ConflictMode, error) {
+    item, err := txn.Get(conflictConfigKey(bucket))
+    if err == badger.ErrKeyNotFound {
+        return ConflictLastWriterWins, nil
+    }
+    if err != nil {
+        return "", err
+    }
+    var cfg ConflictConfiguration
+    err = item.Value(func(val []byte) error { return json.Unmarshal(val, &cfg) })
+    return cfg.Mode, err
+}
+
+func conflictWrite(e SyncEntry) ConflictWrite {
+    w := ConflictWrite{VersionID: e.Version.VersionID, Deleted: e.Deleted()}
+    w.Stamp, w.Origin = e.stamp()
+    if e.Tombstone != nil {
+        w.VersionID = ""
+    }
+    return w
+}
+
+// recordConflict records that winner overwrote loser, unless that was
+// already recorded, and reports whether it was new.
+func recordConflict(txn *badger.Txn, winner, loser SyncEntry, mode ConflictMode, reason string) (bool, error) {
+    c := Conflict{
+        Bucket:     winner.Bucket,
+        Key:        winner.Key,
+        DetectedAt: time.Now().UTC(),
+        Winner:     conflictWrite(winner),
+        Loser:      conflictWrite(loser),
+        Resolution: mode,
+        Reason:     reason,
+    }
+    k := conflictKey(c.Bucket, c.Key, c.Loser)
+    if _, err := txn.Get(k); err == nil {
+        return false, nil
+    } else if err != badger.ErrKeyNotFound {
+        return false, err
+    }
+    data, err := json.Marshal(c)
+    if err != nil {
+        return false, err
+    }
+    return true, txn.Set(k, data)
+}
+
+// siblingVersionID names a version kept as a sibling that had no version
+// ID of its own. It is derived from the write, so every node that keeps
+// the sibling gives it the same name.
+func siblingVersionID(v ObjectVersion) string {
+    sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s", v.Origin, v.Stamp)))
+    return hex.EncodeToString(sum[:16])
+}
+
+// renameVersion gives the i-th version of info a new ID, carrying its
+// object lock along.
+func renameVersion(txn *badger.Txn, info *ObjectInfo, i int, versionID string) error {
+    old := info.Versions[i].VersionID
+    if i > 0 {
+        fromData, fromKey := noncurrentDataKeys(info.Bucket, info.Key, old)
+        toData, toKey := noncurrentDataKeys(info.Bucket, info.Key, versionID)
+        if err := moveVersionData(txn, fromData, fromKey, toData, toKey); err != nil {
+            return err
+        }
+    }
+    for _, keys := range [][2][]byte{
+        {retentionKey(info.Bucket, info.Key, old), retentionKey(info.Bucket, info.Key, versionID)},
+        {legalHoldKey(info.Bucket, info.Key, old), legalHoldKey(info.Bucket, info.Key, versionID)},
+    } {
+        if err := moveValue(txn, keys[0], keys[1]); err != nil && err != badger.ErrKeyNotFound {
+            return err
+        }
+    }
+    info.Versions[i].VersionID = versionID
+    return nil
+}
+
+// keepSibling stores version, which lost to the current version of info,
+// as the next older version. It returns the ID the sibling was kept under,
+// or "" if it was already kept.
+func (s *BadgerStore) keepSibling(txn *badger.Txn, info *ObjectInfo, version ObjectVersion, manifest, wrappedKey []byte) (string, error) {
+    if version.VersionID == NullVersionID {
+        version.VersionID = siblingVersionID(version)
+    }
+    if info.find(version.VersionID) >= 0 {
+        return "", nil
+    }
+    dataKey, keyKey := noncurrentDataKeys(info.Bucket, info.Key, version.VersionID)
+    if err := txn.Set(dataKey, manifest); err != nil {
+        return "", err
+    }
+    if err := txn.Set(keyKey, wrappedKey); err != nil {
+        return "", err
+    }
+    versions := append([]ObjectVersion{info.Versions[0], version}, info.Versions[1:]...)
+    info.Versions = versions
+    return version.VersionID, s.putObjectInfo(txn, info)
+}
+
+// ListConflicts returns the conflicts recorded for bucket, or for every
+// bucket if it is empty, most recent first and at most limit of them.
+func (s *BadgerStore) ListConflicts(ctx context.Context, bucket string, limit int) ([]Conflict, error) {
+    var conflicts []Conflict
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        prefix := conflictPrefix(bucket)
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            var c Conflict
+            if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &c) }); err != nil {
+                return err
+            }
+            conflicts = append(conflicts, c)
+        }
+        return nil
+    })
+    sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].DetectedAt.After(conflicts[j].DetectedAt) })
+    if limit > 0 && len(conflicts) > limit {
+        conflicts = conflicts[:limit]
+    }
+    return conflicts, err
+}
+
+// ExpireConflicts forgets conflicts recorded more than retention ago.
+func (s *BadgerStore) ExpireConflicts(ctx context.Context, retention time.Duration) error {
+    cutoff := time.Now().Add(-retention)
+    var expired [][]byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        prefix := conflictPrefix("")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            var c Conflict
+            if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &c) }); err != nil {
+                return err
+            }
+            if c.DetectedAt.Before(cutoff) {
+                expired = append(expired, it.Item().KeyCopy(nil))
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    return s.db.Update(func(txn *badger.Txn) error {
+        for _, k := range expired {
+            if err := txn.Delete(k); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
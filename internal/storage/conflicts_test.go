@@ -0,0 +1,102 @@
+package storage
+
+import (
+    "context"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// replicate copies the state of bucket/key, blocks included, from one
+// store to another, as anti-entropy or gossip would.
+func replicate(t *testing.T, from, to *BadgerStore, bucket, key string) bool {
+    t.Helper()
+    ctx := context.Background()
+    entries, err := from.SyncEntries([]string{bucket + "/" + key})
+    require.NoError(t, err)
+    require.Len(t, entries, 1)
+    for _, c := range entries[0].Blocks {
+        data, err := from.GetBlock(ctx, c)
+        require.NoError(t, err)
+        require.NoError(t, to.PutBlock(ctx, c, data))
+    }
+    applied, err := to.ApplySyncEntry(ctx, entries[0])
+    require.NoError(t, err)
+    return applied
+}
+
+func TestApplySyncEntry_ResolvesConcurrentWrites(t *testing.T) {
+    ctx := context.Background()
+    a, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer a.Close()
+    b, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer b.Close()
+
+    // Neither writer saw the other's write; the later one wins everywhere.
+    require.NoError(t, a.PutObject("lww", "k", []byte("from a")))
+    require.NoError(t, b.PutObject("lww", "k", []byte("from b")))
+    assert.False(t, replicate(t, a, b, "lww", "k"))
+    assert.True(t, replicate(t, b, a, "lww", "k"))
+    for _, s := range []*BadgerStore{a, b} {
+        data, err := s.GetObject("lww", "k")
+        require.NoError(t, err)
+        assert.Equal(t, []byte("from b"), data)
+        conflicts, err := s.ListConflicts(ctx, "lww", 0)
+        require.NoError(t, err)
+        require.Len(t, conflicts, 1)
+        assert.Equal(t, b.NodeID(), conflicts[0].Winner.Origin)
+        assert.Equal(t, a.NodeID(), conflicts[0].Loser.Origin)
+        assert.Equal(t, ConflictLastWriterWins, conflicts[0].Resolution)
+        assert.Equal(t, "later timestamp", conflicts[0].Reason)
+    }
+
+    // A write made after seeing the other replaces it without conflict.
+    require.NoError(t, a.PutObject("lww", "k", []byte("after b")))
+    assert.True(t, replicate(t, a, b, "lww", "k"))
+    conflicts, err := b.ListConflicts(ctx, "", 0)
+    require.NoError(t, err)
+    assert.Len(t, conflicts, 1)
+
+    // With siblings kept, the losing write stays on as an older version
+    // under the same ID on both nodes.
+    for _, s := range []*BadgerStore{a, b} {
+        require.NoError(t, s.PutConflictConfiguration("sib", ConflictConfiguration{Mode: ConflictSiblings}))
+    }
+    require.NoError(t, a.PutObject("sib", "k", []byte("from a")))
+    require.NoError(t, b.PutObject("sib", "k", []byte("from b")))
+    assert.True(t, replicate(t, a, b, "sib", "k"))
+    assert.True(t, replicate(t, b, a, "sib", "k"))
+    assert.False(t, replicate(t, a, b, "sib", "k"), "delivered twice")
+    var siblings []string
+    for _, s := range []*BadgerStore{a, b} {
+        data, err := s.GetObject("sib", "k")
+        require.NoError(t, err)
+        assert.Equal(t, []byte("from b"), data)
+        info, err := s.GetObjectInfo("sib", "k")
+        require.NoError(t, err)
+        require.Len(t, info.Versions, 2)
+        data, err = s.GetObjectVersion("sib", "k", info.Versions[1].VersionID)
+        require.NoError(t, err)
+        assert.Equal(t, []byte("from a"), data)
+        siblings = append(siblings, info.Versions[1].VersionID)
+        conflicts, err := s.ListConflicts(ctx, "sib", 0)
+        require.NoError(t, err)
+        require.Len(t, conflicts, 1)
+        assert.Equal(t, ConflictSiblings, conflicts[0].Resolution)
+    }
+    assert.Equal(t, siblings[0], siblings[1])
+
+    // Writes stamped alike are ordered by origin node ID.
+    stamp := hlc.Timestamp{Wall: 1}
+    low := SyncEntry{Bucket: "b", Key: "k", Version: ObjectVersion{Stamp: stamp, Origin: "a"}}
+    high := SyncEntry{Bucket: "b", Key: "k", Version: ObjectVersion{Stamp: stamp, Origin: "b"}}
+    newer, reason := high.newerThan(low)
+    assert.True(t, newer)
+    assert.Equal(t, "same timestamp, greater origin node ID", reason)
+    newer, _ = low.newerThan(high)
+    assert.False(t, newer)
+}
@@ -2,20 +2,128 @@ package storage
 
 import (
     "context"
-    "crypto/sha256"
-    "encoding/json"
+    "errors"
 
     "github.com/Alyanaky/SecureDAG/internal/dag"
     "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
 )
 
-func (s *BadgerStore) StoreDAG(ctx context.Context, node *dag.MerkleNode) error {
-    hash := sha256.Sum256(node.Hash)
-    data, err := json.Marshal(node)
+// StoreDAG stores a tree built by dag.BuildTree as DAG-CBOR nodes and
+// returns the CID of its root node. sizes holds the size of each chunk
+// block in leaf order.
+func (s *BadgerStore) StoreDAG(ctx context.Context, root *dag.MerkleNode, sizes []uint64) (cid.Cid, error) {
+    blocks, err := dag.EncodeTree(root, sizes)
     if err != nil {
-        return err
+        return cid.Undef, err
+    }
+    if len(blocks) == 0 {
+        return cid.Undef, errors.New("cannot store an empty DAG")
+    }
+    err = s.db.Update(func(txn *badger.Txn) error {
+        for _, b := range blocks {
+            if err := txn.Set(blockKey(b.CID), b.Data); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return cid.Undef, err
     }
-    return s.db.Update(func(txn *badger.Txn) error {
-        return txn.Set(hash[:], data)
+    // The root is last; announce it first.
+    for i := len(blocks) - 1; i >= 0; i-- {
+        s.announce(blocks[i].CID)
+    }
+    return blocks[len(blocks)-1].CID, nil
+}
+
+// LoadNode loads a single DAG node without its children.
+func (s *BadgerStore) LoadNode(ctx context.Context, c cid.Cid) (*dag.Node, error) {
+    var node *dag.Node
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        node, err = loadNode(txn, c)
+        return err
     })
+    return node, err
+}
+
+// LoadDAG loads a DAG stored by StoreDAG and rebuilds its Merkle tree.
+func (s *BadgerStore) LoadDAG(ctx context.Context, c cid.Cid) (*dag.MerkleNode, error) {
+    var root *dag.MerkleNode
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        root, err = loadDAG(txn, c)
+        return err
+    })
+    return root, err
+}
+
+func loadNode(txn *badger.Txn, c cid.Cid) (*dag.Node, error) {
+    if c.Prefix().Codec != dag.CodecDagCBOR {
+        return nil, dag.ErrInvalidNode
+    }
+    data, err := getBlock(txn, c)
+    if err != nil {
+        return nil, err
+    }
+    return dag.DecodeNode(data)
+}
+
+func loadDAG(txn *badger.Txn, c cid.Cid) (*dag.MerkleNode, error) {
+    if c.Prefix().Codec == dag.CodecRaw {
+        hash, err := dag.LeafHash(c)
+        if err != nil {
+            return nil, err
+        }
+        return &dag.MerkleNode{Hash: hash}, nil
+    }
+    n, err := loadNode(txn, c)
+    if err != nil {
+        return nil, err
+    }
+    var children []*dag.MerkleNode
+    for _, l := range n.Links {
+        child, err := loadDAG(txn, l.CID)
+        if err != nil {
+            return nil, err
+        }
+        children = append(children, child)
+    }
+    switch len(children) {
+    case 1:
+        return children[0], nil
+    case 2:
+        return dag.NewMerkleNode(nil, children[0], children[1]), nil
+    }
+    return nil, dag.ErrInvalidNode
+}
+
+// walkDAG visits a DAG depth first and left to right. visit gets each node
+// with its encoding and each chunk with nil data; chunks are not read.
+func walkDAG(txn *badger.Txn, c cid.Cid, visit func(c cid.Cid, data []byte) error) error {
+    if c.Prefix().Codec == dag.CodecRaw {
+        return visit(c, nil)
+    }
+    if c.Prefix().Codec != dag.CodecDagCBOR {
+        return dag.ErrInvalidNode
+    }
+    data, err := getBlock(txn, c)
+    if err != nil {
+        return err
+    }
+    if err := visit(c, data); err != nil {
+        return err
+    }
+    n, err := dag.DecodeNode(data)
+    if err != nil {
+        return err
+    }
+    for _, l := range n.Links {
+        if err := walkDAG(txn, l.CID, visit); err != nil {
+            return err
+        }
+    }
+    return nil
 }
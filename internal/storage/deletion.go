@@ -2,48 +2,345 @@ package storage
 
 import (
     "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
     "time"
 
+    "github.com/Alyanaky/SecureDAG/internal/translog"
     "github.com/dgraph-io/badger/v4"
 )
 
-func (s *BadgerStore) SoftDeleteObject(ctx context.Context, bucket, key string) error {
-    return s.db.Update(func(txn *badger.Txn) error {
-        objKey := []byte(bucket + "/" + key)
-        deletedKey := []byte(bucket + "/" + key + "/deleted")
-        item, err := txn.Get(objKey)
+var ErrObjectExists = errors.New("an object already exists under this key")
+
+// TrashEntry records an object that was soft-deleted and can still be restored.
+// Every delete gets an entry of its own, named by ID.
+type TrashEntry struct {
+    ID        string        `json:"id"`
+    Bucket    string        `json:"bucket"`
+    Key       string        `json:"key"`
+    Size      int64         `json:"size"`
+    DeletedAt time.Time     `json:"deleted_at"`
+    DeletedBy string        `json:"deleted_by,omitempty"`
+    Version   ObjectVersion `json:"version"`
+}
+
+// TrashConfiguration controls soft deletion for a bucket. While RetentionDays
+// is positive, deletes move objects to the trash and PurgeDeletedObjects keeps
+// them for that many days.
+type TrashConfiguration struct {
+    RetentionDays int `json:"retention_days"`
+}
+
+func trashEntryKey(bucket, key, id string) []byte {
+    return nameKey("trash", bucket, key, id)
+}
+
+// trashEntryID names a trash entry by the time of its delete, so that the
+// entries of a key sort from oldest to newest.
+func trashEntryID(deletedAt time.Time) string {
+    return fmt.Sprintf("%016x", deletedAt.UnixNano())
+}
+
+func trashDataKeys(bucket, key, id string) (data, aesKey []byte) {
+    return dataKey("trash", bucket, key, id), dataKey("trash-key", bucket, key, id)
+}
+
+func trashConfigKey(bucket string) []byte {
+    return []byte("trashconfig/" + bucket)
+}
+
+func (c TrashConfiguration) validate() error {
+    if c.RetentionDays < 0 {
+        return errors.New("trash retention days must not be negative")
+    }
+    return nil
+}
+
+func (s *BadgerStore) PutTrashConfiguration(bucket string, cfg TrashConfiguration) error {
+    if err := cfg.validate(); err != nil {
+        return err
+    }
+    data, err := json.Marshal(cfg)
+    if err != nil {
+        return err
+    }
+    return s.updatePolicy(bucket, PolicyTrash, func(txn *badger.Txn) error {
+        return txn.Set(trashConfigKey(bucket), data)
+    }, translog.Entry{
+        Type:   translog.TypePolicy,
+        Bucket: bucket,
+        Detail: fmt.Sprintf("trash-retention-days=%d", cfg.RetentionDays),
+    })
+}
+
+func (s *BadgerStore) GetTrashConfiguration(bucket string) (TrashConfiguration, error) {
+    var cfg TrashConfiguration
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        cfg, err = getTrashConfiguration(txn, bucket)
+        return err
+    })
+    return cfg, err
+}
+
+func getTrashConfiguration(txn *badger.Txn, bucket string) (TrashConfiguration, error) {
+    var cfg TrashConfiguration
+    item, err := txn.Get(trashConfigKey(bucket))
+    if err == badger.ErrKeyNotFound {
+        return cfg, nil
+    }
+    if err != nil {
+        return cfg, err
+    }
+    err = item.Value(func(val []byte) error {
+        return json.Unmarshal(val, &cfg)
+    })
+    return cfg, err
+}
+
+// RemoveObject deletes the current version of an object as a DELETE without
+// a version ID does. An unversioned bucket with a trash retention window
+// moves it to the trash; otherwise DeleteObject removes it or places a delete
+// marker.
+func (s *BadgerStore) RemoveObject(ctx context.Context, bucket, key, deletedBy string, bypassGovernance bool) error {
+    trash, err := s.GetTrashConfiguration(bucket)
+    if err != nil {
+        return err
+    }
+    versioning, err := s.GetBucketVersioning(bucket)
+    if err != nil {
+        return err
+    }
+    // Versioned buckets keep deleted data behind delete markers instead of in the trash.
+    if trash.RetentionDays > 0 && versioning == "" {
+        return s.SoftDeleteObject(ctx, bucket, key, deletedBy, bypassGovernance)
+    }
+    return s.DeleteObject(bucket, key, bypassGovernance)
+}
+
+// SoftDeleteObject moves the current version of an object and its wrapped key
+// into the trash. A version under retention or legal hold stays in place
+// unless bypassGovernance lifts a governance-mode retention.
+func (s *BadgerStore) SoftDeleteObject(ctx context.Context, bucket, key, deletedBy string, bypassGovernance bool) error {
+    e := translog.Entry{Type: translog.TypeDelete, Bucket: bucket, Key: key, Detail: "trash"}
+    return s.update(&e, func(txn *badger.Txn) error {
+        info, err := getObjectInfo(txn, bucket, key)
         if err != nil {
             return err
         }
-        data, err := item.ValueCopy(nil)
+        latest, ok := info.Latest()
+        if !ok || latest.IsDeleteMarker {
+            return ErrNotFound
+        }
+        if err := checkObjectLock(txn, bucket, key, latest.VersionID, bypassGovernance); err != nil {
+            return err
+        }
+        entry := TrashEntry{
+            Bucket:    bucket,
+            Key:       key,
+            Size:      latest.Size,
+            DeletedAt: time.Now().UTC(),
+            DeletedBy: deletedBy,
+            Version:   latest,
+        }
+        entry.ID = trashEntryID(entry.DeletedAt)
+        record, err := json.Marshal(entry)
         if err != nil {
             return err
         }
-        if err := txn.Set(deletedKey, data); err != nil {
+
+        objKey, keyEncKey := currentDataKeys(bucket, key)
+        deletedKey, deletedKeyEncKey := trashDataKeys(bucket, key, entry.ID)
+        if err := moveVersionData(txn, objKey, keyEncKey, deletedKey, deletedKeyEncKey); err != nil {
+            return err
+        }
+        // Lock records travel with the data, so a later version with the same
+        // ID starts unlocked and the entry stays locked until it is purged.
+        if err := moveLock(txn, versionLockKeys(bucket, key, latest.VersionID), trashLockKeys(bucket, key, entry.ID)); err != nil {
             return err
         }
-        return txn.Delete(objKey)
+        if err := txn.Set(trashEntryKey(bucket, key, entry.ID), record); err != nil {
+            return err
+        }
+        info.Versions = info.Versions[1:]
+        if err := promoteLatest(txn, info); err != nil {
+            return err
+        }
+        e.VersionID = latest.VersionID
+        return s.putObjectInfo(txn, info)
     })
 }
 
-func (s *BadgerStore) PurgeDeletedObjects(ctx context.Context, retention time.Duration) error {
-    cutoff := time.Now().Add(-retention)
-    return s.db.Update(func(txn *badger.Txn) error {
+// RestoreObject moves the trash entry id of a soft-deleted object back into
+// place, or its newest entry if id is empty. It fails with ErrObjectExists if
+// the key has been written again since the delete.
+func (s *BadgerStore) RestoreObject(ctx context.Context, bucket, key, id string) error {
+    e := translog.Entry{Type: translog.TypeVersion, Bucket: bucket, Key: key, Detail: "restore"}
+    return s.update(&e, func(txn *badger.Txn) error {
+        entry, err := getTrashEntry(txn, bucket, key, id)
+        if err != nil {
+            return err
+        }
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        if latest, ok := info.Latest(); ok && !latest.IsDeleteMarker {
+            return ErrObjectExists
+        }
+        if info.find(entry.Version.VersionID) >= 0 {
+            return ErrObjectExists
+        }
+
+        deletedKey, deletedKeyEncKey := trashDataKeys(bucket, key, entry.ID)
+        objKey, keyEncKey := currentDataKeys(bucket, key)
+        if err := moveVersionData(txn, deletedKey, deletedKeyEncKey, objKey, keyEncKey); err != nil {
+            return err
+        }
+        if err := moveLock(txn, trashLockKeys(bucket, key, entry.ID), versionLockKeys(bucket, key, entry.Version.VersionID)); err != nil {
+            return err
+        }
+        if err := txn.Delete(trashEntryKey(bucket, key, entry.ID)); err != nil {
+            return err
+        }
+        info.Versions = append([]ObjectVersion{entry.Version}, info.Versions...)
+        e.VersionID = entry.Version.VersionID
+        return s.putObjectInfo(txn, info)
+    })
+}
+
+// getTrashEntry reads the trash entry id of a key, or its newest entry if id
+// is empty.
+func getTrashEntry(txn *badger.Txn, bucket, key, id string) (TrashEntry, error) {
+    var entry TrashEntry
+    var item *badger.Item
+    if id != "" {
+        var err error
+        if item, err = txn.Get(trashEntryKey(bucket, key, id)); err != nil {
+            return entry, err
+        }
+    } else {
         opts := badger.DefaultIteratorOptions
-        opts.PrefetchValues = false
+        opts.Reverse = true
         it := txn.NewIterator(opts)
         defer it.Close()
+        prefix := append(nameKey("trash", bucket, key), '/')
+        // Reverse iteration starts at the last key not above the seek key.
+        it.Seek(append(append([]byte{}, prefix...), 0xff))
+        if !it.ValidForPrefix(prefix) {
+            return entry, badger.ErrKeyNotFound
+        }
+        item = it.Item()
+    }
+    err := item.Value(func(val []byte) error {
+        return json.Unmarshal(val, &entry)
+    })
+    return entry, err
+}
 
-        prefix := []byte("/deleted")
+// ListTrash returns the soft-deleted objects of a bucket.
+func (s *BadgerStore) ListTrash(ctx context.Context, bucket string) ([]TrashEntry, error) {
+    var entries []TrashEntry
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := append(nameKey("trash", bucket), '/')
         for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-            item := it.Item()
-            key := item.KeyCopy(nil)
-            if item.ExpiresAt() != 0 && time.Unix(int64(item.ExpiresAt()), 0).Before(cutoff) {
-                if err := txn.Delete(key); err != nil {
+            var entry TrashEntry
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &entry)
+            })
+            if err != nil {
+                return err
+            }
+            entries = append(entries, entry)
+        }
+        return nil
+    })
+    return entries, err
+}
+
+func deleteTrashEntry(txn *badger.Txn, entry TrashEntry) error {
+    dataKey, aesKey := trashDataKeys(entry.Bucket, entry.Key, entry.ID)
+    if err := deleteVersionData(txn, entry.Version, dataKey, aesKey); err != nil {
+        return err
+    }
+    if err := clearLock(txn, trashLockKeys(entry.Bucket, entry.Key, entry.ID)); err != nil {
+        return err
+    }
+    return txn.Delete(trashEntryKey(entry.Bucket, entry.Key, entry.ID))
+}
+
+// PurgeDeletedObjects permanently removes trash entries older than the
+// bucket's trash retention, or older than retention for buckets without a
+// trash configuration. Locked objects are kept.
+func (s *BadgerStore) PurgeDeletedObjects(ctx context.Context, retention time.Duration) error {
+    now := time.Now()
+    var expired []TrashEntry
+    cutoffs := make(map[string]time.Time)
+
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := []byte("trash/")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            var entry TrashEntry
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &entry)
+            })
+            if err != nil {
+                return err
+            }
+            cutoff, ok := cutoffs[entry.Bucket]
+            if !ok {
+                cutoff = now.Add(-retention)
+                cfg, err := getTrashConfiguration(txn, entry.Bucket)
+                if err != nil {
                     return err
                 }
+                if cfg.RetentionDays > 0 {
+                    cutoff = now.AddDate(0, 0, -cfg.RetentionDays)
+                }
+                cutoffs[entry.Bucket] = cutoff
+            }
+            if entry.DeletedAt.Before(cutoff) {
+                expired = append(expired, entry)
             }
         }
         return nil
     })
+    if err != nil {
+        return err
+    }
+
+    for _, entry := range expired {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        e := translog.Entry{
+            Type:      translog.TypeDelete,
+            Bucket:    entry.Bucket,
+            Key:       entry.Key,
+            VersionID: entry.Version.VersionID,
+            Detail:    "purge",
+        }
+        err := s.update(&e, func(txn *badger.Txn) error {
+            if err := checkLock(txn, trashLockKeys(entry.Bucket, entry.Key, entry.ID), false); err != nil {
+                return err
+            }
+            return deleteTrashEntry(txn, entry)
+        })
+        if err == ErrObjectLocked {
+            log.Printf("Skipping purge of locked object %s/%s", entry.Bucket, entry.Key)
+            continue
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
 }
@@ -0,0 +1,86 @@
+package storage
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestTrash_SoftDeleteRestoreAndPurge(t *testing.T) {
+    ctx := context.Background()
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObject("bucket", "doc", []byte("contents")))
+    require.NoError(t, store.SoftDeleteObject(ctx, "bucket", "doc", "alice", false))
+
+    _, err = store.GetObject("bucket", "doc")
+    assert.ErrorIs(t, err, ErrNotFound)
+
+    entries, err := store.ListTrash(ctx, "bucket")
+    require.NoError(t, err)
+    require.Len(t, entries, 1)
+    assert.Equal(t, "doc", entries[0].Key)
+    assert.Equal(t, "alice", entries[0].DeletedBy)
+
+    require.NoError(t, store.RestoreObject(ctx, "bucket", "doc", ""))
+    data, err := store.GetObject("bucket", "doc")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("contents"), data)
+
+    require.NoError(t, store.PutTrashConfiguration("bucket", TrashConfiguration{RetentionDays: 7}))
+    require.NoError(t, store.SoftDeleteObject(ctx, "bucket", "doc", "alice", false))
+
+    // The bucket's seven day window wins over the zero default.
+    require.NoError(t, store.PurgeDeletedObjects(ctx, 0))
+    entries, err = store.ListTrash(ctx, "bucket")
+    require.NoError(t, err)
+    assert.Len(t, entries, 1)
+
+    require.NoError(t, store.PutTrashConfiguration("bucket", TrashConfiguration{}))
+    require.NoError(t, store.PurgeDeletedObjects(ctx, time.Nanosecond))
+    entries, err = store.ListTrash(ctx, "bucket")
+    require.NoError(t, err)
+    assert.Empty(t, entries)
+    assert.ErrorIs(t, store.RestoreObject(ctx, "bucket", "doc", ""), ErrNotFound)
+}
+
+func TestTrash_EachDeleteKeepsItsOwnEntry(t *testing.T) {
+    ctx := context.Background()
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObject("bucket", "doc", []byte("first")))
+    require.NoError(t, store.SoftDeleteObject(ctx, "bucket", "doc", "alice", false))
+    require.NoError(t, store.PutObject("bucket", "doc", []byte("second")))
+    require.NoError(t, store.SoftDeleteObject(ctx, "bucket", "doc", "bob", false))
+    // Another bucket's entries are listed apart even when its name extends this one.
+    require.NoError(t, store.PutObject("bucket/doc", "x", []byte("other")))
+    require.NoError(t, store.SoftDeleteObject(ctx, "bucket/doc", "x", "", false))
+
+    entries, err := store.ListTrash(ctx, "bucket")
+    require.NoError(t, err)
+    require.Len(t, entries, 2)
+    assert.Equal(t, "alice", entries[0].DeletedBy)
+    assert.Equal(t, "bob", entries[1].DeletedBy)
+
+    // Without an ID the newest delete comes back.
+    require.NoError(t, store.RestoreObject(ctx, "bucket", "doc", ""))
+    data, err := store.GetObject("bucket", "doc")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("second"), data)
+
+    require.NoError(t, store.SoftDeleteObject(ctx, "bucket", "doc", "bob", false))
+    require.NoError(t, store.RestoreObject(ctx, "bucket", "doc", entries[0].ID))
+    data, err = store.GetObject("bucket", "doc")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("first"), data)
+    entries, err = store.ListTrash(ctx, "bucket")
+    require.NoError(t, err)
+    assert.Len(t, entries, 1)
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestDiffVersions_SmallEdits(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    require.NoError(t, store.PutBucketVersioning("b", VersioningEnabled))
+
+    original := make([]byte, 4<<20)
+    rand.New(rand.NewSource(9)).Read(original)
+    v1, err := store.PutObjectWithOptions("b", "k", original, PutOptions{})
+    require.NoError(t, err)
+
+    // Overwrite a few bytes in the middle and insert some at the front.
+    edited := append([]byte("inserted"), original...)
+    copy(edited[2<<20:], "edited")
+    v2, err := store.PutObjectWithOptions("b", "k", edited, PutOptions{})
+    require.NoError(t, err)
+
+    diff, err := store.DiffVersions("b", "k", v1.VersionID, "")
+    require.NoError(t, err)
+    assert.Equal(t, v2.VersionID, diff.ToVersionID)
+    assert.Equal(t, int64(len(edited)), diff.Size)
+    require.Len(t, diff.Changed, 2)
+    assert.Zero(t, diff.Changed[0].Offset)
+    assert.Less(t, diff.Changed[1].Offset, int64(2<<20))
+    assert.Greater(t, diff.Changed[1].Offset+diff.Changed[1].Length, int64(2<<20))
+    assert.Less(t, diff.ChangedBytes, int64(len(edited)/4))
+
+    same, err := store.DiffVersions("b", "k", v2.VersionID, v2.VersionID)
+    require.NoError(t, err)
+    assert.Empty(t, same.Changed)
+}
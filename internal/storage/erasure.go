@@ -0,0 +1,515 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "log"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+    "github.com/klauspost/reedsolomon"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// MaxErasureShards is the largest stripe Reed-Solomon coding supports.
+const MaxErasureShards = 256
+
+var (
+    ErrInvalidErasureConfiguration = errors.New("invalid erasure coding configuration")
+    ErrNotEnoughPeers              = errors.New("not enough shard peers for the bucket's erasure coding")
+    ErrShardsUnavailable           = errors.New("too few shards available to reconstruct block")
+)
+
+// ErasureConfiguration turns on Reed-Solomon coding for a bucket. Each chunk
+// written to the bucket is split into DataShards shards plus ParityShards
+// parity shards, every one on a different peer, and can be read back from
+// any DataShards of them. A zero configuration keeps chunks in Badger.
+type ErasureConfiguration struct {
+    DataShards   int `json:"data_shards"`
+    ParityShards int `json:"parity_shards"`
+}
+
+func (c ErasureConfiguration) Enabled() bool {
+    return c.DataShards > 0
+}
+
+func (c ErasureConfiguration) String() string {
+    if !c.Enabled() {
+        return "off"
+    }
+    return fmt.Sprintf("%d+%d", c.DataShards, c.ParityShards)
+}
+
+// ShardPeer is a node that stores raw blocks by CID for this one: the
+// shards of erasure-coded chunks, and copies of chunks that the scrubber
+// fetches back or replicates.
+type ShardPeer interface {
+    ID() string
+    PutShard(ctx context.Context, c cid.Cid, data []byte) error
+    // GetShard returns ErrNotFound if the peer does not hold the shard.
+    GetShard(ctx context.Context, c cid.Cid) ([]byte, error)
+    HasShard(ctx context.Context, c cid.Cid) (bool, error)
+    DeleteShard(ctx context.Context, c cid.Cid) error
+}
+
+// exchangePeer is a cluster node reached over the block exchange. Shards
+// are pinned on it, so that its garbage collection keeps them until they
+// are deleted.
+type exchangePeer struct {
+    exchange *p2p.Exchange
+    id       peer.ID
+}
+
+// ExchangePeers returns the nodes ids as shard peers reached over e.
+func ExchangePeers(e *p2p.Exchange, ids []peer.ID) []ShardPeer {
+    peers := make([]ShardPeer, 0, len(ids))
+    for _, id := range ids {
+        peers = append(peers, &exchangePeer{exchange: e, id: id})
+    }
+    return peers
+}
+
+func (p *exchangePeer) ID() string { return p.id.String() }
+
+func (p *exchangePeer) PutShard(ctx context.Context, c cid.Cid, data []byte) error {
+    return p.exchange.PinBlock(ctx, p.id, c, data)
+}
+
+func (p *exchangePeer) GetShard(ctx context.Context, c cid.Cid) ([]byte, error) {
+    blocks, err := p.exchange.FetchBlocks(ctx, p.id, []cid.Cid{c})
+    if data, ok := blocks[c]; ok {
+        return data, nil
+    }
+    if err == nil {
+        err = ErrNotFound
+    }
+    return nil, err
+}
+
+func (p *exchangePeer) HasShard(ctx context.Context, c cid.Cid) (bool, error) {
+    return p.exchange.HasBlock(ctx, p.id, c)
+}
+
+func (p *exchangePeer) DeleteShard(ctx context.Context, c cid.Cid) error {
+    return p.exchange.UnpinBlock(ctx, p.id, c)
+}
+
+// Stripe records where the shards of an erasure-coded chunk are. It is kept
+// in place of the chunk's block, under the chunk's CID.
+type Stripe struct {
+    DataShards   int        `json:"data_shards"`
+    ParityShards int        `json:"parity_shards"`
+    Size         int        `json:"size"`
+    Shards       []ShardRef `json:"shards"`
+}
+
+type ShardRef struct {
+    CID  string `json:"cid"`
+    Peer string `json:"peer"`
+}
+
+// storedBytes is the space the stripe's shards take on peers.
+func (st *Stripe) storedBytes() int64 {
+    shardSize := (st.Size + st.DataShards - 1) / st.DataShards
+    return int64(shardSize * len(st.Shards))
+}
+
+func erasureConfigKey(bucket string) []byte {
+    return []byte("erasure/" + bucket)
+}
+
+var stripePrefix = []byte("stripe/")
+
+func stripeKey(c cid.Cid) []byte {
+    return append(append([]byte{}, stripePrefix...), c.String()...)
+}
+
+func (c ErasureConfiguration) validate() error {
+    total := c.DataShards + c.ParityShards
+    if c.DataShards < 0 || c.ParityShards < 0 || total > MaxErasureShards ||
+        (c.DataShards == 0) != (c.ParityShards == 0) {
+        return ErrInvalidErasureConfiguration
+    }
+    return nil
+}
+
+func (s *BadgerStore) PutErasureConfiguration(bucket string, cfg ErasureConfiguration) error {
+    if err := cfg.validate(); err != nil {
+        return err
+    }
+    data, err := json.Marshal(cfg)
+    if err != nil {
+        return err
+    }
+    return s.updatePolicy(bucket, PolicyErasure, func(txn *badger.Txn) error {
+        if !cfg.Enabled() {
+            return txn.Delete(erasureConfigKey(bucket))
+        }
+        return txn.Set(erasureConfigKey(bucket), data)
+    }, translog.Entry{Type: translog.TypePolicy, Bucket: bucket, Detail: "erasure=" + cfg.String()})
+}
+
+func (s *BadgerStore) GetErasureConfiguration(bucket string) (ErasureConfiguration, error) {
+    var cfg ErasureConfiguration
+    err := s.db.View(func(txn *badger.Txn) error {
+        item, err := txn.Get(erasureConfigKey(bucket))
+        if err == badger.ErrKeyNotFound {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        return item.Value(func(val []byte) error {
+            return json.Unmarshal(val, &cfg)
+        })
+    })
+    return cfg, err
+}
+
+// SetShardPeers sets the peers that erasure-coded shards are placed on.
+func (s *BadgerStore) SetShardPeers(peers []ShardPeer) {
+    s.peersMu.Lock()
+    defer s.peersMu.Unlock()
+    s.shardPeers = append([]ShardPeer(nil), peers...)
+}
+
+func (s *BadgerStore) currentShardPeers() []ShardPeer {
+    s.peersMu.RLock()
+    defer s.peersMu.RUnlock()
+    return s.shardPeers
+}
+
+func (s *BadgerStore) shardPeer(id string) ShardPeer {
+    for _, p := range s.currentShardPeers() {
+        if p.ID() == id {
+            return p
+        }
+    }
+    return nil
+}
+
+// storeBlocks writes the chunks of a new version to Badger, or as stripes
+// across peers if the bucket is erasure coded.
+func (s *BadgerStore) storeBlocks(ctx context.Context, bucket string, blocks [][]byte) error {
+    cfg, err := s.GetErasureConfiguration(bucket)
+    if err != nil {
+        return err
+    }
+    if !cfg.Enabled() {
+        return s.putBlocks(blocks)
+    }
+    return s.stripeBlocks(ctx, cfg, blocks)
+}
+
+// stripeBlocks erasure codes each block that is not stored yet and places
+// its shards on distinct peers, starting at a peer picked from the block's
+// CID so that stripes spread evenly. Chunks already stored, as a block or a
+// stripe, have their record rewritten instead, as putBlocks does, so that
+// the garbage collector sees them written again.
+func (s *BadgerStore) stripeBlocks(ctx context.Context, cfg ErasureConfiguration, blocks [][]byte) error {
+    peers := s.currentShardPeers()
+    if len(peers) < cfg.DataShards+cfg.ParityShards {
+        return ErrNotEnoughPeers
+    }
+    enc, err := reedsolomon.New(cfg.DataShards, cfg.ParityShards)
+    if err != nil {
+        return err
+    }
+
+    stripes := make(map[cid.Cid][]byte)
+    existing := make(map[string][]byte)
+    for _, block := range blocks {
+        c, err := dag.NewCID(dag.CodecRaw, block)
+        if err != nil {
+            return err
+        }
+        if _, done := stripes[c]; done {
+            continue
+        }
+        key, record, err := s.chunkRecord(c)
+        if err != nil {
+            return err
+        }
+        if key != nil {
+            existing[string(key)] = record
+            continue
+        }
+        // Split may use the block's memory for the data shards.
+        shards, err := enc.Split(append([]byte(nil), block...))
+        if err != nil {
+            return err
+        }
+        if err := enc.Encode(shards); err != nil {
+            return err
+        }
+        stripe := Stripe{DataShards: cfg.DataShards, ParityShards: cfg.ParityShards, Size: len(block)}
+        start := int(crc32.ChecksumIEEE(c.Bytes()) % uint32(len(peers)))
+        for i, shard := range shards {
+            sc, err := dag.NewCID(dag.CodecRaw, shard)
+            if err != nil {
+                return err
+            }
+            peer := peers[(start+i)%len(peers)]
+            if err := peer.PutShard(ctx, sc, shard); err != nil {
+                return fmt.Errorf("placing shard on %s: %w", peer.ID(), err)
+            }
+            stripe.Shards = append(stripe.Shards, ShardRef{CID: sc.String(), Peer: peer.ID()})
+        }
+        if stripes[c], err = json.Marshal(stripe); err != nil {
+            return err
+        }
+    }
+
+    wb := s.db.NewWriteBatch()
+    defer wb.Cancel()
+    cids := make([]cid.Cid, 0, len(stripes))
+    for c, data := range stripes {
+        if err := wb.Set(stripeKey(c), data); err != nil {
+            return err
+        }
+        cids = append(cids, c)
+    }
+    for key, record := range existing {
+        if err := wb.Set([]byte(key), record); err != nil {
+            return err
+        }
+    }
+    if err := wb.Flush(); err != nil {
+        return err
+    }
+    s.announce(cids...)
+    return nil
+}
+
+// chunkRecord returns the key and value of the record a chunk is stored
+// under, as a block or as a stripe, or a nil key if it is not stored.
+func (s *BadgerStore) chunkRecord(c cid.Cid) ([]byte, []byte, error) {
+    var key, record []byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        for _, k := range [][]byte{blockKey(c), stripeKey(c)} {
+            item, err := txn.Get(k)
+            if err == badger.ErrKeyNotFound {
+                continue
+            }
+            if err != nil {
+                return err
+            }
+            key = k
+            record, err = item.ValueCopy(nil)
+            return err
+        }
+        return nil
+    })
+    return key, record, err
+}
+
+func getStripe(txn *badger.Txn, c cid.Cid) (*Stripe, error) {
+    item, err := txn.Get(stripeKey(c))
+    if err != nil {
+        return nil, err
+    }
+    return decodeStripe(item)
+}
+
+func decodeStripe(item *badger.Item) (*Stripe, error) {
+    var stripe Stripe
+    err := item.Value(func(val []byte) error {
+        return json.Unmarshal(val, &stripe)
+    })
+    return &stripe, err
+}
+
+// loadBlock reads a block from Badger, or rebuilds it from its shards if it
+// is erasure coded.
+func (s *BadgerStore) loadBlock(txn *badger.Txn, c cid.Cid) ([]byte, error) {
+    block, err := getBlock(txn, c)
+    if err != badger.ErrKeyNotFound {
+        return block, err
+    }
+    stripe, err := getStripe(txn, c)
+    if err != nil {
+        return nil, err
+    }
+    return s.reconstructBlock(context.Background(), c, stripe)
+}
+
+// fetchShards collects verified shards of a stripe until want of them are
+// present. Missing shards are left nil.
+func (s *BadgerStore) fetchShards(ctx context.Context, stripe *Stripe, want int) ([][]byte, int) {
+    shards := make([][]byte, len(stripe.Shards))
+    got := 0
+    for i, ref := range stripe.Shards {
+        if got == want {
+            break
+        }
+        peer := s.shardPeer(ref.Peer)
+        if peer == nil {
+            continue
+        }
+        sc, err := cid.Decode(ref.CID)
+        if err != nil {
+            continue
+        }
+        data, err := peer.GetShard(ctx, sc)
+        if err != nil || dag.VerifyCID(sc, data) != nil {
+            continue
+        }
+        shards[i] = data
+        got++
+    }
+    return shards, got
+}
+
+func (s *BadgerStore) reconstructBlock(ctx context.Context, c cid.Cid, stripe *Stripe) ([]byte, error) {
+    enc, err := reedsolomon.New(stripe.DataShards, stripe.ParityShards)
+    if err != nil {
+        return nil, err
+    }
+    shards, got := s.fetchShards(ctx, stripe, stripe.DataShards)
+    if got < stripe.DataShards {
+        return nil, fmt.Errorf("%w: %s has %d of %d", ErrShardsUnavailable, c, got, stripe.DataShards)
+    }
+    if err := enc.ReconstructData(shards); err != nil {
+        return nil, err
+    }
+    var buf bytes.Buffer
+    if err := enc.Join(&buf, shards, stripe.Size); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), dag.VerifyCID(c, buf.Bytes())
+}
+
+// HealStripes checks that every shard of every stripe is still held by its
+// peer and regenerates lost shards from the surviving ones onto peers that
+// hold no other shard of the stripe. It returns the number of shards
+// regenerated.
+func (s *BadgerStore) HealStripes(ctx context.Context) (int, error) {
+    stripes := make(map[cid.Cid]*Stripe)
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        for it.Seek(stripePrefix); it.ValidForPrefix(stripePrefix); it.Next() {
+            c, err := cid.Decode(string(bytes.TrimPrefix(it.Item().Key(), stripePrefix)))
+            if err != nil {
+                continue
+            }
+            if stripes[c], err = decodeStripe(it.Item()); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    healed := 0
+    for c, stripe := range stripes {
+        if ctx.Err() != nil {
+            return healed, ctx.Err()
+        }
+        n, err := s.healStripe(ctx, c, stripe)
+        if err != nil {
+            log.Printf("Failed to heal stripe %s: %v", c, err)
+        }
+        healed += n
+    }
+    return healed, nil
+}
+
+func (s *BadgerStore) healStripe(ctx context.Context, c cid.Cid, stripe *Stripe) (int, error) {
+    intact := 0
+    for _, ref := range stripe.Shards {
+        peer := s.shardPeer(ref.Peer)
+        sc, err := cid.Decode(ref.CID)
+        if err != nil {
+            return 0, err
+        }
+        if peer != nil {
+            if ok, err := peer.HasShard(ctx, sc); err == nil && ok {
+                intact++
+            }
+        }
+    }
+    if intact == len(stripe.Shards) {
+        return 0, nil
+    }
+
+    enc, err := reedsolomon.New(stripe.DataShards, stripe.ParityShards)
+    if err != nil {
+        return 0, err
+    }
+    // Shards that fail to download or verify are regenerated as well.
+    shards, got := s.fetchShards(ctx, stripe, len(stripe.Shards))
+    if got < stripe.DataShards {
+        return 0, fmt.Errorf("%w: %d of %d left", ErrShardsUnavailable, got, stripe.DataShards)
+    }
+    lost := make([]bool, len(shards))
+    used := make(map[string]bool)
+    for i, shard := range shards {
+        lost[i] = shard == nil
+        if !lost[i] {
+            used[stripe.Shards[i].Peer] = true
+        }
+    }
+    if err := enc.Reconstruct(shards); err != nil {
+        return 0, err
+    }
+
+    var spare []ShardPeer
+    for _, p := range s.currentShardPeers() {
+        if !used[p.ID()] {
+            spare = append(spare, p)
+        }
+    }
+    // Regenerated shards are identical to the lost ones, CIDs included.
+    healed := 0
+    var shortage error
+    for i := range shards {
+        if !lost[i] {
+            continue
+        }
+        if len(spare) == 0 {
+            shortage = ErrNotEnoughPeers
+            break
+        }
+        peer := spare[0]
+        spare = spare[1:]
+        sc, err := cid.Decode(stripe.Shards[i].CID)
+        if err != nil {
+            return healed, err
+        }
+        if err := peer.PutShard(ctx, sc, shards[i]); err != nil {
+            log.Printf("Failed to place shard %s on %s: %v", sc, peer.ID(), err)
+            continue
+        }
+        stripe.Shards[i].Peer = peer.ID()
+        healed++
+    }
+    if healed == 0 {
+        return 0, shortage
+    }
+
+    data, err := json.Marshal(stripe)
+    if err != nil {
+        return 0, err
+    }
+    err = s.db.Update(func(txn *badger.Txn) error {
+        // The chunk may have been collected meanwhile.
+        if _, err := txn.Get(stripeKey(c)); err != nil {
+            return err
+        }
+        return txn.Set(stripeKey(c), data)
+    })
+    if err != nil && err != badger.ErrKeyNotFound {
+        return 0, err
+    }
+    return healed, shortage
+}
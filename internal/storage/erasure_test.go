@@ -0,0 +1,181 @@
+package storage
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "sync"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/ipfs/go-cid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+type memoryPeer struct {
+    id     string
+    mu     sync.Mutex
+    shards map[cid.Cid][]byte
+}
+
+func newMemoryPeers(n int) []*memoryPeer {
+    var peers []*memoryPeer
+    for i := 0; i < n; i++ {
+        peers = append(peers, &memoryPeer{id: fmt.Sprint("peer", i), shards: make(map[cid.Cid][]byte)})
+    }
+    return peers
+}
+
+func (p *memoryPeer) ID() string { return p.id }
+
+func (p *memoryPeer) PutShard(ctx context.Context, c cid.Cid, data []byte) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.shards[c] = append([]byte(nil), data...)
+    return nil
+}
+
+func (p *memoryPeer) GetShard(ctx context.Context, c cid.Cid) ([]byte, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    data, ok := p.shards[c]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    return data, nil
+}
+
+func (p *memoryPeer) HasShard(ctx context.Context, c cid.Cid) (bool, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    _, ok := p.shards[c]
+    return ok, nil
+}
+
+func (p *memoryPeer) DeleteShard(ctx context.Context, c cid.Cid) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    delete(p.shards, c)
+    return nil
+}
+
+func (p *memoryPeer) wipe() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.shards = make(map[cid.Cid][]byte)
+}
+
+func TestErasureCoding_ReconstructsAndHeals(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    ctx := context.Background()
+
+    peers := newMemoryPeers(6)
+    var shardPeers []ShardPeer
+    for _, p := range peers {
+        shardPeers = append(shardPeers, p)
+    }
+    store.SetShardPeers(shardPeers[:4])
+    require.NoError(t, store.PutErasureConfiguration("ec", ErasureConfiguration{DataShards: 3, ParityShards: 2}))
+    assert.ErrorIs(t, store.PutObject("ec", "k", []byte("data")), ErrNotEnoughPeers)
+    store.SetShardPeers(shardPeers)
+
+    data := make([]byte, 300<<10)
+    rand.New(rand.NewSource(1)).Read(data)
+    require.NoError(t, store.PutObject("ec", "k", data))
+
+    // The chunks live only as shards.
+    _, _, blocks, err := store.encodeObject(data)
+    require.NoError(t, err)
+    c, err := dag.NewCID(dag.CodecRaw, blocks[0])
+    require.NoError(t, err)
+    ok, err := store.HasBlock(ctx, c)
+    require.NoError(t, err)
+    assert.False(t, ok)
+    shards := 0
+    for _, p := range peers {
+        shards += len(p.shards)
+    }
+    assert.Equal(t, 5*len(blocks), shards)
+
+    // Any three shards of each stripe are enough.
+    peers[0].wipe()
+    peers[1].wipe()
+    got, err := store.GetObject("ec", "k")
+    require.NoError(t, err)
+    assert.Equal(t, data, got)
+
+    healed, err := store.HealStripes(ctx)
+    require.NoError(t, err)
+    assert.Positive(t, healed)
+    healed, err = store.HealStripes(ctx)
+    require.NoError(t, err)
+    assert.Zero(t, healed)
+
+    // With the lost shards regenerated, two more peers can fail.
+    peers[2].wipe()
+    peers[3].wipe()
+    got, err = store.GetObject("ec", "k")
+    require.NoError(t, err)
+    assert.Equal(t, data, got)
+
+    // Two peers hold at most two shards of any stripe.
+    peers[4].wipe()
+    peers[5].wipe()
+    _, err = store.GetObject("ec", "k")
+    assert.ErrorIs(t, err, ErrShardsUnavailable)
+}
+
+func TestErasureCoding_GarbageCollectsShards(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    ctx := context.Background()
+
+    peers := newMemoryPeers(3)
+    store.SetShardPeers([]ShardPeer{peers[0], peers[1], peers[2]})
+    require.NoError(t, store.PutErasureConfiguration("ec", ErasureConfiguration{DataShards: 2, ParityShards: 1}))
+    require.NoError(t, store.PutObject("ec", "k", []byte("erasure coded")))
+    require.NoError(t, store.DeleteObject("ec", "k", false))
+
+    _, err = store.CollectGarbage(ctx, GCOptions{})
+    require.NoError(t, err)
+    report, err := store.CollectGarbage(ctx, GCOptions{})
+    require.NoError(t, err)
+    assert.Equal(t, 2, report.DeletedBlocks, "the stripe and the DAG node")
+    for _, p := range peers {
+        assert.Empty(t, p.shards)
+    }
+}
+
+func TestErasureCoding_GracePeriodRestartsOnRewrite(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    ctx := context.Background()
+
+    peers := newMemoryPeers(3)
+    store.SetShardPeers([]ShardPeer{peers[0], peers[1], peers[2]})
+    cfg := ErasureConfiguration{DataShards: 2, ParityShards: 1}
+    require.NoError(t, store.PutErasureConfiguration("ec", cfg))
+    require.NoError(t, store.PutObject("ec", "k", []byte("erasure coded")))
+    require.NoError(t, store.DeleteObject("ec", "k", false))
+    _, err = store.CollectGarbage(ctx, GCOptions{})
+    require.NoError(t, err)
+
+    // An upload of the same data finds the stripe already stored.
+    _, _, blocks, err := store.encodeObject([]byte("erasure coded"))
+    require.NoError(t, err)
+    require.NoError(t, store.stripeBlocks(ctx, cfg, blocks))
+
+    report, err := store.CollectGarbage(ctx, GCOptions{})
+    require.NoError(t, err)
+    assert.Equal(t, 1, report.DeletedBlocks, "only the DAG node goes")
+    c, err := dag.NewCID(dag.CodecRaw, blocks[0])
+    require.NoError(t, err)
+    data, err := store.GetBlock(ctx, c)
+    require.NoError(t, err)
+    assert.Equal(t, blocks[0], data)
+}
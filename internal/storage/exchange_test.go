@@ -0,0 +1,305 @@
+package storage
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func startStoreNode(t *testing.T, ctx context.Context, store *BadgerStore, bootstrap ...string) (*p2p.Node, *p2p.Exchange) {
+    t.Helper()
+    node, err := p2p.NewNode(ctx, p2p.Config{
+        Identity:       store.PeerKey(),
+        ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+        BootstrapPeers: bootstrap,
+    })
+    require.NoError(t, err)
+    t.Cleanup(func() { node.Close() })
+    exchange := p2p.NewExchange(node, store)
+    exchange.SetMembers(func(peer.ID) bool { return true })
+    store.SetBlockExchange(exchange)
+    store.SetDHT(p2p.NewDHTOperations(node.DHT))
+    return node, exchange
+}
+
+func TestScrub_RepairsOverExchange(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    local, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer local.Close()
+    remote, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer remote.Close()
+    require.NoError(t, remote.SetClusterKey(local.ClusterKey()))
+
+    localNode, _ := startStoreNode(t, ctx, local)
+    remoteNode, _ := startStoreNode(t, ctx, remote, localNode.Addrs()...)
+    require.Eventually(t, func() bool {
+        return localNode.DHT.RoutingTable().Size() == 1
+    }, 10*time.Second, 50*time.Millisecond)
+
+    // Convergent encryption under the shared cluster key gives both nodes
+    // the same blocks.
+    data := []byte("stored on two nodes")
+    require.NoError(t, local.PutObject("b", "k", data))
+    require.NoError(t, remote.PutObject("b", "k", data))
+    _, _, blocks, err := local.encodeObject(data)
+    require.NoError(t, err)
+    c, err := dag.NewCID(dag.CodecRaw, blocks[0])
+    require.NoError(t, err)
+    require.NoError(t, remoteNode.DHT.Provide(ctx, c, true))
+
+    require.NoError(t, local.db.Update(func(txn *badger.Txn) error {
+        return txn.Delete(blockKey(c))
+    }))
+    report, err := local.Scrub(ctx, ScrubOptions{})
+    require.NoError(t, err)
+    assert.Equal(t, 1, report.Missing)
+    assert.Equal(t, 1, report.Repaired)
+
+    got, err := local.GetObject("b", "k")
+    require.NoError(t, err)
+    assert.Equal(t, data, got)
+}
+
+// startPlacedCluster starts n connected stores that place blocks on each
+// other and accept each other's pushes.
+func startPlacedCluster(t *testing.T, ctx context.Context, n int) ([]*BadgerStore, []*p2p.Exchange, *p2p.Placement) {
+    t.Helper()
+    var (
+        stores    []*BadgerStore
+        nodes     []*p2p.Node
+        exchanges []*p2p.Exchange
+        peers     []p2p.PlacementPeer
+        bootstrap []string
+    )
+    placement := p2p.NewPlacement(nil)
+    for i := 0; i < n; i++ {
+        store, err := NewBadgerStore(t.TempDir())
+        require.NoError(t, err)
+        t.Cleanup(func() { store.Close() })
+        node, exchange := startStoreNode(t, ctx, store, bootstrap...)
+        if i == 0 {
+            bootstrap = node.Addrs()
+        }
+        exchange.SetPlacement(placement)
+        stores = append(stores, store)
+        nodes = append(nodes, node)
+        exchanges = append(exchanges, exchange)
+        peers = append(peers, p2p.PlacementPeer{ID: node.Host.ID(), Capacity: 1})
+    }
+    placement.SetPeers(peers)
+    for _, node := range nodes {
+        node := node
+        require.Eventually(t, func() bool {
+            return node.DHT.RoutingTable().Size() == len(nodes)-1
+        }, 10*time.Second, 50*time.Millisecond)
+    }
+    return stores, exchanges, placement
+}
+
+// assertPlaced checks that every block of an object is on its placement
+// targets.
+func assertPlaced(t *testing.T, ctx context.Context, stores []*BadgerStore, exchanges []*p2p.Exchange, placement *p2p.Placement, bucket, key string) {
+    t.Helper()
+    cids, err := stores[0].currentBlocks(bucket, key)
+    require.NoError(t, err)
+    require.Greater(t, len(cids), 3)
+    index := make(map[peer.ID]int)
+    for i, e := range exchanges {
+        index[e.ID()] = i
+    }
+    for _, c := range cids {
+        for _, target := range placement.Place(c, MinReplicas) {
+            ok, err := stores[index[target]].HasBlock(ctx, c)
+            require.NoError(t, err)
+            assert.True(t, ok, "block %s on its target", c)
+        }
+    }
+}
+
+func TestReplicator_PlacesBlocksOnTargets(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    stores, exchanges, placement := startPlacedCluster(t, ctx, 5)
+
+    data := make([]byte, 600<<10)
+    for i := range data {
+        data[i] = byte(i * 13)
+    }
+    require.NoError(t, stores[0].PutObject("b", "k", data))
+    replicator := NewReplicator(stores[0], nil)
+    replicator.UsePlacement(placement, exchanges[0])
+    require.NoError(t, replicator.EnsureReplicas(ctx))
+    assertPlaced(t, ctx, stores, exchanges, placement, "b", "k")
+
+    // Placement finds the blocks without any provider records.
+    cids, err := stores[0].currentBlocks("b", "k")
+    require.NoError(t, err)
+    blocks, err := exchanges[4].GetBlocks(ctx, cids)
+    require.NoError(t, err)
+    assert.Len(t, blocks, len(cids))
+
+    // Targets keep the blocks pinned for the owner, until placement moves
+    // them elsewhere or the owner collects them.
+    owner := exchanges[0].ID()
+    pinned := func(store *BadgerStore, c cid.Cid) bool {
+        err := store.db.View(func(txn *badger.Txn) error {
+            _, err := txn.Get(pinKey(c, owner))
+            return err
+        })
+        return err == nil
+    }
+    pinnedOn := func(i int) int {
+        n := 0
+        for _, c := range cids {
+            if pinned(stores[i], c) {
+                n++
+            }
+        }
+        return n
+    }
+    require.Positive(t, pinnedOn(1))
+    var peers []p2p.PlacementPeer
+    for _, e := range exchanges {
+        if e != exchanges[1] {
+            peers = append(peers, p2p.PlacementPeer{ID: e.ID(), Capacity: 1})
+        }
+    }
+    placement.SetPeers(peers)
+    require.NoError(t, replicator.EnsureReplicas(ctx))
+    assert.Zero(t, pinnedOn(1))
+    assertPlaced(t, ctx, stores, exchanges, placement, "b", "k")
+
+    require.NoError(t, stores[0].DeleteObject("b", "k", false))
+    for i := 0; i < 2; i++ {
+        _, err := stores[0].CollectGarbage(ctx, GCOptions{})
+        require.NoError(t, err)
+    }
+    require.NoError(t, replicator.EnsureReplicas(ctx))
+    for i := range stores[2:] {
+        assert.Zero(t, pinnedOn(i+2))
+    }
+}
+
+func TestScrub_ReplicatesToPlacementTargets(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    stores, exchanges, placement := startPlacedCluster(t, ctx, 4)
+    data := make([]byte, 600<<10)
+    for i := range data {
+        data[i] = byte(i * 11)
+    }
+    require.NoError(t, stores[0].PutObject("b", "k", data))
+    replicator := NewReplicator(stores[0], stores[0].dht)
+    replicator.UsePlacement(placement, exchanges[0])
+    stores[0].SetReplicator(replicator)
+
+    // No node announces the object, so the DHT counts too few replicas.
+    report, err := stores[0].Scrub(ctx, ScrubOptions{})
+    require.NoError(t, err)
+    assert.Equal(t, 1, report.UnderReplicated)
+    assert.Positive(t, report.Replicated)
+    assertPlaced(t, ctx, stores, exchanges, placement, "b", "k")
+}
+
+func TestBadgerStore_PeerKeyPersists(t *testing.T) {
+    dir := t.TempDir()
+    store, err := NewBadgerStore(dir)
+    require.NoError(t, err)
+    id, err := peer.IDFromPrivateKey(store.PeerKey())
+    require.NoError(t, err)
+    require.NoError(t, store.Close())
+
+    store, err = NewBadgerStore(dir)
+    require.NoError(t, err)
+    defer store.Close()
+    reopened, err := peer.IDFromPrivateKey(store.PeerKey())
+    require.NoError(t, err)
+    assert.Equal(t, id, reopened)
+}
+
+func TestErasure_StripesOverExchange(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    stores, exchanges, _ := startPlacedCluster(t, ctx, 4)
+    var ids []peer.ID
+    for _, e := range exchanges[1:] {
+        ids = append(ids, e.ID())
+    }
+    local := stores[0]
+    local.SetShardPeers(ExchangePeers(exchanges[0], ids))
+    require.NoError(t, local.PutErasureConfiguration("ec", ErasureConfiguration{DataShards: 2, ParityShards: 1}))
+
+    data := make([]byte, 300<<10)
+    for i := range data {
+        data[i] = byte(i * 7)
+    }
+    require.NoError(t, local.PutObject("ec", "k", data))
+    got, err := local.GetObject("ec", "k")
+    require.NoError(t, err)
+    assert.Equal(t, data, got)
+
+    var shards []ShardRef
+    require.NoError(t, local.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        for it.Seek(stripePrefix); it.ValidForPrefix(stripePrefix); it.Next() {
+            stripe, err := decodeStripe(it.Item())
+            if err != nil {
+                return err
+            }
+            shards = append(shards, stripe.Shards...)
+        }
+        return nil
+    }))
+    require.NotEmpty(t, shards)
+    holders := make(map[string]*BadgerStore)
+    for i, e := range exchanges[1:] {
+        holders[e.ID().String()] = stores[i+1]
+    }
+    held := func() int {
+        n := 0
+        for _, ref := range shards {
+            sc, err := cid.Decode(ref.CID)
+            require.NoError(t, err)
+            ok, err := holders[ref.Peer].HasBlock(ctx, sc)
+            require.NoError(t, err)
+            if ok {
+                n++
+            }
+        }
+        return n
+    }
+    collect := func(stores ...*BadgerStore) {
+        // The first run marks candidates, the second deletes them.
+        for i := 0; i < 2; i++ {
+            for _, store := range stores {
+                _, err := store.CollectGarbage(ctx, GCOptions{})
+                require.NoError(t, err)
+            }
+        }
+    }
+
+    // Peers keep the pinned shards through their own collection, until
+    // the owner deletes the object and releases them.
+    collect(stores[1:]...)
+    assert.Equal(t, len(shards), held())
+    require.NoError(t, local.DeleteObject("ec", "k", false))
+    collect(local)
+    collect(stores[1:]...)
+    assert.Zero(t, held())
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log"
+    "time"
+
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+)
+
+// GCOptions controls a garbage collection run. A block is only deleted once
+// it has been unreferenced for GracePeriod, so blocks written by uploads that
+// have not recorded their version yet survive. Deletes are made BatchSize at
+// a time with BatchDelay between batches.
+type GCOptions struct {
+    GracePeriod time.Duration
+    BatchSize   int
+    BatchDelay  time.Duration
+}
+
+var DefaultGCOptions = GCOptions{
+    GracePeriod: time.Hour,
+    BatchSize:   256,
+    BatchDelay:  50 * time.Millisecond,
+}
+
+// GCReport summarizes a garbage collection run. Pending counts unreferenced
+// blocks still inside the grace period.
+type GCReport struct {
+    StartedAt      time.Time `json:"started_at"`
+    FinishedAt     time.Time `json:"finished_at"`
+    LiveBlocks     int       `json:"live_blocks"`
+    PendingBlocks  int       `json:"pending_blocks"`
+    DeletedBlocks  int       `json:"deleted_blocks"`
+    ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+// gcCandidate records when a block was first seen unreferenced, and the
+// Badger version it had then. A block rewritten since, as happens when a new
+// upload shares it, starts its grace period over.
+type gcCandidate struct {
+    Since   time.Time `json:"since"`
+    Version uint64    `json:"version"`
+}
+
+type gcVictim struct {
+    key     []byte
+    version uint64
+}
+
+func gcCandidateKey(blockKey []byte) []byte {
+    return append([]byte("gc/"), blockKey...)
+}
+
+// CollectGarbage deletes blocks that no object version, trash entry or pin
+// references. Multipart parts are not blocks and are never touched. It
+// marks every node and chunk reachable from the manifests, then sweeps the
+// block store: unreferenced blocks become candidates and are deleted on a
+// later run once the grace period has passed. Stripes of erasure-coded
+// chunks are collected the same way, and their shards removed from peers.
+func (s *BadgerStore) CollectGarbage(ctx context.Context, opts GCOptions) (GCReport, error) {
+    report := GCReport{StartedAt: time.Now().UTC()}
+    if opts.BatchSize <= 0 {
+        opts.BatchSize = DefaultGCOptions.BatchSize
+    }
+
+    live := make(map[string]bool)
+    var markTs uint64
+    err := s.db.View(func(txn *badger.Txn) error {
+        markTs = txn.ReadTs()
+        return s.markLive(txn, live)
+    })
+    if err != nil {
+        return report, err
+    }
+    report.LiveBlocks = len(live)
+
+    victims, err := s.sweepCandidates(live, report.StartedAt, opts.GracePeriod, &report)
+    if err != nil {
+        return report, err
+    }
+
+    for len(victims) > 0 {
+        if ctx.Err() != nil {
+            return report, ctx.Err()
+        }
+        batch := victims[:min(opts.BatchSize, len(victims))]
+        victims = victims[len(batch):]
+        var released []*Stripe
+        err := s.db.Update(func(txn *badger.Txn) error {
+            for _, v := range batch {
+                item, err := txn.Get(v.key)
+                if err == badger.ErrKeyNotFound {
+                    continue
+                }
+                if err != nil {
+                    return err
+                }
+                // Rewritten since the mark: an upload may be about to use it.
+                if item.Version() != v.version || item.Version() > markTs {
+                    continue
+                }
+                size := int64(item.ValueSize())
+                if bytes.HasPrefix(v.key, stripePrefix) {
+                    stripe, err := decodeStripe(item)
+                    if err != nil {
+                        return err
+                    }
+                    released = append(released, stripe)
+                    size = stripe.storedBytes()
+                }
+                if err := txn.Delete(v.key); err != nil {
+                    return err
+                }
+                if err := txn.Delete(gcCandidateKey(v.key)); err != nil {
+                    return err
+                }
+                report.DeletedBlocks++
+                report.ReclaimedBytes += size
+            }
+            return nil
+        })
+        if err != nil {
+            return report, err
+        }
+        s.releaseShards(ctx, released)
+        if len(victims) > 0 && opts.BatchDelay > 0 {
+            select {
+            case <-ctx.Done():
+                return report, ctx.Err()
+            case <-time.After(opts.BatchDelay):
+            }
+        }
+    }
+    report.FinishedAt = time.Now().UTC()
+    return report, nil
+}
+
+// markLive adds the block keys reachable from every stored version to live.
+// Chunks of archived versions live in the cold tier, so only their nodes
+// are marked.
+func (s *BadgerStore) markLive(txn *badger.Txn, live map[string]bool) error {
+    it := txn.NewIterator(badger.DefaultIteratorOptions)
+    defer it.Close()
+
+    prefix := []byte("objmeta/")
+    for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+        var info ObjectInfo
+        err := it.Item().Value(func(val []byte) error {
+            return json.Unmarshal(val, &info)
+        })
+        if err != nil {
+            return err
+        }
+        for i, v := range info.Versions {
+            if v.IsDeleteMarker {
+                continue
+            }
+            dataKey, keyKey := versionDataKeys(&info, i)
+            if err := s.markVersion(txn, v, dataKey, keyKey, live); err != nil {
+                return err
+            }
+        }
+    }
+
+    prefix = []byte("trash/")
+    for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+        var entry TrashEntry
+        err := it.Item().Value(func(val []byte) error {
+            return json.Unmarshal(val, &entry)
+        })
+        if err != nil {
+            return err
+        }
+        dataKey, keyKey := trashDataKeys(entry.Bucket, entry.Key, entry.ID)
+        if err := s.markVersion(txn, entry.Version, dataKey, keyKey, live); err != nil {
+            return err
+        }
+    }
+
+    // Blocks that peers pinned here are theirs to release.
+    prefix = []byte("pin/")
+    for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+        err := it.Item().Value(func(val []byte) error {
+            c, err := cid.Cast(val)
+            if err != nil {
+                return err
+            }
+            live[string(blockKey(c))] = true
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *BadgerStore) markVersion(txn *badger.Txn, v ObjectVersion, dataKey, keyKey []byte, live map[string]bool) error {
+    data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+    if err != nil {
+        return err
+    }
+    m, err := s.openManifest(data, aesKey)
+    if err != nil {
+        return err
+    }
+    if m.DAG == "" {
+        return nil
+    }
+    root, err := cid.Decode(m.DAG)
+    if err != nil {
+        return err
+    }
+    chunks := inBadger(v)
+    return walkDAG(txn, root, func(c cid.Cid, data []byte) error {
+        if data != nil || chunks {
+            live[string(blockKey(c))] = true
+        }
+        if data == nil && chunks {
+            live[string(stripeKey(c))] = true
+        }
+        return nil
+    })
+}
+
+// releaseShards deletes the shards of collected stripes from their peers.
+// A shard that cannot be deleted now is left behind on its peer.
+func (s *BadgerStore) releaseShards(ctx context.Context, stripes []*Stripe) {
+    for _, stripe := range stripes {
+        for _, ref := range stripe.Shards {
+            peer := s.shardPeer(ref.Peer)
+            sc, err := cid.Decode(ref.CID)
+            if peer == nil || err != nil {
+                continue
+            }
+            if err := peer.DeleteShard(ctx, sc); err != nil {
+                log.Printf("Failed to delete shard %s from %s: %v", sc, ref.Peer, err)
+            }
+        }
+    }
+}
+
+// sweepCandidates walks every key under the block and stripe prefixes;
+// object data lives under dataPrefix, so each of them is a block or stripe
+// record named by its CID. It records newly unreferenced blocks as
+// candidates, forgets candidates that are referenced again and returns the
+// blocks whose grace period is over.
+func (s *BadgerStore) sweepCandidates(live map[string]bool, now time.Time, grace time.Duration, report *GCReport) ([]gcVictim, error) {
+    candidates := make(map[string]gcCandidate)
+    var victims []gcVictim
+    fresh := make(map[string][]byte)
+    var forgotten [][]byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := gcCandidateKey(nil)
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            var c gcCandidate
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &c)
+            })
+            if err != nil {
+                return err
+            }
+            candidates[string(bytes.TrimPrefix(it.Item().Key(), prefix))] = c
+        }
+
+        keys := badger.DefaultIteratorOptions
+        keys.PrefetchValues = false
+        blocks := txn.NewIterator(keys)
+        defer blocks.Close()
+
+        for _, prefix := range []string{"block/", string(stripePrefix)} {
+            for blocks.Seek([]byte(prefix)); blocks.ValidForPrefix([]byte(prefix)); blocks.Next() {
+                item := blocks.Item()
+                key := string(item.Key())
+                c, pending := candidates[key]
+                delete(candidates, key)
+                switch {
+                case live[key]:
+                    if pending {
+                        forgotten = append(forgotten, []byte(key))
+                    }
+                case pending && c.Version == item.Version() && now.Sub(c.Since) >= grace:
+                    victims = append(victims, gcVictim{key: []byte(key), version: item.Version()})
+                default:
+                    report.PendingBlocks++
+                    if !pending || c.Version != item.Version() {
+                        data, err := json.Marshal(gcCandidate{Since: now, Version: item.Version()})
+                        if err != nil {
+                            return err
+                        }
+                        fresh[key] = data
+                    }
+                }
+            }
+        }
+        // Candidates whose blocks are gone need no tracking.
+        for key := range candidates {
+            forgotten = append(forgotten, []byte(key))
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    wb := s.db.NewWriteBatch()
+    defer wb.Cancel()
+    for key, data := range fresh {
+        if err := wb.Set(gcCandidateKey([]byte(key)), data); err != nil {
+            return nil, err
+        }
+    }
+    for _, key := range forgotten {
+        if err := wb.Delete(gcCandidateKey(key)); err != nil {
+            return nil, err
+        }
+    }
+    return victims, wb.Flush()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+    "context"
+    "math/rand"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage_KeepsSharedAndTrashedBlocks(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    ctx := context.Background()
+    opts := GCOptions{BatchSize: 2}
+
+    shared := make([]byte, 300<<10)
+    rand.New(rand.NewSource(1)).Read(shared)
+    unique := make([]byte, 300<<10)
+    rand.New(rand.NewSource(2)).Read(unique)
+    require.NoError(t, store.PutObject("b", "one", shared))
+    require.NoError(t, store.PutObject("b", "two", shared))
+    require.NoError(t, store.PutObject("b", "gone", unique))
+    require.NoError(t, store.PutObject("b", "trashed", []byte("kept in the trash")))
+    require.NoError(t, store.PutTrashConfiguration("b", TrashConfiguration{RetentionDays: 1}))
+    require.NoError(t, store.SoftDeleteObject(ctx, "b", "trashed", "tester", false))
+
+    report, err := store.CollectGarbage(ctx, opts)
+    require.NoError(t, err)
+    assert.Zero(t, report.PendingBlocks)
+
+    require.NoError(t, store.DeleteObject("b", "gone", false))
+    require.NoError(t, store.DeleteObject("b", "one", false))
+
+    // The first run only notes the orphans; the second deletes them.
+    report, err = store.CollectGarbage(ctx, opts)
+    require.NoError(t, err)
+    assert.Positive(t, report.PendingBlocks)
+    assert.Zero(t, report.DeletedBlocks)
+    pending := report.PendingBlocks
+
+    report, err = store.CollectGarbage(ctx, opts)
+    require.NoError(t, err)
+    assert.Equal(t, pending, report.DeletedBlocks)
+    assert.Greater(t, report.ReclaimedBytes, int64(len(unique)))
+    assert.Zero(t, report.PendingBlocks)
+
+    got, err := store.GetObject("b", "two")
+    require.NoError(t, err)
+    assert.Equal(t, shared, got)
+    require.NoError(t, store.RestoreObject(ctx, "b", "trashed", ""))
+    got, err = store.GetObject("b", "trashed")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("kept in the trash"), got)
+}
+
+func TestCollectGarbage_GracePeriodRestartsOnRewrite(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    ctx := context.Background()
+
+    require.NoError(t, store.PutObject("b", "k", []byte("data")))
+    require.NoError(t, store.DeleteObject("b", "k", false))
+    _, err = store.CollectGarbage(ctx, GCOptions{})
+    require.NoError(t, err)
+
+    // An upload writes the same block again before recording its version.
+    manifest, _, blocks, err := store.encodeObject([]byte("data"))
+    require.NoError(t, err)
+    require.Len(t, manifest.Chunks, 1)
+    require.NoError(t, store.putBlocks(blocks))
+
+    report, err := store.CollectGarbage(ctx, GCOptions{})
+    require.NoError(t, err)
+    assert.Equal(t, 1, report.DeletedBlocks, "only the DAG node goes")
+    c, err := dag.NewCID(dag.CodecRaw, blocks[0])
+    require.NoError(t, err)
+    ok, err := store.HasBlock(ctx, c)
+    require.NoError(t, err)
+    assert.True(t, ok)
+}
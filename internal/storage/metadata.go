@@ -0,0 +1,178 @@
+package storage
+
+import (
+    "encoding/json"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ObjectVersion describes one version of an object. TierKey names the cold
+// tier pack holding the blocks of a version whose storage class moved them
+// out of Badger.
+type ObjectVersion struct {
+    VersionID      string            `json:"version_id"`
+    Size           int64             `json:"size"`
+    LastModified   time.Time         `json:"last_modified"`
+    IsDeleteMarker bool              `json:"is_delete_marker,omitempty"`
+    Tags           map[string]string `json:"tags,omitempty"`
+    StorageClass   string            `json:"storage_class,omitempty"`
+    TierKey        string            `json:"tier_key,omitempty"`
+    RestoredUntil  *time.Time        `json:"restored_until,omitempty"`
+    // Stamp is when Origin, the node the version was written on, wrote it
+    // by its hybrid logical clock. Supersedes is the stamp of the version
+    // that was current there at the time. Versions written before stamps
+    // were kept have neither.
+    Stamp      hlc.Timestamp `json:"stamp"`
+    Origin     peer.ID       `json:"origin,omitempty"`
+    Supersedes hlc.Timestamp `json:"supersedes"`
+}
+
+// stampVersion stamps v as written now on this node, over the version
+// stamped supersedes.
+func (s *BadgerStore) stampVersion(v *ObjectVersion, supersedes hlc.Timestamp) {
+    v.Stamp, v.Origin, v.Supersedes = s.clock.Now(), s.NodeID(), supersedes
+}
+
+// latestStamp returns the stamp of the current version of info, zero if it
+// has none.
+func latestStamp(info *ObjectInfo) hlc.Timestamp {
+    latest, _ := info.Latest()
+    return latest.Stamp
+}
+
+// ObjectInfo lists the versions stored under a key, newest first. The
+// encrypted manifest of the newest version lives under currentDataKeys, those
+// of older versions under noncurrentDataKeys.
+type ObjectInfo struct {
+    Bucket   string          `json:"bucket"`
+    Key      string          `json:"key"`
+    Versions []ObjectVersion `json:"versions"`
+}
+
+// Latest returns the current version of the object, which may be a delete
+// marker.
+func (o *ObjectInfo) Latest() (ObjectVersion, bool) {
+    if len(o.Versions) == 0 {
+        return ObjectVersion{}, false
+    }
+    return o.Versions[0], true
+}
+
+func (o *ObjectInfo) find(versionID string) int {
+    versionID = versionOrNull(versionID)
+    for i, v := range o.Versions {
+        if v.VersionID == versionID {
+            return i
+        }
+    }
+    return -1
+}
+
+func objectMetaKey(bucket, key string) []byte {
+    return []byte("objmeta/" + bucket + "/" + key)
+}
+
+func getObjectInfo(txn *badger.Txn, bucket, key string) (*ObjectInfo, error) {
+    info := &ObjectInfo{Bucket: bucket, Key: key}
+    item, err := txn.Get(objectMetaKey(bucket, key))
+    if err == badger.ErrKeyNotFound {
+        return info, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    err = item.Value(func(val []byte) error {
+        return json.Unmarshal(val, info)
+    })
+    return info, err
+}
+
+// putObjectInfo stores info, or removes the object and leaves a tombstone
+// if no versions are left.
+func (s *BadgerStore) putObjectInfo(txn *badger.Txn, info *ObjectInfo) error {
+    if len(info.Versions) == 0 {
+        return s.removeObjectInfo(txn, info.Bucket, info.Key)
+    }
+    data, err := json.Marshal(info)
+    if err != nil {
+        return err
+    }
+    if err := txn.Delete(tombstoneKey(info.Bucket, info.Key)); err != nil {
+        return err
+    }
+    return txn.Set(objectMetaKey(info.Bucket, info.Key), data)
+}
+
+func (s *BadgerStore) GetObjectInfo(bucket, key string) (*ObjectInfo, error) {
+    var info *ObjectInfo
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        info, err = getObjectInfo(txn, bucket, key)
+        if err == nil && len(info.Versions) == 0 {
+            err = ErrNotFound
+        }
+        return err
+    })
+    return info, err
+}
+
+// ListObjects returns up to limit keys of a bucket in lexical order, starting
+// after startAfter. A limit of zero lists every key.
+func (s *BadgerStore) ListObjects(bucket, startAfter string, limit int) ([]ObjectInfo, error) {
+    var infos []ObjectInfo
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := objectMetaKey(bucket, "")
+        start := prefix
+        if startAfter != "" {
+            start = append(objectMetaKey(bucket, startAfter), 0)
+        }
+        for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+            if limit > 0 && len(infos) >= limit {
+                break
+            }
+            var info ObjectInfo
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &info)
+            })
+            if err != nil {
+                return err
+            }
+            infos = append(infos, info)
+        }
+        return nil
+    })
+    return infos, err
+}
+
+// PutObjectTagging replaces the tags of an object version.
+func (s *BadgerStore) PutObjectTagging(bucket, key, versionID string, tags map[string]string) error {
+    return s.updateVersion(bucket, key, versionID, "tagging", func(v *ObjectVersion) {
+        v.Tags = tags
+    })
+}
+
+// updateVersion changes a version of an object in fn and logs the change
+// with detail.
+func (s *BadgerStore) updateVersion(bucket, key, versionID, detail string, fn func(*ObjectVersion)) error {
+    e := translog.Entry{Type: translog.TypeVersion, Bucket: bucket, Key: key, Detail: detail}
+    return s.update(&e, func(txn *badger.Txn) error {
+        versionID, err := resolveVersion(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        fn(&info.Versions[info.find(versionID)])
+        e.VersionID = versionID
+        return s.putObjectInfo(txn, info)
+    })
+}
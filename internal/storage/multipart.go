@@ -0,0 +1,222 @@
+package storage
+
+import (
+    "bytes"
+    "crypto/md5"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/dgraph-io/badger/v4"
+)
+
+var ErrNoSuchUpload = errors.New("multipart upload does not exist")
+
+// MultipartUpload is an upload session whose parts are kept encrypted until
+// the upload is completed or aborted.
+type MultipartUpload struct {
+    UploadID     string     `json:"upload_id"`
+    Bucket       string     `json:"bucket"`
+    Key          string     `json:"key"`
+    Initiated    time.Time  `json:"initiated"`
+    EncryptedKey []byte     `json:"encrypted_key"`
+    Options      PutOptions `json:"options"`
+}
+
+func multipartKey(bucket, uploadID string) []byte {
+    return []byte("multipart/" + bucket + "/" + uploadID)
+}
+
+func multipartPartPrefix(bucket, uploadID string) []byte {
+    return []byte("mpart/" + bucket + "/" + uploadID + "/")
+}
+
+func multipartPartKey(bucket, uploadID string, partNumber int) []byte {
+    return append(multipartPartPrefix(bucket, uploadID), fmt.Sprintf("%05d", partNumber)...)
+}
+
+func (s *BadgerStore) CreateMultipartUpload(bucket, key string, opts PutOptions) (MultipartUpload, error) {
+    uploadID, err := newVersionID()
+    if err != nil {
+        return MultipartUpload{}, err
+    }
+    aesKey := make([]byte, 32)
+    if _, err := rand.Read(aesKey); err != nil {
+        return MultipartUpload{}, err
+    }
+    encryptedKey, err := s.keyManager.EncryptAESKey(s.keyManager.GetPublicKey(), aesKey)
+    if err != nil {
+        return MultipartUpload{}, err
+    }
+    upload := MultipartUpload{
+        UploadID:     uploadID,
+        Bucket:       bucket,
+        Key:          key,
+        Initiated:    time.Now().UTC(),
+        EncryptedKey: encryptedKey,
+        Options:      opts,
+    }
+    data, err := json.Marshal(upload)
+    if err != nil {
+        return MultipartUpload{}, err
+    }
+    err = s.db.Update(func(txn *badger.Txn) error {
+        return txn.Set(multipartKey(bucket, uploadID), data)
+    })
+    return upload, err
+}
+
+func getMultipartUpload(txn *badger.Txn, bucket, uploadID string) (MultipartUpload, error) {
+    var upload MultipartUpload
+    item, err := txn.Get(multipartKey(bucket, uploadID))
+    if err == badger.ErrKeyNotFound {
+        return upload, ErrNoSuchUpload
+    }
+    if err != nil {
+        return upload, err
+    }
+    err = item.Value(func(val []byte) error {
+        return json.Unmarshal(val, &upload)
+    })
+    return upload, err
+}
+
+// UploadPart stores one part of a multipart upload and returns its ETag.
+func (s *BadgerStore) UploadPart(bucket, uploadID string, partNumber int, data []byte) (string, error) {
+    if partNumber < 1 || partNumber > 10000 {
+        return "", errors.New("part number must be between 1 and 10000")
+    }
+    var upload MultipartUpload
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        upload, err = getMultipartUpload(txn, bucket, uploadID)
+        return err
+    })
+    if err != nil {
+        return "", err
+    }
+    aesKey, err := s.keyManager.DecryptAESKey(s.keyManager.GetPrivateKey(), upload.EncryptedKey)
+    if err != nil {
+        return "", err
+    }
+    encrypted, err := s.keyManager.EncryptData(data, aesKey)
+    if err != nil {
+        return "", err
+    }
+    err = s.db.Update(func(txn *badger.Txn) error {
+        if _, err := getMultipartUpload(txn, bucket, uploadID); err != nil {
+            return err
+        }
+        return txn.Set(multipartPartKey(bucket, uploadID, partNumber), encrypted)
+    })
+    if err != nil {
+        return "", err
+    }
+    sum := md5.Sum(data)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+// CompleteMultipartUpload joins the uploaded parts in part-number order and
+// stores them as a new object version.
+func (s *BadgerStore) CompleteMultipartUpload(bucket, uploadID string) (ObjectVersion, error) {
+    var upload MultipartUpload
+    var parts [][]byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        upload, err = getMultipartUpload(txn, bucket, uploadID)
+        if err != nil {
+            return err
+        }
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := multipartPartPrefix(bucket, uploadID)
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            part, err := it.Item().ValueCopy(nil)
+            if err != nil {
+                return err
+            }
+            parts = append(parts, part)
+        }
+        return nil
+    })
+    if err != nil {
+        return ObjectVersion{}, err
+    }
+
+    aesKey, err := s.keyManager.DecryptAESKey(s.keyManager.GetPrivateKey(), upload.EncryptedKey)
+    if err != nil {
+        return ObjectVersion{}, err
+    }
+    var buf bytes.Buffer
+    for _, part := range parts {
+        plain, err := s.keyManager.DecryptData(part, aesKey)
+        if err != nil {
+            return ObjectVersion{}, err
+        }
+        buf.Write(plain)
+    }
+
+    version, err := s.PutObjectWithOptions(bucket, upload.Key, buf.Bytes(), upload.Options)
+    if err != nil {
+        return ObjectVersion{}, err
+    }
+    return version, s.AbortMultipartUpload(bucket, uploadID)
+}
+
+// AbortMultipartUpload discards an upload session and its parts.
+func (s *BadgerStore) AbortMultipartUpload(bucket, uploadID string) error {
+    var partKeys [][]byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        if _, err := getMultipartUpload(txn, bucket, uploadID); err != nil {
+            return err
+        }
+        opts := badger.DefaultIteratorOptions
+        opts.PrefetchValues = false
+        it := txn.NewIterator(opts)
+        defer it.Close()
+
+        prefix := multipartPartPrefix(bucket, uploadID)
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            partKeys = append(partKeys, it.Item().KeyCopy(nil))
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    return s.db.Update(func(txn *badger.Txn) error {
+        for _, key := range partKeys {
+            if err := txn.Delete(key); err != nil {
+                return err
+            }
+        }
+        return txn.Delete(multipartKey(bucket, uploadID))
+    })
+}
+
+// ListMultipartUploads returns the in-progress uploads of a bucket.
+func (s *BadgerStore) ListMultipartUploads(bucket string) ([]MultipartUpload, error) {
+    var uploads []MultipartUpload
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := multipartKey(bucket, "")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            var upload MultipartUpload
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &upload)
+            })
+            if err != nil {
+                return err
+            }
+            uploads = append(uploads, upload)
+        }
+        return nil
+    })
+    return uploads, err
+}
@@ -0,0 +1,377 @@
+package storage
+
+import (
+    "encoding/json"
+    "errors"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+)
+
+type RetentionMode string
+
+const (
+    RetentionGovernance RetentionMode = "GOVERNANCE"
+    RetentionCompliance RetentionMode = "COMPLIANCE"
+)
+
+// NullVersionID identifies the single, unversioned copy of an object.
+const NullVersionID = "null"
+
+var (
+    ErrObjectLocked         = errors.New("object is protected by object lock")
+    ErrObjectLockNotEnabled = errors.New("object lock is not enabled for bucket")
+    ErrInvalidRetentionMode = errors.New("invalid retention mode")
+    ErrInvalidRetentionDate = errors.New("retain-until date must be in the future")
+    ErrRetentionNotFound    = errors.New("no retention configured for object version")
+)
+
+type ObjectLockConfiguration struct {
+    Enabled     bool          `json:"enabled"`
+    DefaultMode RetentionMode `json:"default_mode,omitempty"`
+    DefaultDays int           `json:"default_days,omitempty"`
+}
+
+type ObjectRetention struct {
+    Mode        RetentionMode `json:"mode"`
+    RetainUntil time.Time     `json:"retain_until"`
+}
+
+func (r ObjectRetention) active(now time.Time) bool {
+    return r.RetainUntil.After(now)
+}
+
+func validRetentionMode(mode RetentionMode) bool {
+    return mode == RetentionGovernance || mode == RetentionCompliance
+}
+
+func objectLockKey(bucket string) []byte {
+    return []byte("objectlock/" + bucket)
+}
+
+func versionOrNull(versionID string) string {
+    if versionID == "" {
+        return NullVersionID
+    }
+    return versionID
+}
+
+func retentionKey(bucket, key, versionID string) []byte {
+    return nameKey("retention", bucket, key, versionOrNull(versionID))
+}
+
+func legalHoldKey(bucket, key, versionID string) []byte {
+    return nameKey("legalhold", bucket, key, versionOrNull(versionID))
+}
+
+// lockKeys are the keys of the retention and legal hold records of a version
+// or trash entry.
+type lockKeys struct {
+    retention, legalHold []byte
+}
+
+func versionLockKeys(bucket, key, versionID string) lockKeys {
+    return lockKeys{retentionKey(bucket, key, versionID), legalHoldKey(bucket, key, versionID)}
+}
+
+// trashLockKeys returns where the lock records of trash entry id are kept
+// while its version is out of the object's version list.
+func trashLockKeys(bucket, key, id string) lockKeys {
+    return lockKeys{nameKey("trash-retention", bucket, key, id), nameKey("trash-legalhold", bucket, key, id)}
+}
+
+func (cfg ObjectLockConfiguration) validate() error {
+    if cfg.DefaultMode != "" && !validRetentionMode(cfg.DefaultMode) {
+        return ErrInvalidRetentionMode
+    }
+    if cfg.DefaultDays < 0 {
+        return errors.New("default retention days must not be negative")
+    }
+    return nil
+}
+
+// checkObjectLockChange refuses to replace the bucket's object lock
+// configuration with cfg if that would switch lock off. Like S3, object
+// lock cannot be disabled once enabled.
+func checkObjectLockChange(txn *badger.Txn, bucket string, cfg ObjectLockConfiguration) error {
+    current, err := getObjectLockConfiguration(txn, bucket)
+    if err != nil {
+        return err
+    }
+    if current.Enabled && !cfg.Enabled {
+        return errors.New("object lock cannot be disabled once enabled")
+    }
+    return nil
+}
+
+func (s *BadgerStore) PutObjectLockConfiguration(bucket string, cfg ObjectLockConfiguration) error {
+    if err := cfg.validate(); err != nil {
+        return err
+    }
+    return s.updatePolicy(bucket, PolicyObjectLock, func(txn *badger.Txn) error {
+        if err := checkObjectLockChange(txn, bucket, cfg); err != nil {
+            return err
+        }
+        data, err := json.Marshal(cfg)
+        if err != nil {
+            return err
+        }
+        return txn.Set(objectLockKey(bucket), data)
+    }, translog.Entry{Type: translog.TypePolicy, Bucket: bucket, Detail: "object-lock"})
+}
+
+func (s *BadgerStore) GetObjectLockConfiguration(bucket string) (ObjectLockConfiguration, error) {
+    var cfg ObjectLockConfiguration
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        cfg, err = getObjectLockConfiguration(txn, bucket)
+        return err
+    })
+    return cfg, err
+}
+
+func getObjectLockConfiguration(txn *badger.Txn, bucket string) (ObjectLockConfiguration, error) {
+    var cfg ObjectLockConfiguration
+    item, err := txn.Get(objectLockKey(bucket))
+    if err == badger.ErrKeyNotFound {
+        return cfg, nil
+    }
+    if err != nil {
+        return cfg, err
+    }
+    err = item.Value(func(val []byte) error {
+        return json.Unmarshal(val, &cfg)
+    })
+    return cfg, err
+}
+
+// PutObjectRetention sets or changes the retention of an object version.
+// Retention may always be extended. Shortening or removing governance
+// retention requires bypassGovernance, and compliance retention can never
+// be shortened, removed or downgraded to governance while it is active.
+func (s *BadgerStore) PutObjectRetention(bucket, key, versionID string, retention ObjectRetention, bypassGovernance bool) error {
+    if !validRetentionMode(retention.Mode) {
+        return ErrInvalidRetentionMode
+    }
+    if !retention.RetainUntil.After(time.Now()) {
+        return ErrInvalidRetentionDate
+    }
+    e := translog.Entry{
+        Type:   translog.TypePolicy,
+        Bucket: bucket,
+        Key:    key,
+        Detail: "retention=" + string(retention.Mode) + " until " + retention.RetainUntil.UTC().Format(time.RFC3339),
+    }
+    return s.update(&e, func(txn *badger.Txn) error {
+        cfg, err := getObjectLockConfiguration(txn, bucket)
+        if err != nil {
+            return err
+        }
+        if !cfg.Enabled {
+            return ErrObjectLockNotEnabled
+        }
+        versionID, err = resolveVersion(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        e.VersionID = versionID
+        current, found, err := getObjectRetention(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        if found && current.active(time.Now()) {
+            weakened := retention.RetainUntil.Before(current.RetainUntil) ||
+                (current.Mode == RetentionCompliance && retention.Mode != RetentionCompliance)
+            if weakened && (current.Mode == RetentionCompliance || !bypassGovernance) {
+                return ErrObjectLocked
+            }
+        }
+        return setObjectRetention(txn, bucket, key, versionID, retention)
+    })
+}
+
+func (s *BadgerStore) GetObjectRetention(bucket, key, versionID string) (ObjectRetention, error) {
+    var retention ObjectRetention
+    err := s.db.View(func(txn *badger.Txn) error {
+        versionID, err := resolveVersion(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        r, found, err := getObjectRetention(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        if !found {
+            return ErrRetentionNotFound
+        }
+        retention = r
+        return nil
+    })
+    return retention, err
+}
+
+func getObjectRetention(txn *badger.Txn, bucket, key, versionID string) (ObjectRetention, bool, error) {
+    return getRetention(txn, retentionKey(bucket, key, versionID))
+}
+
+func getRetention(txn *badger.Txn, k []byte) (ObjectRetention, bool, error) {
+    var retention ObjectRetention
+    item, err := txn.Get(k)
+    if err == badger.ErrKeyNotFound {
+        return retention, false, nil
+    }
+    if err != nil {
+        return retention, false, err
+    }
+    err = item.Value(func(val []byte) error {
+        return json.Unmarshal(val, &retention)
+    })
+    return retention, err == nil, err
+}
+
+func setObjectRetention(txn *badger.Txn, bucket, key, versionID string, retention ObjectRetention) error {
+    data, err := json.Marshal(retention)
+    if err != nil {
+        return err
+    }
+    return txn.Set(retentionKey(bucket, key, versionID), data)
+}
+
+func (s *BadgerStore) PutObjectLegalHold(bucket, key, versionID string, on bool) error {
+    e := translog.Entry{Type: translog.TypePolicy, Bucket: bucket, Key: key, Detail: "legal-hold=off"}
+    if on {
+        e.Detail = "legal-hold=on"
+    }
+    return s.update(&e, func(txn *badger.Txn) error {
+        cfg, err := getObjectLockConfiguration(txn, bucket)
+        if err != nil {
+            return err
+        }
+        if !cfg.Enabled {
+            return ErrObjectLockNotEnabled
+        }
+        versionID, err = resolveVersion(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        e.VersionID = versionID
+        if !on {
+            return txn.Delete(legalHoldKey(bucket, key, versionID))
+        }
+        return txn.Set(legalHoldKey(bucket, key, versionID), []byte{1})
+    })
+}
+
+func (s *BadgerStore) GetObjectLegalHold(bucket, key, versionID string) (bool, error) {
+    var on bool
+    err := s.db.View(func(txn *badger.Txn) error {
+        versionID, err := resolveVersion(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        on, err = hasLegalHold(txn, bucket, key, versionID)
+        return err
+    })
+    return on, err
+}
+
+func hasLegalHold(txn *badger.Txn, bucket, key, versionID string) (bool, error) {
+    return hasRecord(txn, legalHoldKey(bucket, key, versionID))
+}
+
+func hasRecord(txn *badger.Txn, k []byte) (bool, error) {
+    _, err := txn.Get(k)
+    if err == badger.ErrKeyNotFound {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// resolveVersion returns the ID of an existing, non-delete-marker version. An
+// empty versionID refers to the current version.
+func resolveVersion(txn *badger.Txn, bucket, key, versionID string) (string, error) {
+    info, err := getObjectInfo(txn, bucket, key)
+    if err != nil {
+        return "", err
+    }
+    i := 0
+    if versionID != "" {
+        i = info.find(versionID)
+    }
+    if i < 0 || i >= len(info.Versions) || info.Versions[i].IsDeleteMarker {
+        return "", ErrNotFound
+    }
+    return info.Versions[i].VersionID, nil
+}
+
+// CheckObjectLock returns ErrObjectLocked if the version is under retention
+// or legal hold, without changing anything.
+func (s *BadgerStore) CheckObjectLock(bucket, key, versionID string) error {
+    return s.db.View(func(txn *badger.Txn) error {
+        return checkObjectLock(txn, bucket, key, versionOrNull(versionID), false)
+    })
+}
+
+// checkObjectLock returns ErrObjectLocked if the given object version may not
+// be removed or overwritten.
+func checkObjectLock(txn *badger.Txn, bucket, key, versionID string, bypassGovernance bool) error {
+    return checkLock(txn, versionLockKeys(bucket, key, versionID), bypassGovernance)
+}
+
+func checkLock(txn *badger.Txn, keys lockKeys, bypassGovernance bool) error {
+    held, err := hasRecord(txn, keys.legalHold)
+    if err != nil {
+        return err
+    }
+    if held {
+        return ErrObjectLocked
+    }
+    retention, found, err := getRetention(txn, keys.retention)
+    if err != nil {
+        return err
+    }
+    if !found || !retention.active(time.Now()) {
+        return nil
+    }
+    if retention.Mode == RetentionGovernance && bypassGovernance {
+        return nil
+    }
+    return ErrObjectLocked
+}
+
+// applyDefaultRetention stamps a freshly written version with the bucket's
+// default retention, if one is configured.
+func applyDefaultRetention(txn *badger.Txn, bucket, key, versionID string) error {
+    cfg, err := getObjectLockConfiguration(txn, bucket)
+    if err != nil {
+        return err
+    }
+    if !cfg.Enabled || cfg.DefaultMode == "" || cfg.DefaultDays == 0 {
+        return nil
+    }
+    return setObjectRetention(txn, bucket, key, versionID, ObjectRetention{
+        Mode:        cfg.DefaultMode,
+        RetainUntil: time.Now().AddDate(0, 0, cfg.DefaultDays),
+    })
+}
+
+// clearObjectLock drops the lock records of a version that has been removed.
+func clearObjectLock(txn *badger.Txn, bucket, key, versionID string) error {
+    return clearLock(txn, versionLockKeys(bucket, key, versionID))
+}
+
+func clearLock(txn *badger.Txn, keys lockKeys) error {
+    if err := txn.Delete(keys.retention); err != nil {
+        return err
+    }
+    return txn.Delete(keys.legalHold)
+}
+
+// moveLock moves the lock records at from to to, clearing any left at to.
+func moveLock(txn *badger.Txn, from, to lockKeys) error {
+    if err := clearLock(txn, to); err != nil {
+        return err
+    }
+    return moveValues(txn, from.retention, to.retention, from.legalHold, to.legalHold)
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestObjectLock_RetentionAndLegalHold(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObjectLockConfiguration("locked", ObjectLockConfiguration{
+        Enabled:     true,
+        DefaultMode: RetentionGovernance,
+        DefaultDays: 1,
+    }))
+
+    require.NoError(t, store.PutObject("locked", "gov", []byte("data")))
+    assert.ErrorIs(t, store.DeleteObject("locked", "gov", false), ErrObjectLocked)
+    assert.ErrorIs(t, store.PutObject("locked", "gov", []byte("overwrite")), ErrObjectLocked)
+    require.NoError(t, store.PutTrashConfiguration("locked", TrashConfiguration{RetentionDays: 1}))
+    assert.ErrorIs(t, store.SoftDeleteObject(context.Background(), "locked", "gov", "tester", false), ErrObjectLocked)
+    require.NoError(t, store.SoftDeleteObject(context.Background(), "locked", "gov", "tester", true))
+
+    require.NoError(t, store.PutObject("locked", "comp", []byte("data")))
+    until := time.Now().Add(48 * time.Hour)
+    require.NoError(t, store.PutObjectRetention("locked", "comp", "", ObjectRetention{Mode: RetentionCompliance, RetainUntil: until}, false))
+    err = store.PutObjectRetention("locked", "comp", "", ObjectRetention{Mode: RetentionGovernance, RetainUntil: until}, true)
+    assert.ErrorIs(t, err, ErrObjectLocked)
+    assert.ErrorIs(t, store.DeleteObject("locked", "comp", true), ErrObjectLocked)
+
+    require.NoError(t, store.PutObjectLockConfiguration("held", ObjectLockConfiguration{Enabled: true}))
+    require.NoError(t, store.PutObject("held", "key", []byte("data")))
+    require.NoError(t, store.PutObjectLegalHold("held", "key", "", true))
+    assert.ErrorIs(t, store.DeleteObject("held", "key", true), ErrObjectLocked)
+    require.NoError(t, store.PutObjectLegalHold("held", "key", "", false))
+    require.NoError(t, store.DeleteObject("held", "key", false))
+}
+
+func TestObjectLock_TravelsWithTrashEntries(t *testing.T) {
+    ctx := context.Background()
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObjectLockConfiguration("b", ObjectLockConfiguration{Enabled: true}))
+    require.NoError(t, store.PutObject("b", "k", []byte("locked")))
+    until := time.Now().Add(time.Hour)
+    require.NoError(t, store.PutObjectRetention("b", "k", "", ObjectRetention{Mode: RetentionGovernance, RetainUntil: until}, false))
+    require.NoError(t, store.SoftDeleteObject(ctx, "b", "k", "", true))
+
+    // A new version under the same ID does not inherit the trashed one's lock.
+    require.NoError(t, store.PutObject("b", "k", []byte("fresh")))
+    _, err = store.GetObjectRetention("b", "k", "")
+    assert.ErrorIs(t, err, ErrRetentionNotFound)
+    require.NoError(t, store.DeleteObject("b", "k", false))
+
+    // The trash entry keeps it through purges and brings it back on restore.
+    require.NoError(t, store.PurgeDeletedObjects(ctx, time.Nanosecond))
+    entries, err := store.ListTrash(ctx, "b")
+    require.NoError(t, err)
+    assert.Len(t, entries, 1)
+    require.NoError(t, store.RestoreObject(ctx, "b", "k", ""))
+    retention, err := store.GetObjectRetention("b", "k", "")
+    require.NoError(t, err)
+    assert.Equal(t, RetentionGovernance, retention.Mode)
+    assert.True(t, until.Equal(retention.RetainUntil))
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+    "math/rand"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestProveRange(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    data := make([]byte, 1<<20)
+    rand.New(rand.NewSource(3)).Read(data)
+    require.NoError(t, store.PutObject("b", "k", data))
+
+    all, err := store.ProveRange("b", "k", "", 0, 0)
+    require.NoError(t, err)
+    require.Greater(t, len(all.Chunks), 2)
+    var leaves [][]byte
+    for _, c := range all.Chunks {
+        assert.True(t, dag.VerifyProof(all.Root, c.Proof))
+        leaves = append(leaves, c.Proof.Leaf)
+    }
+    // The Merkle root is rebuilt from the stored nodes.
+    assert.Equal(t, dag.RootHash(dag.BuildTree(leaves)), all.Root)
+
+    second := all.Chunks[1]
+    proof, err := store.ProveRange("b", "k", "", second.Offset+1, 1)
+    require.NoError(t, err)
+    assert.Equal(t, []ChunkProof{second}, proof.Chunks)
+
+    _, err = store.ProveRange("b", "k", "", int64(len(data)), 1)
+    assert.ErrorIs(t, err, ErrInvalidRange)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+    "context"
+
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+)
+
+// BlockAnnouncer announces to peers that this node holds blocks.
+type BlockAnnouncer interface {
+    Announce(c cid.Cid)
+}
+
+// SetAnnouncer attaches the announcer that blocks are handed to once they
+// are written.
+func (s *BadgerStore) SetAnnouncer(a BlockAnnouncer) {
+    s.announcer = a
+}
+
+func (s *BadgerStore) announce(cids ...cid.Cid) {
+    if s.announcer == nil {
+        return
+    }
+    for _, c := range cids {
+        s.announcer.Announce(c)
+    }
+}
+
+// ListBlocks calls fn with the CID of every block the store can serve:
+// those in Badger and the erasure-coded chunks it can rebuild.
+func (s *BadgerStore) ListBlocks(ctx context.Context, fn func(c cid.Cid) error) error {
+    return s.db.View(func(txn *badger.Txn) error {
+        opts := badger.DefaultIteratorOptions
+        opts.PrefetchValues = false
+        it := txn.NewIterator(opts)
+        defer it.Close()
+
+        for _, prefix := range [][]byte{[]byte("block/"), stripePrefix} {
+            for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+                if ctx.Err() != nil {
+                    return ctx.Err()
+                }
+                c, err := cid.Decode(string(it.Item().Key()[len(prefix):]))
+                if err != nil {
+                    continue
+                }
+                if err := fn(c); err != nil {
+                    return err
+                }
+            }
+        }
+        return nil
+    })
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+    "context"
+    "sync"
+    "testing"
+
+    "github.com/ipfs/go-cid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+type recordingAnnouncer struct {
+    mu  sync.Mutex
+    set map[cid.Cid]bool
+}
+
+func (a *recordingAnnouncer) Announce(c cid.Cid) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.set[c] = true
+}
+
+func TestBadgerStore_AnnouncesWrittenBlocks(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    announcer := &recordingAnnouncer{set: make(map[cid.Cid]bool)}
+    store.SetAnnouncer(announcer)
+
+    data := make([]byte, 600<<10)
+    for i := range data {
+        data[i] = byte(i * 7)
+    }
+    require.NoError(t, store.PutObject("b", "k", data))
+
+    held := make(map[cid.Cid]bool)
+    require.NoError(t, store.ListBlocks(context.Background(), func(c cid.Cid) error {
+        held[c] = true
+        return nil
+    }))
+    assert.Greater(t, len(held), 2)
+    assert.Equal(t, held, announcer.set)
+}
@@ -2,49 +2,320 @@ package storage
 
 import (
     "context"
+    "encoding/json"
     "log"
 
     "github.com/Alyanaky/SecureDAG/internal/p2p"
     "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+    "github.com/libp2p/go-libp2p/core/peer"
 )
 
 type Replicator struct {
-    store *BadgerStore
-    dht   *p2p.DHTOperations
+    store     *BadgerStore
+    dht       *p2p.DHTOperations
+    placement *p2p.Placement
+    exchange  *p2p.Exchange
 }
 
 func NewReplicator(store *BadgerStore, dht *p2p.DHTOperations) *Replicator {
     return &Replicator{store: store, dht: dht}
 }
 
+// UsePlacement makes the replicator pin each block over the exchange on
+// the MinReplicas peers placement chooses for it.
+func (r *Replicator) UsePlacement(placement *p2p.Placement, exchange *p2p.Exchange) {
+    r.placement = placement
+    r.exchange = exchange
+}
+
 func (r *Replicator) Replicate(ctx context.Context, bucket, key string) error {
-    data, err := r.store.GetObject(bucket, key)
+    if r.placement == nil {
+        data, err := r.store.GetObject(bucket, key)
+        if err != nil {
+            return err
+        }
+        return r.dht.ReplicateData(ctx, bucket+"/"+key, data)
+    }
+
+    cids, err := r.store.currentBlocks(bucket, key)
     if err != nil {
         return err
     }
+    _, err = r.place(ctx, cids)
+    return err
+}
 
-    return r.dht.ReplicateData(ctx, bucket+"/"+key, data)
+// placedKey records that this node pinned block c on p. It holds a
+// placedRecord.
+func placedKey(c cid.Cid, p peer.ID) []byte {
+    return nameKey("placed", c.String(), p.String())
 }
 
-func (r *Replicator) EnsureReplicas(ctx context.Context) error {
-    return r.store.db.View(func(txn *badger.Txn) error {
-        opts := badger.DefaultIteratorOptions
-        opts.PrefetchValues = true
-        it := txn.NewIterator(opts)
+type placedRecord struct {
+    CID  []byte  `json:"cid"`
+    Peer peer.ID `json:"peer"`
+}
+
+func decodePlaced(item *badger.Item) (cid.Cid, peer.ID, error) {
+    var rec placedRecord
+    err := item.Value(func(val []byte) error {
+        return json.Unmarshal(val, &rec)
+    })
+    if err != nil {
+        return cid.Undef, "", err
+    }
+    c, err := cid.Cast(rec.CID)
+    return c, rec.Peer, err
+}
+
+// placedPrefix covers the placed records of c, or of every block if c is
+// undefined.
+func placedPrefix(c cid.Cid) []byte {
+    if !c.Defined() {
+        return []byte("placed/")
+    }
+    return append(nameKey("placed", c.String()), '/')
+}
+
+// placedOn lists the peers this node has pinned c on.
+func (s *BadgerStore) placedOn(c cid.Cid) (map[peer.ID]bool, error) {
+    peers := make(map[peer.ID]bool)
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
         defer it.Close()
+        prefix := placedPrefix(c)
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            _, p, err := decodePlaced(it.Item())
+            if err != nil {
+                return err
+            }
+            peers[p] = true
+        }
+        return nil
+    })
+    return peers, err
+}
+
+func (s *BadgerStore) setPlaced(c cid.Cid, p peer.ID, placed bool) error {
+    return s.db.Update(func(txn *badger.Txn) error {
+        if !placed {
+            return txn.Delete(placedKey(c, p))
+        }
+        rec, err := json.Marshal(placedRecord{CID: c.Bytes(), Peer: p})
+        if err != nil {
+            return err
+        }
+        return txn.Set(placedKey(c, p), rec)
+    })
+}
+
+// place pins blocks on the placement targets that lack them or hold them
+// without this node's pin, and returns how many it sent. Pins on peers that
+// are no longer targets are released. A peer that fails is skipped until
+// the next run.
+func (r *Replicator) place(ctx context.Context, cids []cid.Cid) (int, error) {
+    targets := make(map[peer.ID][]cid.Cid)
+    pinned := make(map[cid.Cid]map[peer.ID]bool)
+    moved := make(map[peer.ID][]cid.Cid)
+    for _, c := range cids {
+        placed, err := r.store.placedOn(c)
+        if err != nil {
+            return 0, err
+        }
+        pinned[c] = placed
+        wanted := make(map[peer.ID]bool)
+        for _, p := range r.placement.Place(c, MinReplicas) {
+            if p != r.exchange.ID() {
+                targets[p] = append(targets[p], c)
+                wanted[p] = true
+            }
+        }
+        for p := range placed {
+            if !wanted[p] {
+                moved[p] = append(moved[p], c)
+            }
+        }
+    }
+    placed := 0
+    for p, wanted := range targets {
+        held, err := r.exchange.HasBlocks(ctx, p, wanted)
+        if err != nil {
+            if ctx.Err() != nil {
+                return placed, ctx.Err()
+            }
+            log.Printf("Failed to query blocks held by %s: %v", p, err)
+            continue
+        }
+        for _, c := range wanted {
+            if held[c] && pinned[c][p] {
+                continue
+            }
+            data, err := r.store.GetBlock(ctx, c)
+            if err != nil {
+                return placed, err
+            }
+            if err := r.exchange.PinBlock(ctx, p, c, data); err != nil {
+                log.Printf("Failed to place block %s on %s: %v", c, p, err)
+                break
+            }
+            if err := r.store.setPlaced(c, p, true); err != nil {
+                return placed, err
+            }
+            placed++
+        }
+    }
+    for p, cids := range moved {
+        if err := r.unpin(ctx, p, cids); err != nil {
+            return placed, err
+        }
+    }
+    return placed, nil
+}
+
+// unpin releases this node's pins of blocks on p. A pin that cannot be
+// released now stays recorded and is retried on the next run.
+func (r *Replicator) unpin(ctx context.Context, p peer.ID, cids []cid.Cid) error {
+    for _, c := range cids {
+        if err := r.exchange.UnpinBlock(ctx, p, c); err != nil {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            log.Printf("Failed to release block %s on %s: %v", c, p, err)
+            return nil
+        }
+        if err := r.store.setPlaced(c, p, false); err != nil {
+            return err
+        }
+    }
+    return nil
+}
 
-        for it.Rewind(); it.Valid(); it.Next() {
-            item := it.Item()
-            key := item.KeyCopy(nil)
-            val, err := item.ValueCopy(nil)
+// releaseCollected releases the pins of blocks this node has since garbage
+// collected, which no object of this node needs on its peers any more.
+func (r *Replicator) releaseCollected(ctx context.Context) error {
+    released := make(map[peer.ID][]cid.Cid)
+    err := r.store.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+        prefix := placedPrefix(cid.Undef)
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            c, p, err := decodePlaced(it.Item())
             if err != nil {
-                log.Printf("Failed to copy value for key %s: %v", key, err)
+                return err
+            }
+            if _, err := txn.Get(blockKey(c)); err == nil {
                 continue
+            } else if err != badger.ErrKeyNotFound {
+                return err
             }
-            if err := r.dht.ReplicateData(ctx, string(key), val); err != nil {
-                log.Printf("Failed to replicate key %s: %v", key, err)
+            released[p] = append(released[p], c)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    for p, cids := range released {
+        if err := r.unpin(ctx, p, cids); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// EnsureReplicas replicates the current version of every object and
+// releases the pins of blocks collected since. Delete markers and the
+// store's own bookkeeping, such as node keys, are never published.
+func (r *Replicator) EnsureReplicas(ctx context.Context) error {
+    var objects []ObjectInfo
+    err := r.store.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := []byte("objmeta/")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            var info ObjectInfo
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &info)
+            })
+            if err != nil {
+                return err
             }
+            objects = append(objects, info)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    for _, info := range objects {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        if latest, ok := info.Latest(); !ok || latest.IsDeleteMarker {
+            continue
         }
+        if err := r.Replicate(ctx, info.Bucket, info.Key); err != nil {
+            log.Printf("Failed to replicate %s/%s: %v", info.Bucket, info.Key, err)
+        }
+    }
+    if r.placement == nil {
         return nil
+    }
+    return r.releaseCollected(ctx)
+}
+
+// currentBlocks lists, once each, the blocks of an object's current version
+// that this node holds: its DAG nodes, and its chunks unless they are
+// archived or erasure coded.
+func (s *BadgerStore) currentBlocks(bucket, key string) ([]cid.Cid, error) {
+    var cids []cid.Cid
+    seen := make(map[cid.Cid]bool)
+    err := s.db.View(func(txn *badger.Txn) error {
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        latest, ok := info.Latest()
+        if !ok || latest.IsDeleteMarker {
+            return ErrNotFound
+        }
+        dataKey, keyKey := versionDataKeys(info, 0)
+        data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+        if err != nil {
+            return err
+        }
+        m, err := s.openManifest(data, aesKey)
+        if err != nil || m.DAG == "" {
+            return err
+        }
+        root, err := cid.Decode(m.DAG)
+        if err != nil {
+            return err
+        }
+        chunks := inBadger(latest)
+        return walkDAG(txn, root, func(c cid.Cid, data []byte) error {
+            if seen[c] {
+                return nil
+            }
+            seen[c] = true
+            if data != nil {
+                cids = append(cids, c)
+                return nil
+            }
+            if !chunks {
+                return nil
+            }
+            if _, err := txn.Get(blockKey(c)); err == badger.ErrKeyNotFound {
+                return nil
+            } else if err != nil {
+                return err
+            }
+            cids = append(cids, c)
+            return nil
+        })
     })
+    return cids, err
 }
@@ -0,0 +1,422 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/Alyanaky/SecureDAG/internal/metrics"
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+)
+
+var (
+    scrubCursorKey = []byte("scrub/cursor")
+    scrubReportKey = []byte("scrub/report")
+)
+
+// ScrubOptions bounds one scrub run. A run verifies about BlocksPerRun
+// blocks, carrying on from where the previous run stopped, and reads at
+// most BytesPerSecond so that foreground traffic keeps its I/O. A zero rate
+// reads unthrottled.
+type ScrubOptions struct {
+    BlocksPerRun   int
+    BytesPerSecond int64
+}
+
+var DefaultScrubOptions = ScrubOptions{
+    BlocksPerRun:   4096,
+    BytesPerSecond: 16 << 20,
+}
+
+// ScrubReport summarizes a scrub run. Unrepairable lists the CIDs of blocks
+// that no peer could supply and the versions whose manifests cannot be
+// opened. PassComplete is set by the run that reaches the last object.
+type ScrubReport struct {
+    StartedAt       time.Time `json:"started_at"`
+    FinishedAt      time.Time `json:"finished_at"`
+    Objects         int       `json:"objects"`
+    Blocks          int       `json:"blocks"`
+    Bytes           int64     `json:"bytes"`
+    Corrupt         int       `json:"corrupt"`
+    Missing         int       `json:"missing"`
+    Repaired        int       `json:"repaired"`
+    UnderReplicated int       `json:"under_replicated"`
+    Replicated      int       `json:"replicated"`
+    Unrepairable    []string  `json:"unrepairable,omitempty"`
+    PassComplete    bool      `json:"pass_complete"`
+}
+
+// Scrub re-reads the blocks of stored object versions, checks each against
+// its CID and each chunk's GCM tag against its key, and rewrites corrupt or
+// missing blocks with copies fetched over the block exchange. Objects the
+// DHT finds on fewer than MinReplicas nodes have their blocks sent to their
+// placement targets by the replicator.
+// Blocks shared by several versions are checked once per run.
+func (s *BadgerStore) Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error) {
+    report := ScrubReport{StartedAt: time.Now().UTC()}
+    if opts.BlocksPerRun <= 0 {
+        opts.BlocksPerRun = DefaultScrubOptions.BlocksPerRun
+    }
+    cursor, err := s.scrubCursor()
+    if err != nil {
+        return report, err
+    }
+    sc := &scrubber{
+        store:    s,
+        ctx:      ctx,
+        report:   &report,
+        seen:     make(map[cid.Cid]bool),
+        throttle: &ioThrottle{rate: opts.BytesPerSecond, start: time.Now()},
+    }
+    for report.Blocks < opts.BlocksPerRun {
+        info, err := s.nextObject(cursor)
+        if err != nil {
+            return report, err
+        }
+        if info == nil {
+            report.PassComplete = true
+            cursor = nil
+            break
+        }
+        for i, v := range info.Versions {
+            if v.IsDeleteMarker || !inBadger(v) {
+                continue
+            }
+            if err := sc.version(info, i); err != nil {
+                return report, err
+            }
+        }
+        report.Objects++
+        cursor = objectMetaKey(info.Bucket, info.Key)
+    }
+    report.FinishedAt = time.Now().UTC()
+
+    data, err := json.Marshal(report)
+    if err != nil {
+        return report, err
+    }
+    err = s.db.Update(func(txn *badger.Txn) error {
+        if err := txn.Set(scrubReportKey, data); err != nil {
+            return err
+        }
+        if cursor == nil {
+            return txn.Delete(scrubCursorKey)
+        }
+        return txn.Set(scrubCursorKey, cursor)
+    })
+    return report, err
+}
+
+// LastScrubReport returns the report of the latest scrub run, or
+// ErrNotFound if there has been none.
+func (s *BadgerStore) LastScrubReport() (ScrubReport, error) {
+    var report ScrubReport
+    err := s.db.View(func(txn *badger.Txn) error {
+        item, err := txn.Get(scrubReportKey)
+        if err != nil {
+            return err
+        }
+        return item.Value(func(val []byte) error {
+            return json.Unmarshal(val, &report)
+        })
+    })
+    return report, err
+}
+
+func (s *BadgerStore) scrubCursor() ([]byte, error) {
+    var cursor []byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        item, err := txn.Get(scrubCursorKey)
+        if err == badger.ErrKeyNotFound {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        cursor, err = item.ValueCopy(nil)
+        return err
+    })
+    return cursor, err
+}
+
+// nextObject returns the object stored after the metadata key cursor, the
+// first one if cursor is nil, or nil at the end.
+func (s *BadgerStore) nextObject(cursor []byte) (*ObjectInfo, error) {
+    var info *ObjectInfo
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := []byte("objmeta/")
+        start := prefix
+        if cursor != nil {
+            start = append(append([]byte{}, cursor...), 0)
+        }
+        it.Seek(start)
+        if !it.ValidForPrefix(prefix) {
+            return nil
+        }
+        info = &ObjectInfo{}
+        return it.Item().Value(func(val []byte) error {
+            return json.Unmarshal(val, info)
+        })
+    })
+    return info, err
+}
+
+type scrubber struct {
+    store    *BadgerStore
+    ctx      context.Context
+    report   *ScrubReport
+    seen     map[cid.Cid]bool
+    throttle *ioThrottle
+    // unplaced collects the intact blocks of the version being scrubbed.
+    unplaced []cid.Cid
+}
+
+func (sc *scrubber) version(info *ObjectInfo, i int) error {
+    var m *Manifest
+    err := sc.store.db.View(func(txn *badger.Txn) error {
+        dataKey, keyKey := versionDataKeys(info, i)
+        data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+        if err != nil {
+            return err
+        }
+        m, err = sc.store.openManifest(data, aesKey)
+        return err
+    })
+    if err == badger.ErrKeyNotFound {
+        // Deleted since the object was listed.
+        return nil
+    }
+    if err != nil {
+        // The manifest exists only here, so there is nothing to repair it from.
+        name := fmt.Sprintf("%s/%s@%s", info.Bucket, info.Key, info.Versions[i].VersionID)
+        log.Printf("Scrub cannot open manifest of %s: %v", name, err)
+        metrics.ScrubFindings.WithLabelValues("unreadable").Inc()
+        sc.report.Unrepairable = append(sc.report.Unrepairable, name)
+        return nil
+    }
+    root, err := manifestRoot(m)
+    if err != nil || !root.Defined() {
+        return nil
+    }
+    replicate := sc.underReplicated(root)
+    next := 0
+    sc.unplaced = nil
+    if err := sc.visit(root, uint64(len(m.Chunks)), m, &next, replicate); err != nil {
+        return err
+    }
+    if replicate {
+        sc.replicate(sc.unplaced)
+    }
+    return nil
+}
+
+// underReplicated asks the DHT how many nodes provide the object's root.
+// Without a DHT the check is skipped.
+func (sc *scrubber) underReplicated(root cid.Cid) bool {
+    n, err := sc.store.dht.CountProviders(sc.ctx, root)
+    if err != nil {
+        if err != p2p.ErrNoDHT {
+            log.Printf("Scrub cannot count replicas of %s: %v", root, err)
+        }
+        return false
+    }
+    if n >= MinReplicas {
+        return false
+    }
+    sc.report.UnderReplicated++
+    metrics.ScrubFindings.WithLabelValues("under_replicated").Inc()
+    return true
+}
+
+// visit scrubs the subtree at c, whose leaves chunks start at chunk *next.
+// Subtrees that were scrubbed already this run or cannot be read are
+// skipped using their leaf count.
+func (sc *scrubber) visit(c cid.Cid, leaves uint64, m *Manifest, next *int, replicate bool) error {
+    if err := sc.ctx.Err(); err != nil {
+        return err
+    }
+    if c.Prefix().Codec == dag.CodecRaw {
+        i := *next
+        *next++
+        if i >= len(m.Chunks) {
+            return nil
+        }
+        _, err := sc.check(c, m.Chunks[i].Key, replicate)
+        return err
+    }
+    if sc.seen[c] {
+        *next += int(leaves)
+        return nil
+    }
+    data, err := sc.check(c, nil, replicate)
+    if err != nil {
+        return err
+    }
+    var node *dag.Node
+    if data != nil {
+        node, err = dag.DecodeNode(data)
+    }
+    if data == nil || err != nil {
+        *next += int(leaves)
+        return nil
+    }
+    for _, l := range node.Links {
+        if err := sc.visit(l.CID, l.Leaves, m, next, replicate); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// check verifies one block and repairs it if needed. It returns the intact
+// block, or nil if the block was seen before or could not be repaired.
+// Erasure-coded chunks are rebuilt to be checked but repaired by
+// HealStripes, not rewritten here.
+func (sc *scrubber) check(c cid.Cid, key []byte, replicate bool) ([]byte, error) {
+    if sc.seen[c] {
+        return nil, nil
+    }
+    sc.seen[c] = true
+
+    var data []byte
+    var striped bool
+    err := sc.store.db.View(func(txn *badger.Txn) error {
+        item, err := txn.Get(blockKey(c))
+        if err == badger.ErrKeyNotFound {
+            if _, err = txn.Get(stripeKey(c)); err == nil {
+                striped = true
+            }
+            return err
+        }
+        if err != nil {
+            return err
+        }
+        data, err = item.ValueCopy(nil)
+        return err
+    })
+    if err != nil && err != badger.ErrKeyNotFound {
+        return nil, err
+    }
+    if striped {
+        if data, err = sc.store.GetBlock(sc.ctx, c); err != nil {
+            data = nil
+        }
+    }
+    sc.report.Blocks++
+    sc.report.Bytes += int64(len(data))
+    metrics.ScrubbedBytes.Add(float64(len(data)))
+    if err := sc.throttle.wait(sc.ctx, len(data)); err != nil {
+        return nil, err
+    }
+
+    finding := ""
+    switch {
+    case data == nil:
+        finding = "missing"
+        sc.report.Missing++
+    case !intact(c, data, key):
+        finding = "corrupt"
+        sc.report.Corrupt++
+    }
+    if finding == "" {
+        if replicate && !striped {
+            sc.unplaced = append(sc.unplaced, c)
+        }
+        return data, nil
+    }
+    metrics.ScrubFindings.WithLabelValues(finding).Inc()
+
+    if !striped {
+        data = sc.store.fetchFromPeers(sc.ctx, c, key)
+    }
+    if striped || data == nil {
+        log.Printf("Scrub found %s block %s and could not repair it", finding, c)
+        metrics.ScrubRepairs.WithLabelValues("rewrite", "failed").Inc()
+        sc.report.Unrepairable = append(sc.report.Unrepairable, c.String())
+        return nil, nil
+    }
+    if err := sc.store.PutBlock(sc.ctx, c, data); err != nil {
+        return nil, err
+    }
+    log.Printf("Scrub rewrote %s block %s from a peer copy", finding, c)
+    metrics.ScrubRepairs.WithLabelValues("rewrite", "ok").Inc()
+    sc.report.Repaired++
+    return data, nil
+}
+
+// replicate has the replicator send intact blocks of an under-replicated
+// version to the placement targets that lack them.
+func (sc *scrubber) replicate(cids []cid.Cid) {
+    r := sc.store.replicator
+    if r == nil || r.placement == nil || len(cids) == 0 {
+        return
+    }
+    placed, err := r.place(sc.ctx, cids)
+    if err != nil {
+        log.Printf("Failed to replicate blocks: %v", err)
+        metrics.ScrubRepairs.WithLabelValues("replicate", "failed").Inc()
+    }
+    metrics.ScrubRepairs.WithLabelValues("replicate", "ok").Add(float64(placed))
+    sc.report.Replicated += placed
+}
+
+// intact reports whether a block matches its CID and, for chunks whose key
+// is given, decrypts with a valid GCM tag.
+func intact(c cid.Cid, data, key []byte) bool {
+    if dag.VerifyCID(c, data) != nil {
+        return false
+    }
+    if key == nil {
+        return true
+    }
+    _, err := crypto.ConvergentDecrypt(data, key)
+    return err == nil
+}
+
+// fetchFromPeers returns an intact copy of a block held by any peer, or nil.
+// The exchange asks the block's placement targets, then its providers.
+func (s *BadgerStore) fetchFromPeers(ctx context.Context, c cid.Cid, key []byte) []byte {
+    if s.exchange != nil {
+        data, err := s.exchange.GetBlock(ctx, c)
+        if err == nil && intact(c, data, key) {
+            return data
+        }
+    }
+    return nil
+}
+
+// ioThrottle paces reads to rate bytes per second.
+type ioThrottle struct {
+    rate  int64
+    start time.Time
+    bytes int64
+}
+
+func (t *ioThrottle) wait(ctx context.Context, n int) error {
+    if t.rate <= 0 {
+        return nil
+    }
+    t.bytes += int64(n)
+    due := t.start.Add(time.Duration(float64(t.bytes) / float64(t.rate) * float64(time.Second)))
+    d := time.Until(due)
+    if d <= 0 {
+        return nil
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+    "context"
+    "math/rand"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/ipfs/go-cid"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// memoryExchange serves the blocks it holds as if peers provided them.
+type memoryExchange map[cid.Cid][]byte
+
+func (m memoryExchange) GetBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+    data, ok := m[c]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    return data, nil
+}
+
+func TestScrub_RepairsFromPeers(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    ctx := context.Background()
+
+    data := make([]byte, 300<<10)
+    rand.New(rand.NewSource(1)).Read(data)
+    require.NoError(t, store.PutObject("b", "k", data))
+    require.NoError(t, store.PutObject("b", "small", []byte("no copy anywhere")))
+    _, _, blocks, err := store.encodeObject(data)
+    require.NoError(t, err)
+    require.Greater(t, len(blocks), 2)
+    _, _, small, err := store.encodeObject([]byte("no copy anywhere"))
+    require.NoError(t, err)
+
+    // Peers hold copies of the large object's chunks only.
+    copies := make(memoryExchange)
+    store.SetBlockExchange(copies)
+    for _, block := range blocks {
+        c, err := dag.NewCID(dag.CodecRaw, block)
+        require.NoError(t, err)
+        copies[c] = block
+    }
+
+    corrupt, err := dag.NewCID(dag.CodecRaw, blocks[0])
+    require.NoError(t, err)
+    missing, err := dag.NewCID(dag.CodecRaw, blocks[1])
+    require.NoError(t, err)
+    lost, err := dag.NewCID(dag.CodecRaw, small[0])
+    require.NoError(t, err)
+    err = store.db.Update(func(txn *badger.Txn) error {
+        flipped := append([]byte(nil), blocks[0]...)
+        flipped[0] ^= 1
+        if err := txn.Set(blockKey(corrupt), flipped); err != nil {
+            return err
+        }
+        if err := txn.Delete(blockKey(missing)); err != nil {
+            return err
+        }
+        return txn.Set(blockKey(lost), []byte("garbage"))
+    })
+    require.NoError(t, err)
+
+    // Runs stop after the object that uses up their budget and the next run
+    // picks up from there.
+    report, err := store.Scrub(ctx, ScrubOptions{BlocksPerRun: 1})
+    require.NoError(t, err)
+    assert.Equal(t, 1, report.Objects)
+    assert.Equal(t, 1, report.Corrupt)
+    assert.Equal(t, 1, report.Missing)
+    assert.Equal(t, 2, report.Repaired)
+    assert.False(t, report.PassComplete)
+
+    report, err = store.Scrub(ctx, ScrubOptions{BlocksPerRun: 1})
+    require.NoError(t, err)
+    assert.Equal(t, 1, report.Objects)
+    assert.Equal(t, 1, report.Corrupt)
+    assert.Equal(t, []string{lost.String()}, report.Unrepairable)
+
+    report, err = store.Scrub(ctx, ScrubOptions{BlocksPerRun: 1})
+    require.NoError(t, err)
+    assert.Zero(t, report.Objects)
+    assert.True(t, report.PassComplete)
+
+    report, err = store.Scrub(ctx, ScrubOptions{BytesPerSecond: 64 << 20})
+    require.NoError(t, err)
+    assert.Equal(t, 2, report.Objects)
+    assert.True(t, report.PassComplete)
+    assert.Zero(t, report.Missing)
+    assert.Equal(t, 1, report.Corrupt, "only the block without a copy is still bad")
+
+    got, err := store.GetObject("b", "k")
+    require.NoError(t, err)
+    assert.Equal(t, data, got)
+    _, err = store.GetObject("b", "small")
+    assert.Error(t, err)
+
+    last, err := store.LastScrubReport()
+    require.NoError(t, err)
+    assert.Equal(t, report.Blocks, last.Blocks)
+}
+
+func TestScrub_ReportsUnrepairable(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObject("b", "k", []byte("only copy")))
+    _, _, blocks, err := store.encodeObject([]byte("only copy"))
+    require.NoError(t, err)
+    c, err := dag.NewCID(dag.CodecRaw, blocks[0])
+    require.NoError(t, err)
+    require.NoError(t, store.db.Update(func(txn *badger.Txn) error {
+        return txn.Delete(blockKey(c))
+    }))
+
+    report, err := store.Scrub(context.Background(), ScrubOptions{})
+    require.NoError(t, err)
+    assert.Equal(t, 2, report.Blocks)
+    assert.Equal(t, 1, report.Missing)
+    assert.Zero(t, report.Repaired)
+    assert.Equal(t, []string{c.String()}, report.Unrepairable)
+}
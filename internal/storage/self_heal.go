@@ -14,9 +14,20 @@ func (s *BadgerStore) SelfHeal(ctx context.Context) error {
         case <-ctx.Done():
             return nil
         case <-ticker.C:
-            if err := s.healBlock(ctx); err != nil {
-                return err
-            }
+        case <-s.healNow:
         }
+        if err := s.healBlock(ctx); err != nil {
+            return err
+        }
+    }
+}
+
+// RequestHeal makes SelfHeal run now rather than at its next tick, such as
+// when a node holding replicas has failed. Requests made while a run is
+// pending are merged into it.
+func (s *BadgerStore) RequestHeal() {
+    select {
+    case s.healNow <- struct{}{}:
+    default:
     }
 }
@@ -0,0 +1,328 @@
+package storage
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/dgraph-io/badger/v4"
+)
+
+const SnapshotInterval = 24 * time.Hour
+
+var ErrNoSuchSnapshot = errors.New("bucket snapshot does not exist")
+
+// SnapshotEntry is one leaf of a bucket snapshot: an object version and the
+// CID of its DAG, empty for empty objects.
+type SnapshotEntry struct {
+    Key       string `json:"key"`
+    VersionID string `json:"version_id"`
+    DAG       string `json:"dag"`
+}
+
+// BucketSnapshot is a signed commitment to every object version stored in
+// a bucket. Root is the Merkle root over the entries sorted by key and
+// version ID, and Previous the hash of the bucket's previous snapshot, so
+// that a rollback to older state or a rewritten history shows.
+type BucketSnapshot struct {
+    Bucket    string    `json:"bucket"`
+    Sequence  uint64    `json:"sequence"`
+    CreatedAt time.Time `json:"created_at"`
+    Objects   int       `json:"objects"`
+    Root      []byte    `json:"root"`
+    Previous  []byte    `json:"previous,omitempty"`
+    PublicKey []byte    `json:"public_key"`
+    Signature []byte    `json:"signature"`
+}
+
+// SnapshotProof shows that an object version is part of a snapshot.
+type SnapshotProof struct {
+    Snapshot BucketSnapshot `json:"snapshot"`
+    Entry    SnapshotEntry  `json:"entry"`
+    Proof    *dag.Proof     `json:"proof"`
+}
+
+// SignedMessage returns the bytes the snapshot signature covers.
+func (b *BucketSnapshot) SignedMessage() []byte {
+    return []byte(fmt.Sprintf("securedag-bucket-snapshot-v1\n%s\n%d\n%s\n%d\n%x\n%x\n",
+        b.Bucket, b.Sequence, b.CreatedAt.UTC().Format(time.RFC3339Nano), b.Objects, b.Root, b.Previous))
+}
+
+// Hash identifies the snapshot in the Previous field of its successor.
+func (b *BucketSnapshot) Hash() []byte {
+    sum := sha256.Sum256(append(b.SignedMessage(), b.Signature...))
+    return sum[:]
+}
+
+// Verify checks the snapshot's signature against publicKey.
+func (b *BucketSnapshot) Verify(publicKey []byte) bool {
+    return crypto.VerifySignature(publicKey, b.SignedMessage(), b.Signature)
+}
+
+// VerifySnapshotProof checks a proof against its snapshot and the node's
+// public key.
+func VerifySnapshotProof(p *SnapshotProof, publicKey []byte) bool {
+    if p == nil || p.Proof == nil || !p.Snapshot.Verify(publicKey) {
+        return false
+    }
+    return bytes.Equal(p.Proof.Leaf, SnapshotLeafHash(p.Entry)) && dag.VerifyProof(p.Snapshot.Root, p.Proof)
+}
+
+// SnapshotLeafHash hashes an entry with each field length-prefixed and a
+// domain prefix, so leaves cannot be confused with interior nodes.
+func SnapshotLeafHash(e SnapshotEntry) []byte {
+    h := sha256.New()
+    h.Write([]byte("securedag-snapshot-leaf\x00"))
+    for _, field := range []string{e.Key, e.VersionID, e.DAG} {
+        h.Write(binary.AppendUvarint(nil, uint64(len(field))))
+        h.Write([]byte(field))
+    }
+    return h.Sum(nil)
+}
+
+func snapshotPrefix(bucket string) []byte {
+    return []byte("snapshot/" + bucket + "/")
+}
+
+// snapshotKey zero-pads the sequence so snapshots sort in order.
+func snapshotKey(bucket string, seq uint64) []byte {
+    return []byte(fmt.Sprintf("snapshot/%s/%020d", bucket, seq))
+}
+
+func snapshotEntriesKey(bucket string, seq uint64) []byte {
+    return []byte(fmt.Sprintf("snapshotentries/%s/%020d", bucket, seq))
+}
+
+// SnapshotBucket commits to the current contents of a bucket and signs the
+// result. The entries are kept so that inclusion proofs can be produced
+// later.
+func (s *BadgerStore) SnapshotBucket(bucket string) (*BucketSnapshot, error) {
+    var snap *BucketSnapshot
+    err := s.db.Update(func(txn *badger.Txn) error {
+        entries, err := s.snapshotEntries(txn, bucket)
+        if err != nil {
+            return err
+        }
+        leaves := make([][]byte, len(entries))
+        for i, e := range entries {
+            leaves[i] = SnapshotLeafHash(e)
+        }
+
+        snap = &BucketSnapshot{
+            Bucket:    bucket,
+            Sequence:  1,
+            CreatedAt: time.Now().UTC(),
+            Objects:   len(entries),
+            PublicKey: s.signingKey.PublicKey(),
+        }
+        snap.Root = dag.RootHash(dag.BuildTree(leaves))
+        previous, found, err := latestSnapshot(txn, bucket)
+        if err != nil {
+            return err
+        }
+        if found {
+            snap.Sequence = previous.Sequence + 1
+            snap.Previous = previous.Hash()
+        }
+        snap.Signature = s.signingKey.Sign(snap.SignedMessage())
+
+        data, err := json.Marshal(snap)
+        if err != nil {
+            return err
+        }
+        if err := txn.Set(snapshotKey(bucket, snap.Sequence), data); err != nil {
+            return err
+        }
+        if data, err = json.Marshal(entries); err != nil {
+            return err
+        }
+        return txn.Set(snapshotEntriesKey(bucket, snap.Sequence), data)
+    })
+    return snap, err
+}
+
+// snapshotEntries lists every stored version of a bucket's objects, sorted
+// by key and version ID.
+func (s *BadgerStore) snapshotEntries(txn *badger.Txn, bucket string) ([]SnapshotEntry, error) {
+    entries := []SnapshotEntry{}
+    it := txn.NewIterator(badger.DefaultIteratorOptions)
+    defer it.Close()
+
+    prefix := objectMetaKey(bucket, "")
+    for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+        var info ObjectInfo
+        err := it.Item().Value(func(val []byte) error {
+            return json.Unmarshal(val, &info)
+        })
+        if err != nil {
+            return nil, err
+        }
+        var versions []SnapshotEntry
+        for i, v := range info.Versions {
+            if v.IsDeleteMarker {
+                continue
+            }
+            dataKey, keyKey := versionDataKeys(&info, i)
+            data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+            if err != nil {
+                return nil, err
+            }
+            m, err := s.openManifest(data, aesKey)
+            if err != nil {
+                return nil, err
+            }
+            versions = append(versions, SnapshotEntry{Key: info.Key, VersionID: v.VersionID, DAG: m.DAG})
+        }
+        sort.Slice(versions, func(i, j int) bool {
+            return versions[i].VersionID < versions[j].VersionID
+        })
+        entries = append(entries, versions...)
+    }
+    return entries, nil
+}
+
+func latestSnapshot(txn *badger.Txn, bucket string) (BucketSnapshot, bool, error) {
+    var snap BucketSnapshot
+    opts := badger.DefaultIteratorOptions
+    opts.Reverse = true
+    it := txn.NewIterator(opts)
+    defer it.Close()
+
+    // Seeking in reverse starts at the last key not above the seek key.
+    prefix := snapshotPrefix(bucket)
+    it.Seek(append(snapshotPrefix(bucket), 0xff))
+    if !it.ValidForPrefix(prefix) {
+        return snap, false, nil
+    }
+    err := it.Item().Value(func(val []byte) error {
+        return json.Unmarshal(val, &snap)
+    })
+    return snap, err == nil, err
+}
+
+// ListSnapshots returns the snapshot history of a bucket, oldest first.
+func (s *BadgerStore) ListSnapshots(bucket string) ([]BucketSnapshot, error) {
+    var snaps []BucketSnapshot
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := snapshotPrefix(bucket)
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            var snap BucketSnapshot
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &snap)
+            })
+            if err != nil {
+                return err
+            }
+            snaps = append(snaps, snap)
+        }
+        return nil
+    })
+    return snaps, err
+}
+
+// ProveSnapshotInclusion proves that an object version was part of a
+// snapshot. An empty versionID picks the key's only version in it; with
+// several the version must be named.
+func (s *BadgerStore) ProveSnapshotInclusion(bucket string, seq uint64, key, versionID string) (*SnapshotProof, error) {
+    var proof *SnapshotProof
+    err := s.db.View(func(txn *badger.Txn) error {
+        item, err := txn.Get(snapshotKey(bucket, seq))
+        if err == badger.ErrKeyNotFound {
+            return ErrNoSuchSnapshot
+        }
+        if err != nil {
+            return err
+        }
+        proof = &SnapshotProof{}
+        err = item.Value(func(val []byte) error {
+            return json.Unmarshal(val, &proof.Snapshot)
+        })
+        if err != nil {
+            return err
+        }
+        if item, err = txn.Get(snapshotEntriesKey(bucket, seq)); err != nil {
+            return err
+        }
+        var entries []SnapshotEntry
+        err = item.Value(func(val []byte) error {
+            return json.Unmarshal(val, &entries)
+        })
+        if err != nil {
+            return err
+        }
+
+        index := -1
+        for i, e := range entries {
+            if e.Key != key || (versionID != "" && e.VersionID != versionID) {
+                continue
+            }
+            if index >= 0 {
+                return errors.New("the key has several versions in this snapshot; name one")
+            }
+            index = i
+        }
+        if index < 0 {
+            return ErrNotFound
+        }
+        leaves := make([][]byte, len(entries))
+        for i, e := range entries {
+            leaves[i] = SnapshotLeafHash(e)
+        }
+        proof.Entry = entries[index]
+        proof.Proof, err = dag.Prove(dag.BuildTree(leaves), index)
+        return err
+    })
+    return proof, err
+}
+
+// SnapshotAllBuckets takes a snapshot of every bucket holding objects.
+func (s *BadgerStore) SnapshotAllBuckets() error {
+    buckets, err := s.objectBuckets()
+    if err != nil {
+        return err
+    }
+    for _, bucket := range buckets {
+        if _, err := s.SnapshotBucket(bucket); err != nil {
+            return fmt.Errorf("snapshot of %s: %w", bucket, err)
+        }
+    }
+    return nil
+}
+
+// objectBuckets lists the buckets with object metadata, skipping from one
+// bucket to the next rather than reading every key.
+func (s *BadgerStore) objectBuckets() ([]string, error) {
+    var buckets []string
+    err := s.db.View(func(txn *badger.Txn) error {
+        opts := badger.DefaultIteratorOptions
+        opts.PrefetchValues = false
+        it := txn.NewIterator(opts)
+        defer it.Close()
+
+        prefix := []byte("objmeta/")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); {
+            rest := bytes.TrimPrefix(it.Item().Key(), prefix)
+            slash := bytes.IndexByte(rest, '/')
+            if slash < 0 {
+                it.Next()
+                continue
+            }
+            bucket := string(rest[:slash])
+            buckets = append(buckets, bucket)
+            // '0' is the byte after '/', so this skips the bucket's keys.
+            it.Seek([]byte("objmeta/" + bucket + "0"))
+        }
+        return nil
+    })
+    return buckets, err
+}
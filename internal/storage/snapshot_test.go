@@ -0,0 +1,58 @@
+package storage
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestSnapshotBucket_HistoryAndProofs(t *testing.T) {
+    dir := t.TempDir()
+    store, err := NewBadgerStore(dir)
+    require.NoError(t, err)
+
+    for _, key := range []string{"a", "b", "c"} {
+        require.NoError(t, store.PutObject("bucket", key, []byte("data of "+key)))
+    }
+    require.NoError(t, store.PutObject("other", "x", nil))
+    buckets, err := store.objectBuckets()
+    require.NoError(t, err)
+    assert.Equal(t, []string{"bucket", "other"}, buckets)
+
+    first, err := store.SnapshotBucket("bucket")
+    require.NoError(t, err)
+    assert.Equal(t, uint64(1), first.Sequence)
+    assert.Equal(t, 3, first.Objects)
+    assert.True(t, first.Verify(store.SigningPublicKey()))
+
+    require.NoError(t, store.DeleteObject("bucket", "b", false))
+    publicKey := store.SigningPublicKey()
+    second, err := store.SnapshotBucket("bucket")
+    require.NoError(t, err)
+    assert.Equal(t, uint64(2), second.Sequence)
+    assert.Equal(t, first.Hash(), second.Previous)
+    assert.NotEqual(t, first.Root, second.Root)
+
+    // "b" is gone but can still be proven part of the first snapshot.
+    proof, err := store.ProveSnapshotInclusion("bucket", 1, "b", "")
+    require.NoError(t, err)
+    assert.True(t, VerifySnapshotProof(proof, publicKey))
+    _, err = store.ProveSnapshotInclusion("bucket", 2, "b", "")
+    assert.ErrorIs(t, err, ErrNotFound)
+
+    proof.Entry.DAG = ""
+    assert.False(t, VerifySnapshotProof(proof, publicKey))
+
+    snaps, err := store.ListSnapshots("bucket")
+    require.NoError(t, err)
+    require.Len(t, snaps, 2)
+    assert.Equal(t, second.Signature, snaps[1].Signature)
+
+    // The signing key survives a restart.
+    require.NoError(t, store.Close())
+    store, err = NewBadgerStore(dir)
+    require.NoError(t, err)
+    defer store.Close()
+    assert.Equal(t, publicKey, store.SigningPublicKey())
+}
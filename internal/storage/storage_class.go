@@ -0,0 +1,419 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+)
+
+// Storage classes, from hottest to coldest. STANDARD objects live in Badger.
+// STANDARD_IA objects live in the cold tier and are read back transparently;
+// GLACIER objects must be restored before they can be read.
+const (
+    StorageClassStandard   = "STANDARD"
+    StorageClassStandardIA = "STANDARD_IA"
+    StorageClassGlacier    = "GLACIER"
+)
+
+var (
+    ErrInvalidStorageClass = errors.New("invalid storage class")
+    ErrInvalidObjectState  = errors.New("object is archived and must be restored first")
+    ErrNoColdTier          = errors.New("no cold tier configured")
+)
+
+var storageClassRank = map[string]int{
+    StorageClassStandard:   0,
+    StorageClassStandardIA: 1,
+    StorageClassGlacier:    2,
+}
+
+// ValidStorageClass reports whether class is known. The empty string means
+// STANDARD.
+func ValidStorageClass(class string) bool {
+    _, ok := storageClassRank[class]
+    return ok || class == ""
+}
+
+// EffectiveStorageClass returns the storage class of the version, STANDARD if
+// none was set.
+func (v ObjectVersion) EffectiveStorageClass() string {
+    if v.StorageClass == "" {
+        return StorageClassStandard
+    }
+    return v.StorageClass
+}
+
+// IsColderStorageClass reports whether to is a colder class than from, so that
+// a transition from one to the other is allowed.
+func IsColderStorageClass(from, to string) bool {
+    return storageClassRank[to] > storageClassRank[from]
+}
+
+func isColdStorageClass(class string) bool {
+    return storageClassRank[class] > 0
+}
+
+func requiresRestore(class string) bool {
+    return class == StorageClassGlacier
+}
+
+// SetColdTier sets the backend that transitioned objects are moved to.
+func (s *BadgerStore) SetColdTier(tier ColdTier) {
+    s.coldTier = tier
+}
+
+func tierGarbageKey(tierKey string) []byte {
+    return []byte("tiergc/" + tierKey)
+}
+
+// putCold uploads ciphertext to the cold tier under a fresh name.
+func (s *BadgerStore) putCold(ctx context.Context, data []byte) (string, error) {
+    if s.coldTier == nil {
+        return "", ErrNoColdTier
+    }
+    name, err := newVersionID()
+    if err != nil {
+        return "", err
+    }
+    return name, s.coldTier.Put(ctx, name, data)
+}
+
+// moveVersionData moves the encrypted manifest and wrapped key of a version
+// between slots.
+func moveVersionData(txn *badger.Txn, fromData, fromKey, toData, toKey []byte) error {
+    if err := moveValue(txn, fromData, toData); err != nil {
+        return err
+    }
+    return moveValue(txn, fromKey, toKey)
+}
+
+// deleteVersionData removes the data of a version. Cold tier packs are
+// queued and removed by SweepColdTier once the transaction has committed.
+func deleteVersionData(txn *badger.Txn, v ObjectVersion, dataKey, aesKey []byte) error {
+    if v.TierKey != "" {
+        if err := txn.Set(tierGarbageKey(v.TierKey), []byte{}); err != nil {
+            return err
+        }
+    }
+    if err := txn.Delete(dataKey); err != nil {
+        return err
+    }
+    return txn.Delete(aesKey)
+}
+
+func restored(v ObjectVersion, now time.Time) bool {
+    return v.RestoredUntil != nil && now.Before(*v.RestoredUntil)
+}
+
+// inBadger reports whether the blocks of a version can be read from Badger.
+func inBadger(v ObjectVersion) bool {
+    return v.TierKey == "" || restored(v, time.Now())
+}
+
+// readVersion returns the encrypted manifest and wrapped key of version i.
+// It fails with ErrInvalidObjectState if the blocks are archived and not
+// restored.
+func readVersion(txn *badger.Txn, info *ObjectInfo, i int) (data, aesKey []byte, err error) {
+    v := info.Versions[i]
+    if !inBadger(v) && requiresRestore(v.StorageClass) {
+        return nil, nil, ErrInvalidObjectState
+    }
+    dataKey, keyKey := versionDataKeys(info, i)
+    return readEncrypted(txn, dataKey, keyKey)
+}
+
+// readObject reassembles the version chosen by pick by walking its DAG,
+// fetching the blocks from the cold tier if they have left Badger.
+func (s *BadgerStore) readObject(bucket, key string, pick func(*ObjectInfo) int) ([]byte, error) {
+    var m *Manifest
+    var leaves, blocks [][]byte
+    var tierKey string
+    err := s.db.View(func(txn *badger.Txn) error {
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        i := pick(info)
+        if i < 0 || info.Versions[i].IsDeleteMarker {
+            return ErrNotFound
+        }
+        data, aesKey, err := readVersion(txn, info, i)
+        if err != nil {
+            return err
+        }
+        if m, err = s.openManifest(data, aesKey); err != nil {
+            return err
+        }
+        if leaves, err = manifestLeaves(txn, m); err != nil {
+            return err
+        }
+        if !inBadger(info.Versions[i]) {
+            tierKey = info.Versions[i].TierKey
+            return nil
+        }
+        blocks, err = s.readBlocks(txn, leaves)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    if tierKey != "" {
+        if blocks, err = s.getCold(context.Background(), tierKey, m, leaves); err != nil {
+            return nil, err
+        }
+    }
+    return assemble(m, blocks)
+}
+
+// getCold fetches and unpacks the blocks of a version from the cold tier.
+func (s *BadgerStore) getCold(ctx context.Context, tierKey string, m *Manifest, leaves [][]byte) ([][]byte, error) {
+    if s.coldTier == nil {
+        return nil, ErrNoColdTier
+    }
+    pack, err := s.coldTier.Get(ctx, tierKey)
+    if err != nil {
+        return nil, err
+    }
+    return unpackBlocks(pack, m, leaves)
+}
+
+// versionBlocks returns the manifest and leaf hashes of a version, and its
+// blocks if they are held in Badger.
+func (s *BadgerStore) versionBlocks(txn *badger.Txn, info *ObjectInfo, i int) (*Manifest, [][]byte, [][]byte, error) {
+    dataKey, keyKey := versionDataKeys(info, i)
+    data, aesKey, err := readEncrypted(txn, dataKey, keyKey)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    m, err := s.openManifest(data, aesKey)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    leaves, err := manifestLeaves(txn, m)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    if !inBadger(info.Versions[i]) {
+        return m, leaves, nil, nil
+    }
+    blocks, err := s.readBlocks(txn, leaves)
+    return m, leaves, blocks, err
+}
+
+// TransitionObjectVersion moves an object version to a colder storage class.
+// Leaving STANDARD packs the version's blocks into one cold tier object; the
+// encrypted manifest stays in Badger.
+func (s *BadgerStore) TransitionObjectVersion(ctx context.Context, bucket, key, versionID, storageClass string) error {
+    if !isColdStorageClass(storageClass) {
+        return fmt.Errorf("%w: cannot transition to %q", ErrInvalidStorageClass, storageClass)
+    }
+    var current ObjectVersion
+    var blocks [][]byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        versionID, err := resolveVersion(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        i := info.find(versionID)
+        current = info.Versions[i]
+        if current.IsDeleteMarker {
+            return ErrNotFound
+        }
+        if current.TierKey != "" {
+            return nil
+        }
+        _, _, blocks, err = s.versionBlocks(txn, info, i)
+        return err
+    })
+    if err != nil {
+        return err
+    }
+    if storageClassRank[storageClass] < storageClassRank[current.StorageClass] {
+        return fmt.Errorf("%w: cannot transition from %s to %s", ErrInvalidStorageClass, current.StorageClass, storageClass)
+    }
+
+    tierKey := current.TierKey
+    if tierKey == "" {
+        if tierKey, err = s.putCold(ctx, packBlocks(blocks)); err != nil {
+            return err
+        }
+    }
+    e := translog.Entry{
+        Type:      translog.TypeVersion,
+        Bucket:    bucket,
+        Key:       key,
+        VersionID: current.VersionID,
+        Detail:    "storage-class=" + storageClass,
+    }
+    err = s.update(&e, func(txn *badger.Txn) error {
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        i := info.find(current.VersionID)
+        if i < 0 || info.Versions[i].TierKey != current.TierKey {
+            return ErrNotFound
+        }
+        v := &info.Versions[i]
+        v.StorageClass, v.TierKey = storageClass, tierKey
+        return s.putObjectInfo(txn, info)
+    })
+    if err != nil && current.TierKey == "" {
+        if derr := s.coldTier.Delete(ctx, tierKey); derr != nil {
+            log.Printf("Failed to remove cold copy %s: %v", tierKey, derr)
+        }
+    }
+    return err
+}
+
+// RestoreArchivedObject copies the blocks of an archived version back into
+// Badger and keeps it readable for the given number of days.
+func (s *BadgerStore) RestoreArchivedObject(ctx context.Context, bucket, key, versionID string, days int) error {
+    if days <= 0 {
+        return errors.New("restore days must be positive")
+    }
+    var current ObjectVersion
+    var m *Manifest
+    var leaves [][]byte
+    err := s.db.View(func(txn *badger.Txn) error {
+        versionID, err := resolveVersion(txn, bucket, key, versionID)
+        if err != nil {
+            return err
+        }
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        i := info.find(versionID)
+        current = info.Versions[i]
+        if current.IsDeleteMarker {
+            return ErrNotFound
+        }
+        if !requiresRestore(current.StorageClass) {
+            return ErrInvalidObjectState
+        }
+        m, leaves, _, err = s.versionBlocks(txn, info, i)
+        return err
+    })
+    if err != nil {
+        return err
+    }
+
+    blocks, err := s.getCold(ctx, current.TierKey, m, leaves)
+    if err != nil {
+        return err
+    }
+    if err := s.putBlocks(blocks); err != nil {
+        return err
+    }
+    until := time.Now().UTC().AddDate(0, 0, days)
+    return s.db.Update(func(txn *badger.Txn) error {
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        i := info.find(current.VersionID)
+        if i < 0 || info.Versions[i].TierKey != current.TierKey {
+            return ErrNotFound
+        }
+        info.Versions[i].RestoredUntil = &until
+        return s.putObjectInfo(txn, info)
+    })
+}
+
+// ExpireRestoredCopies ends the restore period of archived versions once it
+// has passed. Their blocks in Badger are left to garbage collection.
+func (s *BadgerStore) ExpireRestoredCopies(ctx context.Context) error {
+    now := time.Now()
+    type expiredCopy struct{ bucket, key, versionID string }
+    var expired []expiredCopy
+    err := s.db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        prefix := []byte("objmeta/")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            var info ObjectInfo
+            if err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &info)
+            }); err != nil {
+                return err
+            }
+            for _, v := range info.Versions {
+                if v.RestoredUntil != nil && !restored(v, now) {
+                    expired = append(expired, expiredCopy{info.Bucket, info.Key, v.VersionID})
+                }
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    for _, e := range expired {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        err := s.db.Update(func(txn *badger.Txn) error {
+            info, err := getObjectInfo(txn, e.bucket, e.key)
+            if err != nil {
+                return err
+            }
+            i := info.find(e.versionID)
+            if i < 0 || restored(info.Versions[i], time.Now()) {
+                return nil
+            }
+            info.Versions[i].RestoredUntil = nil
+            return s.putObjectInfo(txn, info)
+        })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// SweepColdTier deletes cold tier copies of versions that no longer exist.
+func (s *BadgerStore) SweepColdTier(ctx context.Context) error {
+    if s.coldTier == nil {
+        return nil
+    }
+    var names []string
+    err := s.db.View(func(txn *badger.Txn) error {
+        opts := badger.DefaultIteratorOptions
+        opts.PrefetchValues = false
+        it := txn.NewIterator(opts)
+        defer it.Close()
+
+        prefix := tierGarbageKey("")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            names = append(names, string(it.Item().Key()[len(prefix):]))
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    for _, name := range names {
+        if err := s.coldTier.Delete(ctx, name); err != nil {
+            return err
+        }
+        if err := s.db.Update(func(txn *badger.Txn) error {
+            return txn.Delete(tierGarbageKey(name))
+        }); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "sync"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestStorageClass_TransitionAndRestore(t *testing.T) {
+    ctx := context.Background()
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+    coldDir := t.TempDir()
+    tier, err := NewFilesystemTier(coldDir)
+    require.NoError(t, err)
+    store.SetColdTier(tier)
+
+    require.NoError(t, store.PutObject("b", "k", []byte("cold data")))
+    require.NoError(t, store.TransitionObjectVersion(ctx, "b", "k", "", StorageClassStandardIA))
+    info, err := store.GetObjectInfo("b", "k")
+    require.NoError(t, err)
+    assert.NotEmpty(t, info.Versions[0].TierKey)
+    data, err := store.GetObject("b", "k")
+    require.NoError(t, err)
+    assert.Equal(t, "cold data", string(data))
+
+    require.NoError(t, store.TransitionObjectVersion(ctx, "b", "k", "", StorageClassGlacier))
+    assert.ErrorIs(t, store.TransitionObjectVersion(ctx, "b", "k", "", StorageClassStandardIA), ErrInvalidStorageClass)
+    _, err = store.GetObject("b", "k")
+    assert.ErrorIs(t, err, ErrInvalidObjectState)
+    require.NoError(t, store.RestoreArchivedObject(ctx, "b", "k", "", 1))
+    data, err = store.GetObject("b", "k")
+    require.NoError(t, err)
+    assert.Equal(t, "cold data", string(data))
+
+    require.NoError(t, store.DeleteObject("b", "k", false))
+    require.NoError(t, store.SweepColdTier(ctx))
+    files, err := os.ReadDir(coldDir)
+    require.NoError(t, err)
+    assert.Empty(t, files)
+}
+
+func TestS3Tier_AgainstStub(t *testing.T) {
+    var mu sync.Mutex
+    objects := make(map[string][]byte)
+    stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        defer mu.Unlock()
+        switch r.Method {
+        case http.MethodPut:
+            body, _ := io.ReadAll(r.Body)
+            objects[r.URL.Path] = body
+        case http.MethodGet:
+            body, ok := objects[r.URL.Path]
+            if !ok {
+                w.WriteHeader(http.StatusNotFound)
+                io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+                return
+            }
+            w.Write(body)
+        case http.MethodDelete:
+            delete(objects, r.URL.Path)
+            w.WriteHeader(http.StatusNoContent)
+        }
+    }))
+    defer stub.Close()
+
+    ctx := context.Background()
+    tier := NewS3Tier(stub.URL, "us-east-1", "cold", "key", "secret")
+    require.NoError(t, tier.Put(ctx, "obj", []byte("payload")))
+    assert.Contains(t, objects, "/cold/obj")
+    data, err := tier.Get(ctx, "obj")
+    require.NoError(t, err)
+    assert.Equal(t, "payload", string(data))
+    require.NoError(t, tier.Delete(ctx, "obj"))
+    _, err = tier.Get(ctx, "obj")
+    assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ColdTier is a backend that holds the ciphertext of objects transitioned out
+// of Badger. Wrapped AES keys never leave Badger.
+type ColdTier interface {
+    Put(ctx context.Context, name string, data []byte) error
+    // Get returns ErrNotFound if the object does not exist.
+    Get(ctx context.Context, name string) ([]byte, error)
+    Delete(ctx context.Context, name string) error
+}
+
+// FilesystemTier stores cold objects as files below a local directory.
+type FilesystemTier struct {
+    dir string
+}
+
+func NewFilesystemTier(dir string) (*FilesystemTier, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, err
+    }
+    return &FilesystemTier{dir: dir}, nil
+}
+
+func (t *FilesystemTier) path(name string) (string, error) {
+    if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+        return "", errors.New("invalid cold tier object name")
+    }
+    return filepath.Join(t.dir, name), nil
+}
+
+func (t *FilesystemTier) Put(ctx context.Context, name string, data []byte) error {
+    path, err := t.path(name)
+    if err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+func (t *FilesystemTier) Get(ctx context.Context, name string) ([]byte, error) {
+    path, err := t.path(name)
+    if err != nil {
+        return nil, err
+    }
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, ErrNotFound
+    }
+    return data, err
+}
+
+func (t *FilesystemTier) Delete(ctx context.Context, name string) error {
+    path, err := t.path(name)
+    if err != nil {
+        return err
+    }
+    if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+    return nil
+}
+
+// S3Tier stores cold objects in a bucket of an external S3-compatible endpoint.
+type S3Tier struct {
+    client *s3.Client
+    bucket string
+}
+
+// NewS3Tier connects to endpoint with static credentials, using path-style
+// addressing so that MinIO and local stubs work.
+func NewS3Tier(endpoint, region, bucket, accessKey, secretKey string) *S3Tier {
+    client := s3.New(s3.Options{
+        BaseEndpoint: aws.String(endpoint),
+        Region:       region,
+        UsePathStyle: true,
+        // Plain request bodies keep simple S3-compatible servers working.
+        RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+        Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+            return aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey}, nil
+        }),
+    })
+    return &S3Tier{client: client, bucket: bucket}
+}
+
+func (t *S3Tier) Put(ctx context.Context, name string, data []byte) error {
+    _, err := t.client.PutObject(ctx, &s3.PutObjectInput{
+        Bucket: aws.String(t.bucket),
+        Key:    aws.String(name),
+        Body:   bytes.NewReader(data),
+    })
+    return err
+}
+
+func (t *S3Tier) Get(ctx context.Context, name string) ([]byte, error) {
+    out, err := t.client.GetObject(ctx, &s3.GetObjectInput{
+        Bucket: aws.String(t.bucket),
+        Key:    aws.String(name),
+    })
+    if err != nil {
+        var missing *types.NoSuchKey
+        if errors.As(err, &missing) {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    defer out.Body.Close()
+    return io.ReadAll(out.Body)
+}
+
+func (t *S3Tier) Delete(ctx context.Context, name string) error {
+    _, err := t.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+        Bucket: aws.String(t.bucket),
+        Key:    aws.String(name),
+    })
+    return err
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+    "crypto/rsa"
+    "log"
+    "strings"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/dgraph-io/badger/v4"
+)
+
+// TransparencyLog returns the log every change to the store is appended to.
+func (s *BadgerStore) TransparencyLog() *translog.Log {
+    return s.translog
+}
+
+// update runs fn in a read-write transaction and appends e, which fn may
+// fill in, to the transparency log in the same transaction, so that no
+// change commits without its entry. Subscribers are told of object changes
+// once they are committed.
+func (s *BadgerStore) update(e *translog.Entry, fn func(txn *badger.Txn) error) error {
+    if err := s.commitLogged(e, fn); err != nil {
+        return err
+    }
+    if e.Key != "" && e.Type != translog.TypePolicy {
+        s.notifyChange(Change{Bucket: e.Bucket, Key: e.Key})
+    }
+    return nil
+}
+
+// updateReplicated is update for a change adopted from another node. fn
+// leaves e without a type if it adopts nothing. Subscribers are not told of
+// the change again.
+func (s *BadgerStore) updateReplicated(e *translog.Entry, fn func(txn *badger.Txn) error) error {
+    return s.commitLogged(e, func(txn *badger.Txn) error {
+        if err := fn(txn); err != nil {
+            return err
+        }
+        e.Detail = strings.TrimSpace("replicated " + e.Detail)
+        return nil
+    })
+}
+
+func (s *BadgerStore) commitLogged(e *translog.Entry, fn func(txn *badger.Txn) error) error {
+    txn := s.db.NewTransaction(true)
+    defer txn.Discard()
+    if err := fn(txn); err != nil {
+        return err
+    }
+    if e.Type == "" {
+        return txn.Commit()
+    }
+    _, err := s.translog.Commit(txn, *e)
+    return err
+}
+
+// logKeyRotation records a rotation of the in-memory key pair. Nothing is
+// stored with it, so a failed append is only logged.
+func (s *BadgerStore) logKeyRotation(pub *rsa.PublicKey) {
+    fingerprint, err := crypto.PublicKeyFingerprint(pub)
+    if err != nil {
+        log.Printf("Failed to fingerprint rotated key: %v", err)
+    }
+    e := translog.Entry{Type: translog.TypeKeyRotation, Detail: fingerprint}
+    if _, err := s.translog.Append(e); err != nil {
+        log.Printf("Failed to append key rotation to transparency log: %v", err)
+    }
+}
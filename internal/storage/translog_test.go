@@ -0,0 +1,88 @@
+package storage
+
+import (
+    "context"
+    "strconv"
+    "sync"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/translog"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestTransparencyLog_RecordsMutations(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutBucketVersioning("bucket", VersioningEnabled))
+    v, err := store.PutObjectWithOptions("bucket", "a", []byte("data"), PutOptions{})
+    require.NoError(t, err)
+    require.NoError(t, store.PutObjectTagging("bucket", "a", "", map[string]string{"k": "v"}))
+    require.NoError(t, store.DeleteObject("bucket", "a", false))
+    require.NoError(t, store.DeleteObjectVersion(context.Background(), "bucket", "a", v.VersionID, false))
+    // Failed changes are not logged.
+    assert.Error(t, store.PutObjectLegalHold("bucket", "a", "", true))
+    store.logKeyRotation(&store.keyManager.GetPrivateKey().PublicKey)
+
+    tlog := store.TransparencyLog()
+    records, err := tlog.Entries(0, tlog.Size()-1)
+    require.NoError(t, err)
+    var got []string
+    for _, rec := range records {
+        got = append(got, rec.Entry.Type+" "+rec.Entry.Detail)
+    }
+    assert.Equal(t, []string{
+        "policy versioning=Enabled",
+        "put ",
+        "version tagging",
+        "delete delete-marker",
+        "delete ",
+        "key-rotation " + records[5].Entry.Detail,
+    }, got)
+    assert.Equal(t, v.VersionID, records[1].Entry.VersionID)
+    assert.Equal(t, v.VersionID, records[2].Entry.VersionID)
+    assert.Len(t, records[5].Entry.Detail, 64)
+
+    head, err := tlog.TreeHead()
+    require.NoError(t, err)
+    assert.True(t, head.Verify(store.SigningPublicKey()))
+    proof, err := tlog.InclusionProof(1, head.TreeSize)
+    require.NoError(t, err)
+    assert.True(t, translog.VerifyInclusion(translog.LeafHash(records[1].LeafInput), proof, head.RootHash))
+}
+
+func TestTransparencyLog_CommitsWithConcurrentMutations(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    const n = 16
+    var wg sync.WaitGroup
+    for i := 0; i < n; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            assert.NoError(t, store.PutObject("bucket", strconv.Itoa(i), []byte("data")))
+        }(i)
+    }
+    wg.Wait()
+
+    tlog := store.TransparencyLog()
+    require.Equal(t, uint64(n), tlog.Size())
+    records, err := tlog.Entries(0, n-1)
+    require.NoError(t, err)
+    keys := make(map[string]bool)
+    var leaves [][]byte
+    for _, rec := range records {
+        keys[rec.Entry.Key] = true
+        leaves = append(leaves, translog.LeafHash(rec.LeafInput))
+    }
+    assert.Len(t, keys, n)
+
+    // The stored subtrees add up to the root a replay of the log gives.
+    root, err := tlog.RootHash(n)
+    require.NoError(t, err)
+    assert.Equal(t, translog.TreeHash(leaves), root)
+}
@@ -2,13 +2,368 @@ package storage
 
 import (
     "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "strconv"
 
+    "github.com/Alyanaky/SecureDAG/internal/translog"
     "github.com/dgraph-io/badger/v4"
 )
 
-func (s *BadgerStore) DeleteObjectVersion(ctx context.Context, bucket, key, versionID string) error {
-    return s.db.Update(func(txn *badger.Txn) error {
-        versionKey := []byte(bucket + "/" + key + "/" + versionID)
-        return txn.Delete(versionKey)
+type VersioningStatus string
+
+const (
+    VersioningEnabled   VersioningStatus = "Enabled"
+    VersioningSuspended VersioningStatus = "Suspended"
+)
+
+func versioningKey(bucket string) []byte {
+    return []byte("versioning/" + bucket)
+}
+
+func newVersionID() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+func (status VersioningStatus) validate() error {
+    if status != VersioningEnabled && status != VersioningSuspended {
+        return errors.New("invalid versioning status")
+    }
+    return nil
+}
+
+func (s *BadgerStore) PutBucketVersioning(bucket string, status VersioningStatus) error {
+    if err := status.validate(); err != nil {
+        return err
+    }
+    return s.updatePolicy(bucket, PolicyVersioning, func(txn *badger.Txn) error {
+        return txn.Set(versioningKey(bucket), []byte(status))
+    }, translog.Entry{Type: translog.TypePolicy, Bucket: bucket, Detail: "versioning=" + string(status)})
+}
+
+func (s *BadgerStore) GetBucketVersioning(bucket string) (VersioningStatus, error) {
+    var status VersioningStatus
+    err := s.db.View(func(txn *badger.Txn) error {
+        var err error
+        status, err = getBucketVersioning(txn, bucket)
+        return err
     })
+    return status, err
+}
+
+func getBucketVersioning(txn *badger.Txn, bucket string) (VersioningStatus, error) {
+    item, err := txn.Get(versioningKey(bucket))
+    if err == badger.ErrKeyNotFound {
+        return "", nil
+    }
+    if err != nil {
+        return "", err
+    }
+    val, err := item.ValueCopy(nil)
+    return VersioningStatus(val), err
+}
+
+// dataPrefix holds all object data. Internal records never start with it, so
+// no bucket or key name can overwrite them.
+const dataPrefix = "data/"
+
+// nameKey appends each name to prefix, length-prefixed, so that no bucket,
+// key or ID can make the key read as another.
+func nameKey(prefix string, names ...string) []byte {
+    k := []byte(prefix)
+    for _, name := range names {
+        k = append(k, '/')
+        k = strconv.AppendInt(k, int64(len(name)), 10)
+        k = append(k, ':')
+        k = append(k, name...)
+    }
+    return k
+}
+
+// dataKey builds a key under dataPrefix. kind names what the key holds.
+func dataKey(kind string, names ...string) []byte {
+    return nameKey(dataPrefix+kind, names...)
+}
+
+// currentDataKeys returns the keys holding the ciphertext and wrapped AES key
+// of the newest version of an object.
+func currentDataKeys(bucket, key string) (data, aesKey []byte) {
+    return dataKey("current", bucket, key), dataKey("current-key", bucket, key)
+}
+
+// noncurrentDataKeys returns the keys holding an older version of an object.
+func noncurrentDataKeys(bucket, key, versionID string) (data, aesKey []byte) {
+    return dataKey("version", bucket, key, versionID), dataKey("version-key", bucket, key, versionID)
+}
+
+func versionDataKeys(info *ObjectInfo, i int) (data, aesKey []byte) {
+    if i == 0 {
+        return currentDataKeys(info.Bucket, info.Key)
+    }
+    return noncurrentDataKeys(info.Bucket, info.Key, info.Versions[i].VersionID)
+}
+
+// dataLayoutKey records the layout of the data keys. Stores without it keep
+// object data at its bare bucket and key; an empty value marks the layout
+// that joined them under dataPrefix with slashes. Layout 2 length-prefixed
+// the names, and layout 3 gave every trash entry its own ID.
+var dataLayoutKey = []byte("layout/data")
+
+const dataLayout = 3
+
+// legacyDataKeys returns where a store of layout 0 or 1 kept the data of an
+// object. suffix is empty for the current version, and otherwise the version
+// ID or "deleted" for the trash.
+func legacyDataKeys(layout int, bucket, key, suffix string) (data, aesKey []byte) {
+    base := bucket + "/" + key
+    if suffix != "" {
+        base += "/" + suffix
+    }
+    if layout > 0 {
+        base = dataPrefix + base
+    }
+    return []byte(base), []byte(base + "/key")
+}
+
+// legacyTrashKeys returns where a store of an older layout kept the data of
+// the single trash entry of a key.
+func legacyTrashKeys(layout int, bucket, key string) (data, aesKey []byte) {
+    if layout == 2 {
+        return dataKey("trash", bucket, key), dataKey("trash-key", bucket, key)
+    }
+    return legacyDataKeys(layout, bucket, key, "deleted")
+}
+
+func getDataLayout(txn *badger.Txn) (int, error) {
+    item, err := txn.Get(dataLayoutKey)
+    if err == badger.ErrKeyNotFound {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    val, err := item.ValueCopy(nil)
+    if err != nil || len(val) == 0 {
+        return 1, err
+    }
+    return strconv.Atoi(string(val))
+}
+
+// migrateDataKeys moves object data and trash entries written under an older
+// layout. It runs once per store; each version moves in its own transaction
+// so that large stores stay within Badger's transaction limits.
+func migrateDataKeys(db *badger.DB) error {
+    var layout int
+    err := db.View(func(txn *badger.Txn) error {
+        var err error
+        layout, err = getDataLayout(txn)
+        return err
+    })
+    if err != nil || layout >= dataLayout {
+        return err
+    }
+
+    var moves []func(txn *badger.Txn) error
+    err = db.View(func(txn *badger.Txn) error {
+        it := txn.NewIterator(badger.DefaultIteratorOptions)
+        defer it.Close()
+
+        // Versions have kept their keys since layout 2.
+        if layout < 2 {
+            prefix := []byte("objmeta/")
+            for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+                var info ObjectInfo
+                err := it.Item().Value(func(val []byte) error {
+                    return json.Unmarshal(val, &info)
+                })
+                if err != nil {
+                    return err
+                }
+                for i := range info.Versions {
+                    suffix := ""
+                    if i > 0 {
+                        suffix = info.Versions[i].VersionID
+                    }
+                    fromData, fromKey := legacyDataKeys(layout, info.Bucket, info.Key, suffix)
+                    toData, toKey := versionDataKeys(&info, i)
+                    moves = append(moves, func(txn *badger.Txn) error {
+                        return moveValues(txn, fromData, toData, fromKey, toKey)
+                    })
+                }
+            }
+        }
+
+        prefix := []byte("trash/")
+        for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+            var entry TrashEntry
+            err := it.Item().Value(func(val []byte) error {
+                return json.Unmarshal(val, &entry)
+            })
+            if err != nil {
+                return err
+            }
+            if entry.ID != "" {
+                continue
+            }
+            entry.ID = trashEntryID(entry.DeletedAt)
+            record, err := json.Marshal(entry)
+            if err != nil {
+                return err
+            }
+            oldEntryKey := it.Item().KeyCopy(nil)
+            fromData, fromKey := legacyTrashKeys(layout, entry.Bucket, entry.Key)
+            toData, toKey := trashDataKeys(entry.Bucket, entry.Key, entry.ID)
+            moves = append(moves, func(txn *badger.Txn) error {
+                if err := moveValues(txn, fromData, toData, fromKey, toKey); err != nil {
+                    return err
+                }
+                if err := txn.Delete(oldEntryKey); err != nil {
+                    return err
+                }
+                return txn.Set(trashEntryKey(entry.Bucket, entry.Key, entry.ID), record)
+            })
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    for _, move := range moves {
+        if err := db.Update(move); err != nil {
+            return err
+        }
+    }
+    return db.Update(func(txn *badger.Txn) error {
+        return txn.Set(dataLayoutKey, []byte(strconv.Itoa(dataLayout)))
+    })
+}
+
+// moveValues moves the value of each from key to the to key after it,
+// skipping keys that were never written.
+func moveValues(txn *badger.Txn, pairs ...[]byte) error {
+    for i := 0; i < len(pairs); i += 2 {
+        err := moveValue(txn, pairs[i], pairs[i+1])
+        if err != nil && err != badger.ErrKeyNotFound {
+            return err
+        }
+    }
+    return nil
+}
+
+func moveValue(txn *badger.Txn, from, to []byte) error {
+    item, err := txn.Get(from)
+    if err != nil {
+        return err
+    }
+    val, err := item.ValueCopy(nil)
+    if err != nil {
+        return err
+    }
+    if err := txn.Set(to, val); err != nil {
+        return err
+    }
+    return txn.Delete(from)
+}
+
+// retireLatest moves the data of the newest version out of the current slot so
+// that a new version can take its place.
+func retireLatest(txn *badger.Txn, info *ObjectInfo) error {
+    latest, ok := info.Latest()
+    if !ok || latest.IsDeleteMarker {
+        return nil
+    }
+    fromData, fromKey := currentDataKeys(info.Bucket, info.Key)
+    toData, toKey := noncurrentDataKeys(info.Bucket, info.Key, latest.VersionID)
+    return moveVersionData(txn, fromData, fromKey, toData, toKey)
+}
+
+// promoteLatest moves the data of the newest version into the current slot
+// after the version above it has been removed.
+func promoteLatest(txn *badger.Txn, info *ObjectInfo) error {
+    latest, ok := info.Latest()
+    if !ok || latest.IsDeleteMarker {
+        return nil
+    }
+    fromData, fromKey := noncurrentDataKeys(info.Bucket, info.Key, latest.VersionID)
+    toData, toKey := currentDataKeys(info.Bucket, info.Key)
+    return moveVersionData(txn, fromData, fromKey, toData, toKey)
+}
+
+// dropVersion permanently removes a version and its data, honouring object
+// lock. It reports ErrNotFound if the version does not exist.
+func dropVersion(txn *badger.Txn, info *ObjectInfo, versionID string, bypassGovernance bool) error {
+    i := info.find(versionID)
+    if i < 0 {
+        return ErrNotFound
+    }
+    version := info.Versions[i]
+    if !version.IsDeleteMarker {
+        if err := checkObjectLock(txn, info.Bucket, info.Key, version.VersionID, bypassGovernance); err != nil {
+            return err
+        }
+        dataKey, aesKey := versionDataKeys(info, i)
+        if err := deleteVersionData(txn, version, dataKey, aesKey); err != nil {
+            return err
+        }
+    }
+    if err := clearObjectLock(txn, info.Bucket, info.Key, version.VersionID); err != nil {
+        return err
+    }
+    info.Versions = append(info.Versions[:i], info.Versions[i+1:]...)
+    if i == 0 {
+        return promoteLatest(txn, info)
+    }
+    return nil
+}
+
+// DeleteObjectVersion permanently removes one version of an object, which may
+// be a delete marker.
+func (s *BadgerStore) DeleteObjectVersion(ctx context.Context, bucket, key, versionID string, bypassGovernance bool) error {
+    e := translog.Entry{
+        Type:      translog.TypeDelete,
+        Bucket:    bucket,
+        Key:       key,
+        VersionID: versionOrNull(versionID),
+    }
+    return s.update(&e, func(txn *badger.Txn) error {
+        info, err := getObjectInfo(txn, bucket, key)
+        if err != nil {
+            return err
+        }
+        if err := dropVersion(txn, info, versionID, bypassGovernance); err != nil {
+            return err
+        }
+        return s.putObjectInfo(txn, info)
+    })
+}
+
+// GetObjectVersion returns the plaintext of a specific object version.
+func (s *BadgerStore) GetObjectVersion(bucket, key, versionID string) ([]byte, error) {
+    return s.readObject(bucket, key, func(info *ObjectInfo) int {
+        return info.find(versionID)
+    })
+}
+
+func readEncrypted(txn *badger.Txn, dataKey, aesKey []byte) ([]byte, []byte, error) {
+    item, err := txn.Get(dataKey)
+    if err != nil {
+        return nil, nil, err
+    }
+    data, err := item.ValueCopy(nil)
+    if err != nil {
+        return nil, nil, err
+    }
+    item, err = txn.Get(aesKey)
+    if err != nil {
+        return nil, nil, err
+    }
+    key, err := item.ValueCopy(nil)
+    return data, key, err
 }
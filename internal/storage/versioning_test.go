@@ -0,0 +1,156 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "strconv"
+    "testing"
+
+    "github.com/dgraph-io/badger/v4"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPutObject_CannotOverwriteInternalRecords(t *testing.T) {
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutObject("b", "k", []byte("original")))
+    require.NoError(t, store.PutObject("objmeta", "b/k", []byte("forged")))
+
+    data, err := store.GetObject("b", "k")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("original"), data)
+    data, err = store.GetObject("objmeta", "b/k")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("forged"), data)
+}
+
+func TestPutObject_NamesCannotReachOtherObjectsData(t *testing.T) {
+    ctx := context.Background()
+    store, err := NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    defer store.Close()
+
+    require.NoError(t, store.PutBucketVersioning("b", VersioningEnabled))
+    require.NoError(t, store.PutObject("b", "k", []byte("v1")))
+    require.NoError(t, store.PutObject("b", "k", []byte("v2")))
+    require.NoError(t, store.PutObject("b", "gone", []byte("trashed")))
+    require.NoError(t, store.SoftDeleteObject(ctx, "b", "gone", "", false))
+    info, err := store.GetObjectInfo("b", "k")
+    require.NoError(t, err)
+    older := info.Versions[1].VersionID
+
+    require.NoError(t, store.PutObject("b", "k/key", []byte("forged key")))
+    require.NoError(t, store.PutObject("b", "k/"+older, []byte("forged version")))
+    require.NoError(t, store.PutObject("b", "gone/deleted", []byte("forged trash")))
+    require.NoError(t, store.PutObject("b/k", "", []byte("forged bucket")))
+
+    data, err := store.GetObject("b", "k")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("v2"), data)
+    data, err = store.GetObjectVersion("b", "k", older)
+    require.NoError(t, err)
+    assert.Equal(t, []byte("v1"), data)
+    require.NoError(t, store.RestoreObject(ctx, "b", "gone", ""))
+    data, err = store.GetObject("b", "gone")
+    require.NoError(t, err)
+    assert.Equal(t, []byte("trashed"), data)
+}
+
+func TestNewBadgerStore_MigratesLegacyDataKeys(t *testing.T) {
+    ctx := context.Background()
+    for layout := 0; layout < dataLayout; layout++ {
+        dir := t.TempDir()
+        store, err := NewBadgerStore(dir)
+        require.NoError(t, err)
+        require.NoError(t, store.PutBucketVersioning("b", VersioningEnabled))
+        require.NoError(t, store.PutObject("b", "k", []byte("v1")))
+        require.NoError(t, store.PutObject("b", "k", []byte("v2")))
+        require.NoError(t, store.PutObject("t", "gone", []byte("trashed")))
+        require.NoError(t, store.SoftDeleteObject(ctx, "t", "gone", "", false))
+
+        // Put the data back where stores of the older layout kept it.
+        require.NoError(t, store.db.Update(func(txn *badger.Txn) error {
+            info, err := getObjectInfo(txn, "b", "k")
+            if err != nil {
+                return err
+            }
+            for i := range info.Versions {
+                if layout >= 2 {
+                    break
+                }
+                suffix := ""
+                if i > 0 {
+                    suffix = info.Versions[i].VersionID
+                }
+                data, aesKey := versionDataKeys(info, i)
+                oldData, oldKey := legacyDataKeys(layout, "b", "k", suffix)
+                if err := moveValues(txn, data, oldData, aesKey, oldKey); err != nil {
+                    return err
+                }
+            }
+
+            entry, err := getTrashEntry(txn, "t", "gone", "")
+            if err != nil {
+                return err
+            }
+            data, aesKey := trashDataKeys("t", "gone", entry.ID)
+            oldData, oldKey := legacyTrashKeys(layout, "t", "gone")
+            if err := moveValues(txn, data, oldData, aesKey, oldKey); err != nil {
+                return err
+            }
+            if err := txn.Delete(trashEntryKey("t", "gone", entry.ID)); err != nil {
+                return err
+            }
+            entry.ID = ""
+            record, err := json.Marshal(entry)
+            if err != nil {
+                return err
+            }
+            if err := txn.Set([]byte("trash/t/gone"), record); err != nil {
+                return err
+            }
+            if layout == 0 {
+                return txn.Delete(dataLayoutKey)
+            }
+            if layout == 1 {
+                return txn.Set(dataLayoutKey, []byte{})
+            }
+            return txn.Set(dataLayoutKey, []byte(strconv.Itoa(layout)))
+        }))
+        require.NoError(t, store.Close())
+
+        store, err = NewBadgerStore(dir)
+        require.NoError(t, err)
+        require.NoError(t, store.db.View(func(txn *badger.Txn) error {
+            info, err := getObjectInfo(txn, "b", "k")
+            require.NoError(t, err)
+            require.Len(t, info.Versions, 2)
+            for i := range info.Versions {
+                data, aesKey := versionDataKeys(info, i)
+                for _, key := range [][]byte{data, aesKey} {
+                    _, err = txn.Get(key)
+                    assert.NoError(t, err)
+                }
+            }
+
+            entry, err := getTrashEntry(txn, "t", "gone", "")
+            require.NoError(t, err)
+            assert.NotEmpty(t, entry.ID)
+            data, aesKey := trashDataKeys("t", "gone", entry.ID)
+            oldData, oldKey := legacyTrashKeys(layout, "t", "gone")
+            for _, key := range [][]byte{data, aesKey} {
+                _, err = txn.Get(key)
+                assert.NoError(t, err)
+            }
+            for _, key := range [][]byte{oldData, oldKey, []byte("trash/t/gone")} {
+                _, err = txn.Get(key)
+                assert.ErrorIs(t, err, badger.ErrKeyNotFound)
+            }
+            return nil
+        }))
+        require.NoError(t, store.Close())
+    }
+}
@@ -0,0 +1,324 @@
+// Package sync keeps the nodes of a cluster consistent: changes are
+// broadcast by gossip as they happen, and anti-entropy repairs whatever
+// gossip missed.
+package sync
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math/rand/v2"
+    gosync "sync"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Protocol is the stream protocol nodes compare summaries and send entries
+// over.
+const Protocol = protocol.ID("/" + p2p.Namespace + "/antientropy/1.0.0")
+
+const (
+    // DefaultInterval is how often a node reconciles with a random peer.
+    DefaultInterval = time.Minute
+    // EntryBatchSize is how many entries are requested at once.
+    EntryBatchSize = 64
+
+    maxMessage    = 64 << 20
+    streamTimeout = time.Minute
+)
+
+var ErrMalformedReply = errors.New("malformed anti-entropy reply")
+
+// Report describes one reconciliation with a peer.
+type Report struct {
+    // Ranges is the number of leaf key ranges that differed.
+    Ranges int `json:"ranges"`
+    // Differing is the number of entries the peer holds in another state.
+    Differing int `json:"differing"`
+    // Applied is the number of the peer's entries that were newer and
+    // adopted.
+    Applied int `json:"applied"`
+    // Failed is the number of entries that could not be adopted, such as
+    // when the peer could not supply their blocks.
+    Failed int `json:"failed"`
+}
+
+// AntiEntropy converges object metadata and blocks between nodes. Each
+// round it walks the summary trees of the local node and a peer from the
+// root, descending only into ranges whose hashes differ, and pulls the
+// peer's entries that are missing or newer locally. Every node pulls from
+// its peers in turn, so changes flow both ways.
+type AntiEntropy struct {
+    host     host.Host
+    store    *storage.BadgerStore
+    exchange *p2p.Exchange
+
+    mu      gosync.Mutex
+    members func(peer.ID) bool
+}
+
+// NewAntiEntropy serves the summaries and entries of store to the peers of
+// n, and fetches missing blocks through exchange.
+func NewAntiEntropy(n *p2p.Node, store *storage.BadgerStore, exchange *p2p.Exchange) *AntiEntropy {
+    a := &AntiEntropy{host: n.Host, store: store, exchange: exchange}
+    a.host.SetStreamHandler(Protocol, a.handleStream)
+    return a
+}
+
+// Close stops answering peers.
+func (a *AntiEntropy) Close() {
+    a.host.RemoveStreamHandler(Protocol)
+}
+
+// SetMembers makes the node answer the peers that members reports as part
+// of the cluster. Entries carry the keys of object manifests, so until it
+// is called no peer is answered.
+func (a *AntiEntropy) SetMembers(members func(peer.ID) bool) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.members = members
+}
+
+func (a *AntiEntropy) isMember(p peer.ID) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return a.members != nil && a.members(p)
+}
+
+// Run reconciles with one of peers each interval until ctx is cancelled.
+func (a *AntiEntropy) Run(ctx context.Context, peers func() []peer.ID, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        candidates := peers()
+        if len(candidates) == 0 {
+            continue
+        }
+        p := candidates[rand.IntN(len(candidates))]
+        report, err := a.SyncWith(ctx, p)
+        if err != nil {
+            if ctx.Err() == nil {
+                log.Printf("Anti-entropy with %s failed: %v", p, err)
+            }
+            continue
+        }
+        if report.Differing > 0 {
+            log.Printf("Anti-entropy with %s: %d entries differed in %d ranges, applied %d, failed %d",
+                p, report.Differing, report.Ranges, report.Applied, report.Failed)
+        }
+    }
+}
+
+// SyncWith pulls the entries of p that are missing or newer locally.
+func (a *AntiEntropy) SyncWith(ctx context.Context, p peer.ID) (Report, error) {
+    var report Report
+    digests, err := a.store.SyncDigests(ctx)
+    if err != nil {
+        return report, err
+    }
+    local := BuildTree(digests)
+
+    paths := []string{""}
+    for depth := 0; depth < TreeDepth && len(paths) > 0; depth++ {
+        reply, err := a.request(ctx, p, request{Type: requestTree, Paths: paths})
+        if err != nil {
+            return report, err
+        }
+        var next []string
+        for _, path := range paths {
+            remote := reply.Children[path]
+            if len(remote) != TreeFanout {
+                return report, ErrMalformedReply
+            }
+            for i, h := range local.Children(path) {
+                if !bytes.Equal(h, remote[i]) {
+                    next = append(next, path+hexDigits[i:i+1])
+                }
+            }
+        }
+        paths = next
+    }
+    report.Ranges = len(paths)
+    if len(paths) == 0 {
+        return report, nil
+    }
+
+    reply, err := a.request(ctx, p, request{Type: requestTree, Paths: paths})
+    if err != nil {
+        return report, err
+    }
+    var names []string
+    for _, path := range paths {
+        have := make(map[string][]byte)
+        for _, d := range local.Entries(path) {
+            have[d.Name] = d.Digest
+        }
+        for _, d := range reply.Entries[path] {
+            if !bytes.Equal(have[d.Name], d.Digest) {
+                names = append(names, d.Name)
+            }
+        }
+    }
+    report.Differing = len(names)
+
+    for len(names) > 0 {
+        batch := names[:min(EntryBatchSize, len(names))]
+        names = names[len(batch):]
+        records, err := a.fetchEntries(ctx, p, batch)
+        if err != nil {
+            return report, err
+        }
+        for _, e := range records {
+            applied, err := adopt(ctx, a.store, a.exchange, p, e)
+            switch {
+            case err != nil:
+                log.Printf("Failed to reconcile %s with %s: %v", e.Name(), p, err)
+                report.Failed++
+            case applied:
+                report.Applied++
+            }
+        }
+    }
+    return report, ctx.Err()
+}
+
+// fetchEntries requests the entries of p named by names, at most
+// EntryBatchSize of them.
+func (a *AntiEntropy) fetchEntries(ctx context.Context, p peer.ID, names []string) ([]storage.SyncEntry, error) {
+    reply, err := a.request(ctx, p, request{Type: requestEntries, Names: names})
+    return reply.Records, err
+}
+
+// adopt stores the blocks of e that are missing locally, fetching them
+// from p, and adopts e if it is newer.
+func adopt(ctx context.Context, store *storage.BadgerStore, exchange *p2p.Exchange, p peer.ID, e storage.SyncEntry) (bool, error) {
+    missing, err := store.MissingBlocks(e.Blocks)
+    if err != nil {
+        return false, err
+    }
+    if len(missing) > 0 {
+        blocks, err := exchange.FetchBlocks(ctx, p, missing)
+        if err != nil {
+            return false, err
+        }
+        for _, c := range missing {
+            data, ok := blocks[c]
+            if !ok {
+                return false, fmt.Errorf("%w: peer lacks %s", storage.ErrMissingBlocks, c)
+            }
+            if err := store.PutBlock(ctx, c, data); err != nil {
+                return false, err
+            }
+        }
+    }
+    return store.ApplySyncEntry(ctx, e)
+}
+
+type requestType string
+
+const (
+    requestTree    requestType = "tree"
+    requestEntries requestType = "entries"
+)
+
+// request asks for the child hashes of inner ranges or the digests of leaf
+// ranges, or for full entries by name.
+type request struct {
+    Type  requestType `json:"type"`
+    Paths []string    `json:"paths,omitempty"`
+    Names []string    `json:"names,omitempty"`
+}
+
+type reply struct {
+    Children map[string][][]byte             `json:"children,omitempty"`
+    Entries  map[string][]storage.SyncDigest `json:"entries,omitempty"`
+    Records  []storage.SyncEntry             `json:"records,omitempty"`
+}
+
+func (a *AntiEntropy) request(ctx context.Context, p peer.ID, req request) (reply, error) {
+    s, err := a.host.NewStream(ctx, p, Protocol)
+    if err != nil {
+        return reply{}, err
+    }
+    defer s.Close()
+    stop := context.AfterFunc(ctx, func() { s.Reset() })
+    defer stop()
+
+    if err := json.NewEncoder(s).Encode(req); err != nil {
+        s.Reset()
+        return reply{}, err
+    }
+    s.CloseWrite()
+    var r reply
+    err = json.NewDecoder(io.LimitReader(s, maxMessage)).Decode(&r)
+    if ctx.Err() != nil {
+        err = ctx.Err()
+    }
+    return r, err
+}
+
+func (a *AntiEntropy) handleStream(s network.Stream) {
+    defer s.Close()
+    if !a.isMember(s.Conn().RemotePeer()) {
+        s.Reset()
+        return
+    }
+    s.SetDeadline(time.Now().Add(streamTimeout))
+    var req request
+    if err := json.NewDecoder(io.LimitReader(s, maxMessage)).Decode(&req); err != nil {
+        s.Reset()
+        return
+    }
+    r, err := a.answer(req)
+    if err != nil {
+        log.Printf("Answering anti-entropy request from %s failed: %v", s.Conn().RemotePeer(), err)
+        s.Reset()
+        return
+    }
+    if err := json.NewEncoder(s).Encode(r); err != nil {
+        s.Reset()
+    }
+}
+
+func (a *AntiEntropy) answer(req request) (reply, error) {
+    switch req.Type {
+    case requestTree:
+        digests, err := a.store.SyncDigests(context.Background())
+        if err != nil {
+            return reply{}, err
+        }
+        t := BuildTree(digests)
+        r := reply{Children: make(map[string][][]byte), Entries: make(map[string][]storage.SyncDigest)}
+        for _, path := range req.Paths {
+            switch {
+            case len(path) < TreeDepth:
+                r.Children[path] = t.Children(path)
+            case len(path) == TreeDepth:
+                r.Entries[path] = t.Entries(path)
+            }
+        }
+        return r, nil
+    case requestEntries:
+        if len(req.Names) > EntryBatchSize {
+            return reply{}, fmt.Errorf("%d entries requested at once", len(req.Names))
+        }
+        records, err := a.store.SyncEntries(req.Names)
+        return reply{Records: records}, err
+    }
+    return reply{}, fmt.Errorf("unknown request type %q", req.Type)
+}
@@ -0,0 +1,130 @@
+package sync
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+type testNode struct {
+    node   *p2p.Node
+    store  *storage.BadgerStore
+    sync   *AntiEntropy
+    gossip *Gossip
+}
+
+// startTestNode starts a node with anti-entropy. Gossip is set up but
+// not run.
+func startTestNode(t *testing.T, ctx context.Context, bootstrap ...string) *testNode {
+    t.Helper()
+    store, err := storage.NewBadgerStore(t.TempDir())
+    require.NoError(t, err)
+    t.Cleanup(func() { store.Close() })
+    n, err := p2p.NewNode(ctx, p2p.Config{
+        Identity:       store.PeerKey(),
+        ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+        BootstrapPeers: bootstrap,
+    })
+    require.NoError(t, err)
+    t.Cleanup(func() { n.Close() })
+    exchange := p2p.NewExchange(n, store)
+    exchange.SetMembers(func(peer.ID) bool { return true })
+    t.Cleanup(exchange.Close)
+    a := NewAntiEntropy(n, store, exchange)
+    t.Cleanup(a.Close)
+    a.SetMembers(func(peer.ID) bool { return true })
+    pubsub, err := p2p.NewPubSub(ctx, n)
+    require.NoError(t, err)
+    t.Cleanup(pubsub.Close)
+    pubsub.SetMembers(func(peer.ID) bool { return true })
+    g, err := NewGossip(pubsub, store, a)
+    require.NoError(t, err)
+    return &testNode{node: n, store: store, sync: a, gossip: g}
+}
+
+func TestAntiEntropy_Reconciles(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    a := startTestNode(t, ctx)
+    b := startTestNode(t, ctx, a.node.Addrs()...)
+
+    big := make([]byte, 600<<10)
+    for i := range big {
+        big[i] = byte(i * 7)
+    }
+    require.NoError(t, a.store.PutObject("b", "only-a", big))
+    require.NoError(t, b.store.PutObject("b", "only-b", []byte("written on b")))
+
+    // Conflicting writes resolve to the later one.
+    require.NoError(t, a.store.PutObject("b", "shared", []byte("older")))
+    time.Sleep(2 * time.Millisecond)
+    require.NoError(t, b.store.PutObject("b", "shared", []byte("newer")))
+
+    // Deletes win over the writes they followed, and are not undone by the
+    // node that missed them.
+    require.NoError(t, b.store.PutObject("b", "gone", []byte("deleted on a")))
+    require.NoError(t, b.store.PutObject("v", "marked", []byte("hidden on a")))
+    time.Sleep(2 * time.Millisecond)
+    require.NoError(t, a.store.PutObject("b", "gone", []byte("deleted on a")))
+    require.NoError(t, a.store.DeleteObject("b", "gone", false))
+    require.NoError(t, a.store.PutBucketVersioning("v", storage.VersioningEnabled))
+    require.NoError(t, a.store.DeleteObject("v", "marked", false))
+
+    report, err := a.sync.SyncWith(ctx, b.node.Host.ID())
+    require.NoError(t, err)
+    assert.Equal(t, 2, report.Applied)
+    assert.Less(t, report.Ranges, TreeFanout*TreeFanout*TreeFanout)
+    report, err = b.sync.SyncWith(ctx, a.node.Host.ID())
+    require.NoError(t, err)
+    assert.Equal(t, 3, report.Applied)
+    assert.Zero(t, report.Failed)
+
+    digestsA, err := a.store.SyncDigests(ctx)
+    require.NoError(t, err)
+    digestsB, err := b.store.SyncDigests(ctx)
+    require.NoError(t, err)
+    assert.Equal(t, digestsA, digestsB)
+
+    for _, n := range []*testNode{a, b} {
+        data, err := n.store.GetObject("b", "only-a")
+        require.NoError(t, err)
+        assert.Equal(t, big, data)
+        data, err = n.store.GetObject("b", "only-b")
+        require.NoError(t, err)
+        assert.Equal(t, []byte("written on b"), data)
+        data, err = n.store.GetObject("b", "shared")
+        require.NoError(t, err)
+        assert.Equal(t, []byte("newer"), data)
+        _, err = n.store.GetObject("b", "gone")
+        assert.Error(t, err)
+        _, err = n.store.GetObject("v", "marked")
+        assert.Error(t, err)
+    }
+
+    // Once converged, only the roots are compared.
+    report, err = a.sync.SyncWith(ctx, b.node.Host.ID())
+    require.NoError(t, err)
+    assert.Zero(t, report.Ranges)
+}
+
+func TestAntiEntropy_AnswersOnlyMembers(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    a := startTestNode(t, ctx)
+    b := startTestNode(t, ctx, a.node.Addrs()...)
+    require.NoError(t, a.store.PutObject("b", "secret", []byte("keys inside")))
+
+    a.sync.SetMembers(func(p peer.ID) bool { return p != b.node.Host.ID() })
+    _, err := b.sync.SyncWith(ctx, a.node.Host.ID())
+    assert.Error(t, err)
+    _, err = b.store.GetObject("b", "secret")
+    assert.ErrorIs(t, err, storage.ErrNotFound)
+}
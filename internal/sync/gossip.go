@@ -1,22 +1,205 @@
 package sync
 
 import (
+    "bytes"
     "context"
+    "encoding/json"
+    "errors"
     "log"
+    "time"
 
+    "github.com/Alyanaky/SecureDAG/internal/hlc"
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
     "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/libp2p/go-libp2p/core/peer"
 )
 
-type GossipSync struct {
-    storage *storage.BadgerStore
+// ClusterTopic carries changes to buckets no peer subscribes to yet, such
+// as the first object of a new bucket. Every node subscribes to it.
+const ClusterTopic = "buckets"
+
+const (
+    // changeQueue is how many local changes may wait to be broadcast.
+    // Changes beyond it are dropped and left to anti-entropy.
+    changeQueue = 1024
+
+    applyTimeout = time.Minute
+)
+
+// BucketTopic returns the topic changes to bucket are published to.
+func BucketTopic(bucket string) string {
+    return "bucket/" + bucket
+}
+
+// Update is a change broadcast to the cluster: the new state of an object
+// or of a bucket policy. Stamp is when the publishing node sent it. Object
+// entries go without their manifest and blocks; receivers pull those from
+// the publisher over anti-entropy, which answers only cluster members.
+type Update struct {
+    Stamp  hlc.Timestamp         `json:"stamp"`
+    Object *storage.SyncEntry    `json:"object,omitempty"`
+    Policy *storage.PolicyUpdate `json:"policy,omitempty"`
 }
 
-func NewGossipSync(storage *storage.BadgerStore) *GossipSync {
-    return &GossipSync{storage: storage}
+func (u Update) bucket() string {
+    if u.Object != nil {
+        return u.Object.Bucket
+    }
+    if u.Policy != nil {
+        return u.Policy.Bucket
+    }
+    return ""
+}
+
+// Gossip broadcasts the changes made on this node to the others within
+// seconds, and applies theirs. Each bucket has its own topic, which a node
+// subscribes to once it has heard of the bucket. Updates are applied only
+// if newer than the local state, so duplicates and stale updates arriving
+// out of order change nothing.
+type Gossip struct {
+    pubsub  *p2p.PubSub
+    store   *storage.BadgerStore
+    sync    *AntiEntropy
+    changes chan storage.Change
+}
+
+// NewGossip subscribes to the cluster topic and the topics of the buckets
+// store holds, and queues the changes made to store for Run to publish.
+// Objects announced by other nodes are pulled through sync.
+func NewGossip(pubsub *p2p.PubSub, store *storage.BadgerStore, sync *AntiEntropy) (*Gossip, error) {
+    g := &Gossip{
+        pubsub:  pubsub,
+        store:   store,
+        sync:    sync,
+        changes: make(chan storage.Change, changeQueue),
+    }
+    buckets, err := store.Buckets()
+    if err != nil {
+        return nil, err
+    }
+    if err := pubsub.Subscribe(ClusterTopic, g.handle); err != nil {
+        return nil, err
+    }
+    for _, bucket := range buckets {
+        g.subscribe(bucket)
+    }
+    store.SubscribeChanges(g.enqueue)
+    return g, nil
+}
+
+func (g *Gossip) subscribe(bucket string) {
+    if topic := BucketTopic(bucket); !g.pubsub.Subscribed(topic) {
+        if err := g.pubsub.Subscribe(topic, g.handle); err != nil {
+            log.Printf("Subscribing to %s failed: %v", topic, err)
+        }
+    }
+}
+
+func (g *Gossip) enqueue(c storage.Change) {
+    select {
+    case g.changes <- c:
+    default:
+        log.Printf("Gossip queue full, dropping change to %s/%s%s", c.Bucket, c.Key, c.Policy)
+    }
+}
+
+// Run publishes queued changes until ctx is cancelled.
+func (g *Gossip) Run(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case c := <-g.changes:
+            if err := g.publish(ctx, c); err != nil && ctx.Err() == nil {
+                log.Printf("Publishing change to %s/%s%s failed: %v", c.Bucket, c.Key, c.Policy, err)
+            }
+        }
+    }
+}
+
+// publish broadcasts the current state of what c changed. If no peer
+// subscribes to the bucket yet, the update goes to the cluster topic.
+func (g *Gossip) publish(ctx context.Context, c storage.Change) error {
+    u := Update{Stamp: g.store.Clock().Now()}
+    if c.Key != "" {
+        entries, err := g.store.SyncEntries([]string{c.Bucket + "/" + c.Key})
+        if err != nil {
+            return err
+        }
+        if len(entries) == 0 {
+            return nil
+        }
+        u.Object = &entries[0]
+        u.Object.Manifest, u.Object.Blocks = nil, nil
+    } else {
+        policy, err := g.store.GetPolicyUpdate(c.Bucket, c.Policy)
+        if err != nil {
+            return err
+        }
+        u.Policy = &policy
+    }
+    data, err := json.Marshal(u)
+    if err != nil {
+        return err
+    }
+    g.subscribe(c.Bucket)
+    topic := BucketTopic(c.Bucket)
+    if len(g.pubsub.Subscribers(topic)) == 0 {
+        topic = ClusterTopic
+    }
+    return g.pubsub.Publish(ctx, topic, data)
+}
+
+// handle applies an update published by another node. The entry and
+// blocks of an object are fetched from the publisher, which holds them.
+func (g *Gossip) handle(m *p2p.Message) {
+    var u Update
+    if err := json.Unmarshal(m.Data, &u); err != nil {
+        log.Printf("Malformed update from %s: %v", m.From, err)
+        return
+    }
+    bucket := u.bucket()
+    if bucket == "" {
+        return
+    }
+    if _, err := g.store.Clock().Update(u.Stamp); err != nil {
+        log.Printf("Dropping update from %s: %v", m.From, err)
+        return
+    }
+    g.subscribe(bucket)
+
+    ctx, cancel := context.WithTimeout(context.Background(), applyTimeout)
+    defer cancel()
+    var err error
+    switch {
+    case u.Object != nil:
+        err = g.pull(ctx, m.From, *u.Object)
+    case u.Policy != nil:
+        _, err = g.store.ApplyPolicyUpdate(*u.Policy)
+    }
+    if err != nil && !errors.Is(err, context.Canceled) {
+        log.Printf("Applying update to %s from %s failed: %v", bucket, m.From, err)
+    }
 }
 
-func (g *GossipSync) Sync(ctx context.Context, key string, data []byte) error {
-    // Заглушка для синхронизации через gossip
-    log.Printf("Syncing key %s", key)
-    return g.storage.PutObject("sync", key, data)
+// pull adopts the entry of p that e announced, unless the local state of
+// the object already matches it.
+func (g *Gossip) pull(ctx context.Context, p peer.ID, e storage.SyncEntry) error {
+    local, err := g.store.SyncEntries([]string{e.Name()})
+    if err != nil {
+        return err
+    }
+    if len(local) == 1 && bytes.Equal(local[0].Digest(), e.Digest()) {
+        return nil
+    }
+    entries, err := g.sync.fetchEntries(ctx, p, []string{e.Name()})
+    if err != nil {
+        return err
+    }
+    for _, e := range entries {
+        if _, err := adopt(ctx, g.store, g.sync.exchange, p, e); err != nil {
+            return err
+        }
+    }
+    return nil
 }
@@ -0,0 +1,110 @@
+package sync
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/p2p"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestGossip_PropagatesChanges(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    a := startTestNode(t, ctx)
+    nodes := []*testNode{a, startTestNode(t, ctx, a.node.Addrs()...), startTestNode(t, ctx, a.node.Addrs()...)}
+    for _, n := range nodes {
+        go n.gossip.Run(ctx)
+    }
+    require.Eventually(t, func() bool {
+        return len(a.gossip.pubsub.Subscribers(ClusterTopic)) == 2
+    }, 5*time.Second, 20*time.Millisecond)
+    hasObject := func(n *testNode, key, want string) func() bool {
+        return func() bool {
+            data, err := n.store.GetObject("photos", key)
+            return err == nil && string(data) == want
+        }
+    }
+
+    // The first change to a bucket reaches nodes that have not heard of it.
+    require.NoError(t, a.store.PutObject("photos", "cat", []byte("first")))
+    for _, n := range nodes[1:] {
+        require.Eventually(t, hasObject(n, "cat", "first"), 5*time.Second, 20*time.Millisecond)
+    }
+    require.Eventually(t, func() bool {
+        return len(a.node.Host.Network().Peers()) == 2 &&
+            len(a.gossip.pubsub.Subscribers(BucketTopic("photos"))) == 2
+    }, 5*time.Second, 20*time.Millisecond)
+
+    // Later ones travel on the bucket's topic, from any node.
+    require.NoError(t, nodes[1].store.PutObject("photos", "cat", []byte("second")))
+    require.NoError(t, nodes[2].store.PutBucketVersioning("photos", storage.VersioningEnabled))
+    for _, n := range nodes {
+        n := n
+        require.Eventually(t, hasObject(n, "cat", "second"), 5*time.Second, 20*time.Millisecond)
+        require.Eventually(t, func() bool {
+            status, err := n.store.GetBucketVersioning("photos")
+            return err == nil && status == storage.VersioningEnabled
+        }, 5*time.Second, 20*time.Millisecond)
+    }
+
+    require.NoError(t, a.store.DeleteObject("photos", "cat", false))
+    for _, n := range nodes {
+        n := n
+        require.Eventually(t, func() bool {
+            _, err := n.store.GetObject("photos", "cat")
+            return err != nil
+        }, 5*time.Second, 20*time.Millisecond)
+    }
+
+    // Updates arriving late or twice change nothing.
+    stale, err := nodes[2].store.GetPolicyUpdate("photos", storage.PolicyVersioning)
+    require.NoError(t, err)
+    require.NoError(t, a.store.PutBucketVersioning("photos", storage.VersioningSuspended))
+    for _, n := range nodes {
+        n := n
+        require.Eventually(t, func() bool {
+            status, _ := n.store.GetBucketVersioning("photos")
+            return status == storage.VersioningSuspended
+        }, 5*time.Second, 20*time.Millisecond)
+    }
+    applied, err := nodes[1].store.ApplyPolicyUpdate(stale)
+    require.NoError(t, err)
+    assert.False(t, applied)
+    current, err := a.store.GetPolicyUpdate("photos", storage.PolicyVersioning)
+    require.NoError(t, err)
+    applied, err = nodes[1].store.ApplyPolicyUpdate(current)
+    require.NoError(t, err)
+    assert.False(t, applied)
+
+    digests, err := a.store.SyncDigests(ctx)
+    require.NoError(t, err)
+    for _, n := range nodes[1:] {
+        other, err := n.store.SyncDigests(ctx)
+        require.NoError(t, err)
+        assert.Equal(t, digests, other)
+    }
+
+    // Updates leave manifests, and the keys in them, to anti-entropy.
+    updates := make(chan Update, 1)
+    require.NoError(t, nodes[2].gossip.pubsub.Subscribe(BucketTopic("photos"), func(m *p2p.Message) {
+        var u Update
+        if json.Unmarshal(m.Data, &u) == nil && u.Object != nil {
+            updates <- u
+        }
+    }))
+    require.NoError(t, a.store.PutObject("photos", "dog", []byte("keyed")))
+    select {
+    case u := <-updates:
+        assert.Equal(t, "dog", u.Object.Key)
+        assert.Nil(t, u.Object.Manifest)
+        assert.Empty(t, u.Object.Blocks)
+    case <-ctx.Done():
+        t.Fatal("no update published")
+    }
+}
@@ -0,0 +1,92 @@
+package sync
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/Alyanaky/SecureDAG/internal/storage"
+)
+
+const (
+    // TreeFanout is how many children each node of a summary tree has, one
+    // per hex digit.
+    TreeFanout = 16
+    // TreeDepth is how many levels of children a summary tree has. Its
+    // TreeFanout^TreeDepth leaves are ranges of the hashes of entry names.
+    TreeDepth = 3
+)
+
+const hexDigits = "0123456789abcdef"
+
+// Tree summarises a node's entries by key range. Entries are placed by the
+// hex SHA-256 of their name, so a range is a hash prefix: the root covers
+// every entry, and each leaf the entries whose hash starts with its
+// TreeDepth digits. Two nodes hold the same entries in a range exactly when
+// their hashes for it agree.
+type Tree struct {
+    hashes  map[string][]byte
+    entries map[string][]storage.SyncDigest
+}
+
+// rangeOf returns the leaf range name falls in.
+func rangeOf(name string) string {
+    sum := sha256.Sum256([]byte(name))
+    return hex.EncodeToString(sum[:])[:TreeDepth]
+}
+
+// BuildTree summarises digests, which must be ordered by name.
+func BuildTree(digests []storage.SyncDigest) *Tree {
+    t := &Tree{
+        hashes:  make(map[string][]byte),
+        entries: make(map[string][]storage.SyncDigest),
+    }
+    for _, d := range digests {
+        path := rangeOf(d.Name)
+        t.entries[path] = append(t.entries[path], d)
+    }
+
+    level := make(map[string]bool)
+    for path, entries := range t.entries {
+        leaves := make([][]byte, len(entries))
+        for i, d := range entries {
+            leaves[i] = d.Digest
+        }
+        t.hashes[path] = dag.BuildTree(leaves).Hash
+        level[path[:TreeDepth-1]] = true
+    }
+    for depth := TreeDepth - 1; depth >= 0; depth-- {
+        parents := make(map[string]bool)
+        for path := range level {
+            t.hashes[path] = dag.BuildTree(t.Children(path)).Hash
+            if depth > 0 {
+                parents[path[:depth-1]] = true
+            }
+        }
+        level = parents
+    }
+    return t
+}
+
+// Hash returns the hash of the range path, the empty tree hash if it holds
+// no entries.
+func (t *Tree) Hash(path string) []byte {
+    if h, ok := t.hashes[path]; ok {
+        return h
+    }
+    return dag.EmptyTreeHash()
+}
+
+// Children returns the hashes of the TreeFanout subranges of path.
+func (t *Tree) Children(path string) [][]byte {
+    children := make([][]byte, TreeFanout)
+    for i := range children {
+        children[i] = t.Hash(path + hexDigits[i:i+1])
+    }
+    return children
+}
+
+// Entries returns the digests in the leaf range path.
+func (t *Tree) Entries(path string) []storage.SyncDigest {
+    return t.entries[path]
+}
@@ -0,0 +1,368 @@
+// Package translog keeps an append-only Merkle tree log of the changes made
+// to a store, in the style of RFC 6962 Certificate Transparency logs. Signed
+// tree heads commit to the whole log; inclusion and consistency proofs let
+// auditors check entries and follow the log's growth without trusting the
+// node.
+package translog
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/bits"
+    "sync"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+    "github.com/dgraph-io/badger/v4"
+)
+
+// Entry types.
+const (
+    TypePut         = "put"
+    TypeDelete      = "delete"
+    TypeVersion     = "version"
+    TypePolicy      = "policy"
+    TypeKeyRotation = "key-rotation"
+)
+
+// MaxEntries caps the number of entries Entries returns at once.
+const MaxEntries = 1000
+
+var ErrInvalidRange = errors.New("transparency log range out of bounds")
+
+var sizeKey = []byte("translog/size")
+
+// Entry is one logged change. The JSON encoding stored with the entry is the
+// leaf input its Merkle leaf hash is computed from.
+type Entry struct {
+    Index     uint64    `json:"index"`
+    Time      time.Time `json:"time"`
+    Type      string    `json:"type"`
+    Bucket    string    `json:"bucket,omitempty"`
+    Key       string    `json:"key,omitempty"`
+    VersionID string    `json:"version_id,omitempty"`
+    Detail    string    `json:"detail,omitempty"`
+}
+
+// Record is an entry as the log stores it, along with its leaf input.
+type Record struct {
+    LeafInput []byte `json:"leaf_input"`
+    Entry     Entry  `json:"entry"`
+}
+
+// InclusionProof is the RFC 6962 audit path of a leaf in a tree of
+// TreeSize leaves.
+type InclusionProof struct {
+    LeafIndex uint64   `json:"leaf_index"`
+    TreeSize  uint64   `json:"tree_size"`
+    AuditPath [][]byte `json:"audit_path"`
+}
+
+// ConsistencyProof shows that the tree of FirstSize leaves is a prefix of
+// the tree of SecondSize leaves.
+type ConsistencyProof struct {
+    FirstSize  uint64   `json:"first_size"`
+    SecondSize uint64   `json:"second_size"`
+    Path       [][]byte `json:"path"`
+}
+
+// Log is a transparency log kept in a Badger database under "translog/".
+// Alongside each entry it stores the hashes of the perfect subtrees the
+// entry completes, so any tree hash or proof takes O(log n) reads.
+type Log struct {
+    db     *badger.DB
+    signer *crypto.SigningKey
+
+    mu   sync.Mutex
+    size uint64
+}
+
+func entryKey(index uint64) []byte {
+    return []byte(fmt.Sprintf("translog/entry/%020d", index))
+}
+
+// nodeKey names the hash of the perfect subtree of 2^level leaves starting
+// at leaf index<<level.
+func nodeKey(level int, index uint64) []byte {
+    return []byte(fmt.Sprintf("translog/node/%02d/%020d", level, index))
+}
+
+// Open returns the log stored in db. Tree heads are signed with signer.
+func Open(db *badger.DB, signer *crypto.SigningKey) (*Log, error) {
+    l := &Log{db: db, signer: signer}
+    err := db.View(func(txn *badger.Txn) error {
+        item, err := txn.Get(sizeKey)
+        if err == badger.ErrKeyNotFound {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        return item.Value(func(val []byte) error {
+            if len(val) != 8 {
+                return errors.New("invalid transparency log size")
+            }
+            l.size = binary.BigEndian.Uint64(val)
+            return nil
+        })
+    })
+    return l, err
+}
+
+// Size returns the number of entries in the log.
+func (l *Log) Size() uint64 {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.size
+}
+
+// Append adds an entry to the end of the log and returns it with its index
+// set. A zero Time is set to the current time.
+func (l *Log) Append(e Entry) (Entry, error) {
+    txn := l.db.NewTransaction(true)
+    defer txn.Discard()
+    return l.Commit(txn, e)
+}
+
+// Commit adds e to txn, a read-write transaction of the log's database, and
+// commits it, so that the change txn makes and its entry are stored together
+// or not at all. It returns e with its index set.
+func (l *Log) Commit(txn *badger.Txn, e Entry) (Entry, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    e.Index = l.size
+    if e.Time.IsZero() {
+        e.Time = time.Now().UTC()
+    }
+    leaf, err := json.Marshal(e)
+    if err != nil {
+        return e, err
+    }
+    if err := txn.Set(entryKey(e.Index), leaf); err != nil {
+        return e, err
+    }
+    hash := dag.HashLeaf(leaf)
+    if err := txn.Set(nodeKey(0, e.Index), hash); err != nil {
+        return e, err
+    }
+    // Every subtree this leaf completes gets its hash stored as well. txn
+    // may have started before the entries to its left were committed, so
+    // they are read afresh; holding mu keeps them from changing.
+    err = l.db.View(func(view *badger.Txn) error {
+        for level, index := 1, e.Index; index&1 == 1; level, index = level+1, index>>1 {
+            left, err := getNode(view, level-1, index-1)
+            if err != nil {
+                return err
+            }
+            hash = dag.HashChildren(left, hash)
+            if err := txn.Set(nodeKey(level, index>>1), hash); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return e, err
+    }
+    if err := txn.Set(sizeKey, binary.BigEndian.AppendUint64(nil, e.Index+1)); err != nil {
+        return e, err
+    }
+    if err := txn.Commit(); err != nil {
+        return e, err
+    }
+    l.size++
+    return e, nil
+}
+
+// Entries returns the entries from start to end inclusive, as RFC 6962's
+// get-entries does, truncated to MaxEntries.
+func (l *Log) Entries(start, end uint64) ([]Record, error) {
+    size := l.Size()
+    if start > end || end >= size {
+        return nil, ErrInvalidRange
+    }
+    end = min(end, start+MaxEntries-1)
+    var records []Record
+    err := l.db.View(func(txn *badger.Txn) error {
+        for i := start; i <= end; i++ {
+            item, err := txn.Get(entryKey(i))
+            if err != nil {
+                return err
+            }
+            rec := Record{}
+            if rec.LeafInput, err = item.ValueCopy(nil); err != nil {
+                return err
+            }
+            if err := json.Unmarshal(rec.LeafInput, &rec.Entry); err != nil {
+                return err
+            }
+            records = append(records, rec)
+        }
+        return nil
+    })
+    return records, err
+}
+
+// TreeHead signs the current root of the log.
+func (l *Log) TreeHead() (*TreeHead, error) {
+    size := l.Size()
+    root, err := l.RootHash(size)
+    if err != nil {
+        return nil, err
+    }
+    head := &TreeHead{
+        TreeSize:  size,
+        Timestamp: time.Now().UTC(),
+        RootHash:  root,
+        PublicKey: l.signer.PublicKey(),
+    }
+    head.Signature = l.signer.Sign(head.SignedMessage())
+    return head, nil
+}
+
+// RootHash returns the root hash of the tree formed by the first size
+// entries.
+func (l *Log) RootHash(size uint64) ([]byte, error) {
+    if size > l.Size() {
+        return nil, ErrInvalidRange
+    }
+    var root []byte
+    err := l.db.View(func(txn *badger.Txn) error {
+        var err error
+        root, err = subtreeHash(txn, 0, size)
+        return err
+    })
+    return root, err
+}
+
+// InclusionProof returns the audit path of the entry at index in the tree
+// of the first size entries.
+func (l *Log) InclusionProof(index, size uint64) (*InclusionProof, error) {
+    if index >= size || size > l.Size() {
+        return nil, ErrInvalidRange
+    }
+    proof := &InclusionProof{LeafIndex: index, TreeSize: size, AuditPath: [][]byte{}}
+    err := l.db.View(func(txn *badger.Txn) error {
+        var err error
+        proof.AuditPath, err = auditPath(txn, index, 0, size, proof.AuditPath)
+        return err
+    })
+    return proof, err
+}
+
+// ConsistencyProof proves that the tree of the first entries is a prefix of
+// the tree of the second entries.
+func (l *Log) ConsistencyProof(first, second uint64) (*ConsistencyProof, error) {
+    if first > second || second > l.Size() {
+        return nil, ErrInvalidRange
+    }
+    proof := &ConsistencyProof{FirstSize: first, SecondSize: second, Path: [][]byte{}}
+    if first == 0 || first == second {
+        return proof, nil
+    }
+    err := l.db.View(func(txn *badger.Txn) error {
+        var err error
+        proof.Path, err = subproof(txn, first, 0, second, true, proof.Path)
+        return err
+    })
+    return proof, err
+}
+
+func getNode(txn *badger.Txn, level int, index uint64) ([]byte, error) {
+    item, err := txn.Get(nodeKey(level, index))
+    if err != nil {
+        return nil, err
+    }
+    return item.ValueCopy(nil)
+}
+
+// split returns the largest power of two smaller than n, for n > 1.
+func split(n uint64) uint64 {
+    return 1 << (bits.Len64(n-1) - 1)
+}
+
+// subtreeHash returns MTH(D[lo:hi]). The ranges RFC 6962 recurses into are
+// always either split further or perfect and aligned, so the latter are
+// stored nodes.
+func subtreeHash(txn *badger.Txn, lo, hi uint64) ([]byte, error) {
+    n := hi - lo
+    if n == 0 {
+        return dag.EmptyTreeHash(), nil
+    }
+    if n&(n-1) == 0 && lo%n == 0 {
+        return getNode(txn, bits.TrailingZeros64(n), lo/n)
+    }
+    k := split(n)
+    left, err := subtreeHash(txn, lo, lo+k)
+    if err != nil {
+        return nil, err
+    }
+    right, err := subtreeHash(txn, lo+k, hi)
+    if err != nil {
+        return nil, err
+    }
+    return dag.HashChildren(left, right), nil
+}
+
+// auditPath is PATH(m, D[lo:hi]) of RFC 6962 section 2.1.1, appended to
+// path.
+func auditPath(txn *badger.Txn, m, lo, hi uint64, path [][]byte) ([][]byte, error) {
+    if hi-lo <= 1 {
+        return path, nil
+    }
+    k := split(hi - lo)
+    var sibling []byte
+    var err error
+    if m < lo+k {
+        if path, err = auditPath(txn, m, lo, lo+k, path); err != nil {
+            return nil, err
+        }
+        sibling, err = subtreeHash(txn, lo+k, hi)
+    } else {
+        if path, err = auditPath(txn, m, lo+k, hi, path); err != nil {
+            return nil, err
+        }
+        sibling, err = subtreeHash(txn, lo, lo+k)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return append(path, sibling), nil
+}
+
+// subproof is SUBPROOF(m, D[lo:hi], b) of RFC 6962 section 2.1.2, with m
+// counted from the start of the log, appended to path.
+func subproof(txn *badger.Txn, m, lo, hi uint64, complete bool, path [][]byte) ([][]byte, error) {
+    if m == hi {
+        if complete {
+            return path, nil
+        }
+        hash, err := subtreeHash(txn, lo, hi)
+        if err != nil {
+            return nil, err
+        }
+        return append(path, hash), nil
+    }
+    k := split(hi - lo)
+    var sibling []byte
+    var err error
+    if m <= lo+k {
+        if path, err = subproof(txn, m, lo, lo+k, complete, path); err != nil {
+            return nil, err
+        }
+        sibling, err = subtreeHash(txn, lo+k, hi)
+    } else {
+        if path, err = subproof(txn, m, lo+k, hi, false, path); err != nil {
+            return nil, err
+        }
+        sibling, err = subtreeHash(txn, lo, lo+k)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return append(path, sibling), nil
+}
@@ -0,0 +1,74 @@
+package translog
+
+import (
+    "fmt"
+    "testing"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/dgraph-io/badger/v4"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestLog_ProofsMatchRFC6962(t *testing.T) {
+    dir := t.TempDir()
+    db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+    require.NoError(t, err)
+    signer, err := crypto.NewSigningKey()
+    require.NoError(t, err)
+    l, err := Open(db, signer)
+    require.NoError(t, err)
+
+    var leaves, roots [][]byte
+    roots = append(roots, TreeHash(nil))
+    for i := 0; i < 17; i++ {
+        e, err := l.Append(Entry{Type: TypePut, Bucket: "bucket", Key: fmt.Sprint("key", i)})
+        require.NoError(t, err)
+        assert.Equal(t, uint64(i), e.Index)
+
+        records, err := l.Entries(uint64(i), uint64(i))
+        require.NoError(t, err)
+        leaves = append(leaves, LeafHash(records[0].LeafInput))
+        roots = append(roots, TreeHash(leaves))
+
+        root, err := l.RootHash(uint64(i + 1))
+        require.NoError(t, err)
+        assert.Equal(t, roots[i+1], root, "size %d", i+1)
+    }
+
+    for size := uint64(1); size <= 17; size++ {
+        for index := uint64(0); index < size; index++ {
+            p, err := l.InclusionProof(index, size)
+            require.NoError(t, err)
+            assert.True(t, VerifyInclusion(leaves[index], p, roots[size]), "index %d of %d", index, size)
+            assert.False(t, VerifyInclusion(leaves[(index+1)%17], p, roots[size]))
+        }
+        for first := uint64(0); first <= size; first++ {
+            p, err := l.ConsistencyProof(first, size)
+            require.NoError(t, err)
+            assert.True(t, VerifyConsistency(p, roots[first], roots[size]), "%d to %d", first, size)
+            if first > 0 && first < size {
+                assert.False(t, VerifyConsistency(p, roots[first-1], roots[size]))
+            }
+        }
+    }
+
+    _, err = l.InclusionProof(17, 17)
+    assert.ErrorIs(t, err, ErrInvalidRange)
+    _, err = l.ConsistencyProof(3, 18)
+    assert.ErrorIs(t, err, ErrInvalidRange)
+
+    head, err := l.TreeHead()
+    require.NoError(t, err)
+    assert.Equal(t, uint64(17), head.TreeSize)
+    assert.Equal(t, roots[17], head.RootHash)
+    assert.True(t, head.Verify(signer.PublicKey()))
+    head.TreeSize--
+    assert.False(t, head.Verify(signer.PublicKey()))
+
+    // The log carries on where it left off after a reopen.
+    reopened, err := Open(db, signer)
+    require.NoError(t, err)
+    assert.Equal(t, uint64(17), reopened.Size())
+    require.NoError(t, db.Close())
+}
@@ -0,0 +1,115 @@
+package translog
+
+import (
+    "bytes"
+    "fmt"
+    "time"
+
+    "github.com/Alyanaky/SecureDAG/internal/crypto"
+    "github.com/Alyanaky/SecureDAG/internal/dag"
+)
+
+// TreeHead is a signed commitment to the first TreeSize entries of the log.
+type TreeHead struct {
+    TreeSize  uint64    `json:"tree_size"`
+    Timestamp time.Time `json:"timestamp"`
+    RootHash  []byte    `json:"root_hash"`
+    PublicKey []byte    `json:"public_key"`
+    Signature []byte    `json:"signature"`
+}
+
+// SignedMessage returns the bytes the tree head signature covers.
+func (h *TreeHead) SignedMessage() []byte {
+    return []byte(fmt.Sprintf("securedag-tree-head-v1\n%d\n%s\n%x\n",
+        h.TreeSize, h.Timestamp.UTC().Format(time.RFC3339Nano), h.RootHash))
+}
+
+// Verify checks the tree head's signature against publicKey.
+func (h *TreeHead) Verify(publicKey []byte) bool {
+    return crypto.VerifySignature(publicKey, h.SignedMessage(), h.Signature)
+}
+
+// LeafHash returns the Merkle leaf hash of a record's leaf input.
+func LeafHash(leafInput []byte) []byte {
+    return dag.HashLeaf(leafInput)
+}
+
+// TreeHash computes the root hash over a full list of leaf hashes, for
+// auditors that replay the whole log.
+func TreeHash(leaves [][]byte) []byte {
+    switch len(leaves) {
+    case 0:
+        return dag.EmptyTreeHash()
+    case 1:
+        return leaves[0]
+    }
+    k := split(uint64(len(leaves)))
+    return dag.HashChildren(TreeHash(leaves[:k]), TreeHash(leaves[k:]))
+}
+
+// VerifyInclusion checks an audit path as described in RFC 9162 section
+// 2.1.3.2.
+func VerifyInclusion(leafHash []byte, p *InclusionProof, root []byte) bool {
+    if p == nil || p.LeafIndex >= p.TreeSize {
+        return false
+    }
+    fn, sn := p.LeafIndex, p.TreeSize-1
+    r := leafHash
+    for _, hash := range p.AuditPath {
+        if sn == 0 {
+            return false
+        }
+        if fn&1 == 1 || fn == sn {
+            r = dag.HashChildren(hash, r)
+            for fn&1 == 0 && fn != 0 {
+                fn, sn = fn>>1, sn>>1
+            }
+        } else {
+            r = dag.HashChildren(r, hash)
+        }
+        fn, sn = fn>>1, sn>>1
+    }
+    return sn == 0 && bytes.Equal(r, root)
+}
+
+// VerifyConsistency checks a consistency proof between two tree roots as
+// described in RFC 9162 section 2.1.4.2.
+func VerifyConsistency(p *ConsistencyProof, firstRoot, secondRoot []byte) bool {
+    if p == nil || p.FirstSize > p.SecondSize {
+        return false
+    }
+    if p.FirstSize == p.SecondSize {
+        return len(p.Path) == 0 && bytes.Equal(firstRoot, secondRoot)
+    }
+    if p.FirstSize == 0 {
+        return len(p.Path) == 0
+    }
+    path := p.Path
+    if p.FirstSize&(p.FirstSize-1) == 0 {
+        path = append([][]byte{firstRoot}, path...)
+    }
+    if len(path) == 0 {
+        return false
+    }
+    fn, sn := p.FirstSize-1, p.SecondSize-1
+    for fn&1 == 1 {
+        fn, sn = fn>>1, sn>>1
+    }
+    fr, sr := path[0], path[0]
+    for _, c := range path[1:] {
+        if sn == 0 {
+            return false
+        }
+        if fn&1 == 1 || fn == sn {
+            fr = dag.HashChildren(c, fr)
+            sr = dag.HashChildren(c, sr)
+            for fn&1 == 0 && fn != 0 {
+                fn, sn = fn>>1, sn>>1
+            }
+        } else {
+            sr = dag.HashChildren(sr, c)
+        }
+        fn, sn = fn>>1, sn>>1
+    }
+    return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}